@@ -5,8 +5,8 @@ export GOBIN="`pwd`/bin"
 cp -r ./pkg $GOPATH/src/btcplex
 cp -r ./cmd/* $GOPATH/src/
 
-go get btcplex btcplex-server btcplex-prod btcplex-blocknotify btcplex-import
-go install btcplex-server btcplex-prod btcplex-blocknotify btcplex-import
+go get btcplex btcplex-server btcplex-prod btcplex-blocknotify btcplex-import btcplex-richlist btcplex-rebuild btcplex-electrum btcplex-webhooks
+go install btcplex-server btcplex-prod btcplex-blocknotify btcplex-import btcplex-richlist btcplex-rebuild btcplex-electrum btcplex-webhooks
 
 rm $GOPATH/src/btcplex -rf
 rm $GOPATH/btcplex-* -rf
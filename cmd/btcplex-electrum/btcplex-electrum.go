@@ -0,0 +1,725 @@
+// Electrum protocol server (newline delimited JSON-RPC over TCP) on top of the index,
+// subscriptions are notified from the btcplex:newblock and btcplex:utxs Redis channels.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docopt/docopt.go"
+	"github.com/garyburd/redigo/redis"
+
+	btcplex "github.com/mazaclub/btcplex/pkg"
+)
+
+const (
+	serverversion    = "BTCplex 1.0"
+	idletimeout      = 10 * time.Minute
+	writetimeout     = 30 * time.Second
+	maxrequestsize   = 1 << 20
+	maxsubscriptions = 10000
+	// Notifications waiting to be written before a client is dropped
+	notifybuffer = 256
+	// Headers returned by blockchain.block.headers
+	maxheaders = 2016
+)
+
+// Electrum error codes
+const (
+	errBadRequest     = 1
+	errDaemon         = 2
+	errMethodNotFound = -32601
+	errInvalidParams  = -32602
+)
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (rpcerr *rpcError) Error() string {
+	return rpcerr.Message
+}
+
+// Error of a bitcoind call, only bitcoind error messages are returned as is since
+// connection errors contain the RPC URL
+func daemonError(err error) *rpcError {
+	if bitcoinderr, isbitcoinderr := err.(*btcplex.RPCError); isbitcoinderr {
+		return &rpcError{errDaemon, bitcoinderr.Message}
+	}
+	log.Printf("Daemon error: %v", err)
+	return &rpcError{errDaemon, "Daemon unavailable"}
+}
+
+type request struct {
+	Id     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Subscribed scripthash, address is empty until the scripthash receives something
+type subscription struct {
+	address string
+	status  string
+}
+
+// Notifications are queued and written by their own goroutine, so a slow client never holds up the others
+type session struct {
+	conn         net.Conn
+	writemutex   sync.Mutex
+	mutex        sync.Mutex
+	headers      bool
+	scripthashes map[string]*subscription
+	queue        chan interface{}
+	closed       bool
+}
+
+func newSession(conn net.Conn) *session {
+	s := &session{conn: conn, scripthashes: map[string]*subscription{}, queue: make(chan interface{}, notifybuffer)}
+	go s.write()
+	return s
+}
+
+func (s *session) send(msg interface{}) (err error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.writemutex.Lock()
+	defer s.writemutex.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(writetimeout))
+	_, err = s.conn.Write(append(data, '\n'))
+	return
+}
+
+// Write the queued notifications until the session is closed
+func (s *session) write() {
+	for msg := range s.queue {
+		if err := s.send(msg); err != nil {
+			s.conn.Close()
+		}
+	}
+}
+
+// Queue a notification without ever blocking, a client lagging behind by notifybuffer
+// notifications is disconnected
+func (s *session) notify(method string, params ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}:
+	default:
+		log.Printf("Dropping lagging connection from %v", s.conn.RemoteAddr())
+		s.close()
+	}
+}
+
+func (s *session) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.conn.Close()
+}
+
+// Status of a scripthash shared by the sessions subscribing to it
+type scripthashStatus struct {
+	mutex sync.Mutex
+	// nil until the scripthash receives something
+	hash        *btcplex.ElectrumStatusHash
+	subscribers int
+}
+
+type server struct {
+	conf     *btcplex.Config
+	pool     *redis.Pool
+	ssdb     *redis.Pool
+	mutex    sync.Mutex
+	sessions map[*session]bool
+	statuses map[string]*scripthashStatus
+}
+
+func (srv *server) retain(scripthash string) *scripthashStatus {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	status, tracked := srv.statuses[scripthash]
+	if !tracked {
+		status = new(scripthashStatus)
+		srv.statuses[scripthash] = status
+	}
+	status.subscribers++
+	return status
+}
+
+func (srv *server) release(scripthash string) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if status, tracked := srv.statuses[scripthash]; tracked {
+		status.subscribers--
+		if status.subscribers <= 0 {
+			delete(srv.statuses, scripthash)
+		}
+	}
+}
+
+// Current status of the address, the block (if any) is appended to the confirmed history
+func (srv *server) status(status *scripthashStatus, address string, block *btcplex.Block) (string, error) {
+	if address == "" {
+		return "", nil
+	}
+	status.mutex.Lock()
+	defer status.mutex.Unlock()
+	var err error
+	if status.hash == nil {
+		status.hash, err = btcplex.NewElectrumStatusHash(srv.ssdb, address)
+	} else if block != nil {
+		err = status.hash.AddBlock(srv.ssdb, block)
+	}
+	if err != nil {
+		status.hash = nil
+		return "", err
+	}
+	return status.hash.Status(srv.pool)
+}
+
+// null status for scripthashes without history
+func statusValue(status string) interface{} {
+	if status == "" {
+		return nil
+	}
+	return status
+}
+
+func stringParam(params []interface{}, i int) (string, error) {
+	if i >= len(params) {
+		return "", &rpcError{errInvalidParams, fmt.Sprintf("Missing parameter %v", i)}
+	}
+	value, ok := params[i].(string)
+	if !ok {
+		return "", &rpcError{errInvalidParams, fmt.Sprintf("Parameter %v must be a string", i)}
+	}
+	return value, nil
+}
+
+func uintParam(params []interface{}, i int, def uint64) (uint64, error) {
+	if i >= len(params) {
+		return def, nil
+	}
+	if value, ok := params[i].(json.Number); ok {
+		if n, err := strconv.ParseUint(value.String(), 10, 0); err == nil {
+			return n, nil
+		}
+	}
+	return 0, &rpcError{errInvalidParams, fmt.Sprintf("Parameter %v must be a positive integer", i)}
+}
+
+// Address of the scripthash parameter, empty if it never received anything
+func (srv *server) scriptHashParam(params []interface{}) (scripthash, address string, err error) {
+	if scripthash, err = stringParam(params, 0); err != nil {
+		return
+	}
+	if decoded, derr := hex.DecodeString(scripthash); derr != nil || len(decoded) != 32 {
+		return "", "", &rpcError{errBadRequest, fmt.Sprintf("Invalid scripthash %v", scripthash)}
+	}
+	address, err = btcplex.GetScriptHashAddress(srv.ssdb, scripthash)
+	if err == btcplex.ErrNotFound {
+		return scripthash, "", nil
+	}
+	return
+}
+
+func (srv *server) header(height uint) (header map[string]interface{}, err error) {
+	hash, err := btcplex.GetBlockHash(srv.ssdb, height)
+	if err != nil {
+		return
+	}
+	block, err := btcplex.GetBlockByHash(srv.ssdb, hash)
+	if err != nil {
+		return
+	}
+	raw, err := btcplex.SerializeHeader(block)
+	if err != nil {
+		return
+	}
+	return map[string]interface{}{"height": block.Height, "hex": hex.EncodeToString(raw)}, nil
+}
+
+func (srv *server) bestHeader() (header map[string]interface{}, err error) {
+	c := srv.ssdb.Get()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	c.Close()
+	if err != nil {
+		return
+	}
+	return srv.header(uint(latest))
+}
+
+// Histories past btcplex.MaxElectrumHistory txs are refused
+func historyError(err error) error {
+	if err == btcplex.ErrHistoryTooLarge {
+		return &rpcError{errBadRequest, err.Error()}
+	}
+	return err
+}
+
+func (srv *server) call(s *session, method string, params []interface{}) (result interface{}, err error) {
+	switch method {
+	case "server.version":
+		return []string{serverversion, btcplex.ElectrumProtocolVersion}, nil
+	case "server.banner":
+		return fmt.Sprintf("Welcome to %v, an Electrum server backed by the BTCplex block explorer", serverversion), nil
+	case "server.donation_address":
+		return "", nil
+	case "server.ping":
+		return nil, nil
+	case "server.peers.subscribe":
+		return []interface{}{}, nil
+	case "server.features":
+		genesis, _ := btcplex.GetBlockHash(srv.ssdb, 0)
+		return map[string]interface{}{"genesis_hash": genesis, "hosts": map[string]interface{}{}, "protocol_min": btcplex.ElectrumProtocolVersion,
+			"protocol_max": btcplex.ElectrumProtocolVersion, "server_version": serverversion, "hash_function": "sha256", "pruning": nil}, nil
+
+	case "blockchain.headers.subscribe":
+		s.mutex.Lock()
+		s.headers = true
+		s.mutex.Unlock()
+		return srv.bestHeader()
+	case "blockchain.block.header":
+		height, perr := uintParam(params, 0, 0)
+		if perr != nil || len(params) == 0 {
+			return nil, &rpcError{errInvalidParams, "Invalid height"}
+		}
+		header, herr := srv.header(uint(height))
+		if herr != nil {
+			return nil, &rpcError{errBadRequest, fmt.Sprintf("No block at height %v", height)}
+		}
+		return header["hex"], nil
+	case "blockchain.block.headers":
+		start, perr := uintParam(params, 0, 0)
+		if perr != nil || len(params) == 0 {
+			return nil, &rpcError{errInvalidParams, "Invalid start height"}
+		}
+		count, perr := uintParam(params, 1, 0)
+		if perr != nil || len(params) < 2 {
+			return nil, &rpcError{errInvalidParams, "Invalid count"}
+		}
+		if cp, _ := uintParam(params, 2, 0); cp != 0 {
+			return nil, &rpcError{errBadRequest, "Checkpoints are not supported"}
+		}
+		if count > maxheaders {
+			count = maxheaders
+		}
+		// Stops at the best block
+		headers := ""
+		cnt := 0
+		for height := start; height < start+count; height++ {
+			header, herr := srv.header(uint(height))
+			if herr == btcplex.ErrNotFound {
+				break
+			}
+			if herr != nil {
+				return nil, herr
+			}
+			headers += header["hex"].(string)
+			cnt++
+		}
+		return map[string]interface{}{"count": cnt, "hex": headers, "max": maxheaders}, nil
+	case "blockchain.estimatefee":
+		blocks, perr := uintParam(params, 0, 2)
+		if perr != nil || blocks == 0 || blocks > btcplex.FeeHistoryBlocks {
+			return nil, &rpcError{errInvalidParams, "Invalid number of blocks"}
+		}
+		history, _ := btcplex.GetFeeHistory(srv.ssdb)
+		feerates, sizes, _ := btcplex.GetMempoolFeeRates(srv.pool)
+		return btcplex.UintToFloat(btcplex.EstimateFee(history, feerates, sizes, uint(blocks)).FeePerKb), nil
+	case "blockchain.relayfee":
+		return btcplex.UintToFloat(uint64(btcplex.MinRelayFeeRate * 1000)), nil
+
+	case "blockchain.scripthash.get_history", "blockchain.scripthash.get_mempool":
+		_, address, perr := srv.scriptHashParam(params)
+		if perr != nil {
+			return nil, perr
+		}
+		if address == "" {
+			return []*btcplex.ElectrumHistoryItem{}, nil
+		}
+		if method == "blockchain.scripthash.get_mempool" {
+			return btcplex.GetElectrumMempool(srv.pool, address)
+		}
+		history, herr := btcplex.GetElectrumHistory(srv.ssdb, srv.pool, address)
+		if herr != nil {
+			return nil, historyError(herr)
+		}
+		return history, nil
+	case "blockchain.scripthash.get_balance":
+		_, address, perr := srv.scriptHashParam(params)
+		if perr != nil {
+			return nil, perr
+		}
+		if address == "" {
+			return &btcplex.ElectrumBalance{}, nil
+		}
+		return btcplex.GetElectrumBalance(srv.ssdb, srv.pool, address)
+	case "blockchain.scripthash.listunspent":
+		_, address, perr := srv.scriptHashParam(params)
+		if perr != nil {
+			return nil, perr
+		}
+		utxos := []*btcplex.ElectrumUnspent{}
+		if address == "" {
+			return utxos, nil
+		}
+		unspent, uerr := btcplex.GetAddressUnspent(srv.ssdb, srv.pool, address)
+		if uerr != nil {
+			return nil, uerr
+		}
+		for _, output := range unspent {
+			utxos = append(utxos, btcplex.NewElectrumUnspent(output))
+		}
+		return utxos, nil
+	case "blockchain.scripthash.subscribe":
+		scripthash, address, perr := srv.scriptHashParam(params)
+		if perr != nil {
+			return nil, perr
+		}
+		s.mutex.Lock()
+		_, subscribed := s.scripthashes[scripthash]
+		cnt := len(s.scripthashes)
+		s.mutex.Unlock()
+		if !subscribed && cnt >= maxsubscriptions {
+			return nil, &rpcError{errBadRequest, fmt.Sprintf("At most %v subscriptions per connection", maxsubscriptions)}
+		}
+		shared := srv.retain(scripthash)
+		status, serr := srv.status(shared, address, nil)
+		if serr != nil || subscribed {
+			srv.release(scripthash)
+		}
+		if serr != nil {
+			return nil, historyError(serr)
+		}
+		// Released when the client unsubscribes or disconnects
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.scripthashes[scripthash] = &subscription{address: address, status: status}
+		return statusValue(status), nil
+	case "blockchain.scripthash.unsubscribe":
+		scripthash, perr := stringParam(params, 0)
+		if perr != nil {
+			return nil, perr
+		}
+		s.mutex.Lock()
+		_, subscribed := s.scripthashes[scripthash]
+		delete(s.scripthashes, scripthash)
+		s.mutex.Unlock()
+		if subscribed {
+			srv.release(scripthash)
+		}
+		return subscribed, nil
+
+	case "blockchain.transaction.get":
+		txid, perr := stringParam(params, 0)
+		if perr != nil {
+			return nil, perr
+		}
+		verbose := len(params) > 1 && params[1] == true
+		rawtx, rerr := btcplex.GetRawTxRPC(srv.conf, txid, verbose)
+		if rerr != nil {
+			return nil, daemonError(rerr)
+		}
+		return rawtx, nil
+	case "blockchain.transaction.get_merkle":
+		txid, perr := stringParam(params, 0)
+		if perr != nil {
+			return nil, perr
+		}
+		height, perr := uintParam(params, 1, 0)
+		if perr != nil || len(params) < 2 {
+			return nil, &rpcError{errInvalidParams, "Invalid height"}
+		}
+		merkle, merr := btcplex.GetElectrumMerkle(srv.ssdb, txid, uint(height))
+		if merr == btcplex.ErrNotFound {
+			return nil, &rpcError{errBadRequest, fmt.Sprintf("Tx %v isn't in the block at height %v", txid, height)}
+		}
+		return merkle, merr
+	case "blockchain.transaction.broadcast":
+		rawtx, perr := stringParam(params, 0)
+		if perr != nil {
+			return nil, perr
+		}
+		tx, berr := btcplex.PushTx(srv.conf, srv.pool, srv.ssdb, strings.TrimSpace(rawtx))
+		if pusherr, rejected := berr.(*btcplex.PushTxError); rejected {
+			return nil, &rpcError{errBadRequest, pusherr.Message}
+		}
+		if berr != nil {
+			return nil, daemonError(berr)
+		}
+		return tx.Hash, nil
+	}
+	return nil, &rpcError{errMethodNotFound, fmt.Sprintf("Unknown method %v", method)}
+}
+
+func (srv *server) handle(s *session, req *request) map[string]interface{} {
+	params := []interface{}{}
+	if len(req.Params) > 0 {
+		decoder := json.NewDecoder(strings.NewReader(string(req.Params)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&params); err != nil {
+			return map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "error": &rpcError{errInvalidParams, "Params must be an array"}}
+		}
+	}
+	result, err := srv.call(s, req.Method, params)
+	if err != nil {
+		rpcerr, ok := err.(*rpcError)
+		if !ok {
+			log.Printf("Error handling %v: %v", req.Method, err)
+			rpcerr = &rpcError{errBadRequest, "Internal server error"}
+		}
+		return map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "error": rpcerr}
+	}
+	return map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result}
+}
+
+// Serve a client until it disconnects, requests may be batched
+func (srv *server) serve(conn net.Conn) {
+	s := newSession(conn)
+	srv.mutex.Lock()
+	srv.sessions[s] = true
+	srv.mutex.Unlock()
+	defer func() {
+		srv.mutex.Lock()
+		delete(srv.sessions, s)
+		srv.mutex.Unlock()
+		s.mutex.Lock()
+		s.close()
+		scripthashes := s.scripthashes
+		s.scripthashes = map[string]*subscription{}
+		s.mutex.Unlock()
+		for scripthash := range scripthashes {
+			srv.release(scripthash)
+		}
+	}()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxrequestsize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idletimeout))
+		if !scanner.Scan() {
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var err error
+		if strings.HasPrefix(line, "[") {
+			reqs := []*request{}
+			if err = json.Unmarshal([]byte(line), &reqs); err == nil {
+				responses := []map[string]interface{}{}
+				for _, req := range reqs {
+					responses = append(responses, srv.handle(s, req))
+				}
+				err = s.send(responses)
+			}
+		} else {
+			req := new(request)
+			if err = json.Unmarshal([]byte(line), req); err == nil {
+				err = s.send(srv.handle(s, req))
+			}
+		}
+		if err != nil {
+			log.Printf("Closing connection from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// Send the new status of the subscribed scripthashes (scripthash to address) that changed,
+// each status is computed once for all the sessions, appending the block (if any)
+func (srv *server) refresh(scripthashes map[string]string, block *btcplex.Block) {
+	statuses := map[string]string{}
+	for scripthash, address := range scripthashes {
+		srv.mutex.Lock()
+		shared, subscribed := srv.statuses[scripthash]
+		srv.mutex.Unlock()
+		if !subscribed {
+			continue
+		}
+		status, err := srv.status(shared, address, block)
+		if err != nil {
+			log.Printf("Error computing %v status: %v", address, err)
+			continue
+		}
+		statuses[scripthash] = status
+	}
+	for _, s := range srv.sessionsList() {
+		s.mutex.Lock()
+		subs := map[string]*subscription{}
+		for scripthash := range scripthashes {
+			if sub, subscribed := s.scripthashes[scripthash]; subscribed {
+				subs[scripthash] = sub
+			}
+		}
+		s.mutex.Unlock()
+		for scripthash, sub := range subs {
+			address := scripthashes[scripthash]
+			status, computed := statuses[scripthash]
+			if !computed {
+				continue
+			}
+			s.mutex.Lock()
+			sub.address = address
+			changed := sub.status != status
+			sub.status = status
+			s.mutex.Unlock()
+			if changed {
+				s.notify("blockchain.scripthash.subscribe", scripthash, statusValue(status))
+			}
+		}
+	}
+}
+
+func (srv *server) sessionsList() (sessions []*session) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	for s := range srv.sessions {
+		sessions = append(sessions, s)
+	}
+	return
+}
+
+// Scripthashes (to address) of the addresses involved in the txs
+func txsScriptHashes(txs []*btcplex.Tx) map[string]string {
+	scripthashes := map[string]string{}
+	for _, tx := range txs {
+		for _, address := range tx.Addresses() {
+			if scripthash, err := btcplex.AddressScriptHash(address); err == nil {
+				scripthashes[scripthash] = address
+			}
+		}
+	}
+	return scripthashes
+}
+
+// A new block changes the header, and the history of the addresses involved in its txs
+func (srv *server) notifyBlock(block *btcplex.Block) {
+	header, err := srv.bestHeader()
+	if err != nil {
+		log.Printf("Error fetching the best header: %v", err)
+		return
+	}
+	for _, s := range srv.sessionsList() {
+		s.mutex.Lock()
+		headers := s.headers
+		s.mutex.Unlock()
+		if headers {
+			s.notify("blockchain.headers.subscribe", header)
+		}
+	}
+	srv.refresh(txsScriptHashes(block.Txs), block)
+}
+
+func (srv *server) notifyTx(tx *btcplex.Tx) {
+	srv.refresh(txsScriptHashes([]*btcplex.Tx{tx}), nil)
+}
+
+// Listen for new blocks and unconfirmed transactions, reconnecting on errors
+func (srv *server) subscribe() {
+	for {
+		conn := srv.pool.Get()
+		psc := redis.PubSubConn{Conn: conn}
+		psc.Subscribe("btcplex:newblock", "btcplex:utxs")
+	receive:
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				if v.Channel == "btcplex:newblock" {
+					block := new(btcplex.Block)
+					if err := json.Unmarshal(v.Data, block); err == nil {
+						srv.notifyBlock(block)
+					}
+					continue
+				}
+				tx := new(btcplex.Tx)
+				if err := json.Unmarshal(v.Data, tx); err == nil {
+					srv.notifyTx(tx)
+				}
+			case error:
+				log.Printf("Redis subscription error: %v", v)
+				break receive
+			}
+		}
+		conn.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+func main() {
+	usage := `Serve the Electrum protocol on top of the index.
+
+Usage:
+  btcplex-electrum [--config=<path>] [--listen=<addr>]
+  btcplex-electrum -h | --help
+
+Options:
+  -h --help     	Show this screen.
+  -c <path>, --config <path>	Path to config file [default: config.json].
+  -l <addr>, --listen <addr>	TCP address to listen on [default: :50001].
+`
+
+	arguments, _ := docopt.Parse(usage, nil, true, "btcplex-electrum", false)
+
+	confFile := "config.json"
+	if arguments["--config"] != nil {
+		confFile = arguments["--config"].(string)
+	}
+	listen := ":50001"
+	if arguments["--listen"] != nil {
+		listen = arguments["--listen"].(string)
+	}
+
+	if _, err := os.Stat(confFile); os.IsNotExist(err) {
+		log.Fatalf("Config file not found: %v", confFile)
+	}
+
+	conf, err := btcplex.LoadConfig(confFile)
+	if err != nil {
+		log.Fatalf("Can't load config file: %v", err)
+	}
+	pool, err := btcplex.GetRedis(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to Redis: %v", err)
+	}
+	ssdb, err := btcplex.GetSSDB(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to SSDB: %v", err)
+	}
+
+	srv := &server{conf: conf, pool: pool, ssdb: ssdb, sessions: map[*session]bool{}, statuses: map[string]*scripthashStatus{}}
+	go srv.subscribe()
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("Can't listen on %v: %v", listen, err)
+	}
+	log.Printf("Listening on %v\n", listen)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		go srv.serve(conn)
+	}
+}
@@ -58,6 +58,15 @@ type TxOutCached struct {
 	Value uint64 `json:"value"`
 }
 
+// Side branch block kept until it's connected, or too deep below the main chain tip
+type sideBlock struct {
+	bl     *blkparser.Block
+	height uint
+}
+
+// Side branch blocks this far below the tip are dropped
+const maxreorgdepth = 100
+
 var wg, txwg sync.WaitGroup
 var tximut, txomut sync.Mutex
 
@@ -130,76 +139,9 @@ func main() {
 		log.Fatalf("Error loading block file: ", blockchainerr)
 	}
 
-	block_height := uint(0)
-	for {
-		if !running {
-			break
-		}
-
-		wg.Add(1)
-
-		bl, er := blockchain.NextBlock()
-		if er != nil {
-			log.Println("Initial import done.")
-			break
-		}
-
-		bl.Raw = nil
-
-		if bl.Parent == "" {
-			block_height = uint(0)
-			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "main", true)
-			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "height", 0)
-
-		} else {
-			parentheight, _ := redis.Int(conn.Do("HGET", fmt.Sprintf("block:%v:h", bl.Parent), "height"))
-			block_height = uint(parentheight + 1)
-			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "height", block_height)
-			prevheight := block_height - 1
-			prevhashtest := bl.Parent
-			prevnext := bl.Hash
-			for {
-				prevkey := fmt.Sprintf("height:%v", prevheight)
-				prevcnt, _ := redis.Int(conn.Do("ZCARD", prevkey))
-				// SSDB doesn't support negative slice yet
-				prevs, _ := redis.Strings(conn.Do("ZRANGE", prevkey, 0, prevcnt-1))
-				for _, cprevhash := range prevs {
-					if cprevhash == prevhashtest {
-						// current block parent
-						prevhashtest, _ = redis.String(conn.Do("HGET", fmt.Sprintf("block:%v:h", cprevhash), "parent"))
-						// Set main to 1 and the next => prevnext
-						conn.Do("HMSET", fmt.Sprintf("block:%v:h", cprevhash), "main", true, "next", prevnext)
-						conn.Do("SET", fmt.Sprintf("block:height:%v", prevheight), cprevhash)
-						prevnext = cprevhash
-					} else {
-						// Set main to 0
-						conn.Do("HSET", fmt.Sprintf("block:%v:h", cprevhash), "main", false)
-						oblock, _ := btcplex.GetBlockCachedByHash(pool, cprevhash)
-						for _, otx := range oblock.Txs {
-							otx.Revert(pool)
-						}
-					}
-				}
-				if len(prevs) == 1 {
-					break
-				}
-				prevheight--
-			}
-			//}
-
-		}
-
-		// Orphans blocks handling
-		conn.Do("ZADD", fmt.Sprintf("height:%v", block_height), bl.BlockTime, bl.Hash)
-		conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "parent", bl.Parent)
-
-		if latestheight != 0 && !(latestheight+1 <= int(block_height)) {
-			log.Printf("Skipping block #%v\n", block_height)
-			continue
-		}
-
-		log.Printf("Current block: %v (%v)\n", block_height, bl.Hash)
-
+	// Index the block transactions, tip is false for the side branch blocks connected by a reorganization
+	// (the main chain tip is set by the last block of the branch)
+	indexBlock := func(bl *blkparser.Block, block_height uint, tip bool) {
 		block := new(Block)
 		block.Hash = bl.Hash
 		block.Height = block_height
@@ -260,8 +202,10 @@ func main() {
 					//conn.Send("ZADD", fmt.Sprintf("txo:%v", tx.Hash), txo_index, ntxokey)
 					conn.Do("ZADD", fmt.Sprintf("addr:%v", ntxo.Addr), bl.BlockTime, tx.Hash)
 					conn.Do("ZADD", fmt.Sprintf("addr:%v:received", ntxo.Addr), bl.BlockTime, tx.Hash)
+					btcplex.IndexUnspent(conn, ntxo.Addr, tx.Hash, uint32(txo_index), bl.BlockTime)
 
-					conn.Do("HINCRBY", fmt.Sprintf("addr:%v:h", ntxo.Addr), "tr", ntxo.Value)
+					btcplex.IncrAddressTotal(conn, ntxo.Addr, "tr", int64(ntxo.Value))
+					btcplex.IndexScriptHash(conn, ntxo.Addr)
 
 					txomut.Lock()
 					txos = append(txos, ntxo)
@@ -353,7 +297,8 @@ func main() {
 
 						conn.Do("ZADD", fmt.Sprintf("addr:%v", nprevout.Address), bl.BlockTime, tx.Hash)
 						conn.Do("ZADD", fmt.Sprintf("addr:%v:sent", nprevout.Address), bl.BlockTime, tx.Hash)
-						conn.Do("HINCRBY", fmt.Sprintf("addr:%v:h", nprevout.Address), "ts", nprevout.Value)
+						btcplex.UnindexUnspent(conn, nprevout.Address, txi.InputHash, txi.InputVout)
+						btcplex.IncrAddressTotal(conn, nprevout.Address, "ts", int64(nprevout.Value))
 					}(txi, bl, tx, pool, &total_tx_in, txi_index)
 
 				}
@@ -399,10 +344,139 @@ func main() {
 
 		blockjson, _ := json.Marshal(block)
 		conn.Do("ZADD", "blocks", block.BlockTime, block.Hash)
-		conn.Do("MSET", fmt.Sprintf("block:%v", block.Hash), blockjson, "height:latest", int(block_height), fmt.Sprintf("block:height:%v", block.Height), block.Hash)
+		if tip {
+			conn.Do("MSET", fmt.Sprintf("block:%v", block.Hash), blockjson, "height:latest", int(block_height), fmt.Sprintf("block:height:%v", block.Height), block.Hash)
+		} else {
+			conn.Do("SET", fmt.Sprintf("block:%v", block.Hash), blockjson)
+		}
 		block.Txs = txs
 		blockjsoncache, _ := json.Marshal(block)
 		conn.Do("SET", fmt.Sprintf("block:%v:cached", block.Hash), blockjsoncache)
+	}
+
+	// Side branch blocks waiting for more work
+	sideblocks := map[string]*sideBlock{}
+
+	block_height := uint(0)
+	for {
+		if !running {
+			break
+		}
+
+		wg.Add(1)
+
+		bl, er := blockchain.NextBlock()
+		if er != nil {
+			log.Println("Initial import done.")
+			break
+		}
+
+		bl.Raw = nil
+
+		// Blocks without more work than the main chain are kept aside, and only indexed
+		// if their branch gets more work
+		sidebranch := false
+		if bl.Parent == "" {
+			block_height = uint(0)
+			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "main", true)
+			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "height", 0)
+
+		} else {
+			parentheight, _ := redis.Int(conn.Do("HGET", fmt.Sprintf("block:%v:h", bl.Parent), "height"))
+			block_height = uint(parentheight + 1)
+			conn.Do("HSET", fmt.Sprintf("block:%v:h", bl.Hash), "height", block_height)
+			header := &btcplex.Block{Hash: bl.Hash, Height: block_height, Parent: bl.Parent, Bits: bl.Bits}
+			btcplex.SaveChainWork(conn, header)
+			besthash := btcplex.GetBestBlockHash(conn)
+			sidebranch = !btcplex.IsBestChain(conn, header, besthash)
+			if !sidebranch && besthash != bl.Parent {
+				// The previous main chain may be higher
+				bestheight, _ := redis.Int(conn.Do("GET", "height:latest"))
+				for height := bestheight; height >= int(block_height); height-- {
+					mainhash, _ := redis.String(conn.Do("GET", fmt.Sprintf("block:height:%v", height)))
+					btcplex.DisconnectBlock(pool, conn, mainhash)
+				}
+			}
+			prevheight := block_height - 1
+			prevhashtest := bl.Parent
+			prevnext := bl.Hash
+			// Branch blocks to connect, newest first
+			connect := []string{}
+			for !sidebranch {
+				prevkey := fmt.Sprintf("height:%v", prevheight)
+				prevcnt, _ := redis.Int(conn.Do("ZCARD", prevkey))
+				// SSDB doesn't support negative slice yet
+				prevs, _ := redis.Strings(conn.Do("ZRANGE", prevkey, 0, prevcnt-1))
+				for _, cprevhash := range prevs {
+					wasmain, _ := redis.Bool(conn.Do("HGET", fmt.Sprintf("block:%v:h", cprevhash), "main"))
+					if cprevhash == prevhashtest {
+						// current block parent
+						prevhashtest, _ = redis.String(conn.Do("HGET", fmt.Sprintf("block:%v:h", cprevhash), "parent"))
+						// Set main to 1 and the next => prevnext
+						conn.Do("HMSET", fmt.Sprintf("block:%v:h", cprevhash), "main", true, "next", prevnext)
+						conn.Do("SET", fmt.Sprintf("block:height:%v", prevheight), cprevhash)
+						prevnext = cprevhash
+						if !wasmain {
+							connect = append(connect, cprevhash)
+						}
+					} else if wasmain {
+						// Set main to 0
+						conn.Do("HSET", fmt.Sprintf("block:%v:h", cprevhash), "main", false)
+						oblock, _ := btcplex.GetBlockCachedByHash(pool, cprevhash)
+						for _, otx := range oblock.Txs {
+							otx.Revert(pool)
+						}
+					}
+				}
+				if len(prevs) == 1 {
+					break
+				}
+				prevheight--
+			}
+			// Once the previous main chain is reverted, oldest first since they spend each other's outputs
+			for i := len(connect) - 1; i >= 0; i-- {
+				if sblock, pending := sideblocks[connect[i]]; pending {
+					indexBlock(sblock.bl, sblock.height, false)
+					delete(sideblocks, connect[i])
+					continue
+				}
+				// Indexed while it was in the main chain
+				pblock, _ := btcplex.GetBlockCachedByHash(pool, connect[i])
+				for _, ptx := range pblock.Txs {
+					ptx.Apply(pool)
+				}
+			}
+			//}
+
+		}
+
+		// Orphans blocks handling
+		conn.Do("ZADD", fmt.Sprintf("height:%v", block_height), bl.BlockTime, bl.Hash)
+		conn.Do("HMSET", fmt.Sprintf("block:%v:h", bl.Hash), "parent", bl.Parent, "main", !sidebranch)
+		btcplex.AddChainTip(conn, &btcplex.Block{Hash: bl.Hash, Height: block_height, Parent: bl.Parent})
+
+		if latestheight != 0 && !(latestheight+1 <= int(block_height)) {
+			log.Printf("Skipping block #%v\n", block_height)
+			continue
+		}
+
+		log.Printf("Current block: %v (%v)\n", block_height, bl.Hash)
+
+		if sidebranch {
+			// Only the header is stored, the transactions are indexed if the branch gets more work
+			log.Printf("Block %v has less work than the main chain, kept as a side branch\n", bl.Hash)
+			blockjson, _ := json.Marshal(&Block{Hash: bl.Hash, Height: block_height, Version: bl.Version, MerkleRoot: bl.MerkleRoot, BlockTime: bl.BlockTime,
+				Bits: bl.Bits, Nonce: bl.Nonce, Size: bl.Size, TxCnt: uint32(len(bl.Txs)), Parent: bl.Parent})
+			conn.Do("MSET", fmt.Sprintf("block:%v", bl.Hash), blockjson, fmt.Sprintf("block:%v:cached", bl.Hash), blockjson)
+			sideblocks[bl.Hash] = &sideBlock{bl, block_height}
+		} else {
+			indexBlock(bl, block_height, true)
+			for hash, sblock := range sideblocks {
+				if sblock.height+maxreorgdepth < block_height {
+					delete(sideblocks, hash)
+				}
+			}
+		}
 
 		if !running {
 			log.Printf("Done. Stopped at height: %v.", block_height)
@@ -67,8 +67,11 @@ Options:
 	for {
 		if running {
 			wg.Add(1)
-			done := btcplex.CatchUpLatestBlock(conf, pool, ssdb)
+			done, err := btcplex.CatchUpLatestBlock(conf, pool, ssdb)
 			wg.Done()
+			if err != nil {
+				log.Fatalf("Can't catch up latest block: %v", err)
+			}
 			if done {
 				break
 			}
@@ -84,5 +87,5 @@ Options:
 	defer conn.Close()
 
 	// Process unconfirmed transactions (power the unconfirmed txs page/API)
-	btcplex.ProcessUnconfirmedTxs(conf, pool, &running)
+	btcplex.ProcessUnconfirmedTxs(conf, pool, ssdb, &running)
 }
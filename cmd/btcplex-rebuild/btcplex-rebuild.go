@@ -0,0 +1,101 @@
+// Build the indexes added after the initial import (chain statistics, chain work, chain tips, scripthashes, unspent outputs)
+// for an existing database, they're kept up to date during the sync once built. The rich list is built by btcplex-richlist.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/docopt/docopt.go"
+
+	btcplex "github.com/mazaclub/btcplex/pkg"
+)
+
+func main() {
+	usage := `Rebuild the chain tips, scripthash and unspent outputs indexes or backfill the chain statistics and chain work.
+The scripthash and unspent outputs indexes are built from the rich list addresses, run btcplex-richlist first.
+
+Usage:
+  btcplex-rebuild [--config=<path>] charts
+  btcplex-rebuild [--config=<path>] chainwork
+  btcplex-rebuild [--config=<path>] chaintips
+  btcplex-rebuild [--config=<path>] scripthashes
+  btcplex-rebuild [--config=<path>] unspent
+  btcplex-rebuild -h | --help
+
+Options:
+  -h --help     	Show this screen.
+  -c <path>, --config <path>	Path to config file [default: config.json].
+`
+
+	arguments, _ := docopt.Parse(usage, nil, true, "btcplex-rebuild", false)
+
+	confFile := "config.json"
+	if arguments["--config"] != nil {
+		confFile = arguments["--config"].(string)
+	}
+
+	if _, err := os.Stat(confFile); os.IsNotExist(err) {
+		log.Fatalf("Config file not found: %v", confFile)
+	}
+
+	conf, err := btcplex.LoadConfig(confFile)
+	if err != nil {
+		log.Fatalf("Can't load config file: %v", err)
+	}
+	pool, err := btcplex.GetSSDB(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to SSDB: %v", err)
+	}
+
+	if arguments["charts"].(bool) {
+		err = btcplex.BackfillChartStats(pool, func(height int) {
+			log.Printf("Height %v", height)
+		})
+		if err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		log.Printf("Chain statistics backfilled")
+	}
+
+	if arguments["chainwork"].(bool) {
+		err = btcplex.BackfillChainWork(pool, func(height int) {
+			log.Printf("Height %v", height)
+		})
+		if err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		log.Printf("Chain work backfilled")
+	}
+
+	if arguments["chaintips"].(bool) {
+		err = btcplex.RebuildChainTips(pool, func(height int) {
+			log.Printf("Height %v", height)
+		})
+		if err != nil {
+			log.Fatalf("Rebuild failed: %v", err)
+		}
+		tips, _ := btcplex.GetChainTips(pool)
+		log.Printf("Chain tips rebuilt, %v tips", len(tips))
+	}
+
+	if arguments["scripthashes"].(bool) {
+		err = btcplex.RebuildScriptHashes(pool, func(addresses int) {
+			log.Printf("%v addresses", addresses)
+		})
+		if err != nil {
+			log.Fatalf("Rebuild failed: %v", err)
+		}
+		log.Printf("Scripthash index rebuilt")
+	}
+
+	if arguments["unspent"].(bool) {
+		err = btcplex.RebuildUnspent(pool, func(addresses int) {
+			log.Printf("%v addresses", addresses)
+		})
+		if err != nil {
+			log.Fatalf("Rebuild failed: %v", err)
+		}
+		log.Printf("Unspent outputs index rebuilt")
+	}
+}
@@ -0,0 +1,54 @@
+// Rebuild the rich list index (balances sorted set) of an existing database,
+// it's kept up to date during the import/sync once built.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/docopt/docopt.go"
+
+	btcplex "github.com/mazaclub/btcplex/pkg"
+)
+
+func main() {
+	usage := `Rebuild the rich list index from the addresses totals.
+
+Usage:
+  btcplex-richlist [--config=<path>]
+  btcplex-richlist -h | --help
+
+Options:
+  -h --help     	Show this screen.
+  -c <path>, --config <path>	Path to config file [default: config.json].
+`
+
+	arguments, _ := docopt.Parse(usage, nil, true, "btcplex-richlist", false)
+
+	confFile := "config.json"
+	if arguments["--config"] != nil {
+		confFile = arguments["--config"].(string)
+	}
+
+	if _, err := os.Stat(confFile); os.IsNotExist(err) {
+		log.Fatalf("Config file not found: %v", confFile)
+	}
+
+	conf, err := btcplex.LoadConfig(confFile)
+	if err != nil {
+		log.Fatalf("Can't load config file: %v", err)
+	}
+	pool, err := btcplex.GetSSDB(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to SSDB: %v", err)
+	}
+
+	err = btcplex.RebuildRichList(pool, func(height int, addresses int) {
+		log.Printf("Height %v, %v addresses", height, addresses)
+	})
+	if err != nil {
+		log.Fatalf("Rebuild failed: %v", err)
+	}
+	count, _ := btcplex.GetFundedAddressesCount(pool)
+	log.Printf("Rich list rebuilt, %v funded addresses", count)
+}
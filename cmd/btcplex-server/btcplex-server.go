@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
 	"log"
 	"math"
@@ -30,6 +32,43 @@ type searchForm struct {
 	Query string `form:"q"`
 }
 
+// Martini form for the multi-address API, form encoded or JSON,
+// addresses may also be separated by "|" or ","
+type multiAddrForm struct {
+	Addresses []string `form:"addresses" json:"addresses"`
+}
+
+// Martini form for the broadcast and decode APIs, the hex encoded transaction is accepted as "tx" or "hex"
+type rawTxForm struct {
+	Tx  string `form:"tx" json:"tx"`
+	Hex string `form:"hex" json:"hex"`
+}
+
+func (form rawTxForm) rawTx() string {
+	if rawtx := strings.TrimSpace(form.Tx); rawtx != "" {
+		return rawtx
+	}
+	return strings.TrimSpace(form.Hex)
+}
+
+// Martini forms for the Insight API broadcast and multi-address calls
+type insightTxForm struct {
+	Rawtx string `form:"rawtx" json:"rawtx"`
+}
+
+type insightAddrsForm struct {
+	Addrs string `form:"addrs" json:"addrs"`
+	From  string `form:"from" json:"from"`
+	To    string `form:"to" json:"to"`
+}
+
+// GraphQL request body
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
 // Struct holding page meta data, like meta tags, and some template variables
 type pageMeta struct {
 	Title          string
@@ -39,6 +78,11 @@ type pageMeta struct {
 	Blocks         *[]*btcplex.Block
 	Tx             *btcplex.Tx
 	TxUnconfirmed  bool
+	DecodedTx      *btcplex.DecodedTx
+	RawTx          string
+	RichList       []*btcplex.RichListEntry
+	Distribution   []*btcplex.DistributionBucket
+	FundedCnt      int
 	Txs            *[]*btcplex.Tx
 	AddressData    *btcplex.AddressData
 	LastHeight     uint
@@ -63,13 +107,35 @@ type RedisWrapper struct {
 	Pool *redis.Pool
 }
 
+// Identify a request in logs and error responses (X-Request-Id)
+type requestId string
+
+// Owner of the webhooks, derived from the API key of the request
+type webhookOwner string
+
+// Body of every API error response
+type apiError struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id"`
+	Reason    string `json:"reason,omitempty"`
+}
+
 const (
 	ratelimitwindow = 3600
 	ratelimitcnt    = 3600
 	txperpage       = 20
+	richperpage     = 100
 	synctimeout     = 60 * 8
+	maxlimit        = 100
+	insightprefix   = "/insight-api"
+	insightmaxitems = 1000
+	maxgraphqlbody  = 1 << 20
 )
 
+// An xpub scan counts as many requests, it derives and looks up to btcplex.MaxXpubAddresses addresses
+const ratelimitxpubcost = 10
+
 var conf *btcplex.Config
 
 // Keep track of the number of active SSE client
@@ -90,8 +156,8 @@ func decrementClient() {
 	activeclients--
 }
 
-// Used to rate-limit the API
-func rateLimited(rediswrapper *RedisWrapper, ip string) (bool, int, int) {
+// Used to rate-limit the API, the request counts as cost requests
+func rateLimited(rediswrapper *RedisWrapper, ip string, cost int) (bool, int, int) {
 	conn := rediswrapper.Pool.Get()
 	defer conn.Close()
 	reset := int(time.Now().UTC().Unix()/ratelimitwindow*ratelimitwindow + ratelimitwindow)
@@ -101,26 +167,56 @@ func rateLimited(rediswrapper *RedisWrapper, ip string) (bool, int, int) {
 		return true, cnt, reset
 	} else {
 		conn.Send("MULTI")
-		conn.Send("INCR", ipkey)
+		conn.Send("INCRBY", ipkey, cost)
 		conn.Send("EXPIREAT", ipkey, reset+ratelimitwindow)
 		conn.Do("EXEC")
-		cnt += 1
+		cnt += cost
 		return false, cnt, reset
 	}
 }
 
+// Relay the channel events to the group, with the id they got from btcplex.PublishEvent
 func bcastToRedisPubSub(pool *redis.Pool, psgroup *bcast.Group, redischannel string) {
-	conn := pool.Get()
-	defer conn.Close()
-	psc := redis.PubSubConn{Conn: conn}
-	psc.Subscribe(redischannel)
+	lastid, _ := btcplex.GetLastEventId(pool, redischannel)
 	for {
-		switch v := psc.Receive().(type) {
-		case redis.Message:
-			h1 := psgroup.Join()
-			h1.Send(string(v.Data))
-			h1.Close()
+		conn := pool.Get()
+		psc := redis.PubSubConn{Conn: conn}
+		psc.Subscribe(redischannel)
+	receive:
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				// Ids restart from 1 if Redis lost the counter
+				if last, err := btcplex.GetLastEventId(pool, redischannel); err == nil && last < lastid {
+					lastid = 0
+				}
+				// Every event published since the last one relayed, a message may already
+				// have been relayed along with a previous one
+				events, err := btcplex.GetEvents(pool, redischannel, lastid, btcplex.EventBacklogSize)
+				if err == nil && len(events) == 0 {
+					if id, _ := btcplex.GetEventId(pool, redischannel, string(v.Data)); id != 0 {
+						continue
+					}
+				}
+				if err != nil || len(events) == 0 {
+					// Published without an id
+					events = []*btcplex.Event{{Data: string(v.Data)}}
+				}
+				h1 := psgroup.Join()
+				for _, event := range events {
+					h1.Send(event)
+					if event.Id > lastid {
+						lastid = event.Id
+					}
+				}
+				h1.Close()
+			case error:
+				log.Printf("Redis subscription error on %v: %v", redischannel, v)
+				break receive
+			}
 		}
+		conn.Close()
+		time.Sleep(time.Second)
 	}
 }
 
@@ -140,6 +236,202 @@ func N(n int) []struct{} {
 	return make([]struct{}, n)
 }
 
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Check if the string looks like a block/transaction hash
+func isHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Split and validate a list of addresses separated by "|" or ","
+func splitAddresses(fields []string) (addresses []string, err error) {
+	addresses = []string{}
+	for _, field := range fields {
+		for _, address := range strings.FieldsFunc(field, func(c rune) bool { return c == '|' || c == ',' }) {
+			address = strings.TrimSpace(address)
+			if isaddress, _ := btcplex.IsAddress(address); !isaddress {
+				return nil, fmt.Errorf("Invalid address %v", address)
+			}
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) == 0 || len(addresses) > btcplex.MaxMultiAddresses {
+		return nil, fmt.Errorf("Between 1 and %v addresses are required", btcplex.MaxMultiAddresses)
+	}
+	return
+}
+
+// Return the HTTP status code and message for an error returned by btcplex
+func errorStatus(err error, notfound string) (int, string) {
+	if err == btcplex.ErrNotFound {
+		return 404, notfound
+	}
+	return 500, "Internal server error"
+}
+
+// Parse the cursor and limit parameters of the v2 API
+func cursorParams(req *http.Request) (cursor *btcplex.Cursor, limit int, err error) {
+	limit = txperpage
+	if req.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(req.URL.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > maxlimit {
+			err = fmt.Errorf("Limit must be between 1 and %v", maxlimit)
+			return
+		}
+	}
+	if req.URL.Query().Get("cursor") != "" {
+		cursor, err = btcplex.ParseCursor(req.URL.Query().Get("cursor"))
+	}
+	return
+}
+
+// Blockchain.info JSON format, requested with ?format=blockchain or the raw* paths
+func blockchainFormat(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "blockchain" || strings.HasPrefix(req.URL.Path, "/api/raw")
+}
+
+// Blockchain.info style limit (default 50) and offset
+func limitOffsetParams(req *http.Request) (limit, offset int, err error) {
+	limit = 50
+	if req.URL.Query().Get("limit") != "" {
+		limit, err = strconv.Atoi(req.URL.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > maxlimit {
+			err = fmt.Errorf("Limit must be between 1 and %v", maxlimit)
+			return
+		}
+	}
+	if req.URL.Query().Get("offset") != "" {
+		offset, err = strconv.Atoi(req.URL.Query().Get("offset"))
+		if err != nil || offset < 0 {
+			err = fmt.Errorf("Invalid offset")
+			return
+		}
+	}
+	return
+}
+
+// Insight style from (included, default 0) and to (excluded, default from+count) range
+func insightRange(fromparam, toparam string, count, maxcount int) (from, to int, err error) {
+	if fromparam != "" {
+		from, err = strconv.Atoi(fromparam)
+		if err != nil || from < 0 {
+			return 0, 0, fmt.Errorf("Invalid from")
+		}
+	}
+	to = from + count
+	if toparam != "" {
+		to, err = strconv.Atoi(toparam)
+		if err != nil || to <= from {
+			return 0, 0, fmt.Errorf("Invalid to")
+		}
+	}
+	if to-from > maxcount || to > insightmaxitems {
+		return 0, 0, fmt.Errorf("At most %v items up to %v can be requested", maxcount, insightmaxitems)
+	}
+	return
+}
+
+// Number of blocks of the query API averages
+func recentBlocksParam(params martini.Params) (blocks int, err error) {
+	blocks = btcplex.DefaultRecentBlocks
+	if params["blocks"] != "" {
+		blocks, err = strconv.Atoi(params["blocks"])
+		if err != nil || blocks < 1 || blocks > btcplex.MaxRecentBlocks {
+			err = fmt.Errorf("Number of blocks must be between 1 and %v", btcplex.MaxRecentBlocks)
+		}
+	}
+	return
+}
+
+// Parse the address history filters, see docs/api_rest.md
+func addressTxsFilter(req *http.Request) (filter *btcplex.AddressTxsFilter, err error) {
+	query := req.URL.Query()
+	filter = &btcplex.AddressTxsFilter{Direction: query.Get("direction")}
+	switch filter.Direction {
+	case "", "all", "sent", "received":
+	default:
+		err = fmt.Errorf("Direction must be sent, received or all")
+		return
+	}
+	switch query.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Asc = true
+	default:
+		err = fmt.Errorf("Order must be asc or desc")
+		return
+	}
+	params := []struct {
+		name string
+		bits int
+		set  func(uint64)
+	}{
+		{"from_time", 32, func(v uint64) { filter.FromTime = uint32(v) }},
+		{"to_time", 32, func(v uint64) { filter.ToTime = uint32(v) }},
+		{"from_height", 0, func(v uint64) { filter.FromHeight = uint(v) }},
+		{"to_height", 0, func(v uint64) { filter.ToHeight = uint(v) }},
+		{"min_value", 64, func(v uint64) { filter.MinValue = v }},
+	}
+	for _, param := range params {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		v, perr := strconv.ParseUint(query.Get(param.name), 10, param.bits)
+		if perr != nil {
+			err = fmt.Errorf("Invalid %v", param.name)
+			return
+		}
+		param.set(v)
+	}
+	return
+}
+
+// HATEOAS links for a v2 API page, the next link keeps the query parameters
+func cursorLinks(req *http.Request, path string, next *btcplex.Cursor) map[string]map[string]string {
+	links := initHATEOAS(nil, req)
+	if next != nil {
+		query := req.URL.Query()
+		query.Set("cursor", next.String())
+		links = addHATEOAS(links, "next", fmt.Sprintf("%v%v?%v", conf.AppUrl, path, query.Encode()))
+	}
+	return links
+}
+
+// The next cursor is null on the last page
+func cursorString(next *btcplex.Cursor) interface{} {
+	if next == nil {
+		return nil
+	}
+	return next.String()
+}
+
+// Number of rich list pages, only the top addresses are listed
+func richListPages(fundedcnt int) int {
+	if fundedcnt > btcplex.MaxRichListSize {
+		fundedcnt = btcplex.MaxRichListSize
+	}
+	return int(math.Ceil(float64(fundedcnt) / float64(richperpage)))
+}
+
+func renderAPIError(r render.Render, rid requestId, code int, message string) {
+	r.JSON(code, &apiError{Code: code, Message: message, RequestId: string(rid)})
+}
+
+func renderErrorPage(r render.Render, pm *pageMeta, code int, message string) {
+	pm.Title = http.StatusText(code)
+	pm.Error = message
+	pm.Analytics = conf.AppGoogleAnalytics
+	r.HTML(code, "error", pm)
+}
+
 func main() {
 	var err error
 	var latestheight, latestheightcache int
@@ -182,6 +474,7 @@ Options:
 	if err != nil {
 		log.Fatalf("Can't connect to SSDB: %v\n", err)
 	}
+	btcplex.UnconfirmedSpentPool = pool
 
 	// Setup some pubsub:
 
@@ -200,7 +493,7 @@ Options:
 	// also track the status/check if BTCplex goes out of sync
 	latestheightticker := time.NewTicker(1 * time.Second)
 	checkinprogress := false
-	bitcoindheight := btcplex.GetBlockCountRPC(conf)
+	bitcoindheight, _ := btcplex.GetBlockCountRPC(conf)
 	btcplexsynced := true
 	go func(pool *redis.Pool, latestheight *int) {
 		c := pool.Get()
@@ -215,7 +508,10 @@ Options:
 				latestheightcache = *latestheight
 			}
 
-			bitcoindheight = btcplex.GetBlockCountRPC(conf)
+			// Keep the last known height while bitcoind is unreachable
+			if height, err := btcplex.GetBlockCountRPC(conf); err == nil {
+				bitcoindheight = height
+			}
 			if uint(latestheightcache) != bitcoindheight && !checkinprogress && btcplexsynced {
 				checkinprogress = true
 				go func(checkinprogress *bool) {
@@ -251,6 +547,11 @@ Options:
 	go bcastToRedisPubSub(pool, utxgroup, "btcplex:utxs")
 	// TODO Ticker for utxs count => events_unconfirmed
 
+	// PubSub channel for double spends detected in the memory pool
+	doublespendgroup := bcast.NewGroup()
+	go doublespendgroup.Broadcasting(0)
+	go bcastToRedisPubSub(pool, doublespendgroup, "btcplex:doublespends")
+
 	newblockgroup := bcast.NewGroup()
 	go newblockgroup.Broadcasting(0)
 	go bcastToRedisPubSub(pool, newblockgroup, "btcplex:newblock")
@@ -258,6 +559,10 @@ Options:
 	btcplexsyncedgroup := bcast.NewGroup()
 	go btcplexsyncedgroup.Broadcasting(0)
 
+	// Single Redis subscriber shared by the WebSocket connections and the address SSE streams
+	hub := btcplex.NewHub(pool)
+	go hub.Run()
+
 	// Go template helper
 	appHelpers := template.FuncMap{
 		"cut": func(addr string, length int) string {
@@ -333,17 +638,38 @@ Options:
 		Funcs:     []template.FuncMap{appHelpers},
 	}))
 
+	// Reuse the request id set by the reverse proxy, or generate a new one
+	m.Use(func(res http.ResponseWriter, req *http.Request, c martini.Context) {
+		rid := req.Header.Get("X-Request-Id")
+		if rid == "" {
+			rid = newRequestId()
+		}
+		res.Header().Set("X-Request-Id", rid)
+		c.Map(requestId(rid))
+	})
+
+	// Index backed API calls fail with 503 when BTCplex is out of sync
+	indexSynced := func(r render.Render, rid requestId) {
+		if !btcplexsynced {
+			renderAPIError(r, rid, 503, "Index out of sync with bitcoind")
+		}
+	}
+
 	// We rate limit the API if enabled in the config
 	if conf.AppApiRateLimited {
-		m.Use(func(res http.ResponseWriter, req *http.Request, rediswrapper *RedisWrapper, log *log.Logger) {
+		m.Use(func(res http.ResponseWriter, req *http.Request, r render.Render, rid requestId, rediswrapper *RedisWrapper, log *log.Logger) {
 			remoteIP := strings.Split(req.RemoteAddr, ":")[0]
 			_, xforwardedfor := req.Header["X-Forwarded-For"]
 			if xforwardedfor {
 				remoteIP = req.Header["X-Forwarded-For"][1]
 			}
 			log.Printf("R:%v\nip:%+v\n", time.Now(), remoteIP)
-			if strings.Contains(req.RequestURI, "/api/") {
-				ratelimited, cnt, reset := rateLimited(rediswrapper, remoteIP)
+			if strings.Contains(req.RequestURI, "/api/") || strings.HasPrefix(req.URL.Path, insightprefix+"/") || req.URL.Path == "/graphql" {
+				cost := 1
+				if strings.HasPrefix(req.URL.Path, "/api/xpub/") {
+					cost = ratelimitxpubcost
+				}
+				ratelimited, cnt, reset := rateLimited(rediswrapper, remoteIP, cost)
 				// Set X-RateLimit-* Header
 				res.Header().Set("X-RateLimit-Limit", strconv.Itoa(ratelimitcnt))
 				res.Header().Set("X-RateLimit-Remaining", strconv.Itoa(ratelimitcnt-cnt))
@@ -353,7 +679,7 @@ Options:
 				res.Header().Set("Access-Control-Allow-Origin", "*")
 
 				if ratelimited {
-					res.WriteHeader(429)
+					renderAPIError(r, rid, 429, "Rate limit exceeded")
 				}
 			}
 		})
@@ -390,12 +716,22 @@ Options:
 		r.HTML(200, "blocks", &pm)
 	})
 
-	m.Get("/block/:hash", func(params martini.Params, r render.Render, db *redis.Pool) {
+	m.Get("/block/:hash", func(params martini.Params, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
 		pm.LastHeight = uint(latestheight)
-		block, _ := btcplex.GetBlockCachedByHash(db, params["hash"])
+		if !isHash(params["hash"]) {
+			renderErrorPage(r, pm, 400, "Malformed block hash")
+			return
+		}
+		block, err := btcplex.GetBlockCachedByHash(db, params["hash"])
+		if err != nil {
+			code, message := errorStatus(err, "Block not found")
+			renderErrorPage(r, pm, code, message)
+			return
+		}
 		block.FetchMeta(db)
+		block.FetchUnconfirmedSpent(rdb.Pool)
 		btcplex.By(btcplex.TxIndex).Sort(block.Txs)
 		pm.Block = block
 		pm.Title = fmt.Sprintf("Bitcoin block #%v", block.Height)
@@ -404,10 +740,24 @@ Options:
 		r.HTML(200, "block", &pm)
 	})
 
-	m.Get("/api/block/:hash", func(params martini.Params, r render.Render, db *redis.Pool, req *http.Request) {
-		block, _ := btcplex.GetBlockCachedByHash(db, params["hash"])
+	apiBlock := func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		if !isHash(params["hash"]) {
+			renderAPIError(r, rid, 400, "Malformed block hash")
+			return
+		}
+		block, err := btcplex.GetBlockCachedByHash(db, params["hash"])
+		if err != nil {
+			code, message := errorStatus(err, "Block not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
 		block.FetchMeta(db)
+		block.FetchUnconfirmedSpent(rdb.Pool)
 		btcplex.By(btcplex.TxIndex).Sort(block.Txs)
+		if blockchainFormat(req) {
+			r.JSON(200, btcplex.NewBlockchainBlock(block))
+			return
+		}
 		block.Links = initHATEOAS(block.Links, req)
 		if block.Parent != "" {
 			block.Links = addHATEOAS(block.Links, "previous_block", fmt.Sprintf("%v/api/block/%v", conf.AppUrl, block.Parent))
@@ -416,35 +766,245 @@ Options:
 			block.Links = addHATEOAS(block.Links, "next_block", fmt.Sprintf("%v/api/block/%v", conf.AppUrl, block.Next))
 		}
 		r.JSON(200, block)
-	})
+	}
+	m.Get("/api/block/:hash", indexSynced, apiBlock)
+	m.Get("/api/rawblock/:hash", indexSynced, apiBlock)
 
 	m.Get("/unconfirmed-transactions", func(params martini.Params, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
-		//rpool := rdb.Pool
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
 		pm.LastHeight = uint(latestheight)
 		pm.Menu = "utxs"
 		pm.Title = "Unconfirmed transactions"
 		pm.Description = "Transactions waiting to be included in a Bitcoin block, updated in real time."
-		//utxs, _ := btcplex.GetUnconfirmedTxs(rpool)
-		pm.Txs = &[]*btcplex.Tx{}
+		utxs, _ := btcplex.GetUnconfirmedTxs(rdb.Pool, "time", 0, 49)
+		pm.Txs = &utxs
 		pm.Analytics = conf.AppGoogleAnalytics
 		r.HTML(200, "unconfirmed-transactions", &pm)
 	})
 
+	m.Get("/api/mempool", func(r render.Render, rid requestId, rdb *RedisWrapper, req *http.Request) {
+		sortby := req.URL.Query().Get("sort")
+		if sortby != "feerate" {
+			sortby = "time"
+		}
+		info, _ := btcplex.GetMempoolInfo(rdb.Pool)
+		lastPage := int(math.Ceil(float64(info.TxCnt) / float64(txperpage)))
+		currentPageStr := req.URL.Query().Get("page")
+		if currentPageStr == "" {
+			currentPageStr = "1"
+		}
+		currentPage, err := strconv.Atoi(currentPageStr)
+		if err != nil || currentPage < 1 {
+			renderAPIError(r, rid, 400, "Invalid page")
+			return
+		}
+		utxs, _ := btcplex.GetUnconfirmedTxs(rdb.Pool, sortby, txperpage*(currentPage-1), txperpage*currentPage-1)
+		// HATEOS section
+		links := initHATEOAS(nil, req)
+		pageurl := "%v/api/mempool?sort=%v&page=%v"
+		if currentPage < lastPage {
+			links = addHATEOAS(links, "last", fmt.Sprintf(pageurl, conf.AppUrl, sortby, lastPage))
+			links = addHATEOAS(links, "next", fmt.Sprintf(pageurl, conf.AppUrl, sortby, currentPage+1))
+		}
+		if currentPage > 1 {
+			links = addHATEOAS(links, "previous", fmt.Sprintf(pageurl, conf.AppUrl, sortby, currentPage-1))
+		}
+		r.JSON(200, map[string]interface{}{"n_tx": info.TxCnt, "size": info.Size, "txs": utxs, "_links": links})
+	})
+
+	m.Get("/api/richlist", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		fundedcnt, err := btcplex.GetFundedAddressesCount(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		lastPage := richListPages(fundedcnt)
+		currentPageStr := req.URL.Query().Get("page")
+		if currentPageStr == "" {
+			currentPageStr = "1"
+		}
+		currentPage, err := strconv.Atoi(currentPageStr)
+		if err != nil || currentPage < 1 || (currentPage > lastPage && currentPage > 1) {
+			renderAPIError(r, rid, 400, "Invalid page")
+			return
+		}
+		richlist, err := btcplex.GetRichList(db, richperpage*(currentPage-1), richperpage*currentPage-1)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		// HATEOS section
+		links := initHATEOAS(nil, req)
+		pageurl := "%v/api/richlist?page=%v"
+		if currentPage < lastPage {
+			links = addHATEOAS(links, "last", fmt.Sprintf(pageurl, conf.AppUrl, lastPage))
+			links = addHATEOAS(links, "next", fmt.Sprintf(pageurl, conf.AppUrl, currentPage+1))
+		}
+		if currentPage > 1 {
+			links = addHATEOAS(links, "previous", fmt.Sprintf(pageurl, conf.AppUrl, currentPage-1))
+		}
+		links = addHATEOAS(links, "distribution", fmt.Sprintf("%v/api/richlist/distribution", conf.AppUrl))
+		r.JSON(200, map[string]interface{}{"funded_addresses": fundedcnt, "addresses": richlist, "_links": links})
+	})
+
+	m.Get("/api/richlist/distribution", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		distribution, err := btcplex.GetDistribution(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, map[string]interface{}{"buckets": distribution, "_links": initHATEOAS(nil, req)})
+	})
+
+	m.Get("/api/charts/:metric", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		metric, ok := btcplex.ChartMetrics[params["metric"]]
+		if !ok {
+			renderAPIError(r, rid, 404, "Unknown metric")
+			return
+		}
+		rangeparam := req.URL.Query().Get("range")
+		if rangeparam == "" {
+			rangeparam = "30d"
+		}
+		period, ok := btcplex.ChartRanges[rangeparam]
+		if !ok {
+			renderAPIError(r, rid, 400, "Invalid range")
+			return
+		}
+		resolution := req.URL.Query().Get("resolution")
+		if resolution == "" {
+			resolution = "day"
+		}
+		to := time.Now().UTC().Unix()
+		from := int64(0)
+		if period > 0 {
+			from = to - period
+		}
+		points, err := btcplex.GetChart(db, params["metric"], resolution, from, to)
+		if err == btcplex.ErrUnknownResolution || err == btcplex.ErrChartRangeTooLarge {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, map[string]interface{}{"metric": params["metric"], "unit": metric.Unit, "range": rangeparam,
+			"resolution": resolution, "values": points, "_links": initHATEOAS(nil, req)})
+	})
+
+	m.Get("/api/emission", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		info, err := btcplex.GetSupplyInfo(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		info.Links = initHATEOAS(info.Links, req)
+		info.Links = addHATEOAS(info.Links, "underclaimed", fmt.Sprintf("%v/api/emission/underclaimed", conf.AppUrl))
+		r.JSON(200, info)
+	})
+
+	m.Get("/api/emission/underclaimed", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		blocks, next, err := btcplex.GetUnderclaimedBlocks(db, cursor, limit)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, map[string]interface{}{"blocks": blocks, "next_cursor": cursorString(next), "_links": cursorLinks(req, "/api/emission/underclaimed", next)})
+	})
+
+	m.Get("/api/chaintips", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		tips, err := btcplex.GetChainTips(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, map[string]interface{}{"tips": tips, "_links": initHATEOAS(nil, req)})
+	})
+
+	m.Get("/api/mempool/info", func(r render.Render, rdb *RedisWrapper, req *http.Request) {
+		info, _ := btcplex.GetMempoolInfo(rdb.Pool)
+		info.Links = initHATEOAS(info.Links, req)
+		info.Links = addHATEOAS(info.Links, "histogram", fmt.Sprintf("%v/api/mempool/histogram", conf.AppUrl))
+		info.Links = addHATEOAS(info.Links, "txs", fmt.Sprintf("%v/api/mempool", conf.AppUrl))
+		r.JSON(200, info)
+	})
+
+	m.Get("/api/mempool/histogram", func(r render.Render, rdb *RedisWrapper) {
+		histogram, _ := btcplex.GetMempoolHistogram(rdb.Pool)
+		r.JSON(200, histogram)
+	})
+
+	m.Get("/api/mempool/address/:address", func(params martini.Params, r render.Render, rid requestId, rdb *RedisWrapper, req *http.Request) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		utxs, _ := btcplex.GetUnconfirmedTxsByAddress(rdb.Pool, params["address"])
+		links := initHATEOAS(nil, req)
+		links = addHATEOAS(links, "address", fmt.Sprintf("%v/api/address/%v", conf.AppUrl, params["address"]))
+		r.JSON(200, map[string]interface{}{"address": params["address"], "n_tx": len(utxs), "txs": utxs, "_links": links})
+	})
+
+	m.Get("/api/fees/estimate", func(r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		var blocks uint64
+		if req.URL.Query().Get("blocks") != "" {
+			var err error
+			blocks, err = strconv.ParseUint(req.URL.Query().Get("blocks"), 10, 0)
+			if err != nil || blocks == 0 || blocks > btcplex.FeeHistoryBlocks {
+				renderAPIError(r, rid, 400, "Invalid blocks")
+				return
+			}
+		}
+		history, _ := btcplex.GetFeeHistory(db)
+		feerates, sizes, _ := btcplex.GetMempoolFeeRates(rdb.Pool)
+		estimate := btcplex.EstimateFee(history, feerates, sizes, uint(blocks))
+		estimate.Links = initHATEOAS(estimate.Links, req)
+		estimate.Links = addHATEOAS(estimate.Links, "recommended", fmt.Sprintf("%v/api/fees/recommended", conf.AppUrl))
+		r.JSON(200, estimate)
+	})
+
+	m.Get("/api/fees/recommended", func(r render.Render, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		history, _ := btcplex.GetFeeHistory(db)
+		feerates, sizes, _ := btcplex.GetMempoolFeeRates(rdb.Pool)
+		fees := btcplex.RecommendFees(history, feerates, sizes)
+		fees.Links = initHATEOAS(fees.Links, req)
+		r.JSON(200, fees)
+	})
+
 	m.Get("/tx/:hash", func(params martini.Params, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
 		var tx *btcplex.Tx
+		var err error
 		rpool := rdb.Pool
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
 		pm.LastHeight = uint(latestheight)
+		if !isHash(params["hash"]) {
+			renderErrorPage(r, pm, 400, "Malformed transaction hash")
+			return
+		}
 		isutx, _ := btcplex.IsUnconfirmedTx(rpool, params["hash"])
 		if isutx {
 			pm.TxUnconfirmed = true
-			tx, _ = btcplex.GetUnconfirmedTx(rpool, params["hash"])
+			tx, err = btcplex.GetUnconfirmedTx(rpool, params["hash"])
 		} else {
-			tx, _ = btcplex.GetTx(db, params["hash"])
-			tx.Build(db)
+			tx, err = btcplex.GetTx(db, params["hash"])
+		}
+		if err != nil {
+			code, message := errorStatus(err, "Transaction not found")
+			renderErrorPage(r, pm, code, message)
+			return
+		}
+		tx.FetchConflicts(rpool)
+		// Confirmed txs outputs are marked by Build
+		if isutx {
+			tx.FetchUnconfirmedSpent(rpool)
 		}
 		pm.Tx = tx
 		pm.Title = fmt.Sprintf("Bitcoin transaction %v", tx.Hash)
@@ -452,32 +1012,62 @@ Options:
 		pm.Analytics = conf.AppGoogleAnalytics
 		r.HTML(200, "tx", pm)
 	})
-	m.Get("/api/tx/:hash", func(params martini.Params, r render.Render, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+	apiTx := func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
 		var tx *btcplex.Tx
+		var err error
 		rpool := rdb.Pool
+		if !isHash(params["hash"]) {
+			renderAPIError(r, rid, 400, "Malformed transaction hash")
+			return
+		}
 		isutx, _ := btcplex.IsUnconfirmedTx(rpool, params["hash"])
 		if isutx {
-			tx, _ = btcplex.GetUnconfirmedTx(rpool, params["hash"])
+			tx, err = btcplex.GetUnconfirmedTx(rpool, params["hash"])
 		} else {
-			tx, _ = btcplex.GetTx(db, params["hash"])
-			tx.Build(db)
+			tx, err = btcplex.GetTx(db, params["hash"])
+		}
+		if err != nil {
+			code, message := errorStatus(err, "Transaction not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
+		tx.FetchConflicts(rpool)
+		// Confirmed txs outputs are marked by Build
+		if isutx {
+			tx.FetchUnconfirmedSpent(rpool)
+		}
+		if blockchainFormat(req) {
+			r.JSON(200, btcplex.NewBlockchainTx(tx))
+			return
 		}
 		tx.Links = initHATEOAS(tx.Links, req)
 		if tx.BlockHash != "" {
 			tx.Links = addHATEOAS(tx.Links, "block", fmt.Sprintf("%v/api/block/%v", conf.AppUrl, tx.BlockHash))
 		}
 		r.JSON(200, tx)
-	})
+	}
+	m.Get("/api/tx/:hash", indexSynced, apiTx)
+	m.Get("/api/rawtx/:hash", indexSynced, apiTx)
 
-	m.Get("/address/:address", func(params martini.Params, r render.Render, db *redis.Pool, req *http.Request) {
+	m.Get("/address/:address", func(params martini.Params, r render.Render, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
 		pm.LastHeight = uint(latestheight)
 		pm.PaginationData = new(PaginationData)
 		pm.Title = fmt.Sprintf("Bitcoin address %v", params["address"])
 		pm.Description = fmt.Sprintf("Transactions and summary for the Bitcoin address %v.", params["address"])
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderErrorPage(r, pm, 400, "Invalid address")
+			return
+		}
 		// AddressData
-		addressdata, _ := btcplex.GetAddress(db, params["address"])
+		addressdata, err := btcplex.GetAddress(db, params["address"])
+		if err != nil {
+			code, message := errorStatus(err, "Address not found")
+			renderErrorPage(r, pm, code, message)
+			return
+		}
+		addressdata.FetchUnconfirmed(rdb.Pool)
 		pm.AddressData = addressdata
 		// Pagination
 		d := float64(addressdata.TxCnt) / float64(txperpage)
@@ -501,14 +1091,41 @@ Options:
 		addressdata.FetchTxs(db, txperpage*(pm.PaginationData.CurrentPage-1), txperpage*pm.PaginationData.CurrentPage)
 		r.HTML(200, "address", pm)
 	})
-	m.Get("/api/address/:address", func(params martini.Params, r render.Render, db *redis.Pool, req *http.Request) {
-		addressdata, _ := btcplex.GetAddress(db, params["address"])
+	apiAddress := func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		addressdata, err := btcplex.GetAddress(db, params["address"])
+		if err != nil {
+			code, message := errorStatus(err, "Address not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
+		if blockchainFormat(req) {
+			limit, offset, err := limitOffsetParams(req)
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			if err := addressdata.FetchTxs(db, offset, offset+limit-1); err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, btcplex.NewBlockchainAddress(addressdata))
+			return
+		}
+		addressdata.FetchUnconfirmed(rdb.Pool)
 		lastPage := int(math.Ceil(float64(addressdata.TxCnt) / float64(txperpage)))
 		currentPageStr := req.URL.Query().Get("page")
 		if currentPageStr == "" {
 			currentPageStr = "1"
 		}
-		currentPage, _ := strconv.Atoi(currentPageStr)
+		currentPage, err := strconv.Atoi(currentPageStr)
+		if err != nil || currentPage < 1 {
+			renderAPIError(r, rid, 400, "Invalid page")
+			return
+		}
 		// HATEOS section
 		addressdata.Links = initHATEOAS(addressdata.Links, req)
 		pageurl := "%v/api/address/%v?page=%v"
@@ -519,8 +1136,202 @@ Options:
 		if currentPage > 1 {
 			addressdata.Links = addHATEOAS(addressdata.Links, "previous", fmt.Sprintf(pageurl, conf.AppUrl, params["address"], currentPage-1))
 		}
-		addressdata.FetchTxs(db, txperpage*(currentPage-1), txperpage*currentPage)
+		if err := addressdata.FetchTxs(db, txperpage*(currentPage-1), txperpage*currentPage); err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
 		r.JSON(200, addressdata)
+	}
+	m.Get("/api/address/:address", indexSynced, apiAddress)
+	m.Get("/api/rawaddr/:address", indexSynced, apiAddress)
+
+	m.Get("/api/address/:address/txs", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		filter, err := addressTxsFilter(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		addressdata := &btcplex.AddressData{Address: params["address"]}
+		next, err := addressdata.FetchFilteredTxs(db, filter, cursor, limit)
+		if err != nil {
+			// from_height past the latest block
+			code, message := errorStatus(err, "Block not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
+		links := cursorLinks(req, fmt.Sprintf("/api/address/%v/txs", params["address"]), next)
+		links = addHATEOAS(links, "address", fmt.Sprintf("%v/api/address/%v", conf.AppUrl, params["address"]))
+		r.JSON(200, map[string]interface{}{"address": params["address"], "txs": addressdata.Txs, "next_cursor": cursorString(next), "_links": links})
+	})
+
+	m.Post("/api/multiaddr", indexSynced, binding.Bind(multiAddrForm{}), func(form multiAddrForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		if len(errs.Overall)+len(errs.Fields) > 0 {
+			renderAPIError(r, rid, 400, "Malformed request body")
+			return
+		}
+		addresses, err := splitAddresses(form.Addresses)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		multi, next, err := btcplex.GetMultiAddress(db, addresses, cursor, limit)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		for _, addressdata := range multi.Addresses {
+			addressdata.FetchUnconfirmed(rdb.Pool)
+		}
+		r.JSON(200, map[string]interface{}{"addresses": multi.Addresses, "total_received": multi.TotalReceived, "total_sent": multi.TotalSent,
+			"final_balance": multi.FinalBalance, "txs": multi.Txs, "next_cursor": cursorString(next), "_links": initHATEOAS(nil, req)})
+	})
+
+	m.Get("/api/xpub/:xpub", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		gap := btcplex.DefaultGapLimit
+		if req.URL.Query().Get("gap") != "" {
+			var err error
+			gap, err = strconv.Atoi(req.URL.Query().Get("gap"))
+			if err != nil || gap < 1 || gap > btcplex.MaxGapLimit {
+				renderAPIError(r, rid, 400, fmt.Sprintf("Gap must be between 1 and %v", btcplex.MaxGapLimit))
+				return
+			}
+		}
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		xpubdata, err := btcplex.ScanXpub(rdb.Pool, db, params["xpub"], gap, cursor, limit)
+		if err == btcplex.ErrInvalidXpub {
+			renderAPIError(r, rid, 400, "Invalid extended public key")
+			return
+		}
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		xpubdata.Links = cursorLinks(req, fmt.Sprintf("/api/xpub/%v", params["xpub"]), xpubdata.NextCursor)
+		r.JSON(200, xpubdata)
+	})
+
+	m.Post("/api/pushtx", indexSynced, binding.Bind(rawTxForm{}), func(form rawTxForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+		if len(errs.Overall)+len(errs.Fields) > 0 {
+			renderAPIError(r, rid, 400, "Malformed request body")
+			return
+		}
+		rawtx := form.rawTx()
+		if rawtx == "" {
+			renderAPIError(r, rid, 400, "Missing raw transaction")
+			return
+		}
+		tx, err := btcplex.PushTx(conf, rdb.Pool, db, rawtx)
+		if pusherr, rejected := err.(*btcplex.PushTxError); rejected {
+			r.JSON(400, &apiError{Code: 400, Message: pusherr.Message, RequestId: string(rid), Reason: pusherr.Reason})
+			return
+		}
+		if err != nil {
+			log.Printf("Error broadcasting transaction: %v", err)
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, tx)
+	})
+
+	// Decoded locally, so transactions not broadcast yet can be inspected
+	decodeRawTx := func(form rawTxForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+		if len(errs.Overall)+len(errs.Fields) > 0 {
+			renderAPIError(r, rid, 400, "Malformed request body")
+			return
+		}
+		dtx, err := btcplex.DecodeTx(rdb.Pool, db, form.rawTx())
+		if err == btcplex.ErrInvalidRawTx {
+			renderAPIError(r, rid, 400, "Invalid raw transaction")
+			return
+		}
+		if err == btcplex.ErrRawTxTooLarge {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, dtx)
+	}
+	// Hex encoded, with some room for the form or JSON encoding
+	limitRawTxBody := func(w http.ResponseWriter, req *http.Request) {
+		req.Body = http.MaxBytesReader(w, req.Body, 2*btcplex.MaxRawTxSize+1024)
+	}
+	m.Get("/api/decoderawtransaction", limitRawTxBody, binding.Bind(rawTxForm{}), decodeRawTx)
+	m.Post("/api/decoderawtransaction", limitRawTxBody, binding.Bind(rawTxForm{}), decodeRawTx)
+
+	// API v2, paginated with cursors (v1 endpoints are kept unchanged)
+	m.Get("/api/v2/blocks", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		blocks, next, err := btcplex.GetBlocksFromCursor(db, cursor, limit)
+		if err != nil {
+			code, message := errorStatus(err, "Block not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
+		r.JSON(200, map[string]interface{}{"blocks": blocks, "next_cursor": cursorString(next), "_links": cursorLinks(req, "/api/v2/blocks", next)})
+	})
+
+	m.Get("/api/v2/address/:address/txs", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		addressdata := &btcplex.AddressData{Address: params["address"]}
+		next, err := addressdata.FetchTxsFromCursor(db, cursor, limit)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		links := cursorLinks(req, fmt.Sprintf("/api/v2/address/%v/txs", params["address"]), next)
+		links = addHATEOAS(links, "address", fmt.Sprintf("%v/api/address/%v", conf.AppUrl, params["address"]))
+		r.JSON(200, map[string]interface{}{"address": params["address"], "txs": addressdata.Txs, "next_cursor": cursorString(next), "_links": links})
+	})
+
+	m.Get("/api/v2/mempool", func(r render.Render, rid requestId, rdb *RedisWrapper, req *http.Request) {
+		sortby := req.URL.Query().Get("sort")
+		if sortby != "feerate" {
+			sortby = "time"
+		}
+		cursor, limit, err := cursorParams(req)
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		utxs, next, err := btcplex.GetUnconfirmedTxsFromCursor(rdb.Pool, sortby, cursor, limit)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, map[string]interface{}{"txs": utxs, "next_cursor": cursorString(next), "_links": cursorLinks(req, "/api/v2/mempool", next)})
 	})
 
 	m.Get("/about", func(r render.Render) {
@@ -534,25 +1345,97 @@ Options:
 		r.HTML(200, "about", pm)
 	})
 
-	m.Get("/status", func(r render.Render) {
+	m.Get("/richlist", func(r render.Render, db *redis.Pool, req *http.Request) {
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
 		pm.LastHeight = uint(latestheight)
-		pm.Title = "Status"
-		pm.Description = "BTCplex status page."
-		pm.Menu = "status"
+		pm.Title = "Rich list"
+		pm.Description = "Top addresses by balance and coins distribution."
+		pm.Menu = "richlist"
 		pm.Analytics = conf.AppGoogleAnalytics
-		btcplexinfo, _ := btcplex.GetInfoRPC(conf)
-		pm.BitcoindInfo = btcplexinfo
-		r.HTML(200, "status", pm)
+		pm.FundedCnt, _ = btcplex.GetFundedAddressesCount(db)
+		pm.PaginationData = new(PaginationData)
+		pm.PaginationData.MaxPage = richListPages(pm.FundedCnt)
+		pm.PaginationData.CurrentPage, _ = strconv.Atoi(req.URL.Query().Get("page"))
+		if pm.PaginationData.CurrentPage < 1 {
+			pm.PaginationData.CurrentPage = 1
+		}
+		if pm.PaginationData.CurrentPage > pm.PaginationData.MaxPage && pm.PaginationData.CurrentPage > 1 {
+			renderErrorPage(r, pm, 404, "Page not found")
+			return
+		}
+		pm.PaginationData.Pages = N(pm.PaginationData.MaxPage)
+		if pm.PaginationData.CurrentPage > 1 {
+			pm.PaginationData.Prev = pm.PaginationData.CurrentPage - 1
+		}
+		if pm.PaginationData.CurrentPage < pm.PaginationData.MaxPage {
+			pm.PaginationData.Next = pm.PaginationData.CurrentPage + 1
+		}
+		var err error
+		pm.RichList, err = btcplex.GetRichList(db, richperpage*(pm.PaginationData.CurrentPage-1), richperpage*pm.PaginationData.CurrentPage-1)
+		if err == nil {
+			pm.Distribution, err = btcplex.GetDistribution(db)
+		}
+		if err != nil {
+			renderErrorPage(r, pm, 500, "Internal server error")
+			return
+		}
+		r.HTML(200, "richlist", pm)
 	})
 
-	m.Post("/search", binding.Form(searchForm{}), binding.ErrorHandler, func(search searchForm, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
-		rpool := rdb.Pool
+	m.Get("/decode", func(r render.Render) {
 		pm := new(pageMeta)
 		pm.BtcplexSynced = btcplexsynced
-		// Check if the query isa block height
-		isblockheight, hash := btcplex.IsBlockHeight(db, search.Query)
+		pm.LastHeight = uint(latestheight)
+		pm.Title = "Decode transaction"
+		pm.Description = "Decode a raw Bitcoin transaction."
+		pm.Menu = "decode"
+		pm.Analytics = conf.AppGoogleAnalytics
+		r.HTML(200, "decode", pm)
+	})
+
+	m.Post("/decode", limitRawTxBody, binding.Form(rawTxForm{}), binding.ErrorHandler, func(form rawTxForm, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
+		pm := new(pageMeta)
+		pm.BtcplexSynced = btcplexsynced
+		pm.LastHeight = uint(latestheight)
+		pm.Title = "Decode transaction"
+		pm.Description = "Decode a raw Bitcoin transaction."
+		pm.Menu = "decode"
+		pm.Analytics = conf.AppGoogleAnalytics
+		pm.RawTx = form.rawTx()
+		dtx, err := btcplex.DecodeTx(rdb.Pool, db, pm.RawTx)
+		switch {
+		case err == btcplex.ErrInvalidRawTx:
+			pm.Error = "Invalid raw transaction"
+		case err == btcplex.ErrRawTxTooLarge:
+			pm.Error = err.Error()
+		case err != nil:
+			renderErrorPage(r, pm, 500, "Internal server error")
+			return
+		}
+		pm.DecodedTx = dtx
+		r.HTML(200, "decode", pm)
+	})
+
+	m.Get("/status", func(r render.Render) {
+		pm := new(pageMeta)
+		pm.BtcplexSynced = btcplexsynced
+		pm.LastHeight = uint(latestheight)
+		pm.Title = "Status"
+		pm.Description = "BTCplex status page."
+		pm.Menu = "status"
+		pm.Analytics = conf.AppGoogleAnalytics
+		btcplexinfo, _ := btcplex.GetInfoRPC(conf)
+		pm.BitcoindInfo = btcplexinfo
+		r.HTML(200, "status", pm)
+	})
+
+	m.Post("/search", binding.Form(searchForm{}), binding.ErrorHandler, func(search searchForm, r render.Render, db *redis.Pool, rdb *RedisWrapper) {
+		rpool := rdb.Pool
+		pm := new(pageMeta)
+		pm.BtcplexSynced = btcplexsynced
+		// Check if the query isa block height
+		isblockheight, hash := btcplex.IsBlockHeight(db, search.Query)
 		if isblockheight && hash != "" {
 			r.Redirect(fmt.Sprintf("/block/%v", hash))
 		}
@@ -585,248 +1468,404 @@ Options:
 		r.JSON(200, latestheight)
 	})
 
-	//	m.Get("/api/latesthash", func(r render.Render) {
-	//		r.JSON(200, latesthash)
-	//	})
+	m.Get("/api/latesthash", indexSynced, func(r render.Render, db *redis.Pool) {
+		c := db.Get()
+		defer c.Close()
+		r.JSON(200, btcplex.GetBestBlockHash(c))
+	})
 
-	m.Get("/api/getblockhash/:height", func(r render.Render, params martini.Params, db *redis.Pool) {
-		height, _ := strconv.ParseUint(params["height"], 10, 0)
-		blockhash, _ := btcplex.GetBlockHash(db, uint(height))
+	m.Get("/api/getblockhash/:height", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		height, err := strconv.ParseUint(params["height"], 10, 0)
+		if err != nil {
+			renderAPIError(r, rid, 400, "Invalid block height")
+			return
+		}
+		blockhash, err := btcplex.GetBlockHash(db, uint(height))
+		if err != nil {
+			code, message := errorStatus(err, "Block not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
 		r.JSON(200, blockhash)
 	})
 
-	m.Get("/api/getreceivedbyaddress/:address", func(r render.Render, params martini.Params, db *redis.Pool) {
-		res, _ := btcplex.GetReceivedByAddress(db, params["address"])
+	m.Get("/api/getreceivedbyaddress/:address", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		res, err := btcplex.GetReceivedByAddress(db, params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
 		r.JSON(200, res)
 	})
 
-	m.Get("/api/getsentbyaddress/:address", func(r render.Render, params martini.Params, db *redis.Pool) {
-		res, _ := btcplex.GetSentByAddress(db, params["address"])
+	m.Get("/api/getsentbyaddress/:address", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		res, err := btcplex.GetSentByAddress(db, params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
 		r.JSON(200, res)
 	})
 
-	m.Get("/api/addressbalance/:address", func(r render.Render, params martini.Params, db *redis.Pool) {
-		res, _ := btcplex.AddressBalance(db, params["address"])
+	m.Get("/api/addressbalance/:address", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		res, err := btcplex.AddressBalance(db, params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
 		r.JSON(200, res)
 	})
 
+	totalCoins := func(r render.Render, rid requestId, req *http.Request) {
+		height := uint(latestheight)
+		if req.URL.Query().Get("height") != "" {
+			h, err := strconv.ParseUint(req.URL.Query().Get("height"), 10, 0)
+			if err != nil {
+				renderAPIError(r, rid, 400, "Invalid block height")
+				return
+			}
+			// Capped at the tip, the tail emission would overflow for huge heights
+			if h < uint64(height) {
+				height = uint(h)
+			}
+		}
+		r.JSON(200, btcplex.SubsidySupply(height))
+	}
+	m.Get("/api/totalcoins", indexSynced, totalCoins)
+	m.Get("/api/totalbc", indexSynced, totalCoins)
+
+	m.Get("/api/bcperblock", indexSynced, func(r render.Render) {
+		r.JSON(200, float64(btcplex.GetBlockReward(uint(latestheight)+1))/float64(btcplex.COIN))
+	})
+
+	m.Get("/api/circulating", indexSynced, func(r render.Render, rid requestId, db *redis.Pool) {
+		info, err := btcplex.GetSupplyInfo(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, info.Circulating)
+	})
+
+	m.Get("/api/getdifficulty", indexSynced, func(r render.Render, rid requestId, db *redis.Pool) {
+		difficulty, err := btcplex.GetDifficulty(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, difficulty)
+	})
+
+	m.Get("/api/hashestowin", indexSynced, func(r render.Render, rid requestId, db *redis.Pool) {
+		difficulty, err := btcplex.GetDifficulty(db)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, btcplex.HashesToWin(difficulty))
+	})
+
+	m.Get("/api/probability", indexSynced, func(r render.Render, rid requestId, db *redis.Pool) {
+		difficulty, err := btcplex.GetDifficulty(db)
+		if err != nil || difficulty == 0 {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, 1/btcplex.HashesToWin(difficulty))
+	})
+
+	for name, average := range map[string]func(*btcplex.RecentStats) float64{
+		"avgtxsize":   (*btcplex.RecentStats).AvgTxSize,
+		"avgtxvalue":  (*btcplex.RecentStats).AvgTxValue,
+		"avgtxnumber": (*btcplex.RecentStats).AvgTxNumber,
+		"interval":    (*btcplex.RecentStats).AvgInterval,
+		"eta": func(stats *btcplex.RecentStats) float64 {
+			return stats.ETA(time.Now().Unix())
+		},
+	} {
+		average := average
+		handler := func(params martini.Params, r render.Render, rid requestId, db *redis.Pool) {
+			blocks, err := recentBlocksParam(params)
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			stats, err := btcplex.GetRecentStats(db, blocks)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, average(stats))
+		}
+		m.Get("/api/"+name, indexSynced, handler)
+		m.Get("/api/"+name+"/:blocks", indexSynced, handler)
+	}
+
+	m.Get("/api/hashrate", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+		window := conf.HashRateWindow
+		if window == 0 {
+			window = btcplex.DefaultHashRateWindow
+		}
+		if req.URL.Query().Get("blocks") != "" {
+			blocks, err := strconv.ParseUint(req.URL.Query().Get("blocks"), 10, 0)
+			if err != nil || blocks == 0 {
+				renderAPIError(r, rid, 400, "Invalid number of blocks")
+				return
+			}
+			window = uint(blocks)
+		}
+		hashrate, err := btcplex.EstimateHashRate(db, window)
+		if err == btcplex.ErrChainWorkMissing {
+			renderAPIError(r, rid, 503, "Chain work not indexed yet")
+			return
+		}
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, hashrate)
+	})
+
+	m.Get("/api/addressfirstseen/:address", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		firstseen, err := btcplex.AddressFirstSeen(db, params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, firstseen)
+	})
+
+	m.Get("/api/addresstohash/:address", func(r render.Render, rid requestId, params martini.Params) {
+		hash160, err := btcplex.AddressToHash(params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		r.JSON(200, strings.ToUpper(hash160))
+	})
+
+	m.Get("/api/hashtoaddress/:hash", func(r render.Render, rid requestId, params martini.Params) {
+		address, err := btcplex.HashToAddress(params["hash"])
+		if err != nil {
+			renderAPIError(r, rid, 400, "Invalid hash160")
+			return
+		}
+		r.JSON(200, address)
+	})
+
+	// Transaction totals, in satoshis
+	for name, total := range map[string]func(*btcplex.Tx) uint64{
+		"txtotalbtcoutput": func(tx *btcplex.Tx) uint64 { return tx.TotalOut },
+		"txtotalbtcinput":  func(tx *btcplex.Tx) uint64 { return tx.TotalIn },
+		"txfee":            (*btcplex.Tx).Fee,
+	} {
+		total := total
+		m.Get("/api/"+name+"/:hash", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+			if !isHash(params["hash"]) {
+				renderAPIError(r, rid, 400, "Malformed transaction hash")
+				return
+			}
+			tx, err := btcplex.GetTx(db, params["hash"])
+			if err != nil {
+				code, message := errorStatus(err, "Transaction not found")
+				renderAPIError(r, rid, code, message)
+				return
+			}
+			r.JSON(200, total(tx))
+		})
+	}
+
+	m.Get("/api/txresult/:hash/:address", indexSynced, func(r render.Render, rid requestId, params martini.Params, db *redis.Pool) {
+		if !isHash(params["hash"]) {
+			renderAPIError(r, rid, 400, "Malformed transaction hash")
+			return
+		}
+		if isaddress, _ := btcplex.IsAddress(params["address"]); !isaddress {
+			renderAPIError(r, rid, 400, "Invalid address")
+			return
+		}
+		tx, err := btcplex.GetTx(db, params["hash"])
+		if err != nil {
+			code, message := errorStatus(err, "Transaction not found")
+			renderAPIError(r, rid, code, message)
+			return
+		}
+		r.JSON(200, tx.AddressInfo(params["address"]).Value)
+	})
+
+	m.Get("/api/unconfirmedcount", func(r render.Render, rid requestId, rdb *RedisWrapper) {
+		info, err := btcplex.GetMempoolInfo(rdb.Pool)
+		if err != nil {
+			renderAPIError(r, rid, 500, "Internal server error")
+			return
+		}
+		r.JSON(200, info.TxCnt)
+	})
+
 	m.Get("/api/checkaddress/:address", func(params martini.Params, r render.Render) {
 		valid, _ := btcplex.ValidA58([]byte(params["address"]))
 		r.JSON(200, valid)
 	})
 
-	m.Get("/api/blocknotify", func(w http.ResponseWriter, r *http.Request) {
+	m.Get("/api/blocknotify", func(w http.ResponseWriter, r *http.Request, rdb *RedisWrapper) {
 		incrementClient()
 		defer decrementClient()
-		running := true
-		notifier := w.(http.CloseNotifier).CloseNotify()
-		timer := time.NewTimer(time.Second * 1800)
-
-		f, _ := w.(http.Flusher)
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
 		bnotifier := blocknotifygroup.Join()
 		defer bnotifier.Close()
-
-		var ls interface{}
-		for {
-			if running {
-				select {
-				case ls = <-bnotifier.In:
-					io.WriteString(w, fmt.Sprintf("data: %v\n\n", ls.(string)))
-					f.Flush()
-				case <-notifier:
-					running = false
-					log.Println("CLOSED")
-					break
-				case <-timer.C:
-					running = false
-					log.Println("TimeOUT")
-				}
-			} else {
-				log.Println("DONE")
-				break
-			}
+		stream := newSSEStream(w, "blocknotify")
+		if stream.replay(rdb.Pool, r, "btcplex:blocknotify2", nil) != nil {
+			return
 		}
+		stream.run(bnotifier.In, time.Second*1800, nil)
 	})
 
-	m.Get("/api/utxs/:address", func(w http.ResponseWriter, params martini.Params, r *http.Request, rdb *RedisWrapper) {
+	m.Get("/api/utxs/:address", func(w http.ResponseWriter, params martini.Params, req *http.Request, r render.Render, rid requestId, rdb *RedisWrapper) {
+		addresses, err := sseAddresses(params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
 		incrementClient()
 		defer decrementClient()
-		rpool := rdb.Pool
-		running := true
-		notifier := w.(http.CloseNotifier).CloseNotify()
-		timer := time.NewTimer(time.Second * 3600)
-
-		f, _ := w.(http.Flusher)
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
-		utxs := make(chan string)
-		go func(rpool *redis.Pool, utxs chan<- string) {
-			conn := rpool.Get()
-			defer conn.Close()
-			psc := redis.PubSubConn{Conn: conn}
-			psc.Subscribe(fmt.Sprintf("addr:%v:txs", params["address"]))
-			for {
-				switch v := psc.Receive().(type) {
-				case redis.Message:
-					utxs <- string(v.Data)
-				}
-			}
-		}(rpool, utxs)
 
-		var ls string
-		for {
-			if running {
-				select {
-				case ls = <-utxs:
-					io.WriteString(w, fmt.Sprintf("data: %v\n\n", ls))
-					f.Flush()
-				case <-notifier:
-					running = false
-					log.Println("CLOSED")
-					break
-				case <-timer.C:
-					running = false
-					log.Println("TimeOUT")
-				}
-			} else {
-				log.Println("DONE")
-				break
-			}
+		// Fed by the shared Redis subscriber, unsubscribed when the stream ends
+		sub := hub.NewSubscriber(ssemaxaddresses)
+		defer sub.Close()
+		for _, address := range addresses {
+			sub.Subscribe(fmt.Sprintf("addr:%v:txs", address))
+		}
+		done := make(chan struct{})
+		defer close(done)
+
+		stream := newSSEStream(w, "tx")
+		if stream.replay(rdb.Pool, req, "btcplex:utxs", addressFilter(addresses, false)) != nil {
+			return
 		}
+		stream.run(hubEvents(sub, rdb.Pool, "btcplex:utxs", done), time.Second*3600, nil)
 	})
 
-	m.Get("/api/utxs", func(w http.ResponseWriter, r *http.Request) {
+	m.Get("/api/utxs", func(w http.ResponseWriter, r *http.Request, rdb *RedisWrapper) {
 		incrementClient()
 		defer decrementClient()
-		running := true
-		notifier := w.(http.CloseNotifier).CloseNotify()
-		timer := time.NewTimer(time.Second * 3600)
-
-		f, _ := w.(http.Flusher)
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
 		utx := utxgroup.Join()
 		defer utx.Close()
+		stream := newSSEStream(w, "tx")
+		if stream.replay(rdb.Pool, r, "btcplex:utxs", nil) != nil {
+			return
+		}
+		stream.run(utx.In, time.Second*3600, nil)
+	})
 
-		var ls interface{}
-		for {
-			if running {
-				select {
-				case ls = <-utx.In:
-					io.WriteString(w, fmt.Sprintf("data: %v\n\n", ls.(string)))
-					f.Flush()
-				case <-notifier:
-					running = false
-					log.Println("CLOSED")
-					break
-				case <-timer.C:
-					running = false
-					log.Println("TimeOUT")
-				}
-			} else {
-				log.Println("DONE")
-				break
-			}
+	m.Get("/api/doublespends", func(w http.ResponseWriter, r *http.Request, rdb *RedisWrapper) {
+		incrementClient()
+		defer decrementClient()
+		ds := doublespendgroup.Join()
+		defer ds.Close()
+		stream := newSSEStream(w, "doublespend")
+		if stream.replay(rdb.Pool, r, "btcplex:doublespends", nil) != nil {
+			return
 		}
+		stream.run(ds.In, time.Second*3600, nil)
 	})
 
-	m.Get("/events", func(w http.ResponseWriter, r *http.Request) {
-		running := true
-		notifier := w.(http.CloseNotifier).CloseNotify()
-		timer := time.NewTimer(time.Second * 8400)
+	m.Get("/api/doublespends/:address", func(w http.ResponseWriter, params martini.Params, req *http.Request, r render.Render, rid requestId, rdb *RedisWrapper) {
+		addresses, err := sseAddresses(params["address"])
+		if err != nil {
+			renderAPIError(r, rid, 400, err.Error())
+			return
+		}
+		incrementClient()
+		defer decrementClient()
 
-		f, _ := w.(http.Flusher)
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+		// Fed by the shared Redis subscriber, unsubscribed when the stream ends
+		sub := hub.NewSubscriber(ssemaxaddresses)
+		defer sub.Close()
+		for _, address := range addresses {
+			sub.Subscribe(fmt.Sprintf("addr:%v:doublespends", address))
+		}
+		done := make(chan struct{})
+		defer close(done)
 
+		stream := newSSEStream(w, "doublespend")
+		if stream.replay(rdb.Pool, req, "btcplex:doublespends", addressFilter(addresses, true)) != nil {
+			return
+		}
+		stream.run(hubEvents(sub, rdb.Pool, "btcplex:doublespends", done), time.Second*3600, nil)
+	})
+
+	m.Get("/events", func(w http.ResponseWriter, r *http.Request, rdb *RedisWrapper) {
 		newblockg := newblockgroup.Join()
 		defer newblockg.Close()
-		var ls interface{}
-		for {
-			if running {
-				select {
-				case ls = <-newblockg.In:
-					io.WriteString(w, fmt.Sprintf("data: %v\n\n", ls.(string)))
-					f.Flush()
-				case <-notifier:
-					running = false
-					log.Println("CLOSED")
-					break
-				case <-timer.C:
-					running = false
-					log.Println("TimeOUT")
-				}
-			} else {
-				log.Println("DONE")
-				break
-			}
+		stream := newSSEStream(w, "block")
+		if stream.replay(rdb.Pool, r, "btcplex:newblock", nil) != nil {
+			return
 		}
+		stream.run(newblockg.In, time.Second*8400, nil)
 	})
 
-	m.Get("/events_unconfirmed", func(w http.ResponseWriter, r *http.Request) {
-		running := true
-		notifier := w.(http.CloseNotifier).CloseNotify()
-		timer := time.NewTimer(time.Second * 3600)
-
-		f, _ := w.(http.Flusher)
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
+	m.Get("/events_unconfirmed", func(w http.ResponseWriter, r *http.Request, rdb *RedisWrapper) {
 		utx := utxgroup.Join()
 		defer utx.Close()
 
-		var ls interface{}
-		for {
-			if running {
-				select {
-				case ls = <-utx.In:
-					buf := bytes.NewBufferString("")
-					utx := new(btcplex.Tx)
-					json.Unmarshal([]byte(ls.(string)), utx)
-					t := template.New("").Funcs(appHelpers)
-					utxtmpl, _ := ioutil.ReadFile(fmt.Sprintf("%v/utx.tmpl", tmpldir))
-					t, err := t.Parse(string(utxtmpl))
-					if err != nil {
-						log.Printf("ERR:%v", err)
-					}
+		// Render the transaction with the utx template
+		format := func(data string) (string, bool) {
+			buf := bytes.NewBufferString("")
+			utx := new(btcplex.Tx)
+			json.Unmarshal([]byte(data), utx)
+			t := template.New("").Funcs(appHelpers)
+			utxtmpl, _ := ioutil.ReadFile(fmt.Sprintf("%v/utx.tmpl", tmpldir))
+			t, err := t.Parse(string(utxtmpl))
+			if err != nil {
+				log.Printf("ERR:%v", err)
+			}
 
-					err = t.Execute(buf, utx)
-					if err != nil {
-						log.Printf("ERR EXEC:%v", err)
-					}
-					res := map[string]interface{}{}
-					// Full unconfirmed cnt from global variables
-					res["cnt"] = utxscnt
-					// HTML template of the transaction
-					res["tmpl"] = buf.String()
-					// Last updated time
-					res["time"] = time.Now().UTC().Format(time.RFC3339)
-					resjson, _ := json.Marshal(res)
-					io.WriteString(w, fmt.Sprintf("data: %v\n\n", string(resjson)))
-					f.Flush()
-				case <-notifier:
-					running = false
-					log.Println("CLOSED")
-					break
-				case <-timer.C:
-					running = false
-					log.Println("TimeOUT")
-				}
-			} else {
-				log.Println("DONE")
-				break
+			err = t.Execute(buf, utx)
+			if err != nil {
+				log.Printf("ERR EXEC:%v", err)
 			}
+			res := map[string]interface{}{}
+			// Full unconfirmed cnt from global variables
+			res["cnt"] = utxscnt
+			// HTML template of the transaction
+			res["tmpl"] = buf.String()
+			// Last updated time
+			res["time"] = time.Now().UTC().Format(time.RFC3339)
+			resjson, _ := json.Marshal(res)
+			return string(resjson), true
+		}
+
+		stream := newSSEStream(w, "tx")
+		if stream.replay(rdb.Pool, r, "btcplex:utxs", format) != nil {
+			return
 		}
+		stream.run(utx.In, time.Second*3600, format)
+	})
+
+	m.Get("/ws", func(w http.ResponseWriter, req *http.Request) {
+		// Upgrade replies with the HTTP error itself
+		conn, err := wsupgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		incrementClient()
+		defer decrementClient()
+		serveWebSocket(conn, hub, ssdb)
 	})
 
 	m.Get("/api/info", func(r render.Render) {
@@ -836,6 +1875,532 @@ Options:
 		r.JSON(200, map[string]interface{}{"activeclients": activeclients, "info": btcplexinfo})
 	})
 
+	// GraphQL, the query is sent as JSON (POST) or in the query string (GET), see docs/api_graphql.md
+	graphQL := func(r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, w http.ResponseWriter, req *http.Request) {
+		gqlreq := new(graphQLRequest)
+		if req.Method == "POST" {
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxgraphqlbody))
+			if err != nil {
+				renderAPIError(r, rid, 413, "Request body too large")
+				return
+			}
+			if err := json.Unmarshal(body, gqlreq); err != nil {
+				renderAPIError(r, rid, 400, "Malformed request body")
+				return
+			}
+		} else {
+			gqlreq.Query = req.URL.Query().Get("query")
+			gqlreq.OperationName = req.URL.Query().Get("operationName")
+			if variables := req.URL.Query().Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &gqlreq.Variables); err != nil {
+					renderAPIError(r, rid, 400, "Invalid variables")
+					return
+				}
+			}
+		}
+		if strings.TrimSpace(gqlreq.Query) == "" {
+			renderAPIError(r, rid, 400, "Missing query")
+			return
+		}
+		resp := btcplex.ExecuteGraphQL(db, rdb.Pool, gqlreq.Query, gqlreq.Variables, gqlreq.OperationName)
+		if resp.Data == nil {
+			r.JSON(400, resp)
+			return
+		}
+		r.JSON(200, resp)
+	}
+	m.Get("/graphql", indexSynced, graphQL)
+	m.Post("/graphql", indexSynced, graphQL)
+
+	// Webhooks API, authenticated with one of the configured API keys, see docs/api_webhooks.md
+	if len(conf.AppWebhooksApiKeys) > 0 {
+		webhookAuth := func(req *http.Request, r render.Render, rid requestId, c martini.Context) {
+			apikey := req.Header.Get("X-Api-Key")
+			for _, key := range conf.AppWebhooksApiKeys {
+				if apikey != "" && subtle.ConstantTimeCompare([]byte(apikey), []byte(key)) == 1 {
+					c.Map(webhookOwner(btcplex.WebhookOwner(apikey)))
+					return
+				}
+			}
+			renderAPIError(r, rid, 401, "Invalid API key")
+		}
+		// Map the webhook if it belongs to the API key owner
+		ownedWebhook := func(params martini.Params, owner webhookOwner, r render.Render, rid requestId, rdb *RedisWrapper, c martini.Context) {
+			hook, err := btcplex.GetWebhook(rdb.Pool, params["id"])
+			if err == nil && hook.Owner != string(owner) {
+				err = btcplex.ErrNotFound
+			}
+			if err != nil {
+				code, message := errorStatus(err, "Webhook not found")
+				renderAPIError(r, rid, code, message)
+				return
+			}
+			c.Map(hook)
+		}
+
+		m.Post("/api/webhooks", webhookAuth, func(owner webhookOwner, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+			hook := new(btcplex.Webhook)
+			if err := json.NewDecoder(req.Body).Decode(hook); err != nil {
+				renderAPIError(r, rid, 400, "Malformed request body")
+				return
+			}
+			// Generated server side
+			hook.Secret, hook.Triggered = "", 0
+			if err := btcplex.ValidateWebhook(hook); err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			if err := btcplex.CreateWebhook(rdb.Pool, string(owner), hook); err != nil {
+				if err == btcplex.ErrTooManyWebhooks {
+					renderAPIError(r, rid, 400, fmt.Sprintf("At most %v webhooks per API key", btcplex.MaxWebhooksPerOwner))
+					return
+				}
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			// The tx may already have enough confirmations
+			if hook.Event == btcplex.WebhookTx {
+				btcplex.CheckTxWebhook(db, rdb.Pool, hook, uint(latestheight))
+			}
+			r.JSON(201, hook)
+		})
+
+		m.Get("/api/webhooks", webhookAuth, func(owner webhookOwner, r render.Render, rid requestId, rdb *RedisWrapper) {
+			hooks, err := btcplex.GetOwnerWebhooks(rdb.Pool, string(owner))
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, map[string]interface{}{"webhooks": hooks})
+		})
+
+		m.Get("/api/webhooks/:id", webhookAuth, ownedWebhook, func(hook *btcplex.Webhook, r render.Render) {
+			r.JSON(200, hook)
+		})
+
+		m.Delete("/api/webhooks/:id", webhookAuth, ownedWebhook, func(hook *btcplex.Webhook, r render.Render, rid requestId, rdb *RedisWrapper) {
+			if err := btcplex.DeleteWebhook(rdb.Pool, hook); err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.Status(204)
+		})
+
+		m.Get("/api/webhooks/:id/deliveries", webhookAuth, ownedWebhook, func(hook *btcplex.Webhook, r render.Render, rid requestId, rdb *RedisWrapper) {
+			entries, err := btcplex.GetWebhookLog(rdb.Pool, hook.Id)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, map[string]interface{}{"deliveries": entries})
+		})
+
+		m.Get("/api/webhooks/:id/deadletter", webhookAuth, ownedWebhook, func(hook *btcplex.Webhook, r render.Render, rid requestId, rdb *RedisWrapper) {
+			deliveries, err := btcplex.GetWebhookDeadLetters(rdb.Pool, hook.Id)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, map[string]interface{}{"deliveries": deliveries})
+		})
+
+		m.Post("/api/webhooks/:id/redeliver", webhookAuth, ownedWebhook, func(hook *btcplex.Webhook, r render.Render, rid requestId, rdb *RedisWrapper) {
+			cnt, err := btcplex.RedeliverWebhookDeadLetters(rdb.Pool, hook.Id)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, map[string]interface{}{"queued": cnt})
+		})
+	}
+
+	// Insight API for the wallets of Bitcoin forks, see docs/api_insight.md
+	if conf.AppInsightApi {
+		m.Get(insightprefix+"/block/:hash", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool) {
+			if !isHash(params["hash"]) {
+				renderAPIError(r, rid, 400, "Malformed block hash")
+				return
+			}
+			block, err := btcplex.GetBlockCachedByHash(db, params["hash"])
+			if err != nil {
+				code, message := errorStatus(err, "Block not found")
+				renderAPIError(r, rid, code, message)
+				return
+			}
+			block.FetchMeta(db)
+			btcplex.By(btcplex.TxIndex).Sort(block.Txs)
+			r.JSON(200, btcplex.NewInsightBlock(block, uint(latestheight)))
+		})
+
+		m.Get(insightprefix+"/block-index/:height", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool) {
+			height, err := strconv.ParseUint(params["height"], 10, 0)
+			if err != nil {
+				renderAPIError(r, rid, 400, "Invalid block height")
+				return
+			}
+			blockhash, err := btcplex.GetBlockHash(db, uint(height))
+			if err != nil {
+				code, message := errorStatus(err, "Block not found")
+				renderAPIError(r, rid, code, message)
+				return
+			}
+			r.JSON(200, map[string]string{"blockHash": blockhash})
+		})
+
+		// Latest blocks only, blockDate isn't supported
+		m.Get(insightprefix+"/blocks", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+			cursor, limit, err := cursorParams(req)
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			blocks, _, err := btcplex.GetBlocksFromCursor(db, cursor, limit)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			summaries := []*btcplex.InsightBlockSummary{}
+			for _, block := range blocks {
+				summaries = append(summaries, btcplex.NewInsightBlockSummary(block))
+			}
+			r.JSON(200, map[string]interface{}{"blocks": summaries, "length": len(summaries)})
+		})
+
+		m.Get(insightprefix+"/tx/:txid", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+			var tx *btcplex.Tx
+			var err error
+			if !isHash(params["txid"]) {
+				renderAPIError(r, rid, 400, "Malformed transaction hash")
+				return
+			}
+			isutx, _ := btcplex.IsUnconfirmedTx(rdb.Pool, params["txid"])
+			if isutx {
+				tx, err = btcplex.GetUnconfirmedTx(rdb.Pool, params["txid"])
+			} else {
+				tx, err = btcplex.GetTx(db, params["txid"])
+			}
+			if err != nil {
+				code, message := errorStatus(err, "Transaction not found")
+				renderAPIError(r, rid, code, message)
+				return
+			}
+			if isutx {
+				tx.FetchUnconfirmedSpent(rdb.Pool)
+			}
+			r.JSON(200, btcplex.NewInsightTx(tx, uint(latestheight)))
+		})
+
+		// Transactions of a block or an address, the unconfirmed ones come first on the first page of an address
+		m.Get(insightprefix+"/txs", indexSynced, func(r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+			query := req.URL.Query()
+			page := 0
+			if query.Get("pageNum") != "" {
+				var err error
+				page, err = strconv.Atoi(query.Get("pageNum"))
+				if err != nil || page < 0 {
+					renderAPIError(r, rid, 400, "Invalid pageNum")
+					return
+				}
+			}
+			start := page * btcplex.InsightTxsPerPage
+			txs := []*btcplex.Tx{}
+			total := 0
+			switch {
+			case query.Get("block") != "":
+				if !isHash(query.Get("block")) {
+					renderAPIError(r, rid, 400, "Malformed block hash")
+					return
+				}
+				block, err := btcplex.GetBlockCachedByHash(db, query.Get("block"))
+				if err != nil {
+					code, message := errorStatus(err, "Block not found")
+					renderAPIError(r, rid, code, message)
+					return
+				}
+				btcplex.By(btcplex.TxIndex).Sort(block.Txs)
+				total = len(block.Txs)
+				for i := start; i < total && i < start+btcplex.InsightTxsPerPage; i++ {
+					// The cached block outputs spent status is outdated
+					tx, err := btcplex.GetTx(db, block.Txs[i].Hash)
+					if err != nil {
+						renderAPIError(r, rid, 500, "Internal server error")
+						return
+					}
+					txs = append(txs, tx)
+				}
+			case query.Get("address") != "":
+				if isaddress, _ := btcplex.IsAddress(query.Get("address")); !isaddress {
+					renderAPIError(r, rid, 400, "Invalid address")
+					return
+				}
+				addressdata, err := btcplex.GetAddress(db, query.Get("address"))
+				if err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+				total = int(addressdata.TxCnt)
+				if page == 0 {
+					utxs, _ := btcplex.GetUnconfirmedTxsByAddress(rdb.Pool, addressdata.Address)
+					btcplex.FetchUnconfirmedSpentTxs(rdb.Pool, utxs)
+					txs = append(txs, utxs...)
+				}
+				if err := addressdata.FetchTxs(db, start, start+btcplex.InsightTxsPerPage-1); err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+				txs = append(txs, addressdata.Txs...)
+			default:
+				renderAPIError(r, rid, 400, "Block or address required")
+				return
+			}
+			itxs := []*btcplex.InsightTx{}
+			for _, tx := range txs {
+				itxs = append(itxs, btcplex.NewInsightTx(tx, uint(latestheight)))
+			}
+			pages := (total + btcplex.InsightTxsPerPage - 1) / btcplex.InsightTxsPerPage
+			r.JSON(200, map[string]interface{}{"pagesTotal": pages, "txs": itxs})
+		})
+
+		m.Post(insightprefix+"/tx/send", indexSynced, binding.Bind(insightTxForm{}), func(form insightTxForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+			if len(errs.Overall)+len(errs.Fields) > 0 {
+				renderAPIError(r, rid, 400, "Malformed request body")
+				return
+			}
+			rawtx := strings.TrimSpace(form.Rawtx)
+			if rawtx == "" {
+				renderAPIError(r, rid, 400, "Missing raw transaction")
+				return
+			}
+			tx, err := btcplex.PushTx(conf, rdb.Pool, db, rawtx)
+			if pusherr, rejected := err.(*btcplex.PushTxError); rejected {
+				r.JSON(400, &apiError{Code: 400, Message: pusherr.Message, RequestId: string(rid), Reason: pusherr.Reason})
+				return
+			}
+			if err != nil {
+				log.Printf("Error broadcasting transaction: %v", err)
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			r.JSON(200, map[string]string{"txid": tx.Hash})
+		})
+
+		m.Get(insightprefix+"/addr/:addr", indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+			if isaddress, _ := btcplex.IsAddress(params["addr"]); !isaddress {
+				renderAPIError(r, rid, 400, "Invalid address")
+				return
+			}
+			addressdata, err := btcplex.GetAddress(db, params["addr"])
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			addressdata.FetchUnconfirmed(rdb.Pool)
+			var txids []string
+			if req.URL.Query().Get("noTxList") != "1" {
+				from, to, err := insightRange(req.URL.Query().Get("from"), req.URL.Query().Get("to"), insightmaxitems, insightmaxitems)
+				if err != nil {
+					renderAPIError(r, rid, 400, err.Error())
+					return
+				}
+				if txids, err = btcplex.GetAddressTxHashes(db, params["addr"], from, to-1); err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+			}
+			r.JSON(200, btcplex.NewInsightAddress(addressdata, txids))
+		})
+
+		// Address properties, in satoshis
+		for name, property := range map[string]func(*btcplex.AddressData) int64{
+			"balance":       func(addressdata *btcplex.AddressData) int64 { return int64(addressdata.FinalBalance) },
+			"totalReceived": func(addressdata *btcplex.AddressData) int64 { return int64(addressdata.TotalReceived) },
+			"totalSent":     func(addressdata *btcplex.AddressData) int64 { return int64(addressdata.TotalSent) },
+			"unconfirmedBalance": func(addressdata *btcplex.AddressData) int64 {
+				return int64(addressdata.UnconfirmedReceived) - int64(addressdata.UnconfirmedSent)
+			},
+		} {
+			property := property
+			m.Get(insightprefix+"/addr/:addr/"+name, indexSynced, func(params martini.Params, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+				if isaddress, _ := btcplex.IsAddress(params["addr"]); !isaddress {
+					renderAPIError(r, rid, 400, "Invalid address")
+					return
+				}
+				addressdata, err := btcplex.GetAddress(db, params["addr"])
+				if err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+				addressdata.FetchUnconfirmed(rdb.Pool)
+				r.JSON(200, property(addressdata))
+			})
+		}
+
+		// Addresses are taken from the path or the addrs parameter
+		insightUtxo := func(params martini.Params, form insightAddrsForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+			if len(errs.Overall)+len(errs.Fields) > 0 {
+				renderAPIError(r, rid, 400, "Malformed request body")
+				return
+			}
+			addresses, err := splitAddresses([]string{params["addr"], params["addrs"], form.Addrs})
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			unspent, err := btcplex.GetAddressesUnspent(db, rdb.Pool, addresses)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			utxos := []*btcplex.InsightUtxo{}
+			for _, output := range unspent {
+				utxos = append(utxos, btcplex.NewInsightUtxo(output, uint(latestheight)))
+			}
+			r.JSON(200, utxos)
+		}
+		m.Get(insightprefix+"/addr/:addr/utxo", indexSynced, binding.Bind(insightAddrsForm{}), insightUtxo)
+		m.Get(insightprefix+"/addrs/:addrs/utxo", indexSynced, binding.Bind(insightAddrsForm{}), insightUtxo)
+		m.Post(insightprefix+"/addrs/utxo", indexSynced, binding.Bind(insightAddrsForm{}), insightUtxo)
+
+		insightAddrsTxs := func(params martini.Params, form insightAddrsForm, errs binding.Errors, r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper) {
+			if len(errs.Overall)+len(errs.Fields) > 0 {
+				renderAPIError(r, rid, 400, "Malformed request body")
+				return
+			}
+			addresses, err := splitAddresses([]string{params["addrs"], form.Addrs})
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			from, to, err := insightRange(form.From, form.To, btcplex.InsightTxsPerPage, 50)
+			if err != nil {
+				renderAPIError(r, rid, 400, err.Error())
+				return
+			}
+			txs, _, err := btcplex.GetMultiAddressTxs(db, addresses, nil, to)
+			if err != nil {
+				renderAPIError(r, rid, 500, "Internal server error")
+				return
+			}
+			// Txs involving several of the addresses are counted more than once
+			total := 0
+			for _, address := range addresses {
+				addressdata, err := btcplex.GetAddress(db, address)
+				if err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+				total += int(addressdata.TxCnt)
+			}
+			items := []*btcplex.InsightTx{}
+			for i := from; i < len(txs); i++ {
+				items = append(items, btcplex.NewInsightTx(txs[i].Tx, uint(latestheight)))
+			}
+			r.JSON(200, map[string]interface{}{"totalItems": total, "from": from, "to": from + len(items), "items": items})
+		}
+		m.Get(insightprefix+"/addrs/:addrs/txs", indexSynced, binding.Bind(insightAddrsForm{}), insightAddrsTxs)
+		m.Post(insightprefix+"/addrs/txs", indexSynced, binding.Bind(insightAddrsForm{}), insightAddrsTxs)
+
+		m.Get(insightprefix+"/status", func(r render.Render, rid requestId, db *redis.Pool, req *http.Request) {
+			switch req.URL.Query().Get("q") {
+			case "getDifficulty":
+				difficulty, err := btcplex.GetDifficulty(db)
+				if err != nil {
+					renderAPIError(r, rid, 500, "Internal server error")
+					return
+				}
+				r.JSON(200, map[string]float64{"difficulty": difficulty})
+			case "getBestBlockHash", "getLastBlockHash":
+				c := db.Get()
+				defer c.Close()
+				besthash := btcplex.GetBestBlockHash(c)
+				r.JSON(200, map[string]string{"bestblockhash": besthash, "syncTipHash": besthash, "lastblockhash": besthash})
+			default:
+				info, err := btcplex.GetInfoRPC(conf)
+				if err != nil {
+					log.Printf("Error fetching bitcoind info: %v", err)
+					renderAPIError(r, rid, 503, "Bitcoind unavailable")
+					return
+				}
+				r.JSON(200, map[string]interface{}{"info": info})
+			}
+		})
+
+		m.Get(insightprefix+"/sync", func(r render.Render, rid requestId) {
+			bitcoindheight, err := btcplex.GetBlockCountRPC(conf)
+			if err != nil {
+				log.Printf("Error fetching bitcoind block count: %v", err)
+				renderAPIError(r, rid, 503, "Bitcoind unavailable")
+				return
+			}
+			status, percentage := "finished", 100
+			if !btcplexsynced {
+				status = "syncing"
+				if bitcoindheight > 0 {
+					percentage = latestheight * 100 / int(bitcoindheight)
+				}
+			}
+			r.JSON(200, map[string]interface{}{"status": status, "blockChainHeight": bitcoindheight, "syncPercentage": percentage,
+				"height": latestheight, "error": nil, "type": "btcplex"})
+		})
+
+		// Fee per kB in coins for each of the comma separated nbBlocks
+		m.Get(insightprefix+"/utils/estimatefee", func(r render.Render, rid requestId, db *redis.Pool, rdb *RedisWrapper, req *http.Request) {
+			nbblocks := req.URL.Query().Get("nbBlocks")
+			if nbblocks == "" {
+				nbblocks = "2"
+			}
+			history, _ := btcplex.GetFeeHistory(db)
+			feerates, sizes, _ := btcplex.GetMempoolFeeRates(rdb.Pool)
+			fees := map[string]float64{}
+			for _, nb := range strings.Split(nbblocks, ",") {
+				nb = strings.TrimSpace(nb)
+				blocks, err := strconv.ParseUint(nb, 10, 0)
+				if err != nil || blocks == 0 || blocks > btcplex.FeeHistoryBlocks {
+					renderAPIError(r, rid, 400, "Invalid nbBlocks")
+					return
+				}
+				fees[nb] = btcplex.UintToFloat(btcplex.EstimateFee(history, feerates, sizes, uint(blocks)).FeePerKb)
+			}
+			r.JSON(200, fees)
+		})
+
+		// Socket.io events, over websocket or polling
+		socketIO := func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Query().Get("transport") {
+			case "polling":
+				serveSocketIOPolling(w, req, hub)
+			case "websocket":
+				// Polling sessions aren't offered upgrades
+				if req.URL.Query().Get("sid") != "" {
+					sioError(w, eioErrUnknownSid, "Session ID unknown")
+					return
+				}
+				conn, err := wsupgrader.Upgrade(w, req, nil)
+				if err != nil {
+					return
+				}
+				incrementClient()
+				defer decrementClient()
+				serveSocketIO(conn, hub)
+			default:
+				sioError(w, eioErrTransportUnknown, "Transport unknown")
+			}
+		}
+		m.Get("/socket.io/", socketIO)
+		m.Post("/socket.io/", socketIO)
+	}
+
+	m.NotFound(func(r render.Render, rid requestId, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/api/") || strings.HasPrefix(req.URL.Path, insightprefix+"/") {
+			renderAPIError(r, rid, 404, "Unknown API endpoint")
+			return
+		}
+		pm := new(pageMeta)
+		pm.BtcplexSynced = btcplexsynced
+		pm.LastHeight = uint(latestheight)
+		renderErrorPage(r, pm, 404, "Page not found")
+	})
+
 	log.Printf("Listening on port: %v\n", conf.AppPort)
 	http.ListenAndServe(fmt.Sprintf(":%v", conf.AppPort), m)
 }
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"btcplex"
+)
+
+// Insight socket.io events (/socket.io/), over the Engine.io websocket or polling transport. Clients
+// join the inv room (tx and block events) or address rooms (an event named after the address, with the
+// txid), fanned out from the shared Redis subscriber (btcplex.Hub), see docs/api_insight.md
+
+const (
+	siomaxrooms     = 100
+	siopinginterval = 25 * time.Second
+	siopingtimeout  = 60 * time.Second
+	// Packets waiting for the next poll before a polling client is dropped
+	siomaxqueued = 256
+	siomaxpost   = 64 * 1024
+)
+
+// Engine.io errors, sent as {"code": 1, "message": "Session ID unknown"}
+const (
+	eioErrTransportUnknown = 0
+	eioErrUnknownSid       = 1
+	eioErrBadHandshake     = 2
+	eioErrBadRequest       = 3
+	eioErrUnsupportedProto = 5
+)
+
+// Transport independent session, owned by its run loop
+type sioSession struct {
+	sub   *btcplex.HubSubscriber
+	rooms map[string]bool
+}
+
+func newSIOSession(hub *btcplex.Hub) *sioSession {
+	return &sioSession{sub: hub.NewSubscriber(0), rooms: map[string]bool{}}
+}
+
+// Hub channels backing the room
+func sioRoomChannels(room string) ([]string, error) {
+	if room == "inv" {
+		return []string{"btcplex:utxs", "btcplex:newblock"}, nil
+	}
+	if valid, _ := btcplex.IsAddress(room); !valid {
+		return nil, fmt.Errorf("Unknown room %v", room)
+	}
+	return []string{fmt.Sprintf("addr:%v:txs", room)}, nil
+}
+
+// Join or leave the room, errors are only logged like Insight does
+func (s *sioSession) handle(event string, args []json.RawMessage) {
+	if event != "subscribe" && event != "unsubscribe" {
+		return
+	}
+	var room string
+	if len(args) == 0 || json.Unmarshal(args[0], &room) != nil {
+		return
+	}
+	channels, err := sioRoomChannels(room)
+	if err != nil {
+		return
+	}
+	if event == "unsubscribe" {
+		if s.rooms[room] {
+			delete(s.rooms, room)
+			for _, channel := range channels {
+				s.sub.Unsubscribe(channel)
+			}
+		}
+		return
+	}
+	if s.rooms[room] || len(s.rooms) >= siomaxrooms {
+		return
+	}
+	for _, channel := range channels {
+		if err := s.sub.Subscribe(channel); err != nil {
+			return
+		}
+	}
+	s.rooms[room] = true
+}
+
+// Event packets for a hub message
+func (s *sioSession) events(msg *btcplex.HubMessage) (packets []string) {
+	var packet string
+	var err error
+	switch {
+	case msg.Channel == "btcplex:newblock":
+		block := new(btcplex.Block)
+		if json.Unmarshal(msg.Data, block) != nil {
+			return
+		}
+		packet, err = btcplex.SocketIOEventPacket("block", block.Hash)
+	case msg.Channel == "btcplex:utxs":
+		tx := new(btcplex.Tx)
+		if json.Unmarshal(msg.Data, tx) != nil {
+			return
+		}
+		packet, err = btcplex.SocketIOEventPacket("tx", btcplex.NewInsightTxEvent(tx))
+	case strings.HasPrefix(msg.Channel, "addr:"):
+		address := strings.TrimSuffix(strings.TrimPrefix(msg.Channel, "addr:"), ":txs")
+		tx := new(btcplex.Tx)
+		if json.Unmarshal(msg.Data, tx) != nil {
+			return
+		}
+		packet, err = btcplex.SocketIOEventPacket(address, tx.Hash)
+	}
+	if err != nil || packet == "" {
+		return
+	}
+	return []string{packet}
+}
+
+// Handle the client packets (the channel is closed when the transport fails) and the hub
+// messages until the client leaves, stops pinging, or can't keep up with its events
+func (s *sioSession) run(packets <-chan string, send func(packets []string) error) {
+	lastseen := time.Now()
+	ticker := time.NewTicker(siopinginterval)
+	defer ticker.Stop()
+	for {
+		var replies []string
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			lastseen = time.Now()
+			switch {
+			case packet == btcplex.EngineIOPing:
+				replies = []string{btcplex.EngineIOPong}
+			case packet == btcplex.EngineIOClose || packet == btcplex.SocketIOClose:
+				return
+			case strings.HasPrefix(packet, btcplex.SocketIOEvent):
+				event, args, ackid, err := btcplex.ParseSocketIOEvent(packet)
+				if err != nil {
+					continue
+				}
+				s.handle(event, args)
+				if ackid != "" {
+					ack, _ := btcplex.SocketIOAckPacket(ackid)
+					replies = []string{ack}
+				}
+			}
+		case msg, ok := <-s.sub.C:
+			if !ok {
+				send([]string{btcplex.EngineIOClose})
+				return
+			}
+			replies = s.events(msg)
+		case <-ticker.C:
+			// Clients ping every siopinginterval
+			if time.Since(lastseen) > siopinginterval+siopingtimeout {
+				return
+			}
+		}
+		if len(replies) > 0 && send(replies) != nil {
+			return
+		}
+	}
+}
+
+// Serve the websocket connection until the session ends
+func serveSocketIO(conn *websocket.Conn, hub *btcplex.Hub) {
+	defer conn.Close()
+	s := newSIOSession(hub)
+	defer s.sub.Close()
+
+	send := func(packets []string) error {
+		for _, packet := range packets {
+			conn.SetWriteDeadline(time.Now().Add(wswritetimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(packet)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	conn.SetReadLimit(wsmaxmessagesize)
+	if send([]string{btcplex.EngineIOOpenPacket(newRequestId(), siopinginterval, siopingtimeout), btcplex.SocketIOConnect}) != nil {
+		return
+	}
+
+	packets := make(chan string)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(packets)
+		for {
+			conn.SetReadDeadline(time.Now().Add(siopinginterval + siopingtimeout))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case packets <- string(data):
+			case <-quit:
+				return
+			}
+		}
+	}()
+	s.run(packets, send)
+}
+
+// Polling session, the client posts its packets and long-polls the queued ones. Upgrades
+// aren't offered so polling clients stay on polling
+type sioPoll struct {
+	packets chan string
+	mutex   sync.Mutex
+	queued  []string
+	// Signaled when packets are queued or the session ends
+	ready   chan struct{}
+	polling bool
+	closed  bool
+}
+
+var siopolls = map[string]*sioPoll{}
+var siopollsmutex sync.Mutex
+
+func (p *sioPoll) send(packets []string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.queued)+len(packets) > siomaxqueued {
+		return fmt.Errorf("Client lagging behind")
+	}
+	p.queued = append(p.queued, packets...)
+	p.signal()
+	return nil
+}
+
+func (p *sioPoll) signal() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Start a polling session, its handshake is returned by the first poll
+func openSIOPoll(hub *btcplex.Hub) (sid string, p *sioPoll) {
+	sid = newRequestId()
+	p = &sioPoll{packets: make(chan string, siomaxqueued), ready: make(chan struct{}, 1)}
+	p.send([]string{btcplex.EngineIOOpenPacket(sid, siopinginterval, siopingtimeout), btcplex.SocketIOConnect})
+	siopollsmutex.Lock()
+	siopolls[sid] = p
+	siopollsmutex.Unlock()
+	incrementClient()
+	go func() {
+		defer decrementClient()
+		s := newSIOSession(hub)
+		s.run(p.packets, p.send)
+		s.sub.Close()
+		siopollsmutex.Lock()
+		delete(siopolls, sid)
+		siopollsmutex.Unlock()
+		p.mutex.Lock()
+		p.closed = true
+		p.signal()
+		p.mutex.Unlock()
+	}()
+	return
+}
+
+// Queued packets, waiting up to siopinginterval for some (a noop packet is returned otherwise)
+func (p *sioPoll) poll() (packets []string, err error) {
+	p.mutex.Lock()
+	if p.polling {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("Overlapping poll")
+	}
+	p.polling = true
+	wait := len(p.queued) == 0 && !p.closed
+	p.mutex.Unlock()
+	if wait {
+		select {
+		case <-p.ready:
+		case <-time.After(siopinginterval):
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.polling = false
+	packets, p.queued = p.queued, nil
+	if p.closed {
+		packets = append(packets, btcplex.EngineIOClose)
+	}
+	if len(packets) == 0 {
+		packets = []string{btcplex.EngineIONoop}
+	}
+	return
+}
+
+func sioError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": message})
+}
+
+// Engine.io polling requests: the handshake (GET without sid), polls (GET) and client packets (POST)
+func serveSocketIOPolling(w http.ResponseWriter, req *http.Request, hub *btcplex.Hub) {
+	// Browsers send the cookies (withCredentials), the origin has to be echoed back
+	if origin := req.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	query := req.URL.Query()
+	if query.Get("EIO") != "3" {
+		sioError(w, eioErrUnsupportedProto, "Unsupported protocol version")
+		return
+	}
+	// JSONP polling isn't supported
+	if query.Get("j") != "" {
+		sioError(w, eioErrBadRequest, "Bad request")
+		return
+	}
+	sid := query.Get("sid")
+	if sid == "" && req.Method != "GET" {
+		sioError(w, eioErrBadHandshake, "Bad handshake method")
+		return
+	}
+	var p *sioPoll
+	if sid == "" {
+		sid, p = openSIOPoll(hub)
+	} else {
+		siopollsmutex.Lock()
+		p = siopolls[sid]
+		siopollsmutex.Unlock()
+		if p == nil {
+			sioError(w, eioErrUnknownSid, "Session ID unknown")
+			return
+		}
+	}
+	if req.Method == "POST" {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, siomaxpost))
+		if err != nil {
+			sioError(w, eioErrBadRequest, "Bad request")
+			return
+		}
+		packets, err := btcplex.ParseEngineIOPayload(string(body))
+		if err != nil {
+			sioError(w, eioErrBadRequest, "Bad request")
+			return
+		}
+		for _, packet := range packets {
+			select {
+			case p.packets <- packet:
+			default:
+				sioError(w, eioErrBadRequest, "Bad request")
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("ok"))
+		return
+	}
+	packets, err := p.poll()
+	if err != nil {
+		sioError(w, eioErrBadRequest, "Bad request")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Write([]byte(btcplex.EngineIOPayload(packets)))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"btcplex"
+)
+
+// Server-Sent Events streams, events carry the id assigned by btcplex.PublishEvent so a reconnecting
+// client gets the events it missed (from the backlog) with the Last-Event-ID header, see docs/api_sse.md
+
+const (
+	sseheartbeat = 15 * time.Second
+	// Addresses followed by a single address stream
+	ssemaxaddresses = 20
+)
+
+// Turn the event data into the message data, false to skip the event
+type sseFormat func(data string) (string, bool)
+
+type sseStream struct {
+	w    http.ResponseWriter
+	f    http.Flusher
+	name string
+	// Id of the latest event sent
+	lastid uint64
+}
+
+func newSSEStream(w http.ResponseWriter, name string) *sseStream {
+	f, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	return &sseStream{w: w, f: f, name: name}
+}
+
+func (s *sseStream) write(msg string) (err error) {
+	if _, err = io.WriteString(s.w, msg); err != nil {
+		return
+	}
+	s.f.Flush()
+	return
+}
+
+func (s *sseStream) send(event *btcplex.Event, format sseFormat) error {
+	if event.Id != 0 {
+		// Already sent while replaying the backlog
+		if event.Id <= s.lastid {
+			return nil
+		}
+		s.lastid = event.Id
+	}
+	data := event.Data
+	if format != nil {
+		var ok bool
+		if data, ok = format(data); !ok {
+			return nil
+		}
+	}
+	return s.write(btcplex.FormatSSE(s.name, event.Id, data))
+}
+
+// Send the channel events published after the Last-Event-ID header (or the last_event_id
+// parameter, for clients that can't set headers) that are still in the backlog
+func (s *sseStream) replay(pool *redis.Pool, req *http.Request, channel string, format sseFormat) error {
+	lastid := req.Header.Get("Last-Event-ID")
+	if lastid == "" {
+		lastid = req.URL.Query().Get("last_event_id")
+	}
+	after, err := strconv.ParseUint(lastid, 10, 64)
+	if err != nil {
+		return nil
+	}
+	events, err := btcplex.GetEvents(pool, channel, after, btcplex.EventBacklogSize)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := s.send(event, format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send the events (*btcplex.Event) and a heartbeat event every 15s, until the client leaves,
+// the timeout, or the events channel is closed
+func (s *sseStream) run(events <-chan interface{}, timeout time.Duration, format sseFormat) {
+	notifier := s.w.(http.CloseNotifier).CloseNotify()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	heartbeat := time.NewTicker(sseheartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := s.send(event.(*btcplex.Event), format); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			// Without id, the client keeps its last event id
+			if err := s.write(btcplex.FormatSSE("heartbeat", 0, strconv.FormatInt(time.Now().Unix(), 10))); err != nil {
+				return
+			}
+		case <-notifier:
+			return
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Events of the hub subscriber messages, their id is found by digest in eventchannel (btcplex:utxs or
+// btcplex:doublespends) publishing the same payloads. The channel is closed with the subscriber
+// (when the stream ends, or if it's dropped for lagging behind).
+func hubEvents(sub *btcplex.HubSubscriber, pool *redis.Pool, eventchannel string, done <-chan struct{}) <-chan interface{} {
+	events := make(chan interface{})
+	go func() {
+		defer close(events)
+		for msg := range sub.C {
+			id, _ := btcplex.GetEventId(pool, eventchannel, string(msg.Data))
+			select {
+			case events <- &btcplex.Event{Id: id, Data: string(msg.Data)}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// Comma separated list of addresses followed by an address stream
+func sseAddresses(param string) (addresses []string, err error) {
+	seen := map[string]bool{}
+	for _, address := range strings.Split(param, ",") {
+		if valid, _ := btcplex.IsAddress(address); !valid {
+			return nil, fmt.Errorf("Invalid address %v", address)
+		}
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	if len(addresses) > ssemaxaddresses {
+		return nil, fmt.Errorf("At most %v addresses per stream", ssemaxaddresses)
+	}
+	return
+}
+
+// Keep the txs (or double spends) involving one of the addresses
+func addressFilter(addresses []string, doublespend bool) sseFormat {
+	followed := map[string]bool{}
+	for _, address := range addresses {
+		followed[address] = true
+	}
+	return func(data string) (string, bool) {
+		tx := new(btcplex.Tx)
+		if doublespend {
+			ds := &btcplex.DoubleSpend{Tx: tx}
+			if json.Unmarshal([]byte(data), ds) != nil {
+				return "", false
+			}
+			for _, conflict := range ds.Conflicts {
+				if conflict.PrevOut != nil && followed[conflict.PrevOut.Address] {
+					return data, true
+				}
+			}
+		} else if json.Unmarshal([]byte(data), tx) != nil {
+			return "", false
+		}
+		for _, addr := range tx.Addresses() {
+			if followed[addr] {
+				return data, true
+			}
+		}
+		return "", false
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/websocket"
+
+	"btcplex"
+)
+
+// WebSocket API (/ws), a connection subscribes to several channels at once, notifications are
+// fanned out from the shared Redis subscriber (btcplex.Hub), see docs/api_websocket.md
+
+const (
+	wsmaxsubscriptions     = 100
+	wsmaxmessagesize       = 4096
+	wsdefaultconfirmations = 6
+	wsmaxconfirmations     = 100
+	wswritetimeout         = 10 * time.Second
+	wspinginterval         = 30 * time.Second
+	wsreadtimeout          = 60 * time.Second
+)
+
+var wsupgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// Public API, like the CORS headers
+	CheckOrigin: func(req *http.Request) bool { return true },
+}
+
+type wsRequest struct {
+	Op            string `json:"op"`
+	Channel       string `json:"channel"`
+	Address       string `json:"address"`
+	Txid          string `json:"txid"`
+	Confirmations int    `json:"confirmations"`
+}
+
+// Owned by the serveWebSocket loop, only it writes to the connection
+type wsSession struct {
+	conn          *websocket.Conn
+	ssdb          *redis.Pool
+	sub           *btcplex.HubSubscriber
+	subscriptions map[string]bool
+	// Confirmations wanted for the subscribed txs
+	txs map[string]int
+}
+
+func wsError(message string) map[string]interface{} {
+	return map[string]interface{}{"type": "error", "message": message}
+}
+
+func (s *wsSession) write(msg interface{}) error {
+	s.conn.SetWriteDeadline(time.Now().Add(wswritetimeout))
+	return s.conn.WriteJSON(msg)
+}
+
+// Key of the subscription, and the hub channel backing it
+func (s *wsSession) subscription(req *wsRequest) (key, channel string, err error) {
+	switch req.Channel {
+	case "blocks":
+		return "blocks", "btcplex:newblock", nil
+	case "mempool":
+		return "mempool", "btcplex:utxs", nil
+	case "address":
+		if valid, _ := btcplex.IsAddress(req.Address); !valid {
+			return "", "", fmt.Errorf("Invalid address %v", req.Address)
+		}
+		return "address:" + req.Address, fmt.Sprintf("addr:%v:txs", req.Address), nil
+	case "tx":
+		if !isHash(req.Txid) {
+			return "", "", fmt.Errorf("Invalid txid %v", req.Txid)
+		}
+		// Confirmations are checked on each new block
+		return "tx:" + req.Txid, "btcplex:newblock", nil
+	}
+	return "", "", fmt.Errorf("Unknown channel %v", req.Channel)
+}
+
+// The newblock channel is shared by the blocks and tx subscriptions
+func (s *wsSession) channelUsed(channel string) bool {
+	if channel != "btcplex:newblock" {
+		return false
+	}
+	return s.subscriptions["blocks"] || len(s.txs) > 0
+}
+
+// Current confirmations of the tx, 0 if it's unconfirmed (or unknown)
+func (s *wsSession) txConfirmation(txid string, latest uint) map[string]interface{} {
+	msg := map[string]interface{}{"type": "tx_confirmation", "txid": txid, "confirmations": uint(0)}
+	tx, err := btcplex.GetTx(s.ssdb, txid)
+	if err != nil || tx.BlockHash == "" {
+		return msg
+	}
+	msg["confirmations"] = btcplex.Confirmations(tx.BlockHeight, latest)
+	msg["block_hash"] = tx.BlockHash
+	msg["block_height"] = tx.BlockHeight
+	return msg
+}
+
+func (s *wsSession) latestHeight() uint {
+	c := s.ssdb.Get()
+	defer c.Close()
+	latest, _ := redis.Int(c.Do("GET", "height:latest"))
+	return uint(latest)
+}
+
+func (s *wsSession) handle(req *wsRequest) (replies []interface{}) {
+	switch req.Op {
+	case "ping":
+		return []interface{}{map[string]interface{}{"type": "pong"}}
+	case "subscribe", "unsubscribe":
+	default:
+		return []interface{}{wsError(fmt.Sprintf("Unknown op %v", req.Op))}
+	}
+	key, channel, err := s.subscription(req)
+	if err != nil {
+		return []interface{}{wsError(err.Error())}
+	}
+	reply := map[string]interface{}{"type": req.Op + "d", "channel": req.Channel}
+	if req.Address != "" {
+		reply["address"] = req.Address
+	}
+	if req.Txid != "" {
+		reply["txid"] = req.Txid
+	}
+	if req.Op == "unsubscribe" {
+		if !s.subscriptions[key] {
+			return []interface{}{wsError(fmt.Sprintf("Not subscribed to %v", key))}
+		}
+		delete(s.subscriptions, key)
+		delete(s.txs, req.Txid)
+		if !s.channelUsed(channel) {
+			s.sub.Unsubscribe(channel)
+		}
+		return []interface{}{reply}
+	}
+
+	if !s.subscriptions[key] && len(s.subscriptions) >= wsmaxsubscriptions {
+		return []interface{}{wsError(fmt.Sprintf("At most %v subscriptions per connection", wsmaxsubscriptions))}
+	}
+	if req.Channel == "tx" {
+		confirmations := req.Confirmations
+		if confirmations == 0 {
+			confirmations = wsdefaultconfirmations
+		}
+		if confirmations < 1 || confirmations > wsmaxconfirmations {
+			return []interface{}{wsError(fmt.Sprintf("Confirmations must be between 1 and %v", wsmaxconfirmations))}
+		}
+		reply["confirmations"] = confirmations
+		// Already confirmed enough, nothing to wait for
+		status := s.txConfirmation(req.Txid, s.latestHeight())
+		if status["confirmations"].(uint) >= uint(confirmations) {
+			return []interface{}{reply, status}
+		}
+		s.txs[req.Txid] = confirmations
+		replies = append(replies, reply, status)
+	} else {
+		replies = append(replies, reply)
+	}
+	if err := s.sub.Subscribe(channel); err != nil {
+		return []interface{}{wsError(err.Error())}
+	}
+	s.subscriptions[key] = true
+	return
+}
+
+// Notifications for a hub message
+func (s *wsSession) notifications(msg *btcplex.HubMessage) (notifs []interface{}) {
+	data := json.RawMessage(msg.Data)
+	switch {
+	case msg.Channel == "btcplex:newblock":
+		if s.subscriptions["blocks"] {
+			notifs = append(notifs, map[string]interface{}{"type": "block", "data": data})
+		}
+		if len(s.txs) == 0 {
+			return
+		}
+		block := new(btcplex.Block)
+		if err := json.Unmarshal(msg.Data, block); err != nil {
+			return
+		}
+		for txid, confirmations := range s.txs {
+			status := s.txConfirmation(txid, block.Height)
+			if status["confirmations"].(uint) == 0 {
+				continue
+			}
+			notifs = append(notifs, status)
+			// The subscription ends once the tx has enough confirmations
+			if status["confirmations"].(uint) >= uint(confirmations) {
+				delete(s.txs, txid)
+				delete(s.subscriptions, "tx:"+txid)
+			}
+		}
+		if !s.channelUsed(msg.Channel) {
+			s.sub.Unsubscribe(msg.Channel)
+		}
+	case msg.Channel == "btcplex:utxs":
+		notifs = append(notifs, map[string]interface{}{"type": "tx", "data": data})
+	case strings.HasPrefix(msg.Channel, "addr:"):
+		address := strings.TrimSuffix(strings.TrimPrefix(msg.Channel, "addr:"), ":txs")
+		notifs = append(notifs, map[string]interface{}{"type": "address_tx", "address": address, "data": data})
+	}
+	return
+}
+
+// Serve the connection until the client leaves (or can't keep up with its notifications)
+func serveWebSocket(conn *websocket.Conn, hub *btcplex.Hub, ssdb *redis.Pool) {
+	defer conn.Close()
+	s := &wsSession{conn: conn, ssdb: ssdb, sub: hub.NewSubscriber(0), subscriptions: map[string]bool{}, txs: map[string]int{}}
+	defer s.sub.Close()
+
+	conn.SetReadLimit(wsmaxmessagesize)
+	conn.SetReadDeadline(time.Now().Add(wsreadtimeout))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsreadtimeout))
+		return nil
+	})
+
+	requests := make(chan interface{})
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(requests)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var request interface{} = wsError("Malformed message")
+			req := new(wsRequest)
+			if json.Unmarshal(data, req) == nil {
+				request = req
+			}
+			select {
+			case requests <- request:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wspinginterval)
+	defer ping.Stop()
+	for {
+		var msgs []interface{}
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				return
+			}
+			if req, valid := request.(*wsRequest); valid {
+				msgs = s.handle(req)
+			} else {
+				msgs = []interface{}{request}
+			}
+		case msg, ok := <-s.sub.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Too slow"),
+					time.Now().Add(wswritetimeout))
+				return
+			}
+			msgs = s.notifications(msg)
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wswritetimeout)); err != nil {
+				return
+			}
+		}
+		for _, msg := range msgs {
+			if err := s.write(msg); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Webhooks worker, queue deliveries from the btcplex:newblock, btcplex:utxs and addr:%v:txs
+// Redis channels and POST them to the registered URLs, retrying with exponential backoff.
+// Messages are handed to a queue so the subscription keeps up while webhooks are looked up.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docopt/docopt.go"
+	"github.com/garyburd/redigo/redis"
+
+	btcplex "github.com/mazaclub/btcplex/pkg"
+)
+
+const (
+	sendtimeout = 10 * time.Second
+	claimbatch  = 10
+)
+
+// Messages received but not processed yet, Redis drops subscribers that don't read fast enough
+const eventqueue = 10000
+
+type event struct {
+	channel string
+	data    []byte
+}
+
+type worker struct {
+	pool *redis.Pool
+	ssdb *redis.Pool
+}
+
+func (w *worker) queue(hook *btcplex.Webhook, payload map[string]interface{}) {
+	if _, err := btcplex.QueueWebhookDelivery(w.pool, hook, payload); err != nil {
+		log.Printf("Can't queue delivery for webhook %v: %v", hook.Id, err)
+	}
+}
+
+func (w *worker) checkTx(hook *btcplex.Webhook, latest uint) {
+	if _, err := btcplex.CheckTxWebhook(w.ssdb, w.pool, hook, latest); err != nil {
+		log.Printf("Can't check webhook %v: %v", hook.Id, err)
+	}
+}
+
+func (w *worker) newBlock(data []byte) {
+	block := new(btcplex.Block)
+	if err := json.Unmarshal(data, block); err != nil {
+		return
+	}
+	hooks, _ := btcplex.GetTargetWebhooks(w.pool, btcplex.WebhookBlock, "")
+	for _, hook := range hooks {
+		w.queue(hook, map[string]interface{}{"block": json.RawMessage(data)})
+	}
+	txids, _ := btcplex.GetPendingWebhookTxs(w.pool)
+	for _, txid := range txids {
+		hooks, _ := btcplex.GetTargetWebhooks(w.pool, btcplex.WebhookTx, txid)
+		for _, hook := range hooks {
+			w.checkTx(hook, block.Height)
+		}
+	}
+	// Address webhooks are fired from the memory pool, except for the txs mined before being seen there
+	txs, err := btcplex.ClaimUnpublishedBlockTxs(w.pool, block)
+	if err != nil {
+		log.Printf("Can't check block %v txs: %v", block.Hash, err)
+	}
+	for _, tx := range txs {
+		txjson, _ := json.Marshal(tx)
+		for _, address := range tx.Addresses() {
+			w.addressTx(address, txjson)
+		}
+	}
+}
+
+// Tx webhooks waiting for the tx to enter the memory pool
+func (w *worker) newTx(data []byte) {
+	tx := new(btcplex.Tx)
+	if err := json.Unmarshal(data, tx); err != nil {
+		return
+	}
+	hooks, _ := btcplex.GetTargetWebhooks(w.pool, btcplex.WebhookTx, tx.Hash)
+	for _, hook := range hooks {
+		if hook.Confirmations == 0 {
+			w.checkTx(hook, 0)
+		}
+	}
+}
+
+func (w *worker) addressTx(address string, data []byte) {
+	hooks, _ := btcplex.GetTargetWebhooks(w.pool, btcplex.WebhookAddress, address)
+	if len(hooks) == 0 {
+		return
+	}
+	tx := new(btcplex.Tx)
+	if err := json.Unmarshal(data, tx); err != nil {
+		return
+	}
+	// Only payments to the address are notified, not spends from it
+	received := btcplex.AddressReceived(tx, address)
+	if received == 0 {
+		return
+	}
+	for _, hook := range hooks {
+		w.queue(hook, map[string]interface{}{"address": address, "received": received, "tx": json.RawMessage(data)})
+	}
+}
+
+func (w *worker) process(events <-chan *event) {
+	for e := range events {
+		switch {
+		case e.channel == "btcplex:newblock":
+			w.newBlock(e.data)
+		case e.channel == "btcplex:utxs":
+			w.newTx(e.data)
+		default:
+			w.addressTx(strings.TrimSuffix(strings.TrimPrefix(e.channel, "addr:"), ":txs"), e.data)
+		}
+	}
+}
+
+func enqueue(events chan<- *event, e *event) {
+	select {
+	case events <- e:
+	default:
+		log.Printf("Event queue full, %v message dropped", e.channel)
+	}
+}
+
+func (w *worker) subscribe(events chan<- *event) {
+	for {
+		conn := w.pool.Get()
+		psc := redis.PubSubConn{Conn: conn}
+		psc.Subscribe("btcplex:newblock", "btcplex:utxs")
+		psc.PSubscribe("addr:*:txs")
+	receive:
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				enqueue(events, &event{v.Channel, v.Data})
+			case redis.PMessage:
+				enqueue(events, &event{v.Channel, v.Data})
+			case error:
+				log.Printf("Redis subscription error: %v", v)
+				break receive
+			}
+		}
+		conn.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+// Send the due deliveries, polling the queue every second when it's empty
+func (w *worker) deliver(client *http.Client) {
+	for {
+		deliveries, err := btcplex.ClaimWebhookDeliveries(w.pool, claimbatch)
+		if err != nil {
+			log.Printf("Can't claim deliveries: %v", err)
+		}
+		if len(deliveries) == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, delivery := range deliveries {
+			if err := btcplex.ProcessWebhookDelivery(w.pool, client, delivery); err != nil {
+				log.Printf("Delivery %v (attempt %v) of webhook %v failed: %v", delivery.Id, delivery.Attempt, delivery.WebhookId, err)
+			}
+		}
+	}
+}
+
+func main() {
+	usage := `Deliver the webhooks registered with the API.
+
+Usage:
+  btcplex-webhooks [--config=<path>] [--workers=<n>]
+  btcplex-webhooks -h | --help
+
+Options:
+  -h --help     	Show this screen.
+  -c <path>, --config <path>	Path to config file [default: config.json].
+  -w <n>, --workers <n>	Concurrent deliveries [default: 4].
+`
+
+	arguments, _ := docopt.Parse(usage, nil, true, "btcplex-webhooks", false)
+
+	confFile := "config.json"
+	if arguments["--config"] != nil {
+		confFile = arguments["--config"].(string)
+	}
+	workers := 4
+	if arguments["--workers"] != nil {
+		n, err := strconv.Atoi(arguments["--workers"].(string))
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid number of workers: %v", arguments["--workers"])
+		}
+		workers = n
+	}
+
+	if _, err := os.Stat(confFile); os.IsNotExist(err) {
+		log.Fatalf("Config file not found: %v", confFile)
+	}
+
+	conf, err := btcplex.LoadConfig(confFile)
+	if err != nil {
+		log.Fatalf("Can't load config file: %v", err)
+	}
+	pool, err := btcplex.GetRedis(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to Redis: %v", err)
+	}
+	ssdb, err := btcplex.GetSSDB(conf)
+	if err != nil {
+		log.Fatalf("Can't connect to SSDB: %v", err)
+	}
+
+	w := &worker{pool: pool, ssdb: ssdb}
+	client := btcplex.NewWebhookClient(sendtimeout)
+	for i := 1; i < workers; i++ {
+		go w.deliver(client)
+	}
+	events := make(chan *event, eventqueue)
+	go w.process(events)
+	go w.subscribe(events)
+	log.Printf("Delivering webhooks with %v workers\n", workers)
+	w.deliver(client)
+}
@@ -7,15 +7,19 @@ import (
 )
 
 type AddressData struct {
-	Address       string                       `json:"address"`
-	TxCnt         uint64                       `json:"n_tx"`
-	ReceivedCnt   uint64                       `json:"-"`
-	SentCnt       uint64                       `json:"-"`
-	TotalReceived uint64                       `json:"total_received"`
-	TotalSent     uint64                       `json:"total_sent"`
-	FinalBalance  uint64                       `json:"final_balance"`
-	Txs           []*Tx                        `json:"txs"`
-	Links         map[string]map[string]string `json:"_links,omitempty"`
+	Address             string                       `json:"address"`
+	TxCnt               uint64                       `json:"n_tx"`
+	ReceivedCnt         uint64                       `json:"-"`
+	SentCnt             uint64                       `json:"-"`
+	TotalReceived       uint64                       `json:"total_received"`
+	TotalSent           uint64                       `json:"total_sent"`
+	FinalBalance        uint64                       `json:"final_balance"`
+	UnconfirmedTxCnt    uint64                       `json:"n_unconfirmed_tx"`
+	UnconfirmedReceived uint64                       `json:"unconfirmed_received"`
+	UnconfirmedSent     uint64                       `json:"unconfirmed_sent"`
+	PendingBalance      int64                        `json:"pending_balance"`
+	Txs                 []*Tx                        `json:"txs"`
+	Links               map[string]map[string]string `json:"_links,omitempty"`
 }
 
 type AddressHash struct {
@@ -35,10 +39,10 @@ func GetAddress(rpool *redis.Pool, address string) (addressdata *AddressData, er
 	addressh := new(AddressHash)
 	v, err := redis.Values(c.Do("HGETALL", fmt.Sprintf("addr:%v:h", address)))
 	if err != nil {
-		panic(err)
+		return
 	}
-	if err := redis.ScanStruct(v, addressh); err != nil {
-		panic(err)
+	if err = redis.ScanStruct(v, addressh); err != nil {
+		return
 	}
 
 	totalreceived := uint64(addressh.TotalReceived)
@@ -57,25 +61,84 @@ func GetAddress(rpool *redis.Pool, address string) (addressdata *AddressData, er
 	addressdata.Address = address
 	addressdata.SentCnt = uint64(sentcnt)
 	addressdata.ReceivedCnt = uint64(receivedcnt)
+	addressdata.PendingBalance = int64(finalbalance)
 
 	return
 }
 
+// Compute unconfirmed received/sent and pending balance from the Redis memory pool store
+func (addrData *AddressData) FetchUnconfirmed(pool *redis.Pool) (err error) {
+	utxs, err := GetUnconfirmedTxsByAddress(pool, addrData.Address)
+	if err != nil {
+		return
+	}
+	addrData.UnconfirmedTxCnt = 0
+	addrData.UnconfirmedReceived = 0
+	addrData.UnconfirmedSent = 0
+	seen := map[string]bool{}
+	for _, utx := range utxs {
+		if seen[utx.Hash] {
+			continue
+		}
+		seen[utx.Hash] = true
+		addrData.UnconfirmedTxCnt++
+		for _, txi := range utx.TxIns {
+			if txi.PrevOut.Address == addrData.Address {
+				addrData.UnconfirmedSent += txi.PrevOut.Value
+			}
+		}
+		for _, txo := range utx.TxOuts {
+			if txo.Addr == addrData.Address {
+				addrData.UnconfirmedReceived += txo.Value
+			}
+		}
+	}
+	addrData.PendingBalance = int64(addrData.FinalBalance) + int64(addrData.UnconfirmedReceived) - int64(addrData.UnconfirmedSent)
+	return
+}
+
+// Fetch the address txs from start to stop (both included), newest first
 func (addrData *AddressData) FetchTxs(rpool *redis.Pool, start, stop int) (err error) {
 	c := rpool.Get()
 	defer c.Close()
 
-	txs := []*Tx{}
-
 	zkey := fmt.Sprintf("addr:%v", addrData.Address)
 
 	data, err := redis.Strings(c.Do("ZREVRANGE", zkey, start, stop))
 	if err != nil {
 		return
 	}
+	addrData.Txs, err = addrData.buildTxs(rpool, data)
+	return
+}
+
+// Return the hashes of the address txs from start to stop (both included), newest first
+func GetAddressTxHashes(rpool *redis.Pool, address string, start, stop int) (hashes []string, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	return redis.Strings(c.Do("ZREVRANGE", fmt.Sprintf("addr:%v", address), start, stop))
+}
+
+// Fetch up to limit txs following the cursor (nil for the most recent ones),
+// txs with the same block time are ordered by hash and skipped by offset so pages are stable
+func (addrData *AddressData) FetchTxsFromCursor(rpool *redis.Pool, cursor *Cursor, limit int) (next *Cursor, err error) {
+	c := rpool.Get()
+	defer c.Close()
+
+	data, next, err := ZRevRangeFromCursor(c, fmt.Sprintf("addr:%v", addrData.Address), cursor, limit)
+	if err != nil {
+		return
+	}
+	addrData.Txs, err = addrData.buildTxs(rpool, data)
+	return
+}
+
+// Fetch the given txs along with the address related info
+func (addrData *AddressData) buildTxs(rpool *redis.Pool, hashes []string) (txs []*Tx, err error) {
+	txs = []*Tx{}
 	txs1 := []*Tx{}
 
-	for _, txd := range data {
+	for _, txd := range hashes {
 		tx, txerr := GetTx(rpool, txd)
 		if txerr != nil {
 			err = txerr
@@ -85,33 +148,40 @@ func (addrData *AddressData) FetchTxs(rpool *redis.Pool, start, stop int) (err e
 	}
 
 	for _, ctx := range txs1 {
-		txaddressinfo := new(TxAddressInfo)
-		for _, txi := range ctx.TxIns {
-			if txi.PrevOut.Address == addrData.Address {
-				txaddressinfo.InTxIn = true
-				txaddressinfo.Value -= int64(txi.PrevOut.Value)
-			}
+		ctx.TxAddressInfo = ctx.AddressInfo(addrData.Address)
+		txs = append(txs, ctx)
+	}
+	return
+}
+
+// Return how the transaction involves the address, Value being the address balance change
+func (tx *Tx) AddressInfo(address string) (txaddressinfo *TxAddressInfo) {
+	txaddressinfo = new(TxAddressInfo)
+	for _, txi := range tx.TxIns {
+		if txi.PrevOut.Address == address {
+			txaddressinfo.InTxIn = true
+			txaddressinfo.Value -= int64(txi.PrevOut.Value)
 		}
-		for _, txo := range ctx.TxOuts {
-			if txo.Addr == addrData.Address {
-				txaddressinfo.InTxOut = true
-				txaddressinfo.Value += int64(txo.Value)
-			}
+	}
+	for _, txo := range tx.TxOuts {
+		if txo.Addr == address {
+			txaddressinfo.InTxOut = true
+			txaddressinfo.Value += int64(txo.Value)
 		}
-		ctx.TxAddressInfo = txaddressinfo
-		txs = append(txs, ctx)
 	}
-	addrData.Txs = txs
 	return
 }
 
-// Return the block time at which the address first appeared
+// Return the block time at which the address first appeared, 0 if it never did
 func AddressFirstSeen(rpool *redis.Pool, address string) (firstseen uint64, err error) {
 	c := rpool.Get()
 	defer c.Close()
 
 	zkey := fmt.Sprintf("addr:%v", address)
-	data, _ := redis.Strings(c.Do("ZRANGE", zkey, 0, 0, "withscores"))
+	data, err := redis.Strings(c.Do("ZRANGE", zkey, 0, 0, "withscores"))
+	if err != nil || len(data) < 2 {
+		return
+	}
 	txoutblocktime, _ := strconv.Atoi(data[1])
 	firstseen = uint64(txoutblocktime)
 	return
@@ -124,10 +194,10 @@ func GetReceivedByAddress(rpool *redis.Pool, address string) (total uint64, err
 	addressh := new(AddressHash)
 	v, err := redis.Values(c.Do("HGETALL", fmt.Sprintf("addr:%v:h", address)))
 	if err != nil {
-		panic(err)
+		return
 	}
-	if err := redis.ScanStruct(v, addressh); err != nil {
-		panic(err)
+	if err = redis.ScanStruct(v, addressh); err != nil {
+		return
 	}
 	total = uint64(addressh.TotalReceived)
 	return
@@ -140,10 +210,10 @@ func GetSentByAddress(rpool *redis.Pool, address string) (total uint64, err erro
 	addressh := new(AddressHash)
 	v, err := redis.Values(c.Do("HGETALL", fmt.Sprintf("addr:%v:h", address)))
 	if err != nil {
-		panic(err)
+		return
 	}
-	if err := redis.ScanStruct(v, addressh); err != nil {
-		panic(err)
+	if err = redis.ScanStruct(v, addressh); err != nil {
+		return
 	}
 	total = uint64(addressh.TotalSent)
 	return
@@ -156,11 +226,148 @@ func AddressBalance(rpool *redis.Pool, address string) (balance uint64, err erro
 	addressh := new(AddressHash)
 	v, err := redis.Values(c.Do("HGETALL", fmt.Sprintf("addr:%v:h", address)))
 	if err != nil {
-		panic(err)
+		return
 	}
-	if err := redis.ScanStruct(v, addressh); err != nil {
-		panic(err)
+	if err = redis.ScanStruct(v, addressh); err != nil {
+		return
 	}
 	balance = uint64(addressh.TotalReceived - addressh.TotalSent)
 	return
 }
+
+// Block times are only loosely ordered by height (a block can be up to 2 hours
+// older than its parent median time), height ranges are widened by this margin
+const blockTimeDrift = 7200
+
+// Filters for the address transactions history, zero values mean no filter
+type AddressTxsFilter struct {
+	Direction  string // "sent", "received" or "all"
+	FromTime   uint32
+	ToTime     uint32
+	FromHeight uint
+	ToHeight   uint
+	MinValue   uint64
+	Asc        bool
+}
+
+// Sorted set to query: addr:%v, addr:%v:sent or addr:%v:received (all scored by block time)
+func (filter *AddressTxsFilter) key(address string) string {
+	switch filter.Direction {
+	case "sent", "received":
+		return fmt.Sprintf("addr:%v:%v", address, filter.Direction)
+	}
+	return fmt.Sprintf("addr:%v", address)
+}
+
+// Block time range to query, derived from both the time and height ranges
+func (filter *AddressTxsFilter) scoreRange(rpool *redis.Pool) (min, max string, err error) {
+	mintime, maxtime := int64(filter.FromTime), int64(filter.ToTime)
+	if filter.FromHeight > 0 {
+		block, berr := getBlockByHeight(rpool, filter.FromHeight)
+		if berr != nil {
+			err = berr
+			return
+		}
+		if t := int64(block.BlockTime) - blockTimeDrift; t > mintime {
+			mintime = t
+		}
+	}
+	if filter.ToHeight > 0 {
+		block, berr := getBlockByHeight(rpool, filter.ToHeight)
+		if berr == ErrNotFound {
+			// Not mined yet
+			berr = nil
+			block = nil
+		}
+		if berr != nil {
+			err = berr
+			return
+		}
+		if block != nil {
+			if t := int64(block.BlockTime) + blockTimeDrift; maxtime == 0 || t < maxtime {
+				maxtime = t
+			}
+		}
+	}
+	min, max = "-inf", "+inf"
+	if mintime > 0 {
+		min = strconv.FormatInt(mintime, 10)
+	}
+	if maxtime > 0 {
+		max = strconv.FormatInt(maxtime, 10)
+	}
+	return
+}
+
+// Check the filters that can't be applied on the sorted set (exact heights and value)
+func (filter *AddressTxsFilter) match(tx *Tx) bool {
+	if tx.BlockHeight < filter.FromHeight || (filter.ToHeight > 0 && tx.BlockHeight > filter.ToHeight) {
+		return false
+	}
+	if filter.MinValue > 0 {
+		value := tx.TxAddressInfo.Value
+		if value < 0 {
+			value = -value
+		}
+		if uint64(value) < filter.MinValue {
+			return false
+		}
+	}
+	return true
+}
+
+// Entries of the sorted set scanned per page, times the limit
+const FilteredTxsScanFactor = 10
+
+// Fetch up to limit txs matching the filter following the cursor, at most FilteredTxsScanFactor * limit
+// txs are scanned so a page may be short (or even empty) when few txs match, next resumes the scan
+func (addrData *AddressData) FetchFilteredTxs(rpool *redis.Pool, filter *AddressTxsFilter, cursor *Cursor, limit int) (next *Cursor, err error) {
+	c := rpool.Get()
+	defer c.Close()
+
+	min, max, err := filter.scoreRange(rpool)
+	if err != nil {
+		return
+	}
+	key := filter.key(addrData.Address)
+	addrData.Txs = []*Tx{}
+	scanned := 0
+	for {
+		members, scores, znext, zerr := ZRangeFromCursor(c, key, min, max, filter.Asc, cursor, limit)
+		if zerr != nil {
+			err = zerr
+			return
+		}
+		txs, terr := addrData.buildTxs(rpool, members)
+		if terr != nil {
+			err = terr
+			return
+		}
+		for i, tx := range txs {
+			if len(addrData.Txs) == limit {
+				return
+			}
+			if filter.match(tx) {
+				addrData.Txs = append(addrData.Txs, tx)
+			}
+			next = CursorAfter(cursor, scores[:i+1])
+		}
+		if znext == nil {
+			next = nil
+			return
+		}
+		cursor = znext
+		scanned += len(members)
+		if len(addrData.Txs) == limit || scanned >= FilteredTxsScanFactor*limit {
+			return
+		}
+	}
+}
+
+func getBlockByHeight(rpool *redis.Pool, height uint) (block *Block, err error) {
+	hash, err := GetBlockHash(rpool, height)
+	if err != nil {
+		return
+	}
+	return GetBlockByHash(rpool, hash)
+}
@@ -0,0 +1,51 @@
+package btcplex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddressTxsFilter(t *testing.T) {
+	tx := &Tx{BlockHeight: 1000, TxAddressInfo: &TxAddressInfo{Value: -5000}}
+	tests := []struct {
+		filter *AddressTxsFilter
+		key    string
+		match  bool
+	}{
+		{&AddressTxsFilter{}, "addr:A", true},
+		{&AddressTxsFilter{Direction: "all"}, "addr:A", true},
+		{&AddressTxsFilter{Direction: "sent", MinValue: 5000}, "addr:A:sent", true},
+		{&AddressTxsFilter{Direction: "received", MinValue: 5001}, "addr:A:received", false},
+		{&AddressTxsFilter{FromHeight: 1000, ToHeight: 1000}, "addr:A", true},
+		{&AddressTxsFilter{FromHeight: 1001}, "addr:A", false},
+		{&AddressTxsFilter{ToHeight: 999}, "addr:A", false},
+	}
+	for _, test := range tests {
+		if key := test.filter.key("A"); key != test.key {
+			t.Errorf("%+v key = %v, want %v", test.filter, key, test.key)
+		}
+		if match := test.filter.match(tx); match != test.match {
+			t.Errorf("%+v match = %v, want %v", test.filter, match, test.match)
+		}
+	}
+}
+
+func TestUnspentOutputs(t *testing.T) {
+	tx := &Tx{Hash: "tx", BlockHeight: 10, BlockTime: 1000, TxOuts: []*TxOut{
+		{Addr: "A", Value: 1000, Index: 0, Spent: &TxoSpent{Spent: true}},
+		{Addr: "B", Value: 2000, Index: 1},
+		{Addr: "A", Value: 3000, Index: 2, Spent: &TxoSpent{Spent: true, Unconfirmed: true}},
+		{Addr: "A", Value: 4000, Index: 3, Spent: &TxoSpent{}},
+		{Addr: "A", Value: 5000, Index: 4},
+	}}
+	want := []*UnspentOutput{
+		{TxHash: "tx", Index: 3, Address: "A", Value: 4000, BlockHeight: 10, BlockTime: 1000},
+		{TxHash: "tx", Index: 4, Address: "A", Value: 5000, BlockHeight: 10, BlockTime: 1000},
+	}
+	if unspent := tx.unspentOutputs("A"); !reflect.DeepEqual(unspent, want) {
+		t.Errorf("unspentOutputs(A) = %+v, want %+v", unspent, want)
+	}
+	if unspent := tx.unspentOutputs("C"); len(unspent) != 0 {
+		t.Errorf("unspentOutputs(C) = %+v, want none", unspent)
+	}
+}
@@ -0,0 +1,84 @@
+package btcplex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+var ErrInvalidBase58 = errors.New("Invalid base58 string")
+var ErrInvalidChecksum = errors.New("Invalid checksum")
+
+var big58 = big.NewInt(58)
+
+// Encode bytes in base58 (using tmpl, the alphabet from addresscheck.go),
+// leading zero bytes are encoded as leading 1s
+func Base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+	out := []byte{}
+	for n.Sign() > 0 {
+		n.DivMod(n, big58, mod)
+		out = append(out, tmpl[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, tmpl[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func Base58Decode(s string) (data []byte, err error) {
+	n := new(big.Int)
+	for _, c := range []byte(s) {
+		i := bytes.IndexByte(tmpl, c)
+		if i < 0 {
+			return nil, ErrInvalidBase58
+		}
+		n.Mul(n, big58)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == tmpl[0] {
+		zeros++
+	}
+	data = append(make([]byte, zeros), n.Bytes()...)
+	return
+}
+
+func checksum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	h = sha256.Sum256(h[:])
+	return h[:4]
+}
+
+// Base58 with a 4 bytes double SHA-256 checksum, as used by addresses and extended keys
+func Base58CheckEncode(data []byte) string {
+	return Base58Encode(append(append([]byte{}, data...), checksum(data)...))
+}
+
+func Base58CheckDecode(s string) (data []byte, err error) {
+	raw, err := Base58Decode(s)
+	if err != nil {
+		return
+	}
+	if len(raw) < 4 {
+		return nil, ErrInvalidChecksum
+	}
+	data = raw[:len(raw)-4]
+	if !bytes.Equal(checksum(data), raw[len(raw)-4:]) {
+		return nil, ErrInvalidChecksum
+	}
+	return
+}
+
+// Encode a pubkey hash address for the active chain
+func PubKeyHashAddress(hash160 []byte) string {
+	return Base58CheckEncode(append([]byte{ActiveChainParams.PubKeyHashAddrID}, hash160...))
+}
@@ -0,0 +1,151 @@
+package btcplex
+
+// Blockchain.info JSON format (rawtx, rawblock and rawaddr), scripts aren't stored so they're
+// always empty and tx_index is always 0, blocks are indexed by height
+
+type BlockchainOutput struct {
+	Type    int    `json:"type"`
+	Spent   bool   `json:"spent"`
+	Value   uint64 `json:"value"`
+	N       uint32 `json:"n"`
+	TxIndex uint64 `json:"tx_index"`
+	Script  string `json:"script"`
+	Addr    string `json:"addr,omitempty"`
+}
+
+type BlockchainInput struct {
+	Sequence uint32            `json:"sequence"`
+	Script   string            `json:"script"`
+	PrevOut  *BlockchainOutput `json:"prev_out,omitempty"`
+}
+
+type BlockchainTx struct {
+	Hash        string              `json:"hash"`
+	Ver         uint32              `json:"ver"`
+	VinSz       uint32              `json:"vin_sz"`
+	VoutSz      uint32              `json:"vout_sz"`
+	LockTime    uint32              `json:"lock_time"`
+	Size        uint32              `json:"size"`
+	RelayedBy   string              `json:"relayed_by"`
+	BlockHeight *uint               `json:"block_height,omitempty"`
+	TxIndex     uint64              `json:"tx_index"`
+	Time        uint32              `json:"time"`
+	Fee         uint64              `json:"fee"`
+	DoubleSpend bool                `json:"double_spend"`
+	Result      *int64              `json:"result,omitempty"`
+	Inputs      []*BlockchainInput  `json:"inputs"`
+	Out         []*BlockchainOutput `json:"out"`
+}
+
+type BlockchainBlock struct {
+	Hash         string          `json:"hash"`
+	Ver          uint32          `json:"ver"`
+	PrevBlock    string          `json:"prev_block"`
+	NextBlock    []string        `json:"next_block"`
+	MrklRoot     string          `json:"mrkl_root"`
+	Time         uint32          `json:"time"`
+	Bits         uint32          `json:"bits"`
+	Fee          uint64          `json:"fee"`
+	Nonce        uint32          `json:"nonce"`
+	NTx          uint32          `json:"n_tx"`
+	Size         uint32          `json:"size"`
+	BlockIndex   uint            `json:"block_index"`
+	MainChain    bool            `json:"main_chain"`
+	Height       uint            `json:"height"`
+	ReceivedTime uint32          `json:"received_time"`
+	RelayedBy    string          `json:"relayed_by"`
+	Tx           []*BlockchainTx `json:"tx"`
+}
+
+type BlockchainAddress struct {
+	Hash160       string          `json:"hash160"`
+	Address       string          `json:"address"`
+	NTx           uint64          `json:"n_tx"`
+	TotalReceived uint64          `json:"total_received"`
+	TotalSent     uint64          `json:"total_sent"`
+	FinalBalance  uint64          `json:"final_balance"`
+	Txs           []*BlockchainTx `json:"txs"`
+}
+
+func NewBlockchainTx(tx *Tx) (btx *BlockchainTx) {
+	btx = &BlockchainTx{
+		Hash:        tx.Hash,
+		Ver:         tx.Version,
+		VinSz:       tx.TxInCnt,
+		VoutSz:      tx.TxOutCnt,
+		LockTime:    tx.LockTime,
+		Size:        tx.Size,
+		RelayedBy:   "0.0.0.0",
+		Time:        tx.BlockTime,
+		Fee:         tx.Fee(),
+		DoubleSpend: tx.DoubleSpent,
+		Inputs:      []*BlockchainInput{},
+		Out:         []*BlockchainOutput{},
+	}
+	if tx.BlockHash != "" {
+		height := tx.BlockHeight
+		btx.BlockHeight = &height
+	} else {
+		btx.Time = tx.FirstSeenTime
+	}
+	if tx.TxAddressInfo != nil {
+		result := tx.TxAddressInfo.Value
+		btx.Result = &result
+	}
+	for _, txi := range tx.TxIns {
+		input := &BlockchainInput{Sequence: txi.Sequence}
+		if txi.PrevOut != nil {
+			input.PrevOut = &BlockchainOutput{Spent: true, Value: txi.PrevOut.Value, N: txi.PrevOut.Vout, Addr: txi.PrevOut.Address}
+		}
+		btx.Inputs = append(btx.Inputs, input)
+	}
+	for _, txo := range tx.TxOuts {
+		btx.Out = append(btx.Out, &BlockchainOutput{Spent: txo.Spent != nil && txo.Spent.Spent, Value: txo.Value, N: txo.Index, Addr: txo.Addr})
+	}
+	return
+}
+
+func NewBlockchainBlock(block *Block) (bblock *BlockchainBlock) {
+	bblock = &BlockchainBlock{
+		Hash:         block.Hash,
+		Ver:          block.Version,
+		PrevBlock:    block.Parent,
+		NextBlock:    []string{},
+		MrklRoot:     block.MerkleRoot,
+		Time:         block.BlockTime,
+		Bits:         block.Bits,
+		Nonce:        block.Nonce,
+		NTx:          block.TxCnt,
+		Size:         block.Size,
+		BlockIndex:   block.Height,
+		MainChain:    block.Main,
+		Height:       block.Height,
+		ReceivedTime: block.BlockTime,
+		RelayedBy:    "0.0.0.0",
+		Tx:           []*BlockchainTx{},
+	}
+	if block.Next != "" {
+		bblock.NextBlock = append(bblock.NextBlock, block.Next)
+	}
+	for _, tx := range block.Txs {
+		bblock.Fee += tx.Fee()
+		bblock.Tx = append(bblock.Tx, NewBlockchainTx(tx))
+	}
+	return
+}
+
+func NewBlockchainAddress(addrData *AddressData) (baddr *BlockchainAddress) {
+	baddr = &BlockchainAddress{
+		Address:       addrData.Address,
+		NTx:           addrData.TxCnt,
+		TotalReceived: addrData.TotalReceived,
+		TotalSent:     addrData.TotalSent,
+		FinalBalance:  addrData.FinalBalance,
+		Txs:           []*BlockchainTx{},
+	}
+	baddr.Hash160, _ = AddressToHash(addrData.Address)
+	for _, tx := range addrData.Txs {
+		baddr.Txs = append(baddr.Txs, NewBlockchainTx(tx))
+	}
+	return
+}
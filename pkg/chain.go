@@ -0,0 +1,165 @@
+package btcplex
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Chain selection, every known block is kept with its cumulative work (see difficulty.go),
+// the blocks without children are indexed in ``chaintips`` (hash sorted by height) and the
+// main chain ends at the tip with the most work
+
+const ChainTipsKey = "chaintips"
+
+type ChainTip struct {
+	Height    uint   `json:"height"`
+	Hash      string `json:"hash"`
+	BranchLen int    `json:"branchlen"`
+	Status    string `json:"status"`
+	Chainwork string `json:"chainwork,omitempty"`
+}
+
+// Hash of the main chain tip, empty if no block is indexed
+func GetBestBlockHash(c redis.Conn) string {
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return ""
+	}
+	hash, _ := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", latest)))
+	return hash
+}
+
+// Store the block position in the tree of known blocks, it isn't part of the main chain yet
+func SaveBlockHeader(c redis.Conn, block *Block) {
+	c.Do("ZADD", fmt.Sprintf("height:%v", block.Height), block.BlockTime, block.Hash)
+	c.Do("HMSET", fmt.Sprintf("block:%v:h", block.Hash), "parent", block.Parent, "height", block.Height)
+	SaveChainWork(c, block)
+	AddChainTip(c, block)
+}
+
+// The block replaces its parent as a tip
+func AddChainTip(c redis.Conn, block *Block) {
+	if block.Parent != "" {
+		c.Do("ZREM", ChainTipsKey, block.Parent)
+	}
+	c.Do("ZADD", ChainTipsKey, block.Height, block.Hash)
+}
+
+// Whether the block extends the main chain or has more work than its tip, the latest
+// block wins if the chain work isn't indexed
+func IsBestChain(c redis.Conn, block *Block, besthash string) bool {
+	if besthash == "" || block.Parent == besthash {
+		return true
+	}
+	work, err := GetChainWork(c, block.Hash)
+	if err != nil {
+		return true
+	}
+	bestwork, err := GetChainWork(c, besthash)
+	if err != nil {
+		return true
+	}
+	return work.Cmp(bestwork) > 0
+}
+
+// Walk back from the block until the main chain, return the side branch blocks (newest first)
+// and the height of the fork point
+func FindFork(rpool *redis.Pool, hash string) (branch []string, forkheight uint, err error) {
+	branch = []string{}
+	for {
+		meta, merr := NewBlockMeta(rpool, hash)
+		if merr != nil {
+			return nil, 0, merr
+		}
+		if meta.Main {
+			return branch, uint(meta.Height), nil
+		}
+		if meta.Parent == "" {
+			return nil, 0, ErrNotFound
+		}
+		branch = append(branch, hash)
+		hash = meta.Parent
+	}
+}
+
+// Remove a block from the main chain, reverting its transactions and statistics
+func DisconnectBlock(rpool *redis.Pool, c redis.Conn, hash string) (err error) {
+	meta, err := NewBlockMeta(rpool, hash)
+	if err != nil {
+		return
+	}
+	c.Do("HSET", fmt.Sprintf("block:%v:h", hash), "main", false)
+	if oblock, oerr := GetBlockCachedByHash(rpool, hash); oerr == nil {
+		for _, otx := range oblock.Txs {
+			otx.Revert(rpool)
+		}
+	}
+	if err = RevertBlockStats(c, hash); err != nil {
+		return
+	}
+	if err = RevertBlockFeeStats(c, hash); err != nil {
+		return
+	}
+	_, err = c.Do("DEL", fmt.Sprintf("block:height:%v", meta.Height))
+	return
+}
+
+// Return every known tip, highest first, like bitcoind getchaintips
+func GetChainTips(rpool *redis.Pool) (tips []*ChainTip, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	tips = []*ChainTip{}
+	cnt, err := redis.Int(c.Do("ZCARD", ChainTipsKey))
+	if err != nil || cnt == 0 {
+		return
+	}
+	// SSDB doesn't support negative slice yet
+	hashes, err := redis.Strings(c.Do("ZREVRANGE", ChainTipsKey, 0, cnt-1))
+	if err != nil {
+		return
+	}
+	for _, hash := range hashes {
+		meta, merr := NewBlockMeta(rpool, hash)
+		if merr != nil {
+			return nil, merr
+		}
+		tip := &ChainTip{Height: uint(meta.Height), Hash: hash, Status: "active", Chainwork: meta.Chainwork}
+		if !meta.Main {
+			branch, _, ferr := FindFork(rpool, hash)
+			if ferr != nil {
+				return nil, ferr
+			}
+			tip.BranchLen = len(branch)
+			tip.Status = "valid-fork"
+		}
+		tips = append(tips, tip)
+	}
+	return
+}
+
+// Index the tips of the blocks stored up to the latest height, progress is reported every 1000 blocks
+func RebuildChainTips(rpool *redis.Pool, progress func(height int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	for height := 0; height <= latest; height++ {
+		if progress != nil && height%1000 == 0 {
+			progress(height)
+		}
+		heightkey := fmt.Sprintf("height:%v", height)
+		cnt, _ := redis.Int(c.Do("ZCARD", heightkey))
+		hashes, herr := redis.Strings(c.Do("ZRANGE", heightkey, 0, cnt-1))
+		if herr != nil {
+			return herr
+		}
+		for _, hash := range hashes {
+			parent, _ := redis.String(c.Do("HGET", fmt.Sprintf("block:%v:h", hash), "parent"))
+			AddChainTip(c, &Block{Hash: hash, Height: uint(height), Parent: parent})
+		}
+	}
+	return
+}
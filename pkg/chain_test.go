@@ -0,0 +1,49 @@
+package btcplex
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Serve HGET replies from a map of "key field" to value
+type hashConn struct {
+	recordConn
+	fields map[string]string
+}
+
+func (c *hashConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "HGET" {
+		if v, ok := c.fields[fmt.Sprintf("%v %v", args[0], args[1])]; ok {
+			return []byte(v), nil
+		}
+	}
+	return nil, nil
+}
+
+func TestIsBestChain(t *testing.T) {
+	c := &hashConn{fields: map[string]string{
+		"block:best:h chainwork":  formatChainWork(BlockWork(0x1d00ffff)),
+		"block:more:h chainwork":  formatChainWork(BlockWork(0x1c00ffff)),
+		"block:equal:h chainwork": formatChainWork(BlockWork(0x1d00ffff)),
+		"block:less:h chainwork":  formatChainWork(BlockWork(0x1e00ffff)),
+	}}
+	tests := []struct {
+		block    *Block
+		besthash string
+		best     bool
+	}{
+		{&Block{Hash: "less", Parent: "best"}, "best", true},
+		{&Block{Hash: "more", Parent: "other"}, "best", true},
+		// First seen wins
+		{&Block{Hash: "equal", Parent: "other"}, "best", false},
+		{&Block{Hash: "less", Parent: "other"}, "best", false},
+		// Chain work not indexed
+		{&Block{Hash: "unknown", Parent: "other"}, "best", true},
+		{&Block{Hash: "less", Parent: "other"}, "", true},
+	}
+	for _, test := range tests {
+		if best := IsBestChain(c, test.block, test.besthash); best != test.best {
+			t.Errorf("IsBestChain(%v, %v) = %v, want %v", test.block.Hash, test.besthash, best, test.best)
+		}
+	}
+}
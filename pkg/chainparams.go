@@ -0,0 +1,45 @@
+package btcplex
+
+import (
+	"fmt"
+)
+
+// Network specific parameters (address and extended keys version bytes, block spacing in seconds)
+type ChainParams struct {
+	Name             string
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	HDPublicKeyID    [4]byte
+	HDPrivateKeyID   [4]byte
+	TargetSpacing    uint32
+}
+
+var MazaCoinParams = &ChainParams{
+	Name:             "mazacoin",
+	PubKeyHashAddrID: 50,
+	ScriptHashAddrID: 9,
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+	HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+	TargetSpacing:    120,
+}
+
+var BitcoinParams = &ChainParams{
+	Name:             "bitcoin",
+	PubKeyHashAddrID: 0,
+	ScriptHashAddrID: 5,
+	HDPublicKeyID:    [4]byte{0x04, 0x88, 0xb2, 0x1e},
+	HDPrivateKeyID:   [4]byte{0x04, 0x88, 0xad, 0xe4},
+	TargetSpacing:    600,
+}
+
+// Params of the chain being indexed, set with the "chain" config key
+var ActiveChainParams = MazaCoinParams
+
+func GetChainParams(name string) (params *ChainParams, err error) {
+	for _, params = range []*ChainParams{MazaCoinParams, BitcoinParams} {
+		if params.Name == name {
+			return
+		}
+	}
+	return nil, fmt.Errorf("Unknown chain %v", name)
+}
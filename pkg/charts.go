@@ -0,0 +1,415 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Chain statistics, each block stats are saved once (``block:%v:stats``) and
+// added to its day totals (``stats:day:%v``, days since epoch) and to the unclaimed
+// supply (see supply.go), orphaned blocks are reverted using the saved stats
+
+var ErrUnknownMetric = errors.New("Unknown metric")
+var ErrUnknownResolution = errors.New("Unknown resolution")
+var ErrChartRangeTooLarge = errors.New("Range too large for the resolution")
+
+// Max number of points returned with the block resolution
+const MaxChartBlocks = 2000
+
+// Day totals only hold integers, difficulty is summed in thousandths
+const difficultyScale = 1000
+
+type BlockStats struct {
+	Height     uint    `json:"height"`
+	Time       uint32  `json:"time"`
+	TxCnt      uint32  `json:"n_tx"`
+	Size       uint32  `json:"size"`
+	Volume     uint64  `json:"volume"`
+	Fees       uint64  `json:"fees"`
+	Interval   int64   `json:"interval"`
+	Difficulty float64 `json:"difficulty"`
+	Unclaimed  uint64  `json:"unclaimed"`
+}
+
+// Day totals, averages are computed using the number of blocks
+type DayStats struct {
+	Day        int64 `redis:"-"`
+	Blocks     int64 `redis:"blocks"`
+	TxCnt      int64 `redis:"txs"`
+	Size       int64 `redis:"size"`
+	Volume     int64 `redis:"volume"`
+	Fees       int64 `redis:"fees"`
+	Interval   int64 `redis:"interval"`
+	Difficulty int64 `redis:"difficulty"`
+}
+
+type ChartPoint struct {
+	X int64   `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type chartMetric struct {
+	Field   string
+	Average bool
+	Unit    string
+	Scale   float64
+}
+
+// Available metrics, and the day totals field they are computed from
+var ChartMetrics = map[string]*chartMetric{
+	"blocks":         {"blocks", false, "blocks", 1},
+	"transactions":   {"txs", false, "transactions", 1},
+	"volume":         {"volume", false, "satoshis", 1},
+	"fees":           {"fees", false, "satoshis", 1},
+	"block-size":     {"size", true, "bytes", 1},
+	"block-interval": {"interval", true, "seconds", 1},
+	"difficulty":     {"difficulty", true, "difficulty", difficultyScale},
+}
+
+// Time ranges accepted by the charts API, in seconds (0 for the whole chain)
+var ChartRanges = map[string]int64{
+	"24h": 86400,
+	"7d":  7 * 86400,
+	"30d": 30 * 86400,
+	"90d": 90 * 86400,
+	"1y":  365 * 86400,
+	"all": 0,
+}
+
+func (stats *BlockStats) value(field string) float64 {
+	switch field {
+	case "blocks":
+		return 1
+	case "txs":
+		return float64(stats.TxCnt)
+	case "size":
+		return float64(stats.Size)
+	case "volume":
+		return float64(stats.Volume)
+	case "fees":
+		return float64(stats.Fees)
+	case "interval":
+		return float64(stats.Interval)
+	case "difficulty":
+		return stats.Difficulty
+	}
+	return 0
+}
+
+func (stats *DayStats) value(field string) int64 {
+	switch field {
+	case "blocks":
+		return stats.Blocks
+	case "txs":
+		return stats.TxCnt
+	case "size":
+		return stats.Size
+	case "volume":
+		return stats.Volume
+	case "fees":
+		return stats.Fees
+	case "interval":
+		return stats.Interval
+	case "difficulty":
+		return stats.Difficulty
+	}
+	return 0
+}
+
+// Compute the stats of a block with its transactions, generation transactions have no TxIns
+func NewBlockStats(block *Block, parenttime uint32) (stats *BlockStats) {
+	stats = &BlockStats{
+		Height:     block.Height,
+		Time:       block.BlockTime,
+		TxCnt:      block.TxCnt,
+		Size:       block.Size,
+		Volume:     block.TotalBTC,
+		Difficulty: BitsToDifficulty(block.Bits),
+	}
+	if parenttime != 0 {
+		stats.Interval = int64(block.BlockTime) - int64(parenttime)
+	}
+	var coinbase *Tx
+	for _, tx := range block.Txs {
+		if len(tx.TxIns) == 0 {
+			coinbase = tx
+		} else if tx.TotalIn > tx.TotalOut {
+			stats.Fees += tx.TotalIn - tx.TotalOut
+		}
+	}
+	stats.Unclaimed = unclaimedReward(block.Height, stats.Fees, coinbase)
+	return
+}
+
+func statsDayKey(blocktime uint32) string {
+	return fmt.Sprintf("stats:day:%v", int64(blocktime)/86400)
+}
+
+func incrDayStats(c redis.Conn, stats *BlockStats, sign int64) {
+	daykey := statsDayKey(stats.Time)
+	c.Do("HINCRBY", daykey, "blocks", sign)
+	c.Do("HINCRBY", daykey, "txs", sign*int64(stats.TxCnt))
+	c.Do("HINCRBY", daykey, "size", sign*int64(stats.Size))
+	c.Do("HINCRBY", daykey, "volume", sign*int64(stats.Volume))
+	c.Do("HINCRBY", daykey, "fees", sign*int64(stats.Fees))
+	c.Do("HINCRBY", daykey, "interval", sign*stats.Interval)
+	c.Do("HINCRBY", daykey, "difficulty", sign*scaledDifficulty(stats.Difficulty))
+}
+
+func scaledDifficulty(difficulty float64) int64 {
+	return int64(difficulty*difficultyScale + 0.5)
+}
+
+// Save the block stats and add them to the day totals, only once per block
+func SaveBlockStats(c redis.Conn, hash string, stats *BlockStats) (err error) {
+	statskey := fmt.Sprintf("block:%v:stats", hash)
+	exists, err := redis.Bool(c.Do("EXISTS", statskey))
+	if err != nil || exists {
+		return
+	}
+	statsjson, _ := json.Marshal(stats)
+	if _, err = c.Do("SET", statskey, statsjson); err != nil {
+		return
+	}
+	incrDayStats(c, stats, 1)
+	incrUnclaimed(c, hash, stats, 1)
+	return
+}
+
+// Remove an orphaned block stats from the day totals
+func RevertBlockStats(c redis.Conn, hash string) (err error) {
+	statskey := fmt.Sprintf("block:%v:stats", hash)
+	statsjson, err := redis.String(c.Do("GET", statskey))
+	if err == redis.ErrNil {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	stats := new(BlockStats)
+	if err = json.Unmarshal([]byte(statsjson), stats); err != nil {
+		return
+	}
+	incrDayStats(c, stats, -1)
+	incrUnclaimed(c, hash, stats, -1)
+	_, err = c.Do("DEL", statskey)
+	return
+}
+
+// Add the difficulty to stats saved before it was tracked
+func addStatsDifficulty(c redis.Conn, hash string, bits uint32) (err error) {
+	statskey := fmt.Sprintf("block:%v:stats", hash)
+	statsjson, err := redis.String(c.Do("GET", statskey))
+	if err != nil {
+		return
+	}
+	stats := new(BlockStats)
+	if err = json.Unmarshal([]byte(statsjson), stats); err != nil || stats.Difficulty != 0 {
+		return
+	}
+	stats.Difficulty = BitsToDifficulty(bits)
+	statsjson2, _ := json.Marshal(stats)
+	if _, err = c.Do("SET", statskey, statsjson2); err != nil {
+		return
+	}
+	_, err = c.Do("HINCRBY", statsDayKey(stats.Time), "difficulty", scaledDifficulty(stats.Difficulty))
+	return
+}
+
+// Add the unclaimed reward to stats saved before it was tracked (without the unclaimed key)
+func addStatsUnclaimed(c redis.Conn, rpool *redis.Pool, hash string, block *Block) (err error) {
+	statskey := fmt.Sprintf("block:%v:stats", hash)
+	statsjson, err := redis.String(c.Do("GET", statskey))
+	if err != nil {
+		return
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal([]byte(statsjson), &fields); err != nil {
+		return
+	}
+	if _, tracked := fields["unclaimed"]; tracked {
+		return
+	}
+	stats := new(BlockStats)
+	if err = json.Unmarshal([]byte(statsjson), stats); err != nil {
+		return
+	}
+	if err = block.FetchTxs(rpool); err != nil {
+		return
+	}
+	stats.Unclaimed = NewBlockStats(block, 0).Unclaimed
+	statsjson2, _ := json.Marshal(stats)
+	if _, err = c.Do("SET", statskey, statsjson2); err != nil {
+		return
+	}
+	incrUnclaimed(c, hash, stats, 1)
+	return
+}
+
+// Compute and save the stats of every main chain block missing them, progress is reported every 1000 blocks
+func BackfillChartStats(rpool *redis.Pool, progress func(height int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	parenttime := uint32(0)
+	for height := 0; height <= latest; height++ {
+		if progress != nil && height%1000 == 0 {
+			progress(height)
+		}
+		hash, herr := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+		if herr != nil {
+			return herr
+		}
+		block, berr := GetBlockByHash(rpool, hash)
+		if berr != nil {
+			return berr
+		}
+		if exists, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("block:%v:stats", hash))); !exists {
+			if err = block.FetchTxs(rpool); err != nil {
+				return
+			}
+			if err = SaveBlockStats(c, hash, NewBlockStats(block, parenttime)); err != nil {
+				return
+			}
+		} else {
+			if err = addStatsDifficulty(c, hash, block.Bits); err != nil {
+				return
+			}
+			if err = addStatsUnclaimed(c, rpool, hash, block); err != nil {
+				return
+			}
+		}
+		parenttime = block.BlockTime
+	}
+	return
+}
+
+// Start (days since epoch) of the week (starting on monday) or month containing the day
+func chartBucket(day int64, resolution string) int64 {
+	switch resolution {
+	case "week":
+		// 1970-01-01 is a thursday
+		return day - (day+3)%7
+	case "month":
+		t := time.Unix(day*86400, 0).UTC()
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	}
+	return day
+}
+
+// Aggregate consecutive day totals by day, week or month
+func aggregateDayStats(days []*DayStats, metric *chartMetric, resolution string) (points []*ChartPoint) {
+	points = []*ChartPoint{}
+	var total, blocks int64
+	bucket := int64(-1)
+	flush := func() {
+		if bucket < 0 {
+			return
+		}
+		point := &ChartPoint{X: bucket * 86400, Y: float64(total) / metric.Scale}
+		if metric.Average {
+			point.Y = 0
+			if blocks > 0 {
+				point.Y = float64(total) / metric.Scale / float64(blocks)
+			}
+		}
+		points = append(points, point)
+	}
+	for _, day := range days {
+		if b := chartBucket(day.Day, resolution); b != bucket {
+			flush()
+			bucket, total, blocks = b, 0, 0
+		}
+		total += day.value(metric.Field)
+		blocks += day.Blocks
+	}
+	flush()
+	return
+}
+
+// Return the metric values between from and to (unix time), by block, day, week or month
+func GetChart(rpool *redis.Pool, metricname string, resolution string, from, to int64) (points []*ChartPoint, err error) {
+	metric, ok := ChartMetrics[metricname]
+	if !ok {
+		return nil, ErrUnknownMetric
+	}
+	// Ranges expected to hold more than MaxChartBlocks blocks are rejected before walking the chain
+	if resolution == "block" && (from <= 0 || to-from > MaxChartBlocks*int64(ActiveChainParams.TargetSpacing)) {
+		return nil, ErrChartRangeTooLarge
+	}
+	c := rpool.Get()
+	defer c.Close()
+	switch resolution {
+	case "block":
+		return getBlockChart(c, metric, from, to)
+	case "day", "week", "month":
+	default:
+		return nil, ErrUnknownResolution
+	}
+	// Nothing before the genesis block
+	if genesis, gerr := getBlockByHeight(rpool, 0); gerr == nil && from < int64(genesis.BlockTime) {
+		from = int64(genesis.BlockTime)
+	}
+	days := []*DayStats{}
+	for day := from / 86400; day <= to/86400; day++ {
+		v, verr := redis.Values(c.Do("HGETALL", fmt.Sprintf("stats:day:%v", day)))
+		if verr != nil {
+			return nil, verr
+		}
+		stats := &DayStats{Day: day}
+		if err = redis.ScanStruct(v, stats); err != nil {
+			return
+		}
+		days = append(days, stats)
+	}
+	return aggregateDayStats(days, metric, resolution), nil
+}
+
+// Walk the main chain back from the latest block
+func getBlockChart(c redis.Conn, metric *chartMetric, from, to int64) (points []*ChartPoint, err error) {
+	points = []*ChartPoint{}
+	height, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	for ; height >= 0; height-- {
+		hash, herr := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+		if herr != nil {
+			return nil, herr
+		}
+		statsjson, serr := redis.String(c.Do("GET", fmt.Sprintf("block:%v:stats", hash)))
+		if serr == redis.ErrNil {
+			// Not backfilled yet
+			break
+		}
+		if serr != nil {
+			return nil, serr
+		}
+		stats := new(BlockStats)
+		if err = json.Unmarshal([]byte(statsjson), stats); err != nil {
+			return
+		}
+		if int64(stats.Time) < from-blockTimeDrift {
+			break
+		}
+		if int64(stats.Time) < from || int64(stats.Time) > to {
+			continue
+		}
+		if len(points) == MaxChartBlocks {
+			return nil, ErrChartRangeTooLarge
+		}
+		points = append(points, &ChartPoint{X: int64(stats.Time), Y: stats.value(metric.Field)})
+	}
+	// Oldest first
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	return
+}
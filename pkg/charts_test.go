@@ -0,0 +1,95 @@
+package btcplex
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func dayNumber(date string) int64 {
+	t, _ := time.Parse("2006-01-02", date)
+	return t.Unix() / 86400
+}
+
+func TestChartBucket(t *testing.T) {
+	tests := []struct {
+		date, resolution, bucket string
+	}{
+		{"2014-01-01", "day", "2014-01-01"},
+		// Wednesday
+		{"2014-01-01", "week", "2013-12-30"},
+		{"2013-12-30", "week", "2013-12-30"},
+		{"2014-01-05", "week", "2013-12-30"},
+		{"2014-02-28", "month", "2014-02-01"},
+		{"2014-03-01", "month", "2014-03-01"},
+	}
+	for _, test := range tests {
+		if bucket := chartBucket(dayNumber(test.date), test.resolution); bucket != dayNumber(test.bucket) {
+			t.Errorf("chartBucket(%v, %v) = %v, want %v", test.date, test.resolution, time.Unix(bucket*86400, 0).UTC(), test.bucket)
+		}
+	}
+}
+
+func TestAggregateDayStats(t *testing.T) {
+	days := []*DayStats{
+		{Day: dayNumber("2014-01-31"), Blocks: 10, TxCnt: 100, Interval: 1200, Difficulty: 15000},
+		{Day: dayNumber("2014-02-01"), Blocks: 20, TxCnt: 50, Interval: 3000, Difficulty: 60000},
+		{Day: dayNumber("2014-02-02")},
+		{Day: dayNumber("2014-02-03"), Blocks: 10, TxCnt: 10, Interval: 1000, Difficulty: 20000},
+	}
+	tests := []struct {
+		metric, resolution string
+		points             []*ChartPoint
+	}{
+		{"transactions", "day", []*ChartPoint{
+			{dayNumber("2014-01-31") * 86400, 100}, {dayNumber("2014-02-01") * 86400, 50},
+			{dayNumber("2014-02-02") * 86400, 0}, {dayNumber("2014-02-03") * 86400, 10},
+		}},
+		{"transactions", "month", []*ChartPoint{{dayNumber("2014-01-01") * 86400, 100}, {dayNumber("2014-02-01") * 86400, 60}}},
+		// Averaged over the blocks, empty days don't count
+		{"block-interval", "month", []*ChartPoint{{dayNumber("2014-01-01") * 86400, 120}, {dayNumber("2014-02-01") * 86400, 4000.0 / 30}}},
+		{"block-interval", "day", []*ChartPoint{
+			{dayNumber("2014-01-31") * 86400, 120}, {dayNumber("2014-02-01") * 86400, 150},
+			{dayNumber("2014-02-02") * 86400, 0}, {dayNumber("2014-02-03") * 86400, 100},
+		}},
+		// Summed in thousandths
+		{"difficulty", "day", []*ChartPoint{
+			{dayNumber("2014-01-31") * 86400, 1.5}, {dayNumber("2014-02-01") * 86400, 3},
+			{dayNumber("2014-02-02") * 86400, 0}, {dayNumber("2014-02-03") * 86400, 2},
+		}},
+	}
+	for _, test := range tests {
+		points := aggregateDayStats(days, ChartMetrics[test.metric], test.resolution)
+		if !reflect.DeepEqual(points, test.points) {
+			t.Errorf("aggregateDayStats(%v, %v) = %v, want %v", test.metric, test.resolution, points, test.points)
+		}
+	}
+}
+
+func TestGetChartBlockRange(t *testing.T) {
+	// Rejected before any lookup
+	for _, from := range []int64{0, 1000000 - MaxChartBlocks*int64(ActiveChainParams.TargetSpacing) - 1} {
+		if _, err := GetChart(nil, "blocks", "block", from, 1000000); err != ErrChartRangeTooLarge {
+			t.Errorf("GetChart from %v error = %v, want %v", from, err, ErrChartRangeTooLarge)
+		}
+	}
+}
+
+func TestNewBlockStats(t *testing.T) {
+	block := &Block{Height: 2, BlockTime: 1000, TxCnt: 3, Size: 500, TotalBTC: 5000*COIN + 400, Bits: 0x1b0404cb,
+		Txs: []*Tx{
+			{TotalOut: 5000*COIN + 10},
+			{TxIns: []*TxIn{{}}, TotalIn: 300, TotalOut: 290},
+			{TxIns: []*TxIn{{}}, TotalIn: 100, TotalOut: 100},
+		}}
+	want := &BlockStats{Height: 2, Time: 1000, TxCnt: 3, Size: 500, Volume: 5000*COIN + 400, Fees: 10, Interval: 130,
+		Difficulty: BitsToDifficulty(0x1b0404cb)}
+	if stats := NewBlockStats(block, 870); !reflect.DeepEqual(stats, want) {
+		t.Errorf("NewBlockStats = %+v, want %+v", stats, want)
+	}
+	// The generation transaction claimed less than the reward plus fees
+	block.Txs[0].TotalOut = 4000 * COIN
+	if stats := NewBlockStats(block, 870); stats.Unclaimed != 1000*COIN+10 {
+		t.Errorf("Unclaimed = %v, want %v", stats.Unclaimed, 1000*COIN+10)
+	}
+}
@@ -17,6 +17,12 @@ type Config struct {
 	AppApiRateLimited  bool   `json:"app_api_rate_limited"`
 	AppTemplatesPath   string `json:"app_templates_path"`
 	AppGoogleAnalytics string `json:"app_google_analytics"`
+	AppInsightApi      bool   `json:"app_insight_api"`
+	Chain              string `json:"chain"`
+	HashRateWindow     uint   `json:"hashrate_window"`
+
+	// Keys allowed to register webhooks, the webhooks API is disabled without keys
+	AppWebhooksApiKeys []string `json:"app_webhooks_api_keys"`
 }
 
 // Load configuration from json file
@@ -27,5 +33,8 @@ func LoadConfig(path string) (conf *Config, err error) {
 	}
 	conf = new(Config)
 	json.Unmarshal(file, conf)
+	if conf.Chain != "" {
+		ActiveChainParams, err = GetChainParams(conf.Chain)
+	}
 	return
 }
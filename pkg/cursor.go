@@ -0,0 +1,139 @@
+package btcplex
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Position in a sorted set: the score of the last returned member, and the number of members
+// sharing that score already returned (members with the same score keep the sorted set order),
+// opaque to API clients. Unlike offsets, cursors are not shifted when new members are added.
+type Cursor struct {
+	Score float64
+	Index int
+}
+
+func (cursor *Cursor) String() string {
+	raw := strconv.FormatFloat(cursor.Score, 'f', -1, 64) + "|" + strconv.Itoa(cursor.Index)
+	return base64.URLEncoding.EncodeToString([]byte(raw))
+}
+
+func (cursor *Cursor) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(cursor.String())), nil
+}
+
+func ParseCursor(s string) (cursor *Cursor, err error) {
+	raw, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		err = ErrInvalidCursor
+		return
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		err = ErrInvalidCursor
+		return
+	}
+	score, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		err = ErrInvalidCursor
+		return
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil || index < 0 {
+		err = ErrInvalidCursor
+		return
+	}
+	cursor = &Cursor{Score: score, Index: index}
+	return
+}
+
+// Cursor following the last of the returned scores (in order), cursor being the one they follow
+func CursorAfter(cursor *Cursor, scores []float64) *Cursor {
+	last := scores[len(scores)-1]
+	next := &Cursor{Score: last}
+	if cursor != nil && cursor.Score == last {
+		next.Index = cursor.Index
+	}
+	for i := len(scores) - 1; i >= 0 && scores[i] == last; i-- {
+		next.Index++
+	}
+	return next
+}
+
+// Return up to limit members of the sorted set following the cursor (nil to start
+// from the highest score), next is nil when there is nothing left
+func ZRevRangeFromCursor(c redis.Conn, key string, cursor *Cursor, limit int) (members []string, next *Cursor, err error) {
+	members, _, next, err = ZRangeFromCursor(c, key, "-inf", "+inf", false, cursor, limit)
+	return
+}
+
+func zrangeByScore(c redis.Conn, key, min, max string, asc bool, offset, count int) (members []string, scores []float64, err error) {
+	var values []string
+	if asc {
+		values, err = redis.Strings(c.Do("ZRANGEBYSCORE", key, min, max, "WITHSCORES", "LIMIT", offset, count))
+	} else {
+		values, err = redis.Strings(c.Do("ZREVRANGEBYSCORE", key, max, min, "WITHSCORES", "LIMIT", offset, count))
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		score, _ := strconv.ParseFloat(values[i+1], 64)
+		members = append(members, values[i])
+		scores = append(scores, score)
+	}
+	return
+}
+
+// Return up to limit members (along with their scores) with a score between min and max
+// following the cursor, in ascending or descending order, next is nil when there is nothing left.
+// The members sharing the cursor score are skipped by offset, then the range excludes that score.
+func ZRangeFromCursor(c redis.Conn, key, min, max string, asc bool, cursor *Cursor, limit int) (members []string, scores []float64, next *Cursor, err error) {
+	members = []string{}
+	scores = []float64{}
+	if cursor != nil {
+		// Cursors outside of the range start before it, or end the traversal
+		lower, _ := strconv.ParseFloat(min, 64)
+		upper, _ := strconv.ParseFloat(max, 64)
+		if (asc && cursor.Score > upper) || (!asc && cursor.Score < lower) {
+			return
+		}
+		if cursor.Score < lower || cursor.Score > upper {
+			cursor = nil
+		}
+	}
+	// Fetch one more member to know if there is a next page
+	if cursor != nil {
+		score := strconv.FormatFloat(cursor.Score, 'f', -1, 64)
+		tmembers, tscores, terr := zrangeByScore(c, key, score, score, asc, cursor.Index, limit+1)
+		if terr != nil {
+			err = terr
+			return
+		}
+		members = append(members, tmembers...)
+		scores = append(scores, tscores...)
+		if asc {
+			min = "(" + score
+		} else {
+			max = "(" + score
+		}
+	}
+	if len(members) <= limit {
+		rmembers, rscores, rerr := zrangeByScore(c, key, min, max, asc, 0, limit+1-len(members))
+		if rerr != nil {
+			err = rerr
+			return
+		}
+		members = append(members, rmembers...)
+		scores = append(scores, rscores...)
+	}
+	if len(members) > limit {
+		members = members[:limit]
+		scores = scores[:limit]
+		next = CursorAfter(cursor, scores)
+	}
+	return
+}
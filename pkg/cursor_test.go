@@ -0,0 +1,192 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Minimal in-memory sorted set answering Z(REV)RANGEBYSCORE key min/max max/min WITHSCORES LIMIT offset count
+type zsetConn struct {
+	members map[string]float64
+	calls   int
+}
+
+func (c *zsetConn) Close() error                      { return nil }
+func (c *zsetConn) Err() error                        { return nil }
+func (c *zsetConn) Send(string, ...interface{}) error { return nil }
+func (c *zsetConn) Flush() error                      { return nil }
+func (c *zsetConn) Receive() (interface{}, error)     { return nil, nil }
+
+func (c *zsetConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.calls++
+	asc := cmd == "ZRANGEBYSCORE"
+	min, max := args[1].(string), args[2].(string)
+	if !asc {
+		min, max = max, min
+	}
+	offset, count := args[5].(int), args[6].(int)
+	entries := []string{}
+	for member, score := range c.members {
+		if inBound(score, min, true) && inBound(score, max, false) {
+			entries = append(entries, member)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		si, sj := c.members[entries[i]], c.members[entries[j]]
+		if si != sj {
+			return (si < sj) == asc
+		}
+		return (entries[i] < entries[j]) == asc
+	})
+	reply := []interface{}{}
+	for i := offset; i < len(entries) && i < offset+count; i++ {
+		reply = append(reply, []byte(entries[i]), []byte(strconv.FormatFloat(c.members[entries[i]], 'f', -1, 64)))
+	}
+	return reply, nil
+}
+
+// Check the score against a Redis min (or max) bound, "(" making it exclusive
+func inBound(score float64, bound string, min bool) bool {
+	exclusive := strings.HasPrefix(bound, "(")
+	f, _ := strconv.ParseFloat(strings.TrimPrefix(bound, "("), 64)
+	if min {
+		return score > f || (!exclusive && score == f)
+	}
+	return score < f || (!exclusive && score == f)
+}
+
+func TestCursorString(t *testing.T) {
+	tests := []*Cursor{
+		{Score: 1386325540, Index: 3},
+		{Score: 12.345, Index: 0},
+		{Score: 0, Index: 120},
+	}
+	for _, test := range tests {
+		cursor, err := ParseCursor(test.String())
+		if err != nil {
+			t.Fatalf("ParseCursor(%v): %v", test.String(), err)
+		}
+		if *cursor != *test {
+			t.Errorf("ParseCursor(%v) = %+v, want %+v", test.String(), cursor, test)
+		}
+	}
+	out, _ := json.Marshal(map[string]*Cursor{"next": tests[0], "none": nil})
+	if want := `{"next":"` + tests[0].String() + `","none":null}`; string(out) != want {
+		t.Errorf("json.Marshal = %s, want %s", out, want)
+	}
+	for _, invalid := range []string{"", "!!", "bm9zZXBhcmF0b3I=", "YWJjfGRlZg==", "MTB8LTE=", "MTB8eA=="} {
+		if _, err := ParseCursor(invalid); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) error = %v, want ErrInvalidCursor", invalid, err)
+		}
+	}
+}
+
+func TestZRevRangeFromCursor(t *testing.T) {
+	c := &zsetConn{members: map[string]float64{
+		"a": 10, "b": 10, "c": 10, "d": 10, "e": 20, "f": 30, "g": 5,
+	}}
+	want := []string{"f", "e", "d", "c", "b", "a", "g"}
+	for _, limit := range []int{1, 2, 3, 7, 10} {
+		got := []string{}
+		var cursor *Cursor
+		for pages := 0; pages < 10; pages++ {
+			members, next, err := ZRevRangeFromCursor(c, "zset", cursor, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, members...)
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+		if len(got) != len(want) {
+			t.Fatalf("limit %v: got %v, want %v", limit, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("limit %v: got %v, want %v", limit, got, want)
+			}
+		}
+	}
+	// New members with a higher score don't shift the next pages
+	members, next, _ := ZRevRangeFromCursor(c, "zset", nil, 3)
+	c.members["h"] = 40
+	members, _, _ = ZRevRangeFromCursor(c, "zset", next, 3)
+	if len(members) != 3 || members[0] != "c" {
+		t.Errorf("got %v after insertion, want [c b a]", members)
+	}
+}
+
+func TestZRevRangeFromCursorTies(t *testing.T) {
+	// Members sharing a score are skipped by offset, without rescanning them
+	c := &zsetConn{members: map[string]float64{"z": 20}}
+	for i := 0; i < 100; i++ {
+		c.members[fmt.Sprintf("m%03d", i)] = 10
+	}
+	want := []string{"z"}
+	for i := 99; i >= 0; i-- {
+		want = append(want, fmt.Sprintf("m%03d", i))
+	}
+	got := []string{}
+	pages := 0
+	var cursor *Cursor
+	for ; pages < 20; pages++ {
+		members, next, err := ZRevRangeFromCursor(c, "zset", cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, members...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if c.calls > 2*(pages+1) {
+		t.Errorf("%v calls for %v pages, want at most 2 per page", c.calls, pages+1)
+	}
+}
+
+func TestZRangeFromCursor(t *testing.T) {
+	c := &zsetConn{members: map[string]float64{
+		"a": 10, "b": 10, "c": 10, "d": 10, "e": 20, "f": 30, "g": 5,
+	}}
+	tests := []struct {
+		min, max string
+		asc      bool
+		want     []string
+	}{
+		{"-inf", "+inf", true, []string{"g", "a", "b", "c", "d", "e", "f"}},
+		{"10", "20", true, []string{"a", "b", "c", "d", "e"}},
+		{"10", "20", false, []string{"e", "d", "c", "b", "a"}},
+		{"11", "19", false, []string{}},
+	}
+	for _, test := range tests {
+		got := []string{}
+		var cursor *Cursor
+		for pages := 0; pages < 10; pages++ {
+			members, scores, next, err := ZRangeFromCursor(c, "zset", test.min, test.max, test.asc, cursor, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(scores) != len(members) {
+				t.Fatalf("got %v scores for %v members", len(scores), len(members))
+			}
+			got = append(got, members...)
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+		if strings.Join(got, "") != strings.Join(test.want, "") {
+			t.Errorf("ZRangeFromCursor(%v, %v, asc=%v) = %v, want %v", test.min, test.max, test.asc, got, test.want)
+		}
+	}
+}
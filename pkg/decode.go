@@ -0,0 +1,196 @@
+package btcplex
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Decoded transaction, previous outputs are resolved from the index (confirmed
+// or unconfirmed) when available, the fee is only known if all of them are
+
+type DecodedTxIn struct {
+	Index     uint32   `json:"n"`
+	PrevOut   *PrevOut `json:"prev_out,omitempty"`
+	Resolved  bool     `json:"resolved"`
+	Sequence  uint32   `json:"sequence"`
+	ScriptSig string   `json:"script_sig"`
+	ScriptAsm string   `json:"script_sig_asm"`
+	Witness   []string `json:"witness,omitempty"`
+}
+
+type DecodedTxOut struct {
+	Index     uint32   `json:"n"`
+	Value     uint64   `json:"value"`
+	Script    string   `json:"script"`
+	ScriptAsm string   `json:"script_asm"`
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses"`
+}
+
+type DecodedTx struct {
+	Hash           string          `json:"hash"`
+	Version        uint32          `json:"ver"`
+	LockTime       uint32          `json:"lock_time"`
+	Size           int             `json:"size"`
+	Coinbase       bool            `json:"coinbase"`
+	RBF            bool            `json:"rbf"`
+	Status         string          `json:"status"`
+	TxIns          []*DecodedTxIn  `json:"in"`
+	TxOuts         []*DecodedTxOut `json:"out"`
+	TotalIn        uint64          `json:"vin_total"`
+	TotalOut       uint64          `json:"vout_total"`
+	InputsResolved bool            `json:"inputs_resolved"`
+	Fee            uint64          `json:"fee"`
+}
+
+// Decoding is limited to standard sized transactions, and the number of inputs resolved from the index
+const (
+	MaxRawTxSize   = 100000
+	MaxDecodeTxIns = 1000
+)
+
+var ErrRawTxTooLarge = fmt.Errorf("Transaction too large (max %v bytes and %v inputs)", MaxRawTxSize, MaxDecodeTxIns)
+
+// Decode a hex encoded transaction and resolve its inputs from the index
+func DecodeTx(pool *redis.Pool, spool *redis.Pool, rawhex string) (dtx *DecodedTx, err error) {
+	if len(rawhex) > 2*MaxRawTxSize {
+		return nil, ErrRawTxTooLarge
+	}
+	tx, err := DecodeRawTxHex(rawhex)
+	if err != nil {
+		return
+	}
+	if len(tx.TxIns) > MaxDecodeTxIns {
+		return nil, ErrRawTxTooLarge
+	}
+	dtx = NewDecodedTx(tx)
+	c := pool.Get()
+	defer c.Close()
+	sc := spool.Get()
+	defer sc.Close()
+
+	dtx.Status = "unknown"
+	if confirmed, _ := redis.Bool(sc.Do("EXISTS", fmt.Sprintf("tx:%v", dtx.Hash))); confirmed {
+		dtx.Status = "confirmed"
+	} else if unconfirmed, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("btcplex:utx:%v", dtx.Hash))); unconfirmed {
+		dtx.Status = "unconfirmed"
+	}
+	err = dtx.resolveInputs(pool, sc)
+	return
+}
+
+// Resolve the previous outputs from the index, confirmed or unconfirmed
+func (dtx *DecodedTx) resolveInputs(pool *redis.Pool, sc redis.Conn) (err error) {
+	if dtx.Coinbase {
+		return
+	}
+	utxs := map[string]*Tx{}
+	dtx.InputsResolved = true
+	for _, txi := range dtx.TxIns {
+		prevout := txi.PrevOut
+		txojson, _ := redis.String(sc.Do("GET", fmt.Sprintf("txo:%v:%v", prevout.Hash, prevout.Vout)))
+		if txojson != "" {
+			txo := new(TxOut)
+			if err = json.Unmarshal([]byte(txojson), txo); err != nil {
+				return
+			}
+			prevout.Address, prevout.Value, txi.Resolved = txo.Addr, txo.Value, true
+		} else {
+			utx, cached := utxs[prevout.Hash]
+			if !cached {
+				utx, _ = GetUnconfirmedTx(pool, prevout.Hash)
+				utxs[prevout.Hash] = utx
+			}
+			if utx != nil && int(prevout.Vout) < len(utx.TxOuts) {
+				txo := utx.TxOuts[prevout.Vout]
+				prevout.Address, prevout.Value, txi.Resolved = txo.Addr, txo.Value, true
+			}
+		}
+		if !txi.Resolved {
+			dtx.InputsResolved = false
+		}
+		if dtx.TotalIn, err = addValue(dtx.TotalIn, prevout.Value); err != nil {
+			return
+		}
+	}
+	if dtx.InputsResolved && dtx.TotalIn >= dtx.TotalOut {
+		dtx.Fee = dtx.TotalIn - dtx.TotalOut
+	}
+	return
+}
+
+// Transaction in the format stored in the index, outputs are attributed to their first address
+func (dtx *DecodedTx) Tx() (tx *Tx) {
+	tx = &Tx{
+		Hash:     dtx.Hash,
+		Size:     uint32(dtx.Size),
+		LockTime: dtx.LockTime,
+		Version:  dtx.Version,
+		TxInCnt:  uint32(len(dtx.TxIns)),
+		TxOutCnt: uint32(len(dtx.TxOuts)),
+		TotalIn:  dtx.TotalIn,
+		TotalOut: dtx.TotalOut,
+		RBF:      dtx.RBF,
+	}
+	for _, dtxi := range dtx.TxIns {
+		tx.TxIns = append(tx.TxIns, &TxIn{TxHash: dtx.Hash, PrevOut: dtxi.PrevOut, Index: dtxi.Index, Sequence: dtxi.Sequence})
+	}
+	for _, dtxo := range dtx.TxOuts {
+		txo := &TxOut{TxHash: dtx.Hash, Value: dtxo.Value, Index: dtxo.Index, Spent: &TxoSpent{}}
+		if len(dtxo.Addresses) > 0 {
+			txo.Addr = dtxo.Addresses[0]
+		}
+		tx.TxOuts = append(tx.TxOuts, txo)
+	}
+	return
+}
+
+// Decoded transaction without any index lookup
+func NewDecodedTx(tx *RawTx) (dtx *DecodedTx) {
+	dtx = &DecodedTx{
+		Hash:     tx.Hash,
+		Version:  tx.Version,
+		LockTime: tx.LockTime,
+		Size:     tx.Size,
+		Coinbase: tx.IsCoinbase(),
+		TxIns:    []*DecodedTxIn{},
+		TxOuts:   []*DecodedTxOut{},
+	}
+	for i, txi := range tx.TxIns {
+		dtxi := &DecodedTxIn{
+			Index:     uint32(i),
+			Sequence:  txi.Sequence,
+			ScriptSig: hex.EncodeToString(txi.Script),
+		}
+		if dtx.Coinbase {
+			// The coinbase script is arbitrary data
+			dtxi.ScriptAsm = dtxi.ScriptSig
+		} else {
+			dtxi.PrevOut = &PrevOut{Hash: txi.PrevHash, Vout: txi.PrevIndex}
+			dtxi.ScriptAsm = DisassembleScript(txi.Script)
+		}
+		for _, item := range txi.Witness {
+			dtxi.Witness = append(dtxi.Witness, hex.EncodeToString(item))
+		}
+		if txi.Sequence < MaxRBFSequence {
+			dtx.RBF = true
+		}
+		dtx.TxIns = append(dtx.TxIns, dtxi)
+	}
+	for i, txo := range tx.TxOuts {
+		dtxo := &DecodedTxOut{
+			Index:     uint32(i),
+			Value:     txo.Value,
+			Script:    hex.EncodeToString(txo.Script),
+			ScriptAsm: DisassembleScript(txo.Script),
+		}
+		dtxo.Type, dtxo.Addresses = ScriptAddresses(txo.Script)
+		// Can't overflow, DecodeRawTx checked the outputs total
+		dtx.TotalOut += txo.Value
+		dtx.TxOuts = append(dtx.TxOuts, dtxo)
+	}
+	return
+}
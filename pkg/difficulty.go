@@ -0,0 +1,187 @@
+package btcplex
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Proof of work decoded from the block Bits (compact target), the cumulative work
+// of each block is stored as hex in ``block:%v:h`` (``chainwork``)
+
+var ErrChainWorkMissing = errors.New("Chain work not indexed")
+
+// Number of blocks used to estimate the network hash rate when not configured
+const DefaultHashRateWindow = 120
+
+var oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// Decode the compact target, the sign bit makes it negative
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := bits & 0x007fffff
+	exponent := uint(bits >> 24)
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(int64(mantissa >> (8 * (3 - exponent))))
+	} else {
+		target = new(big.Int).Lsh(big.NewInt(int64(mantissa)), 8*(exponent-3))
+	}
+	if bits&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// Difficulty relative to the 0x1d00ffff target, computed like bitcoind
+func BitsToDifficulty(bits uint32) float64 {
+	if bits&0x00ffffff == 0 {
+		return 0
+	}
+	shift := (bits >> 24) & 0xff
+	diff := float64(0x0000ffff) / float64(bits&0x00ffffff)
+	for ; shift < 29; shift++ {
+		diff *= 256
+	}
+	for ; shift > 29; shift-- {
+		diff /= 256
+	}
+	return diff
+}
+
+// Expected number of hashes needed to find a block: 2^256 / (target + 1)
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Div(oneLsh256, target.Add(target, big.NewInt(1)))
+}
+
+func formatChainWork(work *big.Int) string {
+	return fmt.Sprintf("%064x", work)
+}
+
+// Return the cumulative work stored for the block
+func GetChainWork(c redis.Conn, hash string) (work *big.Int, err error) {
+	workhex, err := redis.String(c.Do("HGET", fmt.Sprintf("block:%v:h", hash), "chainwork"))
+	if err == redis.ErrNil || (err == nil && workhex == "") {
+		return nil, ErrChainWorkMissing
+	}
+	if err != nil {
+		return
+	}
+	work, ok := new(big.Int).SetString(workhex, 16)
+	if !ok {
+		return nil, fmt.Errorf("Invalid chain work for block %v: %v", hash, workhex)
+	}
+	return
+}
+
+// Store the block cumulative work, the genesis block (no parent) only counts its own work,
+// nothing is stored if the parent work is missing (see BackfillChainWork)
+func SaveChainWork(c redis.Conn, block *Block) (work *big.Int, err error) {
+	work = BlockWork(block.Bits)
+	if block.Height > 0 {
+		parentwork, perr := GetChainWork(c, block.Parent)
+		if perr != nil {
+			return nil, perr
+		}
+		work.Add(work, parentwork)
+	}
+	_, err = c.Do("HSET", fmt.Sprintf("block:%v:h", block.Hash), "chainwork", formatChainWork(work))
+	return
+}
+
+// Compute the cumulative work of every stored block (orphans included) missing it,
+// progress is reported every 1000 blocks
+func BackfillChainWork(rpool *redis.Pool, progress func(height int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	for height := 0; height <= latest; height++ {
+		if progress != nil && height%1000 == 0 {
+			progress(height)
+		}
+		heightkey := fmt.Sprintf("height:%v", height)
+		cnt, _ := redis.Int(c.Do("ZCARD", heightkey))
+		// SSDB doesn't support negative slice yet
+		hashes, herr := redis.Strings(c.Do("ZRANGE", heightkey, 0, cnt-1))
+		if herr != nil {
+			return herr
+		}
+		for _, hash := range hashes {
+			if _, werr := GetChainWork(c, hash); werr == nil {
+				continue
+			}
+			block, berr := GetBlockByHash(rpool, hash)
+			if berr != nil {
+				return berr
+			}
+			if _, err = SaveChainWork(c, block); err != nil {
+				return fmt.Errorf("Block %v: %v", hash, err)
+			}
+		}
+	}
+	return
+}
+
+// Difficulty of the latest block
+func GetDifficulty(rpool *redis.Pool) (difficulty float64, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	block, err := getBlockByHeight(rpool, uint(latest))
+	if err != nil {
+		return
+	}
+	return BitsToDifficulty(block.Bits), nil
+}
+
+// Average hashes per second over the last window blocks: the work done divided by the time it took
+func EstimateHashRate(rpool *redis.Pool, window uint) (hashrate float64, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	if window == 0 || window > uint(latest) {
+		window = uint(latest)
+	}
+	if window == 0 {
+		return 0, nil
+	}
+	last, err := getBlockByHeight(rpool, uint(latest))
+	if err != nil {
+		return
+	}
+	first, err := getBlockByHeight(rpool, uint(latest)-window)
+	if err != nil {
+		return
+	}
+	lastwork, err := GetChainWork(c, last.Hash)
+	if err != nil {
+		return
+	}
+	firstwork, err := GetChainWork(c, first.Hash)
+	if err != nil {
+		return
+	}
+	return hashRate(new(big.Int).Sub(lastwork, firstwork), int64(last.BlockTime)-int64(first.BlockTime)), nil
+}
+
+func hashRate(work *big.Int, seconds int64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	rate, _ := new(big.Rat).SetFrac(work, big.NewInt(seconds)).Float64()
+	return rate
+}
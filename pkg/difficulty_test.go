@@ -0,0 +1,76 @@
+package btcplex
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target string
+	}{
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x05009234, "92340000"},
+		{0x01003456, "0"},
+		{0x04923456, "-12345600"},
+		{0x207fffff, "7fffff0000000000000000000000000000000000000000000000000000000000"},
+	}
+	for _, test := range tests {
+		if target := CompactToBig(test.bits); target.Text(16) != test.target {
+			t.Errorf("CompactToBig(%#x) = %v, want %v", test.bits, target.Text(16), test.target)
+		}
+	}
+}
+
+func TestBitsToDifficulty(t *testing.T) {
+	tests := []struct {
+		bits       uint32
+		difficulty float64
+	}{
+		{0x1d00ffff, 1},
+		{0x1b0404cb, 16307.420938523983},
+		{0x1e0ffff0, 1.0 / 4096},
+		{0, 0},
+	}
+	for _, test := range tests {
+		if difficulty := BitsToDifficulty(test.bits); math.Abs(difficulty-test.difficulty) > 1e-9*test.difficulty {
+			t.Errorf("BitsToDifficulty(%#x) = %v, want %v", test.bits, difficulty, test.difficulty)
+		}
+	}
+}
+
+func TestBlockWork(t *testing.T) {
+	tests := []struct {
+		bits uint32
+		work int64
+	}{
+		{0x1d00ffff, 4295032833},
+		{0x1b0404cb, 70040908352512},
+		{0x207fffff, 2},
+		{0x04923456, 0},
+	}
+	for _, test := range tests {
+		if work := BlockWork(test.bits); work.Cmp(big.NewInt(test.work)) != 0 {
+			t.Errorf("BlockWork(%#x) = %v, want %v", test.bits, work, test.work)
+		}
+	}
+}
+
+func TestHashRate(t *testing.T) {
+	tests := []struct {
+		work     int64
+		seconds  int64
+		hashrate float64
+	}{
+		{70040908352512 * 10, 1200, 583674236270.9333},
+		{4295032833, 0, 0},
+		{4295032833, -60, 0},
+	}
+	for _, test := range tests {
+		if hashrate := hashRate(big.NewInt(test.work), test.seconds); hashrate != test.hashrate {
+			t.Errorf("hashRate(%v, %v) = %v, want %v", test.work, test.seconds, hashrate, test.hashrate)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package btcplex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Electrum protocol helpers, wallets identify outputs by scripthash (the reversed sha256 of the
+// output script), ``scripthash:%v`` maps each scripthash to the address indexed under ``addr:%v``
+
+const ElectrumProtocolVersion = "1.4"
+
+// Max confirmed txs of an address history, larger histories are refused like ElectrumX does
+const MaxElectrumHistory = 10000
+
+var ErrHistoryTooLarge = errors.New("history too large")
+
+// History entry, Height is 0 for unconfirmed txs and -1 if they spend unconfirmed outputs
+type ElectrumHistoryItem struct {
+	TxHash string `json:"tx_hash"`
+	Height int    `json:"height"`
+	Fee    uint64 `json:"fee,omitempty"`
+}
+
+type ElectrumBalance struct {
+	Confirmed   uint64 `json:"confirmed"`
+	Unconfirmed int64  `json:"unconfirmed"`
+}
+
+type ElectrumUnspent struct {
+	TxHash string `json:"tx_hash"`
+	TxPos  uint32 `json:"tx_pos"`
+	Height uint   `json:"height"`
+	Value  uint64 `json:"value"`
+}
+
+// Merkle branch of a tx, Pos is its index in the block
+type ElectrumMerkle struct {
+	BlockHeight uint     `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
+// Electrum scripthash of the address output script
+func AddressScriptHash(address string) (scripthash string, err error) {
+	_, script, err := AddressScript(address)
+	if err != nil {
+		return
+	}
+	hash := sha256.Sum256(script)
+	return reverseHash(hash[:]), nil
+}
+
+// Map the address scripthash to the address, addresses without standard script are skipped
+func IndexScriptHash(c redis.Conn, address string) (err error) {
+	scripthash, err := AddressScriptHash(address)
+	if err != nil {
+		return nil
+	}
+	_, err = c.Do("SET", fmt.Sprintf("scripthash:%v", scripthash), address)
+	return
+}
+
+// Return the address of the scripthash, ErrNotFound if it never received anything
+func GetScriptHashAddress(rpool *redis.Pool, scripthash string) (address string, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	address, err = redis.String(c.Do("GET", fmt.Sprintf("scripthash:%v", scripthash)))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
+	return
+}
+
+// Index the scripthash of every address of the rich list (addresses are added to it
+// as soon as they receive), progress is reported every 10000 addresses
+func RebuildScriptHashes(rpool *redis.Pool, progress func(addresses int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	cnt, err := redis.Int(c.Do("ZCARD", BalancesKey))
+	if err != nil {
+		return
+	}
+	for start := 0; start < cnt; start += 10000 {
+		if progress != nil {
+			progress(start)
+		}
+		addresses, aerr := redis.Strings(c.Do("ZRANGE", BalancesKey, start, start+9999))
+		if aerr != nil {
+			return aerr
+		}
+		for _, address := range addresses {
+			if err = IndexScriptHash(c, address); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// 80 bytes block header
+func SerializeHeader(block *Block) (header []byte, err error) {
+	parent := make([]byte, 32)
+	if block.Parent != "" {
+		if parent, err = hex.DecodeString(block.Parent); err != nil || len(parent) != 32 {
+			return nil, fmt.Errorf("Invalid parent hash for block %v", block.Hash)
+		}
+	}
+	merkleroot, err := hex.DecodeString(block.MerkleRoot)
+	if err != nil || len(merkleroot) != 32 {
+		return nil, fmt.Errorf("Invalid merkle root for block %v", block.Hash)
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, block.Version)
+	buf.Write(reverseBytes(parent))
+	buf.Write(reverseBytes(merkleroot))
+	binary.Write(buf, binary.LittleEndian, []uint32{block.BlockTime, block.Bits, block.Nonce})
+	return buf.Bytes(), nil
+}
+
+// Hashes of the merkle tree nodes needed to get from the tx at pos to the merkle root, hashes are the
+// block tx hashes in order (the last node of a level is paired with itself if the level is odd)
+func MerkleBranch(hashes []string, pos int) (branch []string, err error) {
+	if pos < 0 || pos >= len(hashes) {
+		return nil, fmt.Errorf("Invalid position %v for %v txs", pos, len(hashes))
+	}
+	level := [][]byte{}
+	for _, hash := range hashes {
+		decoded, derr := hex.DecodeString(hash)
+		if derr != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("Invalid tx hash %v", hash)
+		}
+		level = append(level, reverseBytes(decoded))
+	}
+	branch = []string{}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, reverseHash(level[pos^1]))
+		next := [][]byte{}
+		for i := 0; i < len(level); i += 2 {
+			hash := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			hash = sha256.Sum256(hash[:])
+			next = append(next, hash[:])
+		}
+		level = next
+		pos /= 2
+	}
+	return
+}
+
+// Merkle branch of the tx in the main chain block at height, ErrNotFound if the tx isn't in it
+func GetElectrumMerkle(rpool *redis.Pool, txid string, height uint) (merkle *ElectrumMerkle, err error) {
+	hash, err := GetBlockHash(rpool, height)
+	if err != nil {
+		return
+	}
+	c := rpool.Get()
+	defer c.Close()
+	txskeys, err := redis.Strings(c.Do("ZRANGE", fmt.Sprintf("block:%v:txs", hash), 0, -1))
+	if err != nil {
+		return
+	}
+	pos := -1
+	hashes := []string{}
+	for i, txkey := range txskeys {
+		hashes = append(hashes, strings.TrimPrefix(txkey, "tx:"))
+		if hashes[i] == txid {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return nil, ErrNotFound
+	}
+	branch, err := MerkleBranch(hashes, pos)
+	if err != nil {
+		return
+	}
+	return &ElectrumMerkle{BlockHeight: height, Merkle: branch, Pos: pos}, nil
+}
+
+// Confirmed txs of the address (oldest first, by hash within a block) followed by the memory pool ones,
+// fails with ErrHistoryTooLarge past MaxElectrumHistory confirmed txs
+func GetElectrumHistory(rpool *redis.Pool, pool *redis.Pool, address string) (history []*ElectrumHistoryItem, err error) {
+	history, err = getElectrumConfirmedHistory(rpool, address)
+	if err != nil {
+		return
+	}
+	mempool, err := GetElectrumMempool(pool, address)
+	if err != nil {
+		return
+	}
+	return append(history, mempool...), nil
+}
+
+func getElectrumConfirmedHistory(rpool *redis.Pool, address string) (history []*ElectrumHistoryItem, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	history = []*ElectrumHistoryItem{}
+	zkey := fmt.Sprintf("addr:%v", address)
+	cnt, err := redis.Int(c.Do("ZCARD", zkey))
+	if err != nil {
+		return
+	}
+	if cnt > MaxElectrumHistory {
+		return nil, ErrHistoryTooLarge
+	}
+	for start := 0; start < cnt; start += 500 {
+		hashes, herr := redis.Strings(c.Do("ZRANGE", zkey, start, start+499))
+		if herr != nil {
+			return nil, herr
+		}
+		txskeys := []interface{}{}
+		for _, hash := range hashes {
+			txskeys = append(txskeys, fmt.Sprintf("tx:%v", hash))
+		}
+		if len(txskeys) == 0 {
+			break
+		}
+		txsjson, terr := redis.Strings(c.Do("MGET", txskeys...))
+		if terr != nil {
+			return nil, terr
+		}
+		for _, txjson := range txsjson {
+			tx := new(Tx)
+			if err = json.Unmarshal([]byte(txjson), tx); err != nil {
+				return
+			}
+			history = append(history, &ElectrumHistoryItem{TxHash: tx.Hash, Height: int(tx.BlockHeight)})
+		}
+	}
+	sortElectrumHistory(history)
+	return
+}
+
+// Memory pool txs of the address, Height is -1 for those spending unconfirmed outputs
+func GetElectrumMempool(pool *redis.Pool, address string) (mempool []*ElectrumHistoryItem, err error) {
+	utxs, err := GetUnconfirmedTxsByAddress(pool, address)
+	if err != nil {
+		return
+	}
+	mempool = []*ElectrumHistoryItem{}
+	for _, utx := range utxs {
+		item := &ElectrumHistoryItem{TxHash: utx.Hash, Fee: utx.Fee()}
+		for _, txi := range utx.TxIns {
+			if isutx, _ := IsUnconfirmedTx(pool, txi.PrevOut.Hash); isutx {
+				item.Height = -1
+				break
+			}
+		}
+		mempool = append(mempool, item)
+	}
+	sortElectrumHistory(mempool)
+	return
+}
+
+func sortElectrumHistory(history []*ElectrumHistoryItem) {
+	sort.Slice(history, func(i, j int) bool {
+		if history[i].Height != history[j].Height {
+			return history[i].Height < history[j].Height
+		}
+		return history[i].TxHash < history[j].TxHash
+	})
+}
+
+// Status of a subscribed scripthash, empty (null) if there is no history
+func ElectrumStatus(history []*ElectrumHistoryItem) string {
+	if len(history) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	for _, item := range history {
+		fmt.Fprintf(buf, "%v:%v:", item.TxHash, item.Height)
+	}
+	hash := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(hash[:])
+}
+
+// Status of an address computed incrementally: the confirmed history is hashed once, the txs of the
+// following blocks are appended to the hash and only the memory pool txs are hashed on each update
+type ElectrumStatusHash struct {
+	address string
+	// Confirmed txs hashed, and height of the last one
+	cnt    int
+	height int
+	digest hash.Hash
+}
+
+func NewElectrumStatusHash(rpool *redis.Pool, address string) (h *ElectrumStatusHash, err error) {
+	history, err := getElectrumConfirmedHistory(rpool, address)
+	if err != nil {
+		return
+	}
+	h = &ElectrumStatusHash{address: address, digest: sha256.New()}
+	h.append(history)
+	return
+}
+
+func (h *ElectrumStatusHash) append(history []*ElectrumHistoryItem) {
+	for _, item := range history {
+		fmt.Fprintf(h.digest, "%v:%v:", item.TxHash, item.Height)
+		h.height = item.Height
+	}
+	h.cnt += len(history)
+}
+
+// Append the block txs of the address, the confirmed history is hashed again
+// if it doesn't add up (the block doesn't follow the hashed txs after a reorg)
+func (h *ElectrumStatusHash) AddBlock(rpool *redis.Pool, block *Block) (err error) {
+	history := []*ElectrumHistoryItem{}
+	for _, tx := range block.Txs {
+		for _, address := range tx.Addresses() {
+			if address == h.address {
+				history = append(history, &ElectrumHistoryItem{TxHash: tx.Hash, Height: int(block.Height)})
+				break
+			}
+		}
+	}
+	c := rpool.Get()
+	cnt, err := redis.Int(c.Do("ZCARD", fmt.Sprintf("addr:%v", h.address)))
+	c.Close()
+	if err != nil {
+		return
+	}
+	if cnt > MaxElectrumHistory {
+		return ErrHistoryTooLarge
+	}
+	if len(history) > 0 && int(block.Height) > h.height && cnt == h.cnt+len(history) {
+		sortElectrumHistory(history)
+		h.append(history)
+		return
+	}
+	if cnt == h.cnt && len(history) == 0 {
+		return
+	}
+	rehashed, err := NewElectrumStatusHash(rpool, h.address)
+	if err != nil {
+		return
+	}
+	*h = *rehashed
+	return
+}
+
+// Status including the current memory pool txs
+func (h *ElectrumStatusHash) Status(pool *redis.Pool) (status string, err error) {
+	mempool, err := GetElectrumMempool(pool, h.address)
+	if err != nil {
+		return
+	}
+	return h.status(mempool)
+}
+
+func (h *ElectrumStatusHash) status(mempool []*ElectrumHistoryItem) (status string, err error) {
+	if h.cnt+len(mempool) == 0 {
+		return "", nil
+	}
+	// Hash the memory pool txs on a copy of the digest
+	state, err := h.digest.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return
+	}
+	digest := sha256.New()
+	if err = digest.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return
+	}
+	for _, item := range mempool {
+		fmt.Fprintf(digest, "%v:%v:", item.TxHash, item.Height)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func GetElectrumBalance(rpool *redis.Pool, pool *redis.Pool, address string) (balance *ElectrumBalance, err error) {
+	addressdata, err := GetAddress(rpool, address)
+	if err != nil {
+		return
+	}
+	if err = addressdata.FetchUnconfirmed(pool); err != nil {
+		return
+	}
+	return &ElectrumBalance{Confirmed: addressdata.FinalBalance,
+		Unconfirmed: int64(addressdata.UnconfirmedReceived) - int64(addressdata.UnconfirmedSent)}, nil
+}
+
+func NewElectrumUnspent(unspent *UnspentOutput) *ElectrumUnspent {
+	return &ElectrumUnspent{TxHash: unspent.TxHash, TxPos: unspent.Index, Height: unspent.BlockHeight, Value: unspent.Value}
+}
@@ -0,0 +1,112 @@
+package btcplex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAddressScriptHash(t *testing.T) {
+	tests := []struct {
+		address, scripthash string
+	}{
+		{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", "8b01df4e368ea28f8dc0423bcf7a4923e3a12d307c875e47a0cfbf90b5c39161"},
+		{"4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9", "e7e41b1311c9fc8248e8f6e87cc382ca4b1af9c3189bb896712c3aebdf018639"},
+	}
+	for _, test := range tests {
+		if scripthash, err := AddressScriptHash(test.address); scripthash != test.scripthash || err != nil {
+			t.Errorf("AddressScriptHash(%v) = %v, %v, want %v", test.address, scripthash, err, test.scripthash)
+		}
+	}
+}
+
+func TestSerializeHeader(t *testing.T) {
+	genesis := &Block{Hash: "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f", Version: 1,
+		MerkleRoot: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b", BlockTime: 1231006505, Bits: 0x1d00ffff, Nonce: 2083236893}
+	want := "0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4a29ab5f49ffff001d1dac2b7c"
+	header, err := SerializeHeader(genesis)
+	if err != nil || hex.EncodeToString(header) != want {
+		t.Errorf("SerializeHeader(genesis) = %x, %v, want %v", header, err, want)
+	}
+	genesis.MerkleRoot = "4a5e"
+	if _, err := SerializeHeader(genesis); err == nil {
+		t.Errorf("SerializeHeader(invalid merkle root) should fail")
+	}
+}
+
+func TestMerkleBranch(t *testing.T) {
+	// Block 100000 of the bitcoin chain
+	hashes := []string{
+		"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+		"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+		"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+		"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+	}
+	root := "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766"
+	for pos := range hashes {
+		branch, err := MerkleBranch(hashes, pos)
+		if err != nil || len(branch) != 2 {
+			t.Errorf("MerkleBranch(%v) = %v, %v, want 2 hashes", pos, branch, err)
+			continue
+		}
+		hash, _ := hex.DecodeString(hashes[pos])
+		hash = reverseBytes(hash)
+		for i, node := range branch {
+			sibling, _ := hex.DecodeString(node)
+			sibling = reverseBytes(sibling)
+			pair := append(append([]byte{}, hash...), sibling...)
+			if (pos>>uint(i))%2 == 1 {
+				pair = append(append([]byte{}, sibling...), hash...)
+			}
+			sum := sha256.Sum256(pair)
+			sum = sha256.Sum256(sum[:])
+			hash = sum[:]
+		}
+		if reverseHash(hash) != root {
+			t.Errorf("MerkleBranch(%v) leads to %v, want %v", pos, reverseHash(hash), root)
+		}
+	}
+
+	// The last tx of an odd level is paired with itself
+	if branch, err := MerkleBranch(hashes[:3], 2); err != nil || len(branch) != 2 || branch[0] != hashes[2] {
+		t.Errorf("MerkleBranch(3 txs, 2) = %v, %v, want the tx hash first", branch, err)
+	}
+	if branch, err := MerkleBranch(hashes[:1], 0); err != nil || len(branch) != 0 {
+		t.Errorf("MerkleBranch(1 tx, 0) = %v, %v, want an empty branch", branch, err)
+	}
+	if _, err := MerkleBranch(hashes, 4); err == nil {
+		t.Errorf("MerkleBranch(4 txs, 4) should fail")
+	}
+}
+
+func TestElectrumStatus(t *testing.T) {
+	history := []*ElectrumHistoryItem{{TxHash: "b", Height: 2}, {TxHash: "a", Height: 1}}
+	sortElectrumHistory(history)
+	// Memory pool txs come last
+	history = append(history, &ElectrumHistoryItem{TxHash: "c", Height: 0})
+	if status := ElectrumStatus(history); status != "b730aa9d30e42115ff1f635c92111716be5d1846e9fa1f1dbe29871f4cdcf9a6" {
+		t.Errorf("ElectrumStatus = %v", status)
+	}
+	if status := ElectrumStatus([]*ElectrumHistoryItem{}); status != "" {
+		t.Errorf("ElectrumStatus(no history) = %v, want empty", status)
+	}
+}
+
+func TestElectrumStatusHash(t *testing.T) {
+	h := &ElectrumStatusHash{digest: sha256.New()}
+	if status, err := h.status([]*ElectrumHistoryItem{}); status != "" || err != nil {
+		t.Errorf("status(no history) = %v, %v, want empty", status, err)
+	}
+	h.append([]*ElectrumHistoryItem{{TxHash: "a", Height: 1}})
+	h.append([]*ElectrumHistoryItem{{TxHash: "b", Height: 2}})
+	mempool := []*ElectrumHistoryItem{{TxHash: "c", Height: 0}}
+	for i := 0; i < 2; i++ {
+		// The memory pool txs don't alter the confirmed hash
+		if status, err := h.status(mempool); status != "b730aa9d30e42115ff1f635c92111716be5d1846e9fa1f1dbe29871f4cdcf9a6" || err != nil {
+			t.Errorf("status = %v, %v", status, err)
+		}
+	}
+	if h.cnt != 2 || h.height != 2 {
+		t.Errorf("cnt, height = %v, %v, want 2, 2", h.cnt, h.height)
+	}
+}
@@ -0,0 +1,115 @@
+package btcplex
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Events published over btcplex:blocknotify2, btcplex:newblock, btcplex:utxs and btcplex:doublespends get an id
+// (btcplex:events:%v:id counter) and are kept in a bounded backlog (btcplex:events:%v sorted set, scored by id)
+// so SSE clients can resume from the Last-Event-ID header, see docs/api_sse.md
+
+// Events kept per channel
+const EventBacklogSize = 1000
+
+// The event id is also stored by payload digest (btcplex:events:%v:sha1:%v), for the addr:%v:* channels
+// publishing the same payload
+const eventIdTTL = 3600
+
+// KEYS: backlog, id counter, id by digest; ARGV: channel, data, backlog size, TTL
+var publishEventScript = redis.NewScript(3, `
+local id = redis.call('incr', KEYS[2])
+redis.call('zadd', KEYS[1], id, id .. ':' .. ARGV[2])
+redis.call('zremrangebyrank', KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+redis.call('setex', KEYS[3], ARGV[4], id)
+redis.call('publish', ARGV[1], ARGV[2])
+return id`)
+
+type Event struct {
+	Id   uint64
+	Data string
+}
+
+func eventDigest(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// Publish the data over the channel, recording it in the channel backlog
+func PublishEvent(c redis.Conn, channel, data string) (id uint64, err error) {
+	return redis.Uint64(publishEventScript.Do(c, fmt.Sprintf("btcplex:events:%v", channel), fmt.Sprintf("btcplex:events:%v:id", channel),
+		fmt.Sprintf("btcplex:events:%v:sha1:%v", channel, eventDigest(data)), channel, data, EventBacklogSize, eventIdTTL))
+}
+
+// Backlog members are "<id>:<data>"
+func parseEventMember(member string) (event *Event, err error) {
+	i := strings.IndexByte(member, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("Malformed event %q", member)
+	}
+	id, err := strconv.ParseUint(member[:i], 10, 64)
+	if err != nil {
+		return
+	}
+	return &Event{Id: id, Data: member[i+1:]}, nil
+}
+
+// Events of the channel backlog published after the given id, oldest first
+func GetEvents(pool *redis.Pool, channel string, after uint64, limit int) (events []*Event, err error) {
+	c := pool.Get()
+	defer c.Close()
+	members, err := redis.Strings(c.Do("ZRANGEBYSCORE", fmt.Sprintf("btcplex:events:%v", channel), fmt.Sprintf("(%v", after), "+inf", "LIMIT", 0, limit))
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		event, perr := parseEventMember(member)
+		if perr != nil {
+			return nil, perr
+		}
+		events = append(events, event)
+	}
+	return
+}
+
+// Id of the latest event published over the channel, 0 if none
+func GetLastEventId(pool *redis.Pool, channel string) (id uint64, err error) {
+	c := pool.Get()
+	defer c.Close()
+	id, err = redis.Uint64(c.Do("GET", fmt.Sprintf("btcplex:events:%v:id", channel)))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return
+}
+
+// Id of the event of the channel with the given data (published in the last hour), 0 if unknown
+func GetEventId(pool *redis.Pool, channel, data string) (id uint64, err error) {
+	c := pool.Get()
+	defer c.Close()
+	id, err = redis.Uint64(c.Do("GET", fmt.Sprintf("btcplex:events:%v:sha1:%v", channel, eventDigest(data))))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+	return
+}
+
+// Format an SSE message, without id if it's 0 and spreading multiline data over several data fields
+func FormatSSE(name string, id uint64, data string) string {
+	msg := ""
+	if id != 0 {
+		msg += fmt.Sprintf("id: %v\n", id)
+	}
+	if name != "" {
+		msg += fmt.Sprintf("event: %v\n", name)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		msg += fmt.Sprintf("data: %v\n", strings.TrimSuffix(line, "\r"))
+	}
+	return msg + "\n"
+}
@@ -0,0 +1,48 @@
+package btcplex
+
+import (
+	"testing"
+)
+
+func TestFormatSSE(t *testing.T) {
+	tests := []struct {
+		name string
+		id   uint64
+		data string
+		msg  string
+	}{
+		{"tx", 42, `{"hash":"a"}`, "id: 42\nevent: tx\ndata: {\"hash\":\"a\"}\n\n"},
+		{"heartbeat", 0, "1400000000", "event: heartbeat\ndata: 1400000000\n\n"},
+		{"", 0, "hash", "data: hash\n\n"},
+		{"block", 7, "line1\nline2\r\nline3", "id: 7\nevent: block\ndata: line1\ndata: line2\ndata: line3\n\n"},
+	}
+	for _, test := range tests {
+		if msg := FormatSSE(test.name, test.id, test.data); msg != test.msg {
+			t.Errorf("FormatSSE(%q, %v, %q) = %q, want %q", test.name, test.id, test.data, msg, test.msg)
+		}
+	}
+}
+
+func TestParseEventMember(t *testing.T) {
+	tests := []struct {
+		member string
+		id     uint64
+		data   string
+		valid  bool
+	}{
+		{`12:{"hash":"a:b"}`, 12, `{"hash":"a:b"}`, true},
+		{"1:", 1, "", true},
+		{"nodata", 0, "", false},
+		{"x:data", 0, "", false},
+	}
+	for _, test := range tests {
+		event, err := parseEventMember(test.member)
+		if (err == nil) != test.valid {
+			t.Errorf("parseEventMember(%q) error = %v, want valid %v", test.member, err, test.valid)
+			continue
+		}
+		if test.valid && (event.Id != test.id || event.Data != test.data) {
+			t.Errorf("parseEventMember(%q) = %v %q, want %v %q", test.member, event.Id, event.Data, test.id, test.data)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// Number of blocks kept in btcplex:fees:blocks
+	FeeHistoryBlocks = 288
+	// Minimum number of transactions needed to trust a fee rate range
+	FeeEstimateMinSamples = 20
+	// Share of transactions that must have been confirmed within the target
+	FeeEstimateSuccess = 0.85
+	// Fee rate (satoshis per byte) relayed by default by bitcoind
+	MinRelayFeeRate float64 = 1
+	MaxBlockSize    uint64  = 1000000
+)
+
+// Fee rate and confirmation delay (in blocks) of a transaction seen in the memory pool
+type ConfirmedFeeRate struct {
+	FeeRate float64 `json:"r"`
+	Blocks  uint    `json:"b"`
+}
+
+// Fee rates/confirmation delays of the transactions of a block first seen in the memory pool
+type BlockFeeStats struct {
+	Hash   string              `json:"hash"`
+	Height uint                `json:"height"`
+	Txs    []*ConfirmedFeeRate `json:"txs"`
+}
+
+type FeeEstimate struct {
+	Blocks         uint                         `json:"blocks"`
+	FeeRate        float64                      `json:"fee_rate"`
+	FeePerKb       uint64                       `json:"fee_per_kb"`
+	HistoryFeeRate float64                      `json:"history_fee_rate"`
+	MempoolFeeRate float64                      `json:"mempool_fee_rate"`
+	Links          map[string]map[string]string `json:"_links,omitempty"`
+}
+
+type RecommendedFees struct {
+	FastestFee float64                      `json:"fastest_fee"`
+	FastFee    float64                      `json:"fast_fee"`
+	MediumFee  float64                      `json:"medium_fee"`
+	EconomyFee float64                      `json:"economy_fee"`
+	MinimumFee float64                      `json:"minimum_fee"`
+	Links      map[string]map[string]string `json:"_links,omitempty"`
+}
+
+// Remember the height at which an unconfirmed transaction was first seen,
+// kept longer than the transaction itself so it's still available when the block is processed
+func SaveFirstSeenHeight(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	_, err = c.Do("SETEX", fmt.Sprintf("btcplex:utx:%v:firstseen", tx.Hash), 3600*72, tx.FirstSeenHeight)
+	return
+}
+
+// Record the confirmation delay versus fee rate of every transaction of the block seen in the memory pool,
+// the stats are kept along with the block (block:%v:fees) so they're removed if the block is orphaned
+func RecordBlockFeeStats(pool *redis.Pool, spool *redis.Pool, block *Block) (stats *BlockFeeStats, err error) {
+	c := pool.Get()
+	defer c.Close()
+	stats = &BlockFeeStats{Hash: block.Hash, Height: block.Height, Txs: []*ConfirmedFeeRate{}}
+	txs := []*Tx{}
+	keys := []interface{}{}
+	for _, tx := range block.Txs {
+		// Skip the generation transaction
+		if len(tx.TxIns) == 0 {
+			continue
+		}
+		txs = append(txs, tx)
+		keys = append(keys, fmt.Sprintf("btcplex:utx:%v:firstseen", tx.Hash))
+	}
+	if len(keys) > 0 {
+		heights, merr := redis.Strings(c.Do("MGET", keys...))
+		if merr != nil {
+			err = merr
+			return
+		}
+		for i, sheight := range heights {
+			if sheight == "" {
+				continue
+			}
+			fseenheight, _ := strconv.ParseUint(sheight, 10, 0)
+			stats.Txs = append(stats.Txs, &ConfirmedFeeRate{txs[i].FeeRate(), ConfirmationDelay(uint(fseenheight), block.Height)})
+		}
+	}
+	statsjson, err := json.Marshal(stats)
+	if err != nil {
+		return
+	}
+	sc := spool.Get()
+	defer sc.Close()
+	if _, err = sc.Do("SET", fmt.Sprintf("block:%v:fees", block.Hash), string(statsjson)); err != nil {
+		return
+	}
+	if _, err = sc.Do("ZADD", "btcplex:fees:blocks", block.Height, block.Hash); err != nil {
+		return
+	}
+	cnt, err := redis.Int(sc.Do("ZCARD", "btcplex:fees:blocks"))
+	if err != nil || cnt <= FeeHistoryBlocks {
+		return
+	}
+	old, err := redis.Strings(sc.Do("ZRANGE", "btcplex:fees:blocks", 0, cnt-FeeHistoryBlocks-1))
+	if err != nil {
+		return
+	}
+	for _, hash := range old {
+		if err = RevertBlockFeeStats(sc, hash); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Remove an orphaned block fee stats from the history
+func RevertBlockFeeStats(c redis.Conn, hash string) (err error) {
+	if _, err = c.Do("ZREM", "btcplex:fees:blocks", hash); err != nil {
+		return
+	}
+	_, err = c.Do("DEL", fmt.Sprintf("block:%v:fees", hash))
+	return
+}
+
+// Return the recent blocks fee stats, most recent first
+func GetFeeHistory(spool *redis.Pool) (history []*BlockFeeStats, err error) {
+	c := spool.Get()
+	defer c.Close()
+	history = []*BlockFeeStats{}
+	hashes, err := redis.Strings(c.Do("ZREVRANGE", "btcplex:fees:blocks", 0, FeeHistoryBlocks-1))
+	if err != nil || len(hashes) == 0 {
+		return
+	}
+	keys := []interface{}{}
+	for _, hash := range hashes {
+		keys = append(keys, fmt.Sprintf("block:%v:fees", hash))
+	}
+	statsjson, err := redis.Strings(c.Do("MGET", keys...))
+	if err != nil {
+		return
+	}
+	for _, sjson := range statsjson {
+		if sjson == "" {
+			continue
+		}
+		stats := new(BlockFeeStats)
+		if err = json.Unmarshal([]byte(sjson), stats); err != nil {
+			return
+		}
+		history = append(history, stats)
+	}
+	return
+}
+
+// Number of blocks a transaction first seen when the best block was at fseenheight waited
+func ConfirmationDelay(fseenheight, height uint) uint {
+	if height <= fseenheight {
+		return 1
+	}
+	return height - fseenheight
+}
+
+// Return the lowest fee rate (satoshis per byte) at which at least FeeEstimateSuccess of the
+// transactions were confirmed within the given number of blocks, fee rates are grouped in
+// FeeRateBuckets ranges holding at least FeeEstimateMinSamples transactions.
+func HistoryFeeRate(history []*BlockFeeStats, blocks uint) (feerate float64, ok bool) {
+	total := make([]uint, len(FeeRateBuckets))
+	within := make([]uint, len(FeeRateBuckets))
+	for _, stats := range history {
+		for _, ctx := range stats.Txs {
+			bi := feeRateBucket(ctx.FeeRate)
+			total[bi]++
+			if ctx.Blocks <= blocks {
+				within[bi]++
+			}
+		}
+	}
+	var rangetotal, rangewithin uint
+	for bi := len(FeeRateBuckets) - 1; bi >= 0; bi-- {
+		rangetotal += total[bi]
+		rangewithin += within[bi]
+		if rangetotal < FeeEstimateMinSamples {
+			continue
+		}
+		if float64(rangewithin)/float64(rangetotal) < FeeEstimateSuccess {
+			break
+		}
+		feerate = FeeRateBuckets[bi]
+		ok = true
+		rangetotal, rangewithin = 0, 0
+	}
+	return
+}
+
+// Return the fee rate needed to be included within the given number of blocks
+// if the memory pool was mined by fee rate (0 if everything fits)
+func MempoolFeeRate(feerates []float64, sizes []uint64, blocks uint) float64 {
+	idx := make([]int, len(feerates))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return feerates[idx[i]] > feerates[idx[j]] })
+	capacity := uint64(blocks) * MaxBlockSize
+	var cumsize uint64
+	for _, i := range idx {
+		cumsize += sizes[i]
+		if cumsize > capacity {
+			return feerates[i]
+		}
+	}
+	return 0
+}
+
+// Estimate the fee rate needed to be confirmed within the given number of blocks,
+// from both the recent blocks and the current memory pool
+func EstimateFee(history []*BlockFeeStats, feerates []float64, sizes []uint64, blocks uint) (estimate *FeeEstimate) {
+	if blocks == 0 {
+		blocks = 1
+	}
+	// Targets beyond the history can't be estimated (and would overflow the memory pool capacity)
+	if blocks > FeeHistoryBlocks {
+		blocks = FeeHistoryBlocks
+	}
+	estimate = &FeeEstimate{Blocks: blocks}
+	estimate.HistoryFeeRate, _ = HistoryFeeRate(history, blocks)
+	estimate.MempoolFeeRate = MempoolFeeRate(feerates, sizes, blocks)
+	estimate.FeeRate = MinRelayFeeRate
+	if estimate.HistoryFeeRate > estimate.FeeRate {
+		estimate.FeeRate = estimate.HistoryFeeRate
+	}
+	if estimate.MempoolFeeRate > estimate.FeeRate {
+		estimate.FeeRate = estimate.MempoolFeeRate
+	}
+	estimate.FeePerKb = uint64(estimate.FeeRate*1000 + 0.5)
+	return
+}
+
+// Return fee rates for common confirmation targets, never increasing with the target
+func RecommendFees(history []*BlockFeeStats, feerates []float64, sizes []uint64) (fees *RecommendedFees) {
+	fees = &RecommendedFees{MinimumFee: MinRelayFeeRate}
+	fees.EconomyFee = EstimateFee(history, feerates, sizes, 24).FeeRate
+	fees.MediumFee = EstimateFee(history, feerates, sizes, 6).FeeRate
+	if fees.MediumFee < fees.EconomyFee {
+		fees.MediumFee = fees.EconomyFee
+	}
+	fees.FastFee = EstimateFee(history, feerates, sizes, 3).FeeRate
+	if fees.FastFee < fees.MediumFee {
+		fees.FastFee = fees.MediumFee
+	}
+	fees.FastestFee = EstimateFee(history, feerates, sizes, 1).FeeRate
+	if fees.FastestFee < fees.FastFee {
+		fees.FastestFee = fees.FastFee
+	}
+	return
+}
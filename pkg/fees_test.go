@@ -0,0 +1,129 @@
+package btcplex
+
+import (
+	"testing"
+)
+
+// Build a synthetic history of blocks: for each (fee rate, delay) pair, n transactions
+// are confirmed in every block
+func syntheticHistory(blocks int, n int, samples map[float64]uint) (history []*BlockFeeStats) {
+	history = []*BlockFeeStats{}
+	for i := 0; i < blocks; i++ {
+		stats := &BlockFeeStats{Height: uint(1000 - i), Txs: []*ConfirmedFeeRate{}}
+		for feerate, delay := range samples {
+			for j := 0; j < n; j++ {
+				stats.Txs = append(stats.Txs, &ConfirmedFeeRate{feerate, delay})
+			}
+		}
+		history = append(history, stats)
+	}
+	return
+}
+
+func TestConfirmationDelay(t *testing.T) {
+	if d := ConfirmationDelay(100, 101); d != 1 {
+		t.Error("expected 1 block, got", d)
+	}
+	if d := ConfirmationDelay(100, 106); d != 6 {
+		t.Error("expected 6 blocks, got", d)
+	}
+	if d := ConfirmationDelay(101, 101); d != 1 {
+		t.Error("expected 1 block, got", d)
+	}
+}
+
+func TestHistoryFeeRate(t *testing.T) {
+	// High fee txs get in the next block, medium within 3 blocks, cheap ones wait 10 blocks
+	history := syntheticHistory(20, 5, map[float64]uint{50: 1, 10.5: 3, 2: 10})
+
+	type historyTest struct {
+		Blocks  uint
+		FeeRate float64
+	}
+	historyTests := []historyTest{
+		{1, 50},
+		{2, 50},
+		{3, 10},
+		{6, 10},
+		{10, 2},
+		{25, 2},
+	}
+	for _, item := range historyTests {
+		feerate, ok := HistoryFeeRate(history, item.Blocks)
+		if !ok || feerate != item.FeeRate {
+			t.Error("for target", item.Blocks, "expected", item.FeeRate, "got", feerate, ok)
+		}
+	}
+
+	// Not enough data
+	if _, ok := HistoryFeeRate(syntheticHistory(1, 2, map[float64]uint{50: 1}), 1); ok {
+		t.Error("expected no estimate with too few samples")
+	}
+	if _, ok := HistoryFeeRate([]*BlockFeeStats{}, 1); ok {
+		t.Error("expected no estimate without history")
+	}
+}
+
+func TestMempoolFeeRate(t *testing.T) {
+	// 3 blocks worth of transactions: 1MB at 30, 1MB at 20, 1MB at 5
+	feerates := []float64{}
+	sizes := []uint64{}
+	for _, feerate := range []float64{5, 20, 30} {
+		for i := 0; i < 4; i++ {
+			feerates = append(feerates, feerate)
+			sizes = append(sizes, MaxBlockSize/4)
+		}
+	}
+	// Add a small one so the backlog is above 3 blocks
+	feerates = append(feerates, 1)
+	sizes = append(sizes, 250)
+
+	type mempoolTest struct {
+		Blocks  uint
+		FeeRate float64
+	}
+	mempoolTests := []mempoolTest{
+		{1, 20},
+		{2, 5},
+		{3, 1},
+		{4, 0},
+	}
+	for _, item := range mempoolTests {
+		if feerate := MempoolFeeRate(feerates, sizes, item.Blocks); feerate != item.FeeRate {
+			t.Error("for target", item.Blocks, "expected", item.FeeRate, "got", feerate)
+		}
+	}
+}
+
+func TestEstimateFee(t *testing.T) {
+	history := syntheticHistory(20, 5, map[float64]uint{50: 1, 10.5: 3, 2: 10})
+
+	// Empty memory pool, only the history counts
+	estimate := EstimateFee(history, []float64{}, []uint64{}, 3)
+	if estimate.FeeRate != 10 || estimate.FeePerKb != 10000 {
+		t.Error("expected 10 sat/B, got", estimate.FeeRate, estimate.FeePerKb)
+	}
+
+	// A congested memory pool raises the estimate
+	estimate = EstimateFee(history, []float64{80, 60}, []uint64{MaxBlockSize * 3, 1000}, 3)
+	if estimate.FeeRate != 60 {
+		t.Error("expected 60 sat/B, got", estimate.FeeRate)
+	}
+
+	// Without history nor backlog, fallback to the minimum relay fee
+	estimate = EstimateFee([]*BlockFeeStats{}, []float64{}, []uint64{}, 1)
+	if estimate.FeeRate != MinRelayFeeRate {
+		t.Error("expected", MinRelayFeeRate, "got", estimate.FeeRate)
+	}
+
+	// Targets beyond the history are capped instead of overflowing the memory pool capacity
+	estimate = EstimateFee([]*BlockFeeStats{}, []float64{80}, []uint64{MaxBlockSize * (FeeHistoryBlocks + 1)}, ^uint(0))
+	if estimate.Blocks != FeeHistoryBlocks || estimate.FeeRate != 80 {
+		t.Error("expected", FeeHistoryBlocks, "blocks at 80 sat/B, got", estimate.Blocks, estimate.FeeRate)
+	}
+
+	fees := RecommendFees(history, []float64{}, []uint64{})
+	if fees.FastestFee != 50 || fees.FastFee != 10 || fees.MediumFee != 10 || fees.EconomyFee != 2 || fees.MinimumFee != MinRelayFeeRate {
+		t.Errorf("unexpected recommended fees: %+v", fees)
+	}
+}
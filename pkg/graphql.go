@@ -0,0 +1,934 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Minimal GraphQL (queries only, no introspection besides __typename): the query is parsed,
+// checked against the schema along with its estimated cost, then executed breadth-first so
+// each field is resolved once for all its parents (one MGET per level instead of one GET per object)
+
+// Query limits, list fields count as their limit (or graphQLListEstimate items when unbounded) in the
+// estimated cost, the actual cost is checked again as fields are resolved
+const (
+	MaxGraphQLCost      = 10000
+	MaxGraphQLDepth     = 10
+	graphQLListEstimate = 10
+)
+
+var ErrGraphQLCost = errors.New("Query is too expensive")
+
+type GraphQLError struct {
+	Message string `json:"message"`
+}
+
+func (gqlerr *GraphQLError) String() string {
+	return gqlerr.Message
+}
+
+type GraphQLResponse struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     []*GraphQLError        `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Field of an object type, Type is either a scalar (String, Int, Float, Boolean) or an object type,
+// Resolve is called once with every parent and returns one value per parent
+// ([]interface{} for lists, nil for null)
+type graphQLField struct {
+	Type    string
+	List    bool
+	Args    map[string]string
+	Cost    int
+	Size    func(args map[string]interface{}) int
+	Resolve func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) ([]interface{}, error)
+}
+
+type graphQLSchema map[string]map[string]*graphQLField
+
+// Response objects keep the fields in query order
+type graphQLEntry struct {
+	key   string
+	value interface{}
+}
+
+type graphQLObject []*graphQLEntry
+
+func (obj graphQLObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, entry := range obj {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(append(buf, key...), ':'), value...)
+	}
+	return append(buf, '}'), nil
+}
+
+// Parsed query
+
+type gqlVariable string
+
+type gqlEnum string
+
+type gqlDirective struct {
+	name string
+	args map[string]interface{}
+}
+
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives []*gqlDirective
+	set        []*gqlSelection
+	// Fragment spread (fragment) or inline fragment (inline, with an optional type condition)
+	fragment string
+	inline   bool
+	on       string
+}
+
+type gqlVariableDef struct {
+	name    string
+	typ     string
+	nonnull bool
+	value   interface{}
+	hasdef  bool
+}
+
+type gqlOperation struct {
+	typ  string
+	name string
+	vars []*gqlVariableDef
+	set  []*gqlSelection
+}
+
+type gqlFragment struct {
+	on  string
+	set []*gqlSelection
+}
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// Lexer
+
+const (
+	gqlEOF byte = iota
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+	gqlPunct
+)
+
+type gqlToken struct {
+	kind  byte
+	value string
+	pos   int
+}
+
+func isGraphQLNameChar(ch byte, first bool) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (!first && ch >= '0' && ch <= '9')
+}
+
+func lexGraphQL(query string) (tokens []*gqlToken, err error) {
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == ',':
+			i++
+		case ch == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, &gqlToken{gqlPunct, "...", i})
+			i += 3
+		case strings.IndexByte("!$()[]{}:=@|&", ch) >= 0:
+			tokens = append(tokens, &gqlToken{gqlPunct, string(ch), i})
+			i++
+		case isGraphQLNameChar(ch, true):
+			j := i + 1
+			for j < len(query) && isGraphQLNameChar(query[j], false) {
+				j++
+			}
+			tokens = append(tokens, &gqlToken{gqlName, query[i:j], i})
+			i = j
+		case ch == '-' || (ch >= '0' && ch <= '9'):
+			j, kind := i+1, gqlInt
+			for ; j < len(query); j++ {
+				c := query[j]
+				if c == '.' || c == 'e' || c == 'E' {
+					kind = gqlFloat
+				} else if !(c >= '0' && c <= '9') && !((c == '+' || c == '-') && (query[j-1] == 'e' || query[j-1] == 'E')) {
+					break
+				}
+			}
+			tokens = append(tokens, &gqlToken{kind, query[i:j], i})
+			i = j
+		case strings.HasPrefix(query[i:], `"""`):
+			end := strings.Index(query[i+3:], `"""`)
+			if end < 0 {
+				return nil, fmt.Errorf("Syntax error at %v: unterminated string", i)
+			}
+			tokens = append(tokens, &gqlToken{gqlString, strings.TrimSpace(query[i+3 : i+3+end]), i})
+			i += end + 6
+		case ch == '"':
+			j := i + 1
+			for ; j < len(query) && query[j] != '"'; j++ {
+				if query[j] == '\\' {
+					j++
+				} else if query[j] == '\n' {
+					break
+				}
+			}
+			if j >= len(query) || query[j] != '"' {
+				return nil, fmt.Errorf("Syntax error at %v: unterminated string", i)
+			}
+			// GraphQL escapes are the JSON ones
+			var value string
+			if err = json.Unmarshal([]byte(query[i:j+1]), &value); err != nil {
+				return nil, fmt.Errorf("Syntax error at %v: invalid string", i)
+			}
+			tokens = append(tokens, &gqlToken{gqlString, value, i})
+			i = j + 1
+		default:
+			return nil, fmt.Errorf("Syntax error at %v: unexpected character %q", i, ch)
+		}
+	}
+	return append(tokens, &gqlToken{gqlEOF, "", len(query)}), nil
+}
+
+// Parser
+
+type gqlParser struct {
+	tokens []*gqlToken
+	pos    int
+}
+
+func (p *gqlParser) peek() *gqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *gqlParser) is(kind byte, value string) bool {
+	tok := p.peek()
+	return tok.kind == kind && (value == "" || tok.value == value)
+}
+
+// Consume the next token if it matches
+func (p *gqlParser) skip(kind byte, value string) bool {
+	if p.is(kind, value) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(kind byte, value string) (tok *gqlToken, err error) {
+	tok = p.peek()
+	if !p.skip(kind, value) {
+		want := value
+		if want == "" {
+			want = "a name"
+		}
+		found := tok.value
+		if tok.kind == gqlEOF {
+			found = "end of query"
+		}
+		return nil, fmt.Errorf("Syntax error at %v: expected %v, found %q", tok.pos, want, found)
+	}
+	return
+}
+
+func parseGraphQL(query string) (doc *gqlDocument, err error) {
+	tokens, err := lexGraphQL(query)
+	if err != nil {
+		return
+	}
+	p := &gqlParser{tokens: tokens}
+	doc = &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for !p.is(gqlEOF, "") {
+		if p.is(gqlPunct, "{") {
+			op := &gqlOperation{typ: "query"}
+			if op.set, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+			continue
+		}
+		tok, terr := p.expect(gqlName, "")
+		if terr != nil {
+			return nil, terr
+		}
+		switch tok.value {
+		case "query", "mutation", "subscription":
+			op := &gqlOperation{typ: tok.value}
+			if p.is(gqlName, "") {
+				op.name = p.peek().value
+				p.pos++
+			}
+			if op.vars, err = p.variableDefinitions(); err != nil {
+				return nil, err
+			}
+			if _, err = p.directives(); err != nil {
+				return nil, err
+			}
+			if op.set, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case "fragment":
+			name, nerr := p.expect(gqlName, "")
+			if nerr != nil {
+				return nil, nerr
+			}
+			if _, err = p.expect(gqlName, "on"); err != nil {
+				return nil, err
+			}
+			on, oerr := p.expect(gqlName, "")
+			if oerr != nil {
+				return nil, oerr
+			}
+			if _, err = p.directives(); err != nil {
+				return nil, err
+			}
+			fragment := &gqlFragment{on: on.value}
+			if fragment.set, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			if _, exists := doc.fragments[name.value]; exists {
+				return nil, fmt.Errorf("Fragment %v is defined more than once", name.value)
+			}
+			doc.fragments[name.value] = fragment
+		default:
+			return nil, fmt.Errorf("Syntax error at %v: unexpected %q", tok.pos, tok.value)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, errors.New("No operation in the query")
+	}
+	return
+}
+
+func (p *gqlParser) variableDefinitions() (defs []*gqlVariableDef, err error) {
+	if !p.skip(gqlPunct, "(") {
+		return
+	}
+	for !p.skip(gqlPunct, ")") {
+		if _, err = p.expect(gqlPunct, "$"); err != nil {
+			return
+		}
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return nil, nerr
+		}
+		if _, err = p.expect(gqlPunct, ":"); err != nil {
+			return
+		}
+		def := &gqlVariableDef{name: name.value}
+		if def.typ, err = p.typeRef(); err != nil {
+			return
+		}
+		def.nonnull = strings.HasSuffix(def.typ, "!")
+		if p.skip(gqlPunct, "=") {
+			def.hasdef = true
+			if def.value, err = p.value(true); err != nil {
+				return
+			}
+		}
+		defs = append(defs, def)
+	}
+	return
+}
+
+func (p *gqlParser) typeRef() (typ string, err error) {
+	if p.skip(gqlPunct, "[") {
+		inner, ierr := p.typeRef()
+		if ierr != nil {
+			return "", ierr
+		}
+		if _, err = p.expect(gqlPunct, "]"); err != nil {
+			return
+		}
+		typ = "[" + inner + "]"
+	} else {
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return "", nerr
+		}
+		typ = name.value
+	}
+	if p.skip(gqlPunct, "!") {
+		typ += "!"
+	}
+	return
+}
+
+// Parse a value, variables aren't allowed in constants (variable default values)
+func (p *gqlParser) value(constant bool) (value interface{}, err error) {
+	tok := p.peek()
+	switch {
+	case tok.kind == gqlPunct && tok.value == "$" && !constant:
+		p.pos++
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return nil, nerr
+		}
+		return gqlVariable(name.value), nil
+	case tok.kind == gqlInt:
+		p.pos++
+		return strconv.ParseInt(tok.value, 10, 64)
+	case tok.kind == gqlFloat:
+		p.pos++
+		return strconv.ParseFloat(tok.value, 64)
+	case tok.kind == gqlString:
+		p.pos++
+		return tok.value, nil
+	case tok.kind == gqlName:
+		p.pos++
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return gqlEnum(tok.value), nil
+	case tok.kind == gqlPunct && tok.value == "[":
+		p.pos++
+		list := []interface{}{}
+		for !p.skip(gqlPunct, "]") {
+			item, ierr := p.value(constant)
+			if ierr != nil {
+				return nil, ierr
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case tok.kind == gqlPunct && tok.value == "{":
+		p.pos++
+		obj := map[string]interface{}{}
+		for !p.skip(gqlPunct, "}") {
+			name, nerr := p.expect(gqlName, "")
+			if nerr != nil {
+				return nil, nerr
+			}
+			if _, err = p.expect(gqlPunct, ":"); err != nil {
+				return
+			}
+			if obj[name.value], err = p.value(constant); err != nil {
+				return
+			}
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("Syntax error at %v: unexpected %q", tok.pos, tok.value)
+}
+
+func (p *gqlParser) arguments() (args map[string]interface{}, err error) {
+	args = map[string]interface{}{}
+	if !p.skip(gqlPunct, "(") {
+		return
+	}
+	for !p.skip(gqlPunct, ")") {
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return nil, nerr
+		}
+		if _, err = p.expect(gqlPunct, ":"); err != nil {
+			return
+		}
+		if args[name.value], err = p.value(false); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *gqlParser) directives() (directives []*gqlDirective, err error) {
+	for p.skip(gqlPunct, "@") {
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return nil, nerr
+		}
+		directive := &gqlDirective{name: name.value}
+		if directive.args, err = p.arguments(); err != nil {
+			return
+		}
+		directives = append(directives, directive)
+	}
+	return
+}
+
+func (p *gqlParser) selectionSet() (set []*gqlSelection, err error) {
+	if _, err = p.expect(gqlPunct, "{"); err != nil {
+		return
+	}
+	for !p.skip(gqlPunct, "}") {
+		sel := new(gqlSelection)
+		if p.skip(gqlPunct, "...") {
+			if p.skip(gqlName, "on") {
+				on, oerr := p.expect(gqlName, "")
+				if oerr != nil {
+					return nil, oerr
+				}
+				sel.inline, sel.on = true, on.value
+			} else if p.is(gqlName, "") {
+				sel.fragment = p.peek().value
+				p.pos++
+			} else {
+				sel.inline = true
+			}
+			if sel.directives, err = p.directives(); err != nil {
+				return
+			}
+			if sel.inline {
+				if sel.set, err = p.selectionSet(); err != nil {
+					return
+				}
+			}
+			set = append(set, sel)
+			continue
+		}
+		name, nerr := p.expect(gqlName, "")
+		if nerr != nil {
+			return nil, nerr
+		}
+		sel.name = name.value
+		if p.skip(gqlPunct, ":") {
+			if name, err = p.expect(gqlName, ""); err != nil {
+				return
+			}
+			sel.alias, sel.name = sel.name, name.value
+		}
+		if sel.args, err = p.arguments(); err != nil {
+			return
+		}
+		if sel.directives, err = p.directives(); err != nil {
+			return
+		}
+		if p.is(gqlPunct, "{") {
+			if sel.set, err = p.selectionSet(); err != nil {
+				return
+			}
+		}
+		set = append(set, sel)
+	}
+	return
+}
+
+// Validation and execution
+
+// Field to resolve, selections with the same response key are merged
+type gqlPlan struct {
+	key   string
+	name  string
+	field *graphQLField
+	args  map[string]interface{}
+	plans []*gqlPlan
+}
+
+type gqlExecutor struct {
+	schema    graphQLSchema
+	doc       *gqlDocument
+	variables map[string]interface{}
+	loader    *graphQLLoader
+	cost      int
+	// Cost of the fields resolved so far, lists may be longer than estimated
+	spent int
+}
+
+// Replace the variables by their values
+func (e *gqlExecutor) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case gqlVariable:
+		resolved, defined := e.variables[string(v)]
+		if !defined {
+			return nil, fmt.Errorf("Variable $%v is not defined", v)
+		}
+		return resolved, nil
+	case []interface{}:
+		list := []interface{}{}
+		for _, item := range v {
+			resolved, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, resolved)
+		}
+		return list, nil
+	case map[string]interface{}:
+		obj := map[string]interface{}{}
+		for key, item := range v {
+			resolved, err := e.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = resolved
+		}
+		return obj, nil
+	}
+	return value, nil
+}
+
+// Coerce an argument or variable value to the given type (String, Int, Float, Boolean, [Type], ending with ! if required)
+func coerceGraphQLValue(typ string, value interface{}) (interface{}, error) {
+	nonnull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		if nonnull {
+			return nil, errors.New("required")
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		items, ok := value.([]interface{})
+		if !ok {
+			// A single value is accepted as a list of one
+			items = []interface{}{value}
+		}
+		list := []interface{}{}
+		for _, item := range items {
+			coerced, err := coerceGraphQLValue(typ[1:len(typ)-1], item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, coerced)
+		}
+		return list, nil
+	}
+	switch typ {
+	case "String":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == float64(int(v)) {
+				return int(v), nil
+			}
+		case json.Number:
+			if n, err := strconv.Atoi(v.String()); err == nil {
+				return n, nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		case json.Number:
+			return v.Float64()
+		}
+	case "Boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %v", typ)
+}
+
+// Evaluate @skip/@include
+func (e *gqlExecutor) included(directives []*gqlDirective) (bool, error) {
+	for _, directive := range directives {
+		if directive.name != "skip" && directive.name != "include" {
+			return false, fmt.Errorf("Unknown directive @%v", directive.name)
+		}
+		value, err := e.resolveValue(directive.args["if"])
+		if err != nil {
+			return false, err
+		}
+		cond, ok := value.(bool)
+		if !ok {
+			return false, fmt.Errorf("@%v requires a Boolean if argument", directive.name)
+		}
+		if cond == (directive.name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Flatten fragments, grouping the selections by response key
+func (e *gqlExecutor) collect(typename string, set []*gqlSelection, keys *[]string, groups map[string][]*gqlSelection, visited map[string]bool) error {
+	for _, sel := range set {
+		included, err := e.included(sel.directives)
+		if err != nil {
+			return err
+		}
+		if !included {
+			continue
+		}
+		switch {
+		case sel.inline:
+			if sel.on != "" && sel.on != typename {
+				if _, exists := e.schema[sel.on]; !exists {
+					return fmt.Errorf("Unknown type %v", sel.on)
+				}
+				continue
+			}
+			if err = e.collect(typename, sel.set, keys, groups, visited); err != nil {
+				return err
+			}
+		case sel.fragment != "":
+			fragment, exists := e.doc.fragments[sel.fragment]
+			if !exists {
+				return fmt.Errorf("Unknown fragment %v", sel.fragment)
+			}
+			if visited[sel.fragment] {
+				return fmt.Errorf("Fragment %v spreads itself", sel.fragment)
+			}
+			if fragment.on != typename {
+				if _, exists := e.schema[fragment.on]; !exists {
+					return fmt.Errorf("Unknown type %v", fragment.on)
+				}
+				continue
+			}
+			visited[sel.fragment] = true
+			err = e.collect(typename, fragment.set, keys, groups, visited)
+			delete(visited, sel.fragment)
+			if err != nil {
+				return err
+			}
+		default:
+			key := sel.name
+			if sel.alias != "" {
+				key = sel.alias
+			}
+			if _, exists := groups[key]; !exists {
+				*keys = append(*keys, key)
+			}
+			groups[key] = append(groups[key], sel)
+		}
+	}
+	return nil
+}
+
+// Validate the selection set, and add its cost (items being the estimated number of parents)
+func (e *gqlExecutor) plan(typename string, set []*gqlSelection, depth, items int) (plans []*gqlPlan, err error) {
+	if depth > MaxGraphQLDepth {
+		return nil, fmt.Errorf("Query is nested more than %v levels deep", MaxGraphQLDepth)
+	}
+	keys := []string{}
+	groups := map[string][]*gqlSelection{}
+	if err = e.collect(typename, set, &keys, groups, map[string]bool{}); err != nil {
+		return
+	}
+	for _, key := range keys {
+		sels := groups[key]
+		plan := &gqlPlan{key: key, name: sels[0].name}
+		subset := []*gqlSelection{}
+		for _, sel := range sels {
+			if sel.name != plan.name {
+				return nil, fmt.Errorf("Fields %v and %v conflict on %v", plan.name, sel.name, key)
+			}
+			subset = append(subset, sel.set...)
+		}
+		if plan.name == "__typename" {
+			plans = append(plans, plan)
+			continue
+		}
+		field, exists := e.schema[typename][plan.name]
+		if !exists {
+			return nil, fmt.Errorf("Unknown field %v on %v", plan.name, typename)
+		}
+		plan.field = field
+		if plan.args, err = e.arguments(typename, field, sels[0].args); err != nil {
+			return
+		}
+		e.cost += items * field.Cost
+		if e.cost > MaxGraphQLCost {
+			return nil, ErrGraphQLCost
+		}
+		_, object := e.schema[field.Type]
+		if !object {
+			if len(subset) > 0 {
+				return nil, fmt.Errorf("Field %v on %v is a %v, it can't have a selection", plan.name, typename, field.Type)
+			}
+			plans = append(plans, plan)
+			continue
+		}
+		if len(subset) == 0 {
+			return nil, fmt.Errorf("Field %v on %v needs a selection of %v fields", plan.name, typename, field.Type)
+		}
+		childitems := items
+		if field.List {
+			size := graphQLListEstimate
+			if field.Size != nil {
+				size = field.Size(plan.args)
+			}
+			childitems *= size
+		}
+		if plan.plans, err = e.plan(field.Type, subset, depth+1, childitems); err != nil {
+			return
+		}
+		plans = append(plans, plan)
+	}
+	return
+}
+
+func (e *gqlExecutor) arguments(typename string, field *graphQLField, raw map[string]interface{}) (args map[string]interface{}, err error) {
+	args = map[string]interface{}{}
+	for name := range raw {
+		if _, exists := field.Args[name]; !exists {
+			return nil, fmt.Errorf("Unknown argument %v on %v", name, typename)
+		}
+	}
+	for name, typ := range field.Args {
+		value, verr := e.resolveValue(raw[name])
+		if verr != nil {
+			return nil, verr
+		}
+		coerced, cerr := coerceGraphQLValue(typ, value)
+		if cerr != nil {
+			return nil, fmt.Errorf("Invalid argument %v: %v", name, cerr)
+		}
+		if coerced != nil {
+			args[name] = coerced
+		}
+	}
+	return
+}
+
+// Resolve the plans for every source at once, returning one object per source
+func (e *gqlExecutor) execute(typename string, plans []*gqlPlan, sources []interface{}) (objects []graphQLObject, err error) {
+	objects = make([]graphQLObject, len(sources))
+	for _, plan := range plans {
+		if plan.field == nil {
+			for i := range sources {
+				objects[i] = append(objects[i], &graphQLEntry{plan.key, typename})
+			}
+			continue
+		}
+		e.spent += len(sources) * plan.field.Cost
+		if e.spent > MaxGraphQLCost {
+			return nil, ErrGraphQLCost
+		}
+		values, rerr := plan.field.Resolve(e.loader, sources, plan.args)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if len(values) != len(sources) {
+			return nil, fmt.Errorf("Field %v resolved %v values for %v objects", plan.name, len(values), len(sources))
+		}
+		if plan.plans == nil {
+			for i, value := range values {
+				objects[i] = append(objects[i], &graphQLEntry{plan.key, value})
+			}
+			continue
+		}
+		// Resolve the children of every source in one pass
+		children := []interface{}{}
+		for _, value := range values {
+			if items, islist := value.([]interface{}); islist {
+				for _, item := range items {
+					if item != nil {
+						children = append(children, item)
+					}
+				}
+			} else if value != nil {
+				children = append(children, value)
+			}
+		}
+		childobjects, cerr := e.execute(plan.field.Type, plan.plans, children)
+		if cerr != nil {
+			return nil, cerr
+		}
+		next := 0
+		for i, value := range values {
+			var result interface{}
+			if items, islist := value.([]interface{}); islist {
+				list := []interface{}{}
+				for _, item := range items {
+					if item == nil {
+						list = append(list, nil)
+						continue
+					}
+					list = append(list, childobjects[next])
+					next++
+				}
+				result = list
+			} else if value != nil {
+				result = childobjects[next]
+				next++
+			}
+			objects[i] = append(objects[i], &graphQLEntry{plan.key, result})
+		}
+	}
+	return
+}
+
+// Run the query against the schema, the "Query" type being the root
+func executeGraphQL(schema graphQLSchema, loader *graphQLLoader, query string, variables map[string]interface{}, operation string) (resp *GraphQLResponse) {
+	resp = &GraphQLResponse{}
+	fail := func(err error) *GraphQLResponse {
+		resp.Errors = []*GraphQLError{&GraphQLError{Message: err.Error()}}
+		return resp
+	}
+	doc, err := parseGraphQL(query)
+	if err != nil {
+		return fail(err)
+	}
+	var op *gqlOperation
+	for _, cop := range doc.operations {
+		if operation == "" || cop.name == operation {
+			if op != nil {
+				return fail(errors.New("The operation name is required when the query has several operations"))
+			}
+			op = cop
+		}
+	}
+	if op == nil {
+		return fail(fmt.Errorf("Unknown operation %v", operation))
+	}
+	if op.typ != "query" {
+		return fail(fmt.Errorf("Only queries are supported, not %vs", op.typ))
+	}
+	e := &gqlExecutor{schema: schema, doc: doc, variables: map[string]interface{}{}, loader: loader}
+	for _, def := range op.vars {
+		value, provided := variables[def.name]
+		if !provided && def.hasdef {
+			value = def.value
+		}
+		// Checked against the scalar type, the nullability is checked by the arguments
+		if e.variables[def.name], err = coerceGraphQLValue(strings.TrimSuffix(def.typ, "!"), value); err != nil {
+			return fail(fmt.Errorf("Invalid variable $%v: %v", def.name, err))
+		}
+		if value == nil && def.nonnull {
+			return fail(fmt.Errorf("Variable $%v is required", def.name))
+		}
+	}
+	plans, err := e.plan("Query", op.set, 1, 1)
+	if err != nil {
+		return fail(err)
+	}
+	resp.Extensions = map[string]interface{}{"cost": e.cost, "max_cost": MaxGraphQLCost}
+	objects, err := e.execute("Query", plans, []interface{}{nil})
+	resp.Extensions["actual_cost"] = e.spent
+	if err != nil {
+		return fail(err)
+	}
+	resp.Data = objects[0]
+	return
+}
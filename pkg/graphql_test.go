@@ -0,0 +1,177 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Binary tree of nodes, node n has children 2n and 2n+1 (below 100), resolvers count their calls
+func testGraphQLSchema(calls map[string]int) graphQLSchema {
+	node := func(id int) interface{} {
+		if id < 1 || id >= 100 {
+			return nil
+		}
+		return id
+	}
+	return graphQLSchema{
+		"Query": {
+			"node": {Type: "Node", Args: map[string]string{"id": "Int!"}, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				return []interface{}{node(args["id"].(int))}, nil
+			}},
+			"nodes": {Type: "Node", List: true, Args: limitArgs, Cost: 1, Size: limitSize, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				limit, _, err := limitArg(args)
+				list := []interface{}{}
+				for id := 1; id <= limit; id++ {
+					list = append(list, node(id))
+				}
+				return []interface{}{list}, err
+			}},
+			// Unbounded list, estimated to 10 items
+			"all": {Type: "Node", List: true, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				list := []interface{}{}
+				for id := 1; id < 100; id++ {
+					list = append(list, id)
+				}
+				return []interface{}{list}, nil
+			}},
+		},
+		"Node": {
+			"id": gqlScalar("Int", func(source interface{}) interface{} { return source }),
+			"children": {Type: "Node", List: true, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+				calls["children"]++
+				for _, source := range sources {
+					id := source.(int)
+					values = append(values, []interface{}{node(2 * id), node(2*id + 1)})
+				}
+				return
+			}},
+			"weight": {Type: "Int", Cost: 200, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+				calls["weight"]++
+				for range sources {
+					values = append(values, 1)
+				}
+				return
+			}},
+			"parent": {Type: "Node", Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+				calls["parent"]++
+				for _, source := range sources {
+					values = append(values, node(source.(int)/2))
+				}
+				return
+			}},
+		},
+	}
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	tests := []struct {
+		query     string
+		variables string
+		data      string
+		err       string
+	}{
+		{`{ node(id: 3) { id } }`, ``, `{"node":{"id":3}}`, ``},
+		{`query { a: node(id: 1) { id parent { id } } b: node(id: 200) { id } }`, ``, `{"a":{"id":1,"parent":null},"b":null}`, ``},
+		{`query Q($id: Int!) { node(id: $id) { __typename id } }`, `{"id": 5}`, `{"node":{"__typename":"Node","id":5}}`, ``},
+		{`query Q($id: Int = 7) { node(id: $id) { id } }`, ``, `{"node":{"id":7}}`, ``},
+		{`{ node(id: 49) { children { id } } }`, ``, `{"node":{"children":[{"id":98},{"id":99}]}}`, ``},
+		{`{ node(id: 50) { children { id } } }`, ``, `{"node":{"children":[null,null]}}`, ``},
+		{`{ node(id: 2) { ...f } } fragment f on Node { id parent { id } }`, ``, `{"node":{"id":2,"parent":{"id":1}}}`, ``},
+		{`{ node(id: 2) { ... on Node { id } ... { parent { id } } parent { parent { id } } } }`, ``, `{"node":{"id":2,"parent":{"id":1,"parent":null}}}`, ``},
+		{`query Q($s: Boolean!) { node(id: 2) { id @skip(if: $s) parent @include(if: $s) { id } } }`, `{"s": true}`, `{"node":{"parent":{"id":1}}}`, ``},
+		{`{ node(id: 1) { name } }`, ``, ``, `Unknown field name on Node`},
+		{`{ node(id: "1") { id } }`, ``, ``, `Invalid argument id: expected Int`},
+		{`{ node { id } }`, ``, ``, `Invalid argument id: required`},
+		{`{ node(id: 1, foo: 2) { id } }`, ``, ``, `Unknown argument foo on Query`},
+		{`{ node(id: 1) }`, ``, ``, `Field node on Query needs a selection of Node fields`},
+		{`{ node(id: 1) { id { x } } }`, ``, ``, `Field id on Node is a Int, it can't have a selection`},
+		{`query Q($id: Int!) { node(id: $id) { id } }`, ``, ``, `Variable $id is required`},
+		{`{ node(id: $id) { id } }`, ``, ``, `Variable $id is not defined`},
+		{`{ node(id: 1) { ...f } } fragment f on Node { ...f }`, ``, ``, `Fragment f spreads itself`},
+		{`mutation { node(id: 1) { id } }`, ``, ``, `Only queries are supported, not mutations`},
+		{`{ node(id: 1) { id }`, ``, ``, `Syntax error at 20: expected a name, found "end of query"`},
+		{`{ a: node(id: 1) { id } a: nodes { id } }`, ``, ``, `Fields node and nodes conflict on a`},
+		{`{ nodes(limit: 100) { children { children { children { id } } } } }`, ``, ``, `Query is too expensive`},
+		{`{ node(id: 1) { parent { parent { parent { parent { parent { parent { parent { parent { parent { parent { id } } } } } } } } } } } }`,
+			``, ``, `Query is nested more than 10 levels deep`},
+	}
+	for _, test := range tests {
+		variables := map[string]interface{}{}
+		if test.variables != "" {
+			json.Unmarshal([]byte(test.variables), &variables)
+		}
+		resp := executeGraphQL(testGraphQLSchema(map[string]int{}), nil, test.query, variables, "")
+		if test.err != "" {
+			if len(resp.Errors) != 1 || resp.Errors[0].Message != test.err || resp.Data != nil {
+				t.Errorf("executeGraphQL(%v) = %v, %+v, want error %q", test.query, resp.Data, resp.Errors, test.err)
+			}
+			continue
+		}
+		data, _ := json.Marshal(resp.Data)
+		if len(resp.Errors) > 0 || string(data) != test.data {
+			t.Errorf("executeGraphQL(%v) = %s, %v, want %v", test.query, data, resp.Errors, test.data)
+		}
+	}
+}
+
+func TestGraphQLBatching(t *testing.T) {
+	calls := map[string]int{}
+	resp := executeGraphQL(testGraphQLSchema(calls), nil, `{ nodes(limit: 3) { children { id children { id parent { id } } } } }`, nil, "")
+	if len(resp.Errors) > 0 {
+		t.Fatalf("executeGraphQL errors: %+v", resp.Errors[0])
+	}
+	// One call per level, whatever the number of nodes
+	if calls["children"] != 2 || calls["parent"] != 1 {
+		t.Errorf("resolver calls = %v, want 2 children and 1 parent", calls)
+	}
+	// nodes (1) + children (3) + children (3*10) + parent (3*10*10)
+	if cost := resp.Extensions["cost"]; cost != 334 {
+		t.Errorf("cost = %v, want 334", cost)
+	}
+}
+
+func TestGraphQLActualCost(t *testing.T) {
+	calls := map[string]int{}
+	// Estimated to 1 + 10*200, but all returns 99 nodes
+	resp := executeGraphQL(testGraphQLSchema(calls), nil, `{ all { id weight } }`, nil, "")
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != ErrGraphQLCost.Error() || resp.Data != nil {
+		t.Fatalf("executeGraphQL = %v, %+v, want %v", resp.Data, resp.Errors, ErrGraphQLCost)
+	}
+	if calls["weight"] != 0 {
+		t.Errorf("weight resolved %v times, want none", calls["weight"])
+	}
+	if cost := resp.Extensions["cost"]; cost != 2001 {
+		t.Errorf("cost = %v, want 2001", cost)
+	}
+
+	resp = executeGraphQL(testGraphQLSchema(calls), nil, `{ all { id children { id } } }`, nil, "")
+	if len(resp.Errors) > 0 {
+		t.Fatalf("executeGraphQL errors: %+v", resp.Errors[0])
+	}
+	if cost := resp.Extensions["actual_cost"]; cost != 100 {
+		t.Errorf("actual_cost = %v, want 100", cost)
+	}
+}
+
+func TestLexGraphQL(t *testing.T) {
+	tokens, err := lexGraphQL(`query($a: [Int!]) { x(s: "a\"b", f: -1.5e3, n: 42) ... # comment` + "\n" + `}`)
+	if err != nil {
+		t.Fatalf("lexGraphQL error: %v", err)
+	}
+	values := []string{}
+	for _, tok := range tokens {
+		values = append(values, tok.value)
+	}
+	want := []string{"query", "(", "$", "a", ":", "[", "Int", "!", "]", ")", "{", "x", "(", "s", ":", `a"b`, "f", ":", "-1.5e3", "n", ":", "42", ")", "...", "}", ""}
+	if len(values) != len(want) {
+		t.Fatalf("lexGraphQL = %q, want %q", values, want)
+	}
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("token %v = %q, want %q", i, values[i], want[i])
+		}
+	}
+	if _, err := lexGraphQL(`{ x(s: "abc) }`); err == nil {
+		t.Errorf("lexGraphQL(unterminated string) should fail")
+	}
+}
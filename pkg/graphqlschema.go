@@ -0,0 +1,947 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// GraphQL schema over the index, resolvers share a per query graphQLLoader that batches
+// the lookups with MGET and caches every block/tx it loaded
+
+// Default and max limit of the list fields
+const (
+	graphQLDefaultLimit = 10
+	graphQLMaxLimit     = 100
+	graphQLBatchSize    = 1000
+)
+
+// Source of the Mempool type
+type graphQLMempool struct{}
+
+type graphQLLoader struct {
+	rpool       *redis.Pool
+	pool        *redis.Pool
+	txs         map[string]*Tx
+	blocks      map[string]*Block
+	metas       map[string]bool
+	inputs      map[*Tx]bool
+	outputs     map[*Tx]bool
+	addresses   map[string]*AddressData
+	unconfirmed map[string]bool
+	latest      int
+	mempool     *MempoolInfo
+}
+
+func newGraphQLLoader(rpool, pool *redis.Pool) *graphQLLoader {
+	return &graphQLLoader{rpool: rpool, pool: pool, txs: map[string]*Tx{}, blocks: map[string]*Block{}, metas: map[string]bool{},
+		inputs: map[*Tx]bool{}, outputs: map[*Tx]bool{}, addresses: map[string]*AddressData{}, unconfirmed: map[string]bool{}, latest: -1}
+}
+
+// Execute a GraphQL query against the index (SSDB) and the memory pool (Redis)
+func ExecuteGraphQL(rpool, pool *redis.Pool, query string, variables map[string]interface{}, operation string) *GraphQLResponse {
+	return executeGraphQL(graphQLIndexSchema, newGraphQLLoader(rpool, pool), query, variables, operation)
+}
+
+// MGET in chunks, missing keys are returned as empty strings
+func mgetStrings(c redis.Conn, keys []string) (values []string, err error) {
+	values = []string{}
+	for start := 0; start < len(keys); start += graphQLBatchSize {
+		stop := start + graphQLBatchSize
+		if stop > len(keys) {
+			stop = len(keys)
+		}
+		chunk, cerr := redis.Strings(c.Do("MGET", redis.Args{}.AddFlat(keys[start:stop])...))
+		if cerr != nil {
+			return nil, cerr
+		}
+		values = append(values, chunk...)
+	}
+	return
+}
+
+// Load the txs (confirmed or unconfirmed), nil for unknown hashes
+func (l *graphQLLoader) loadTxs(hashes []string) (txs []*Tx, err error) {
+	missing := []string{}
+	for _, hash := range hashes {
+		if _, cached := l.txs[hash]; !cached && hash != "" {
+			l.txs[hash] = nil
+			missing = append(missing, hash)
+		}
+	}
+	for _, prefix := range []string{"tx:", "btcplex:utx:"} {
+		if len(missing) == 0 {
+			break
+		}
+		pool := l.rpool
+		if prefix == "btcplex:utx:" {
+			pool = l.pool
+		}
+		keys := []string{}
+		for _, hash := range missing {
+			keys = append(keys, prefix+hash)
+		}
+		c := pool.Get()
+		values, merr := mgetStrings(c, keys)
+		c.Close()
+		if merr != nil {
+			return nil, merr
+		}
+		notfound := []string{}
+		for i, value := range values {
+			if value == "" {
+				notfound = append(notfound, missing[i])
+				continue
+			}
+			tx := new(Tx)
+			if err = json.Unmarshal([]byte(value), tx); err != nil {
+				return
+			}
+			l.txs[missing[i]] = tx
+		}
+		missing = notfound
+	}
+	for _, hash := range hashes {
+		txs = append(txs, l.txs[hash])
+	}
+	return
+}
+
+// Fetch the TxIns of the confirmed txs (unconfirmed ones come with them)
+func (l *graphQLLoader) loadInputs(txs []*Tx) (err error) {
+	pending := []*Tx{}
+	keys := []string{}
+	for _, tx := range txs {
+		if tx == nil || l.inputs[tx] {
+			continue
+		}
+		l.inputs[tx] = true
+		if len(tx.TxIns) < int(tx.TxInCnt) {
+			pending = append(pending, tx)
+			for i := 0; i < int(tx.TxInCnt); i++ {
+				keys = append(keys, fmt.Sprintf("txi:%v:%v", tx.Hash, i))
+			}
+		}
+		if tx.TxIns == nil {
+			tx.TxIns = []*TxIn{}
+		}
+	}
+	c := l.rpool.Get()
+	defer c.Close()
+	values, err := mgetStrings(c, keys)
+	if err != nil {
+		return
+	}
+	for _, tx := range pending {
+		tx.TxIns = []*TxIn{}
+		for i := 0; i < int(tx.TxInCnt); i++ {
+			value := values[0]
+			values = values[1:]
+			if value == "" {
+				continue
+			}
+			txi := new(TxIn)
+			if err = json.Unmarshal([]byte(value), txi); err != nil {
+				return
+			}
+			tx.TxIns = append(tx.TxIns, txi)
+		}
+	}
+	for _, tx := range txs {
+		if tx != nil {
+			for _, txi := range tx.TxIns {
+				txi.TxHash = tx.Hash
+			}
+		}
+	}
+	return
+}
+
+// Fetch the TxOuts of the confirmed txs along with their spent data, then mark the
+// outputs spent by unconfirmed txs
+func (l *graphQLLoader) loadOutputs(txs []*Tx) (err error) {
+	pending := []*Tx{}
+	loaded := []*Tx{}
+	keys := []string{}
+	for _, tx := range txs {
+		if tx == nil || l.outputs[tx] {
+			continue
+		}
+		l.outputs[tx] = true
+		loaded = append(loaded, tx)
+		if len(tx.TxOuts) < int(tx.TxOutCnt) {
+			pending = append(pending, tx)
+			for i := 0; i < int(tx.TxOutCnt); i++ {
+				keys = append(keys, fmt.Sprintf("txo:%v:%v", tx.Hash, i), fmt.Sprintf("txo:%v:%v:spent", tx.Hash, i))
+			}
+		}
+		if tx.TxOuts == nil {
+			tx.TxOuts = []*TxOut{}
+		}
+	}
+	c := l.rpool.Get()
+	values, err := mgetStrings(c, keys)
+	c.Close()
+	if err != nil {
+		return
+	}
+	for _, tx := range pending {
+		tx.TxOuts = []*TxOut{}
+		for i := 0; i < int(tx.TxOutCnt); i++ {
+			value, spent := values[0], values[1]
+			values = values[2:]
+			if value == "" {
+				continue
+			}
+			txo := new(TxOut)
+			if err = json.Unmarshal([]byte(value), txo); err != nil {
+				return
+			}
+			if spent != "" {
+				txo.Spent = new(TxoSpent)
+				if err = json.Unmarshal([]byte(spent), txo.Spent); err != nil {
+					return
+				}
+			}
+			tx.TxOuts = append(tx.TxOuts, txo)
+		}
+	}
+	unspent := []*TxOut{}
+	outpoints := redis.Args{}.Add("btcplex:mempool:outpoints")
+	for _, tx := range loaded {
+		for _, txo := range tx.TxOuts {
+			txo.TxHash = tx.Hash
+			if txo.Spent == nil || !txo.Spent.Spent {
+				unspent = append(unspent, txo)
+				outpoints = outpoints.Add(fmt.Sprintf("%v:%v", tx.Hash, txo.Index))
+			}
+		}
+	}
+	if len(unspent) == 0 {
+		return
+	}
+	pc := l.pool.Get()
+	spenders, err := redis.Strings(pc.Do("HMGET", outpoints...))
+	pc.Close()
+	if err != nil {
+		return
+	}
+	if _, err = l.loadTxs(spenders); err != nil {
+		return
+	}
+	for i, spender := range spenders {
+		utx := l.txs[spender]
+		if utx == nil {
+			continue
+		}
+		txo := unspent[i]
+		txo.Spent = &TxoSpent{Spent: true, Unconfirmed: true, InputHash: spender}
+		for txiindex, txi := range utx.TxIns {
+			if txi.PrevOut != nil && txi.PrevOut.Hash == txo.TxHash && txi.PrevOut.Vout == txo.Index {
+				txo.Spent.InputIndex = uint32(txiindex)
+			}
+		}
+	}
+	return
+}
+
+// Load the blocks, nil for unknown hashes
+func (l *graphQLLoader) loadBlocks(hashes []string) (blocks []*Block, err error) {
+	missing := []string{}
+	keys := []string{}
+	for _, hash := range hashes {
+		if _, cached := l.blocks[hash]; !cached && hash != "" {
+			l.blocks[hash] = nil
+			missing = append(missing, hash)
+			keys = append(keys, fmt.Sprintf("block:%v", hash))
+		}
+	}
+	c := l.rpool.Get()
+	values, err := mgetStrings(c, keys)
+	c.Close()
+	if err != nil {
+		return
+	}
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		block := new(Block)
+		if err = json.Unmarshal([]byte(value), block); err != nil {
+			return
+		}
+		l.blocks[missing[i]] = block
+	}
+	for _, hash := range hashes {
+		blocks = append(blocks, l.blocks[hash])
+	}
+	return
+}
+
+// Main chain status, next block and chain work are kept in the block:%v:h hash
+func (l *graphQLLoader) loadMetas(blocks []*Block) (err error) {
+	for _, block := range blocks {
+		if l.metas[block.Hash] {
+			continue
+		}
+		l.metas[block.Hash] = true
+		if err = block.FetchMeta(l.rpool); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (l *graphQLLoader) latestHeight() (height uint, err error) {
+	if l.latest < 0 {
+		c := l.rpool.Get()
+		defer c.Close()
+		if l.latest, err = redis.Int(c.Do("GET", "height:latest")); err != nil {
+			l.latest = -1
+			return
+		}
+	}
+	return uint(l.latest), nil
+}
+
+func (l *graphQLLoader) loadAddresses(addresses []string, unconfirmed bool) (data []*AddressData, err error) {
+	for _, address := range addresses {
+		addrdata, cached := l.addresses[address]
+		if !cached {
+			if addrdata, err = GetAddress(l.rpool, address); err != nil {
+				return
+			}
+			l.addresses[address] = addrdata
+		}
+		if unconfirmed && !l.unconfirmed[address] {
+			l.unconfirmed[address] = true
+			if err = addrdata.FetchUnconfirmed(l.pool); err != nil {
+				return
+			}
+		}
+		data = append(data, addrdata)
+	}
+	return
+}
+
+// Field helpers
+
+func limitArg(args map[string]interface{}) (limit, offset int, err error) {
+	limit, offset = graphQLDefaultLimit, 0
+	if v, ok := args["limit"]; ok {
+		limit = v.(int)
+	}
+	if v, ok := args["offset"]; ok {
+		offset = v.(int)
+	}
+	if limit < 1 || limit > graphQLMaxLimit {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %v", graphQLMaxLimit)
+	}
+	if offset < 0 {
+		return 0, 0, errors.New("offset must be positive")
+	}
+	return
+}
+
+func limitSize(args map[string]interface{}) int {
+	limit, _, err := limitArg(args)
+	if err != nil {
+		return graphQLMaxLimit
+	}
+	return limit
+}
+
+var limitArgs = map[string]string{"limit": "Int", "offset": "Int"}
+
+// Scalar computed from each source
+func gqlScalar(typ string, get func(source interface{}) interface{}) *graphQLField {
+	return &graphQLField{Type: typ, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		for _, source := range sources {
+			values = append(values, get(source))
+		}
+		return
+	}}
+}
+
+func blockScalar(typ string, get func(block *Block) interface{}) *graphQLField {
+	return gqlScalar(typ, func(source interface{}) interface{} { return get(source.(*Block)) })
+}
+
+func txScalar(typ string, get func(tx *Tx) interface{}) *graphQLField {
+	return gqlScalar(typ, func(source interface{}) interface{} { return get(source.(*Tx)) })
+}
+
+func txInScalar(typ string, get func(txi *TxIn) interface{}) *graphQLField {
+	return gqlScalar(typ, func(source interface{}) interface{} { return get(source.(*TxIn)) })
+}
+
+func txOutScalar(typ string, get func(txo *TxOut) interface{}) *graphQLField {
+	return gqlScalar(typ, func(source interface{}) interface{} { return get(source.(*TxOut)) })
+}
+
+// Null for empty strings
+func optionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Resolve a Tx field from the hash of each source
+func txByHash(hash func(source interface{}) string) *graphQLField {
+	return &graphQLField{Type: "Tx", Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		hashes := []string{}
+		for _, source := range sources {
+			hashes = append(hashes, hash(source))
+		}
+		txs, err := l.loadTxs(hashes)
+		if err != nil {
+			return
+		}
+		for _, tx := range txs {
+			values = append(values, txValue(tx))
+		}
+		return
+	}}
+}
+
+func blockByHash(hash func(l *graphQLLoader, sources []interface{}) ([]string, error)) *graphQLField {
+	return &graphQLField{Type: "Block", Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		hashes, err := hash(l, sources)
+		if err != nil {
+			return
+		}
+		blocks, err := l.loadBlocks(hashes)
+		if err != nil {
+			return
+		}
+		for _, block := range blocks {
+			values = append(values, blockValue(block))
+		}
+		return
+	}}
+}
+
+// Untyped nil for missing objects
+func txValue(tx *Tx) interface{} {
+	if tx == nil {
+		return nil
+	}
+	return tx
+}
+
+func blockValue(block *Block) interface{} {
+	if block == nil {
+		return nil
+	}
+	return block
+}
+
+func txsValue(txs []*Tx) interface{} {
+	list := []interface{}{}
+	for _, tx := range txs {
+		list = append(list, txValue(tx))
+	}
+	return list
+}
+
+func blocksOf(sources []interface{}) (blocks []*Block) {
+	for _, source := range sources {
+		blocks = append(blocks, source.(*Block))
+	}
+	return
+}
+
+func txsOf(sources []interface{}) (txs []*Tx) {
+	for _, source := range sources {
+		txs = append(txs, source.(*Tx))
+	}
+	return
+}
+
+func addressesOf(sources []interface{}) (addresses []string) {
+	for _, source := range sources {
+		addresses = append(addresses, source.(string))
+	}
+	return
+}
+
+func blockMetaScalar(typ string, get func(block *Block, latest uint) interface{}) *graphQLField {
+	return &graphQLField{Type: typ, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		blocks := blocksOf(sources)
+		if err = l.loadMetas(blocks); err != nil {
+			return
+		}
+		latest, err := l.latestHeight()
+		if err != nil {
+			return
+		}
+		for _, block := range blocks {
+			values = append(values, get(block, latest))
+		}
+		return
+	}}
+}
+
+func addressScalar(typ string, unconfirmed bool, get func(addrdata *AddressData) interface{}) *graphQLField {
+	return &graphQLField{Type: typ, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		data, err := l.loadAddresses(addressesOf(sources), unconfirmed)
+		if err != nil {
+			return
+		}
+		for _, addrdata := range data {
+			values = append(values, get(addrdata))
+		}
+		return
+	}}
+}
+
+func mempoolScalar(typ string, get func(info *MempoolInfo) interface{}) *graphQLField {
+	return &graphQLField{Type: typ, Cost: 1, Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+		if l.mempool == nil {
+			if l.mempool, err = GetMempoolInfo(l.pool); err != nil {
+				return
+			}
+		}
+		for _ = range sources {
+			values = append(values, get(l.mempool))
+		}
+		return
+	}}
+}
+
+// Resolvers
+
+func resolveQueryBlock(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	hash, _ := args["hash"].(string)
+	if height, ok := args["height"].(int); ok {
+		if hash != "" || height < 0 {
+			return nil, errors.New("block needs either a hash or a positive height")
+		}
+		if hash, err = GetBlockHash(l.rpool, uint(height)); err == ErrNotFound {
+			return []interface{}{nil}, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	if hash == "" {
+		return nil, errors.New("block needs a hash or a height")
+	}
+	blocks, err := l.loadBlocks([]string{hash})
+	if err != nil {
+		return
+	}
+	return []interface{}{blockValue(blocks[0])}, nil
+}
+
+// Latest main chain blocks, best block first
+func resolveQueryBlocks(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	limit, offset, err := limitArg(args)
+	if err != nil {
+		return
+	}
+	latest, err := l.latestHeight()
+	if err != nil {
+		return
+	}
+	keys := []string{}
+	for height := int(latest) - offset; height >= 0 && len(keys) < limit; height-- {
+		keys = append(keys, fmt.Sprintf("block:height:%v", height))
+	}
+	c := l.rpool.Get()
+	hashes, err := mgetStrings(c, keys)
+	c.Close()
+	if err != nil {
+		return
+	}
+	blocks, err := l.loadBlocks(hashes)
+	if err != nil {
+		return
+	}
+	list := []interface{}{}
+	for _, block := range blocks {
+		list = append(list, blockValue(block))
+	}
+	return []interface{}{list}, nil
+}
+
+func resolveQueryTx(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	txs, err := l.loadTxs([]string{args["hash"].(string)})
+	if err != nil {
+		return
+	}
+	return []interface{}{txValue(txs[0])}, nil
+}
+
+func resolveQueryAddress(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	address := args["address"].(string)
+	if valid, _ := IsAddress(address); !valid {
+		return nil, fmt.Errorf("Invalid address %v", address)
+	}
+	return []interface{}{address}, nil
+}
+
+// Page of the block txs, in block order
+func resolveBlockTxs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	limit, offset, err := limitArg(args)
+	if err != nil {
+		return
+	}
+	c := l.rpool.Get()
+	defer c.Close()
+	pages := [][]string{}
+	hashes := []string{}
+	for _, block := range blocksOf(sources) {
+		// Members are tx:%v keys
+		txskeys, zerr := redis.Strings(c.Do("ZRANGE", fmt.Sprintf("block:%v:txs", block.Hash), offset, offset+limit-1))
+		if zerr != nil {
+			return nil, zerr
+		}
+		page := []string{}
+		for _, txkey := range txskeys {
+			page = append(page, strings.TrimPrefix(txkey, "tx:"))
+		}
+		pages = append(pages, page)
+		hashes = append(hashes, page...)
+	}
+	if _, err = l.loadTxs(hashes); err != nil {
+		return
+	}
+	for _, page := range pages {
+		txs, _ := l.loadTxs(page)
+		values = append(values, txsValue(txs))
+	}
+	return
+}
+
+func resolveTxBlock(l *graphQLLoader, sources []interface{}) (hashes []string, err error) {
+	for _, tx := range txsOf(sources) {
+		hashes = append(hashes, tx.BlockHash)
+	}
+	return
+}
+
+func resolveBlockParent(l *graphQLLoader, sources []interface{}) (hashes []string, err error) {
+	for _, block := range blocksOf(sources) {
+		hashes = append(hashes, block.Parent)
+	}
+	return
+}
+
+func resolveBlockNext(l *graphQLLoader, sources []interface{}) (hashes []string, err error) {
+	blocks := blocksOf(sources)
+	if err = l.loadMetas(blocks); err != nil {
+		return
+	}
+	for _, block := range blocks {
+		hashes = append(hashes, block.Next)
+	}
+	return
+}
+
+func resolveTxInputs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	txs := txsOf(sources)
+	if err = l.loadInputs(txs); err != nil {
+		return
+	}
+	for _, tx := range txs {
+		list := []interface{}{}
+		for _, txi := range tx.TxIns {
+			list = append(list, txi)
+		}
+		values = append(values, list)
+	}
+	return
+}
+
+func resolveTxOutputs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	txs := txsOf(sources)
+	if err = l.loadOutputs(txs); err != nil {
+		return
+	}
+	for _, tx := range txs {
+		list := []interface{}{}
+		for _, txo := range tx.TxOuts {
+			list = append(list, txo)
+		}
+		values = append(values, list)
+	}
+	return
+}
+
+// Output spent by the input, loaded along with the other outputs of the previous tx
+func resolveTxInPrevOut(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	hashes := []string{}
+	for _, source := range sources {
+		if prevout := source.(*TxIn).PrevOut; prevout != nil {
+			hashes = append(hashes, prevout.Hash)
+		}
+	}
+	txs, err := l.loadTxs(hashes)
+	if err != nil {
+		return
+	}
+	if err = l.loadOutputs(txs); err != nil {
+		return
+	}
+	for _, source := range sources {
+		var value interface{}
+		if prevout := source.(*TxIn).PrevOut; prevout != nil && l.txs[prevout.Hash] != nil {
+			for _, txo := range l.txs[prevout.Hash].TxOuts {
+				if txo.Index == prevout.Vout {
+					value = txo
+				}
+			}
+		}
+		values = append(values, value)
+	}
+	return
+}
+
+func resolveAddressTxs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	limit, offset, err := limitArg(args)
+	if err != nil {
+		return
+	}
+	pages := [][]string{}
+	hashes := []string{}
+	for _, address := range addressesOf(sources) {
+		page, herr := GetAddressTxHashes(l.rpool, address, offset, offset+limit-1)
+		if herr != nil {
+			return nil, herr
+		}
+		pages = append(pages, page)
+		hashes = append(hashes, page...)
+	}
+	if _, err = l.loadTxs(hashes); err != nil {
+		return
+	}
+	for _, page := range pages {
+		txs, _ := l.loadTxs(page)
+		values = append(values, txsValue(txs))
+	}
+	return
+}
+
+func resolveAddressUnconfirmedTxs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	for _, address := range addressesOf(sources) {
+		utxs, uerr := GetUnconfirmedTxsByAddress(l.pool, address)
+		if uerr != nil {
+			return nil, uerr
+		}
+		for i, utx := range utxs {
+			if cached := l.txs[utx.Hash]; cached != nil {
+				utxs[i] = cached
+			} else {
+				l.txs[utx.Hash] = utx
+			}
+		}
+		values = append(values, txsValue(utxs))
+	}
+	return
+}
+
+func resolveMempoolTxs(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+	limit, offset, err := limitArg(args)
+	if err != nil {
+		return
+	}
+	sortby, _ := args["sort"].(string)
+	if sortby != "" && sortby != "time" && sortby != "feerate" {
+		return nil, errors.New("sort must be time or feerate")
+	}
+	utxs, err := GetUnconfirmedTxs(l.pool, sortby, offset, offset+limit-1)
+	if err != nil {
+		return
+	}
+	for i, utx := range utxs {
+		if cached := l.txs[utx.Hash]; cached != nil {
+			utxs[i] = cached
+		} else {
+			l.txs[utx.Hash] = utx
+		}
+	}
+	for _ = range sources {
+		values = append(values, txsValue(utxs))
+	}
+	return
+}
+
+func confirmations(l *graphQLLoader, height uint) interface{} {
+	latest, err := l.latestHeight()
+	if err != nil {
+		return nil
+	}
+	return Confirmations(height, latest)
+}
+
+var graphQLIndexSchema = graphQLSchema{
+	"Query": {
+		"block":   {Type: "Block", Args: map[string]string{"hash": "String", "height": "Int"}, Cost: 1, Resolve: resolveQueryBlock},
+		"blocks":  {Type: "Block", List: true, Args: limitArgs, Cost: 1, Size: limitSize, Resolve: resolveQueryBlocks},
+		"tx":      {Type: "Tx", Args: map[string]string{"hash": "String!"}, Cost: 1, Resolve: resolveQueryTx},
+		"address": {Type: "Address", Args: map[string]string{"address": "String!"}, Resolve: resolveQueryAddress},
+		"mempool": gqlScalar("Mempool", func(source interface{}) interface{} { return graphQLMempool{} }),
+	},
+	"Block": {
+		"hash":       blockScalar("String", func(block *Block) interface{} { return block.Hash }),
+		"height":     blockScalar("Int", func(block *Block) interface{} { return block.Height }),
+		"version":    blockScalar("Int", func(block *Block) interface{} { return block.Version }),
+		"merkleRoot": blockScalar("String", func(block *Block) interface{} { return block.MerkleRoot }),
+		"time":       blockScalar("Int", func(block *Block) interface{} { return block.BlockTime }),
+		"bits":       blockScalar("Int", func(block *Block) interface{} { return block.Bits }),
+		"nonce":      blockScalar("Int", func(block *Block) interface{} { return block.Nonce }),
+		"size":       blockScalar("Int", func(block *Block) interface{} { return block.Size }),
+		"txCount":    blockScalar("Int", func(block *Block) interface{} { return block.TxCnt }),
+		"totalOut":   blockScalar("Int", func(block *Block) interface{} { return block.TotalBTC }),
+		"reward":     blockScalar("Int", func(block *Block) interface{} { return GetBlockReward(block.Height) }),
+		"difficulty": blockScalar("Float", func(block *Block) interface{} { return BitsToDifficulty(block.Bits) }),
+		"chainwork":  blockMetaScalar("String", func(block *Block, latest uint) interface{} { return optionalString(block.Chainwork) }),
+		"mainChain":  blockMetaScalar("Boolean", func(block *Block, latest uint) interface{} { return block.Main }),
+		"parent":     blockByHash(resolveBlockParent),
+		"next":       blockByHash(resolveBlockNext),
+		"txs":        {Type: "Tx", List: true, Args: limitArgs, Cost: 1, Size: limitSize, Resolve: resolveBlockTxs},
+		"confirmations": blockMetaScalar("Int", func(block *Block, latest uint) interface{} {
+			if !block.Main {
+				return 0
+			}
+			return Confirmations(block.Height, latest)
+		}),
+	},
+	"Tx": {
+		"hash":          txScalar("String", func(tx *Tx) interface{} { return tx.Hash }),
+		"size":          txScalar("Int", func(tx *Tx) interface{} { return tx.Size }),
+		"version":       txScalar("Int", func(tx *Tx) interface{} { return tx.Version }),
+		"lockTime":      txScalar("Int", func(tx *Tx) interface{} { return tx.LockTime }),
+		"inputCount":    txScalar("Int", func(tx *Tx) interface{} { return tx.TxInCnt }),
+		"outputCount":   txScalar("Int", func(tx *Tx) interface{} { return tx.TxOutCnt }),
+		"totalIn":       txScalar("Int", func(tx *Tx) interface{} { return tx.TotalIn }),
+		"totalOut":      txScalar("Int", func(tx *Tx) interface{} { return tx.TotalOut }),
+		"fee":           txScalar("Int", func(tx *Tx) interface{} { return tx.Fee() }),
+		"feeRate":       txScalar("Float", func(tx *Tx) interface{} { return tx.FeeRate() }),
+		"firstSeenTime": txScalar("Int", func(tx *Tx) interface{} { return tx.FirstSeenTime }),
+		"rbf":           txScalar("Boolean", func(tx *Tx) interface{} { return tx.RBF }),
+		"doubleSpent":   txScalar("Boolean", func(tx *Tx) interface{} { return tx.DoubleSpent }),
+		"confirmed":     txScalar("Boolean", func(tx *Tx) interface{} { return tx.BlockHash != "" }),
+		"blockHash":     txScalar("String", func(tx *Tx) interface{} { return optionalString(tx.BlockHash) }),
+		"blockHeight": txScalar("Int", func(tx *Tx) interface{} {
+			if tx.BlockHash == "" {
+				return nil
+			}
+			return tx.BlockHeight
+		}),
+		"time": txScalar("Int", func(tx *Tx) interface{} {
+			if tx.BlockHash == "" {
+				return tx.FirstSeenTime
+			}
+			return tx.BlockTime
+		}),
+		"confirmations": {Type: "Int", Resolve: func(l *graphQLLoader, sources []interface{}, args map[string]interface{}) (values []interface{}, err error) {
+			for _, tx := range txsOf(sources) {
+				if tx.BlockHash == "" {
+					values = append(values, 0)
+				} else {
+					values = append(values, confirmations(l, tx.BlockHeight))
+				}
+			}
+			return
+		}},
+		"block":   blockByHash(resolveTxBlock),
+		"inputs":  {Type: "TxIn", List: true, Cost: 1, Resolve: resolveTxInputs},
+		"outputs": {Type: "TxOut", List: true, Cost: 1, Resolve: resolveTxOutputs},
+	},
+	"TxIn": {
+		"index":    txInScalar("Int", func(txi *TxIn) interface{} { return txi.Index }),
+		"sequence": txInScalar("Int", func(txi *TxIn) interface{} { return txi.Sequence }),
+		"prevTxHash": txInScalar("String", func(txi *TxIn) interface{} {
+			if txi.PrevOut == nil {
+				return nil
+			}
+			return txi.PrevOut.Hash
+		}),
+		"prevOutIndex": txInScalar("Int", func(txi *TxIn) interface{} {
+			if txi.PrevOut == nil {
+				return nil
+			}
+			return txi.PrevOut.Vout
+		}),
+		"value": txInScalar("Int", func(txi *TxIn) interface{} {
+			if txi.PrevOut == nil {
+				return nil
+			}
+			return txi.PrevOut.Value
+		}),
+		"address": txInScalar("Address", func(txi *TxIn) interface{} {
+			if txi.PrevOut == nil {
+				return nil
+			}
+			return optionalString(txi.PrevOut.Address)
+		}),
+		"tx": txByHash(func(source interface{}) string { return source.(*TxIn).TxHash }),
+		"prevTx": txByHash(func(source interface{}) string {
+			if prevout := source.(*TxIn).PrevOut; prevout != nil {
+				return prevout.Hash
+			}
+			return ""
+		}),
+		"prevOut": {Type: "TxOut", Cost: 1, Resolve: resolveTxInPrevOut},
+	},
+	"TxOut": {
+		"index":   txOutScalar("Int", func(txo *TxOut) interface{} { return txo.Index }),
+		"value":   txOutScalar("Int", func(txo *TxOut) interface{} { return txo.Value }),
+		"address": txOutScalar("Address", func(txo *TxOut) interface{} { return optionalString(txo.Addr) }),
+		"spent":   txOutScalar("Boolean", func(txo *TxOut) interface{} { return txo.Spent != nil && txo.Spent.Spent }),
+		"spentUnconfirmed": txOutScalar("Boolean", func(txo *TxOut) interface{} {
+			return txo.Spent != nil && txo.Spent.Spent && txo.Spent.Unconfirmed
+		}),
+		"spentTxHash": txOutScalar("String", func(txo *TxOut) interface{} {
+			if txo.Spent == nil || !txo.Spent.Spent {
+				return nil
+			}
+			return txo.Spent.InputHash
+		}),
+		"spentInputIndex": txOutScalar("Int", func(txo *TxOut) interface{} {
+			if txo.Spent == nil || !txo.Spent.Spent {
+				return nil
+			}
+			return txo.Spent.InputIndex
+		}),
+		"spentHeight": txOutScalar("Int", func(txo *TxOut) interface{} {
+			if txo.Spent == nil || !txo.Spent.Spent || txo.Spent.Unconfirmed {
+				return nil
+			}
+			return txo.Spent.BlockHeight
+		}),
+		"tx": txByHash(func(source interface{}) string { return source.(*TxOut).TxHash }),
+		"spentTx": txByHash(func(source interface{}) string {
+			if spent := source.(*TxOut).Spent; spent != nil && spent.Spent {
+				return spent.InputHash
+			}
+			return ""
+		}),
+	},
+	"Address": {
+		"address":            gqlScalar("String", func(source interface{}) interface{} { return source }),
+		"balance":            addressScalar("Int", false, func(addrdata *AddressData) interface{} { return addrdata.FinalBalance }),
+		"totalReceived":      addressScalar("Int", false, func(addrdata *AddressData) interface{} { return addrdata.TotalReceived }),
+		"totalSent":          addressScalar("Int", false, func(addrdata *AddressData) interface{} { return addrdata.TotalSent }),
+		"txCount":            addressScalar("Int", false, func(addrdata *AddressData) interface{} { return addrdata.TxCnt }),
+		"unconfirmedTxCount": addressScalar("Int", true, func(addrdata *AddressData) interface{} { return addrdata.UnconfirmedTxCnt }),
+		"unconfirmedBalance": addressScalar("Int", true, func(addrdata *AddressData) interface{} {
+			return int64(addrdata.UnconfirmedReceived) - int64(addrdata.UnconfirmedSent)
+		}),
+		"txs":            {Type: "Tx", List: true, Args: limitArgs, Cost: 1, Size: limitSize, Resolve: resolveAddressTxs},
+		"unconfirmedTxs": {Type: "Tx", List: true, Cost: 1, Resolve: resolveAddressUnconfirmedTxs},
+	},
+	"Mempool": {
+		"count":     mempoolScalar("Int", func(info *MempoolInfo) interface{} { return info.TxCnt }),
+		"size":      mempoolScalar("Int", func(info *MempoolInfo) interface{} { return info.Size }),
+		"totalFees": mempoolScalar("Int", func(info *MempoolInfo) interface{} { return info.TotalFees }),
+		"txs": {Type: "Tx", List: true, Args: map[string]string{"limit": "Int", "offset": "Int", "sort": "String"}, Cost: 1, Size: limitSize,
+			Resolve: resolveMempoolTxs},
+	},
+}
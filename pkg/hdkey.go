@@ -0,0 +1,103 @@
+package btcplex
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// BIP32 public derivation only, private extended keys are never accepted
+
+// Child indexes from HardenedKeyStart require the private key
+const HardenedKeyStart = 0x80000000
+
+var ErrInvalidXpub = errors.New("Invalid extended public key")
+var ErrHardenedDerivation = errors.New("Cannot derive a hardened key from a public key")
+
+// The derived key is invalid (probability lower than 1 in 2^127), the next index must be used
+var ErrInvalidChild = errors.New("Invalid child key")
+
+type ExtendedKey struct {
+	Version     [4]byte
+	Depth       byte
+	ParentFP    [4]byte
+	ChildNumber uint32
+	ChainCode   []byte
+	PubKey      []byte
+}
+
+// Parse a base58 encoded extended public key using the active chain version bytes
+func ParseExtendedKey(s string) (key *ExtendedKey, err error) {
+	data, err := Base58CheckDecode(s)
+	if err != nil || len(data) != 78 {
+		return nil, ErrInvalidXpub
+	}
+	key = &ExtendedKey{Depth: data[4], ChildNumber: binary.BigEndian.Uint32(data[9:13])}
+	copy(key.Version[:], data[:4])
+	copy(key.ParentFP[:], data[5:9])
+	key.ChainCode = append([]byte{}, data[13:45]...)
+	key.PubKey = append([]byte{}, data[45:78]...)
+	if key.Version != ActiveChainParams.HDPublicKeyID {
+		return nil, ErrInvalidXpub
+	}
+	if _, perr := parseCompressedPubKey(key.PubKey); perr != nil {
+		return nil, ErrInvalidXpub
+	}
+	return
+}
+
+func (key *ExtendedKey) String() string {
+	data := make([]byte, 0, 78)
+	data = append(data, key.Version[:]...)
+	data = append(data, key.Depth)
+	data = append(data, key.ParentFP[:]...)
+	var childnum [4]byte
+	binary.BigEndian.PutUint32(childnum[:], key.ChildNumber)
+	data = append(data, childnum[:]...)
+	data = append(data, key.ChainCode...)
+	data = append(data, key.PubKey...)
+	return Base58CheckEncode(data)
+}
+
+// Derive the non-hardened child key at the given index (CKDpub)
+func (key *ExtendedKey) Child(index uint32) (child *ExtendedKey, err error) {
+	if index >= HardenedKeyStart {
+		return nil, ErrHardenedDerivation
+	}
+	var data bytes.Buffer
+	data.Write(key.PubKey)
+	binary.Write(&data, binary.BigEndian, index)
+	mac := hmac.New(sha512.New, key.ChainCode)
+	mac.Write(data.Bytes())
+	i := mac.Sum(nil)
+
+	il := new(big.Int).SetBytes(i[:32])
+	if il.Cmp(secpN) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	parent, err := parseCompressedPubKey(key.PubKey)
+	if err != nil {
+		return
+	}
+	point := ecAdd(ecScalarBaseMult(il), parent)
+	if point == nil {
+		return nil, ErrInvalidChild
+	}
+	child = &ExtendedKey{
+		Version:     key.Version,
+		Depth:       key.Depth + 1,
+		ChildNumber: index,
+		ChainCode:   i[32:],
+		PubKey:      point.compressed(),
+	}
+	copy(child.ParentFP[:], Hash160(key.PubKey)[:4])
+	return
+}
+
+// Pubkey hash address of the key for the active chain
+func (key *ExtendedKey) Address() string {
+	return PubKeyHashAddress(Hash160(key.PubKey))
+}
@@ -0,0 +1,95 @@
+package btcplex
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestRipemd160(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"message digest", "5d0689ef49d2fae572b881b123a85ffa21595f36"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890", "9b752e45573d4b39f4dbd3323cab82bf63326bfb"},
+	}
+	for _, test := range tests {
+		if out := hex.EncodeToString(Ripemd160([]byte(test.in))); out != test.out {
+			t.Errorf("Ripemd160(%q) = %v, want %v", test.in, out, test.out)
+		}
+	}
+}
+
+func TestBase58Check(t *testing.T) {
+	for _, addr := range []string{"M7uAERuQW2AotfyLDyewFGcLUDtAYu9v5V", "1111111111111111111114oLvT2"} {
+		data, err := Base58CheckDecode(addr)
+		if err != nil {
+			t.Fatalf("Base58CheckDecode(%v): %v", addr, err)
+		}
+		if out := Base58CheckEncode(data); out != addr {
+			t.Errorf("Base58CheckEncode(%x) = %v, want %v", data, out, addr)
+		}
+	}
+	if _, err := Base58CheckDecode("M7uAERuQW2AotfyLDyewFGcLUDtAYu9v5W"); err != ErrInvalidChecksum {
+		t.Errorf("got %v for a bad checksum, want ErrInvalidChecksum", err)
+	}
+	if _, err := Base58CheckDecode("M7uAERuQW2AotfyLDyewFGcLUDtAYu9v50"); err != ErrInvalidBase58 {
+		t.Errorf("got %v for an invalid character, want ErrInvalidBase58", err)
+	}
+}
+
+func TestExtendedKeyChild(t *testing.T) {
+	tests := []struct {
+		xpub  string
+		path  []uint32
+		child string
+		addr  string
+	}{
+		// BIP32 test vector 1, m/0H/1/2H/2 -> m/0H/1/2H/2/1000000000
+		{
+			"xpub6FHa3pjLCk84BayeJxFW2SP4XRrFd1JYnxeLeU8EqN3vDfZmbqBqaGJAyiLjTAwm6ZLRQUMv1ZACTj37sR62cfN7fe5JnJ7dh8zL4fiyLHV",
+			[]uint32{1000000000},
+			"xpub6H1LXWLaKsWFhvm6RVpEL9P4KfRZSW7abD2ttkWP3SSQvnyA8FSVqNTEcYFgJS2UaFcxupHiYkro49S8yGasTvXEYBVPamhGW6cFJodrTHy",
+			"MTTt5HiDXJSDxWpcESzVeEo9wK3duC8kgR",
+		},
+		// BIP32 test vector 1 master key, m/0/1
+		{
+			"xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+			[]uint32{0, 1},
+			"xpub6AvUGrnEpfvJBbfx7sQ89Q8hEMPM65UteqEX4yUbUiES2jHfjexmfJoxCGSwFMZiPBaKQT1RiKWrKfuDV4vpgVs4Xn8PpPTR2i79rwHd4Zr",
+			"MAJD8a1npv93Jrnru1fHb4uvt67NGS5h8R",
+		},
+	}
+	for _, test := range tests {
+		key, err := ParseExtendedKey(test.xpub)
+		if err != nil {
+			t.Fatalf("ParseExtendedKey(%v): %v", test.xpub, err)
+		}
+		if key.String() != test.xpub {
+			t.Errorf("String() = %v, want %v", key.String(), test.xpub)
+		}
+		for _, index := range test.path {
+			if key, err = key.Child(index); err != nil {
+				t.Fatalf("Child(%v): %v", index, err)
+			}
+		}
+		if key.String() != test.child {
+			t.Errorf("child of %v = %v, want %v", test.xpub, key.String(), test.child)
+		}
+		if key.Address() != test.addr {
+			t.Errorf("Address() = %v, want %v", key.Address(), test.addr)
+		}
+	}
+}
+
+func TestExtendedKeyErrors(t *testing.T) {
+	// BIP32 test vector 1 master private key
+	if _, err := ParseExtendedKey("xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"); err != ErrInvalidXpub {
+		t.Errorf("got %v for a private key, want ErrInvalidXpub", err)
+	}
+	key, _ := ParseExtendedKey("xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8")
+	if _, err := key.Child(HardenedKeyStart); err != ErrHardenedDerivation {
+		t.Errorf("got %v for a hardened index, want ErrHardenedDerivation", err)
+	}
+}
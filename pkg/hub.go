@@ -0,0 +1,164 @@
+package btcplex
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// In-process fan out of the Redis PubSub channels over a single Redis connection, subscribers
+// register for btcplex:newblock, btcplex:utxs, addr:%v:txs or addr:%v:doublespends (subscribed with patterns)
+
+var ErrTooManySubscriptions = errors.New("Too many subscriptions")
+
+// Messages waiting to be consumed before a subscriber is dropped
+const HubBufferSize = 256
+
+var hubChannels = []string{"btcplex:newblock", "btcplex:utxs"}
+
+var hubPatterns = []string{"addr:*:txs", "addr:*:doublespends"}
+
+type HubMessage struct {
+	Channel string
+	Data    []byte
+}
+
+type Hub struct {
+	pool     *redis.Pool
+	mutex    sync.Mutex
+	channels map[string]map[*HubSubscriber]bool
+}
+
+// C is closed when the subscriber is closed, or dropped because it's lagging behind
+type HubSubscriber struct {
+	C        chan *HubMessage
+	hub      *Hub
+	channels map[string]bool
+	limit    int
+	closed   bool
+	lagging  bool
+}
+
+func NewHub(pool *redis.Pool) *Hub {
+	return &Hub{pool: pool, channels: map[string]map[*HubSubscriber]bool{}}
+}
+
+// New subscriber allowed to subscribe to limit channels (0 for no limit)
+func (hub *Hub) NewSubscriber(limit int) *HubSubscriber {
+	return &HubSubscriber{C: make(chan *HubMessage, HubBufferSize), hub: hub, channels: map[string]bool{}, limit: limit}
+}
+
+func (sub *HubSubscriber) Subscribe(channel string) error {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+	if sub.closed || sub.channels[channel] {
+		return nil
+	}
+	if sub.limit > 0 && len(sub.channels) >= sub.limit {
+		return ErrTooManySubscriptions
+	}
+	sub.channels[channel] = true
+	if sub.hub.channels[channel] == nil {
+		sub.hub.channels[channel] = map[*HubSubscriber]bool{}
+	}
+	sub.hub.channels[channel][sub] = true
+	return nil
+}
+
+// Return false if the subscriber wasn't subscribed to the channel
+func (sub *HubSubscriber) Unsubscribe(channel string) bool {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+	return sub.unsubscribe(channel)
+}
+
+func (sub *HubSubscriber) unsubscribe(channel string) bool {
+	if !sub.channels[channel] {
+		return false
+	}
+	delete(sub.channels, channel)
+	delete(sub.hub.channels[channel], sub)
+	if len(sub.hub.channels[channel]) == 0 {
+		delete(sub.hub.channels, channel)
+	}
+	return true
+}
+
+func (sub *HubSubscriber) Subscriptions() int {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+	return len(sub.channels)
+}
+
+// True if the subscriber was dropped for not consuming its messages
+func (sub *HubSubscriber) Lagging() bool {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+	return sub.lagging
+}
+
+func (sub *HubSubscriber) Close() {
+	sub.hub.mutex.Lock()
+	defer sub.hub.mutex.Unlock()
+	sub.close()
+}
+
+func (sub *HubSubscriber) close() {
+	if sub.closed {
+		return
+	}
+	for channel := range sub.channels {
+		sub.unsubscribe(channel)
+	}
+	sub.closed = true
+	close(sub.C)
+}
+
+// Send the message to the channel subscribers, without ever blocking
+func (hub *Hub) Publish(channel string, data []byte) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	msg := &HubMessage{Channel: channel, Data: data}
+	for sub := range hub.channels[channel] {
+		select {
+		case sub.C <- msg:
+		default:
+			sub.lagging = true
+			sub.close()
+		}
+	}
+}
+
+// Number of channels with at least one subscriber
+func (hub *Hub) Channels() int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return len(hub.channels)
+}
+
+// Relay the Redis messages to the subscribers, reconnecting on errors
+func (hub *Hub) Run() {
+	for {
+		conn := hub.pool.Get()
+		psc := redis.PubSubConn{Conn: conn}
+		psc.Subscribe(redis.Args{}.AddFlat(hubChannels)...)
+		psc.PSubscribe(redis.Args{}.AddFlat(hubPatterns)...)
+	receive:
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				hub.Publish(v.Channel, v.Data)
+			case redis.PMessage:
+				hub.Publish(v.Channel, v.Data)
+			case error:
+				log.Printf("Hub subscription error: %v", v)
+				break receive
+			}
+		}
+		conn.Close()
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,68 @@
+package btcplex
+
+import (
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub(nil)
+	sub1, sub2 := hub.NewSubscriber(2), hub.NewSubscriber(0)
+	sub1.Subscribe("addr:a:txs")
+	sub1.Subscribe("btcplex:newblock")
+	if err := sub1.Subscribe("addr:b:txs"); err != ErrTooManySubscriptions {
+		t.Errorf("Subscribe over the limit = %v, want ErrTooManySubscriptions", err)
+	}
+	// Already subscribed channels don't count
+	if err := sub1.Subscribe("addr:a:txs"); err != nil {
+		t.Errorf("Subscribe(subscribed channel) = %v", err)
+	}
+	sub2.Subscribe("addr:a:txs")
+
+	hub.Publish("addr:a:txs", []byte("tx1"))
+	hub.Publish("addr:c:txs", []byte("tx2"))
+	for _, sub := range []*HubSubscriber{sub1, sub2} {
+		if msg := <-sub.C; msg.Channel != "addr:a:txs" || string(msg.Data) != "tx1" {
+			t.Errorf("received %v %s, want addr:a:txs tx1", msg.Channel, msg.Data)
+		}
+		if len(sub.C) != 0 {
+			t.Errorf("received a message for an unsubscribed channel")
+		}
+	}
+
+	if !sub1.Unsubscribe("addr:a:txs") || sub1.Unsubscribe("addr:a:txs") {
+		t.Errorf("Unsubscribe should only succeed once")
+	}
+	hub.Publish("addr:a:txs", []byte("tx3"))
+	if len(sub1.C) != 0 || len(sub2.C) != 1 {
+		t.Errorf("pending messages = %v, %v, want 0, 1", len(sub1.C), len(sub2.C))
+	}
+
+	sub1.Close()
+	if _, open := <-sub1.C; open || sub1.Lagging() {
+		t.Errorf("closed subscriber channel should be closed without lagging")
+	}
+	if hub.Channels() != 1 {
+		t.Errorf("hub channels = %v, want 1", hub.Channels())
+	}
+}
+
+func TestHubLagging(t *testing.T) {
+	hub := NewHub(nil)
+	sub := hub.NewSubscriber(0)
+	sub.Subscribe("btcplex:utxs")
+	for i := 0; i <= HubBufferSize; i++ {
+		hub.Publish("btcplex:utxs", []byte("tx"))
+	}
+	if !sub.Lagging() || hub.Channels() != 0 {
+		t.Errorf("subscriber not consuming its messages should be dropped")
+	}
+	received := 0
+	for _ = range sub.C {
+		received++
+	}
+	if received != HubBufferSize {
+		t.Errorf("received %v messages, want %v", received, HubBufferSize)
+	}
+	// Closing a dropped subscriber is a no-op
+	sub.Close()
+}
@@ -0,0 +1,287 @@
+package btcplex
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// Insight API (bitpay/insight-api) JSON format, amounts are in coins with the satoshis
+// alongside where Insight does so, input scripts aren't stored so scriptSig is never set
+// and output scripts are rebuilt from the address (see AddressScript)
+
+// Number of transactions per page of the Insight txs endpoint
+const InsightTxsPerPage = 10
+
+type InsightVin struct {
+	Txid            string  `json:"txid,omitempty"`
+	Vout            uint32  `json:"vout"`
+	Sequence        uint32  `json:"sequence"`
+	N               int     `json:"n"`
+	Addr            string  `json:"addr,omitempty"`
+	ValueSat        uint64  `json:"valueSat"`
+	Value           float64 `json:"value"`
+	DoubleSpentTxID *string `json:"doubleSpentTxID"`
+}
+
+type InsightScriptPubKey struct {
+	Hex       string   `json:"hex"`
+	Asm       string   `json:"asm"`
+	Addresses []string `json:"addresses,omitempty"`
+	Type      string   `json:"type,omitempty"`
+}
+
+type InsightVout struct {
+	Value        string               `json:"value"`
+	N            uint32               `json:"n"`
+	ScriptPubKey *InsightScriptPubKey `json:"scriptPubKey"`
+	SpentTxId    *string              `json:"spentTxId"`
+	SpentIndex   *uint32              `json:"spentIndex"`
+	SpentHeight  *uint32              `json:"spentHeight"`
+}
+
+type InsightTx struct {
+	Txid          string         `json:"txid"`
+	Version       uint32         `json:"version"`
+	Locktime      uint32         `json:"locktime"`
+	Vin           []*InsightVin  `json:"vin"`
+	Vout          []*InsightVout `json:"vout"`
+	Blockhash     string         `json:"blockhash,omitempty"`
+	Blockheight   int            `json:"blockheight"`
+	Confirmations uint           `json:"confirmations"`
+	Time          uint32         `json:"time"`
+	Blocktime     uint32         `json:"blocktime,omitempty"`
+	IsCoinBase    bool           `json:"isCoinBase,omitempty"`
+	ValueOut      float64        `json:"valueOut"`
+	Size          uint32         `json:"size"`
+	ValueIn       float64        `json:"valueIn,omitempty"`
+	Fees          float64        `json:"fees,omitempty"`
+}
+
+type InsightBlock struct {
+	Hash              string            `json:"hash"`
+	Size              uint32            `json:"size"`
+	Height            uint              `json:"height"`
+	Version           uint32            `json:"version"`
+	Merkleroot        string            `json:"merkleroot"`
+	Tx                []string          `json:"tx"`
+	Time              uint32            `json:"time"`
+	Nonce             uint32            `json:"nonce"`
+	Bits              string            `json:"bits"`
+	Difficulty        float64           `json:"difficulty"`
+	Chainwork         string            `json:"chainwork"`
+	Confirmations     uint              `json:"confirmations"`
+	Previousblockhash string            `json:"previousblockhash,omitempty"`
+	Nextblockhash     string            `json:"nextblockhash,omitempty"`
+	Reward            float64           `json:"reward"`
+	IsMainChain       bool              `json:"isMainChain"`
+	PoolInfo          map[string]string `json:"poolInfo"`
+}
+
+// Entry of the Insight blocks list
+type InsightBlockSummary struct {
+	Height   uint              `json:"height"`
+	Size     uint32            `json:"size"`
+	Hash     string            `json:"hash"`
+	Time     uint32            `json:"time"`
+	Txlength uint32            `json:"txlength"`
+	PoolInfo map[string]string `json:"poolInfo"`
+}
+
+// Insight "Apperances" typos are kept, clients rely on them
+type InsightAddress struct {
+	AddrStr                 string   `json:"addrStr"`
+	Balance                 float64  `json:"balance"`
+	BalanceSat              uint64   `json:"balanceSat"`
+	TotalReceived           float64  `json:"totalReceived"`
+	TotalReceivedSat        uint64   `json:"totalReceivedSat"`
+	TotalSent               float64  `json:"totalSent"`
+	TotalSentSat            uint64   `json:"totalSentSat"`
+	UnconfirmedBalance      float64  `json:"unconfirmedBalance"`
+	UnconfirmedBalanceSat   int64    `json:"unconfirmedBalanceSat"`
+	UnconfirmedTxApperances uint64   `json:"unconfirmedTxApperances"`
+	TxApperances            uint64   `json:"txApperances"`
+	Transactions            []string `json:"transactions,omitempty"`
+}
+
+type InsightUtxo struct {
+	Address       string  `json:"address"`
+	Txid          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Satoshis      uint64  `json:"satoshis"`
+	Height        uint    `json:"height,omitempty"`
+	Confirmations uint    `json:"confirmations"`
+	Ts            uint32  `json:"ts"`
+}
+
+// Socket.io tx event, Vout maps each output address to its value in satoshis
+type InsightTxEvent struct {
+	Txid     string              `json:"txid"`
+	ValueOut float64             `json:"valueOut"`
+	Vout     []map[string]uint64 `json:"vout"`
+	IsRBF    bool                `json:"isRBF"`
+}
+
+// Number of confirmations of a block at height, latest being the best block height
+func Confirmations(height, latest uint) uint {
+	if height > latest {
+		return 0
+	}
+	return latest - height + 1
+}
+
+func insightScriptPubKey(address string) (spk *InsightScriptPubKey) {
+	spk = &InsightScriptPubKey{}
+	class, script, err := AddressScript(address)
+	if err != nil {
+		return
+	}
+	spk.Hex = hex.EncodeToString(script)
+	spk.Asm = DisassembleScript(script)
+	spk.Addresses = []string{address}
+	spk.Type = class
+	return
+}
+
+func NewInsightTx(tx *Tx, latest uint) (itx *InsightTx) {
+	itx = &InsightTx{
+		Txid:        tx.Hash,
+		Version:     tx.Version,
+		Locktime:    tx.LockTime,
+		Vin:         []*InsightVin{},
+		Vout:        []*InsightVout{},
+		Blockheight: -1,
+		Time:        tx.FirstSeenTime,
+		ValueOut:    UintToFloat(tx.TotalOut),
+		Size:        tx.Size,
+	}
+	if tx.BlockHash != "" {
+		itx.Blockhash = tx.BlockHash
+		itx.Blockheight = int(tx.BlockHeight)
+		itx.Confirmations = Confirmations(tx.BlockHeight, latest)
+		itx.Time = tx.BlockTime
+		itx.Blocktime = tx.BlockTime
+	}
+	// Generation inputs aren't indexed
+	if len(tx.TxIns) == 0 {
+		itx.IsCoinBase = true
+	} else {
+		itx.ValueIn = UintToFloat(tx.TotalIn)
+		itx.Fees = UintToFloat(tx.Fee())
+	}
+	for txiindex, txi := range tx.TxIns {
+		vin := &InsightVin{Sequence: txi.Sequence, N: txiindex}
+		if txi.PrevOut != nil {
+			vin.Txid = txi.PrevOut.Hash
+			vin.Vout = txi.PrevOut.Vout
+			vin.Addr = txi.PrevOut.Address
+			vin.ValueSat = txi.PrevOut.Value
+			vin.Value = UintToFloat(txi.PrevOut.Value)
+		}
+		itx.Vin = append(itx.Vin, vin)
+	}
+	for _, txo := range tx.TxOuts {
+		vout := &InsightVout{Value: fmt.Sprintf("%.8f", UintToFloat(txo.Value)), N: txo.Index, ScriptPubKey: insightScriptPubKey(txo.Addr)}
+		if txo.Spent != nil && txo.Spent.Spent {
+			spenttxid, spentindex := txo.Spent.InputHash, txo.Spent.InputIndex
+			vout.SpentTxId = &spenttxid
+			vout.SpentIndex = &spentindex
+			if !txo.Spent.Unconfirmed {
+				spentheight := txo.Spent.BlockHeight
+				vout.SpentHeight = &spentheight
+			}
+		}
+		itx.Vout = append(itx.Vout, vout)
+	}
+	return
+}
+
+func NewInsightBlock(block *Block, latest uint) (iblock *InsightBlock) {
+	iblock = &InsightBlock{
+		Hash:              block.Hash,
+		Size:              block.Size,
+		Height:            block.Height,
+		Version:           block.Version,
+		Merkleroot:        block.MerkleRoot,
+		Tx:                []string{},
+		Time:              block.BlockTime,
+		Nonce:             block.Nonce,
+		Bits:              fmt.Sprintf("%08x", block.Bits),
+		Difficulty:        BitsToDifficulty(block.Bits),
+		Chainwork:         block.Chainwork,
+		Previousblockhash: block.Parent,
+		Nextblockhash:     block.Next,
+		Reward:            UintToFloat(GetBlockReward(block.Height)),
+		IsMainChain:       block.Main,
+		PoolInfo:          map[string]string{},
+	}
+	if block.Main {
+		iblock.Confirmations = Confirmations(block.Height, latest)
+	}
+	for _, tx := range block.Txs {
+		iblock.Tx = append(iblock.Tx, tx.Hash)
+	}
+	return
+}
+
+func NewInsightBlockSummary(block *Block) *InsightBlockSummary {
+	return &InsightBlockSummary{Height: block.Height, Size: block.Size, Hash: block.Hash, Time: block.BlockTime,
+		Txlength: block.TxCnt, PoolInfo: map[string]string{}}
+}
+
+// Build the Insight address summary, the transactions list is left out if txids is nil
+func NewInsightAddress(addrData *AddressData, txids []string) (iaddr *InsightAddress) {
+	unconfirmed := int64(addrData.UnconfirmedReceived) - int64(addrData.UnconfirmedSent)
+	iaddr = &InsightAddress{
+		AddrStr:                 addrData.Address,
+		Balance:                 UintToFloat(addrData.FinalBalance),
+		BalanceSat:              addrData.FinalBalance,
+		TotalReceived:           UintToFloat(addrData.TotalReceived),
+		TotalReceivedSat:        addrData.TotalReceived,
+		TotalSent:               UintToFloat(addrData.TotalSent),
+		TotalSentSat:            addrData.TotalSent,
+		UnconfirmedBalance:      float64(unconfirmed) / float64(COIN),
+		UnconfirmedBalanceSat:   unconfirmed,
+		UnconfirmedTxApperances: addrData.UnconfirmedTxCnt,
+		TxApperances:            addrData.TxCnt,
+		Transactions:            txids,
+	}
+	return
+}
+
+// Outputs without address are left out, the tx signals replaceability if an input sequence is below 0xfffffffe
+func NewInsightTxEvent(tx *Tx) (event *InsightTxEvent) {
+	event = &InsightTxEvent{Txid: tx.Hash, ValueOut: UintToFloat(tx.TotalOut), Vout: []map[string]uint64{}}
+	for _, txo := range tx.TxOuts {
+		if txo.Addr != "" {
+			event.Vout = append(event.Vout, map[string]uint64{txo.Addr: txo.Value})
+		}
+	}
+	for _, txi := range tx.TxIns {
+		if txi.Sequence < 0xfffffffe {
+			event.IsRBF = true
+		}
+	}
+	return
+}
+
+func NewInsightUtxo(unspent *UnspentOutput, latest uint) (iutxo *InsightUtxo) {
+	iutxo = &InsightUtxo{
+		Address:  unspent.Address,
+		Txid:     unspent.TxHash,
+		Vout:     unspent.Index,
+		Amount:   UintToFloat(unspent.Value),
+		Satoshis: unspent.Value,
+		Ts:       unspent.FirstSeenTime,
+	}
+	if _, script, err := AddressScript(unspent.Address); err == nil {
+		iutxo.ScriptPubKey = hex.EncodeToString(script)
+	}
+	if unspent.BlockTime != 0 {
+		iutxo.Height = unspent.BlockHeight
+		iutxo.Confirmations = Confirmations(unspent.BlockHeight, latest)
+		iutxo.Ts = unspent.BlockTime
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package btcplex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfirmations(t *testing.T) {
+	tests := []struct {
+		height, latest, confirmations uint
+	}{
+		{100, 100, 1},
+		{90, 100, 11},
+		{101, 100, 0},
+	}
+	for _, test := range tests {
+		if confirmations := Confirmations(test.height, test.latest); confirmations != test.confirmations {
+			t.Errorf("Confirmations(%v, %v) = %v, want %v", test.height, test.latest, confirmations, test.confirmations)
+		}
+	}
+}
+
+func TestNewInsightTx(t *testing.T) {
+	tx := &Tx{Hash: "tx", Version: 1, Size: 226, BlockHash: "block", BlockHeight: 10, BlockTime: 1000,
+		TotalIn: 5000, TotalOut: 4900,
+		TxIns: []*TxIn{{Sequence: 0xffffffff, PrevOut: &PrevOut{Hash: "prev", Vout: 1, Address: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", Value: 5000}}},
+		TxOuts: []*TxOut{
+			{Addr: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", Value: 4900, Index: 0, Spent: &TxoSpent{Spent: true, BlockHeight: 12, InputHash: "next", InputIndex: 2}},
+		}}
+	spenttxid, spentindex, spentheight := "next", uint32(2), uint32(12)
+	want := &InsightTx{Txid: "tx", Version: 1, Blockhash: "block", Blockheight: 10, Confirmations: 3, Time: 1000, Blocktime: 1000,
+		ValueOut: 0.000049, Size: 226, ValueIn: 0.00005, Fees: 0.000001,
+		Vin: []*InsightVin{{Txid: "prev", Vout: 1, Sequence: 0xffffffff, Addr: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", ValueSat: 5000, Value: 0.00005}},
+		Vout: []*InsightVout{{Value: "0.00004900", ScriptPubKey: &InsightScriptPubKey{
+			Hex:       "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac",
+			Asm:       "OP_DUP OP_HASH160 62e907b15cbf27d5425399ebf6f0fb50ebb88f18 OP_EQUALVERIFY OP_CHECKSIG",
+			Addresses: []string{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"},
+			Type:      ScriptPubKeyHash,
+		}, SpentTxId: &spenttxid, SpentIndex: &spentindex, SpentHeight: &spentheight}},
+	}
+	if itx := NewInsightTx(tx, 12); !reflect.DeepEqual(itx, want) {
+		t.Errorf("NewInsightTx = %+v, want %+v", itx, want)
+	}
+	// Unconfirmed generation-less tx spent in the memory pool
+	utx := &Tx{Hash: "utx", FirstSeenTime: 2000, TxIns: []*TxIn{{PrevOut: &PrevOut{}}},
+		TxOuts: []*TxOut{{Value: 1, Spent: &TxoSpent{Spent: true, Unconfirmed: true, InputHash: "child"}}}}
+	itx := NewInsightTx(utx, 12)
+	if itx.Blockheight != -1 || itx.Confirmations != 0 || itx.Time != 2000 || itx.IsCoinBase {
+		t.Errorf("NewInsightTx(unconfirmed) = %+v", itx)
+	}
+	if vout := itx.Vout[0]; vout.SpentTxId == nil || vout.SpentHeight != nil || vout.ScriptPubKey.Hex != "" {
+		t.Errorf("NewInsightTx(unconfirmed) vout = %+v", vout)
+	}
+	// Generation
+	if itx := NewInsightTx(&Tx{Hash: "gen", BlockHash: "block", TotalOut: 5000 * COIN}, 12); !itx.IsCoinBase || itx.ValueOut != 5000 {
+		t.Errorf("NewInsightTx(generation) isCoinBase = %v, valueOut = %v, want true, 5000", itx.IsCoinBase, itx.ValueOut)
+	}
+}
+
+func TestNewInsightTxEvent(t *testing.T) {
+	tx := &Tx{Hash: "tx", TotalOut: 150001000,
+		TxIns:  []*TxIn{{Sequence: 0xffffffff}, {Sequence: 0xfffffffe}},
+		TxOuts: []*TxOut{{Addr: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", Value: 150000000}, {Value: 0}, {Addr: "4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9", Value: 1000}}}
+	want := &InsightTxEvent{Txid: "tx", ValueOut: 1.50001,
+		Vout: []map[string]uint64{{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn": 150000000}, {"4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9": 1000}}}
+	if event := NewInsightTxEvent(tx); !reflect.DeepEqual(event, want) {
+		t.Errorf("NewInsightTxEvent = %+v, want %+v", event, want)
+	}
+	tx.TxIns[0].Sequence = 0
+	if event := NewInsightTxEvent(tx); !event.IsRBF {
+		t.Errorf("NewInsightTxEvent(sequence 0) isRBF = false, want true")
+	}
+}
+
+func TestNewInsightUtxo(t *testing.T) {
+	tests := []struct {
+		unspent *UnspentOutput
+		utxo    *InsightUtxo
+	}{
+		{&UnspentOutput{TxHash: "tx", Index: 1, Address: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", Value: 150000000, BlockHeight: 10, BlockTime: 1000},
+			&InsightUtxo{Address: "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", Txid: "tx", Vout: 1, ScriptPubKey: "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac",
+				Amount: 1.5, Satoshis: 150000000, Height: 10, Confirmations: 3, Ts: 1000}},
+		// Memory pool
+		{&UnspentOutput{TxHash: "utx", Address: "4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9", Value: 1000, FirstSeenTime: 2000},
+			&InsightUtxo{Address: "4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9", Txid: "utx", ScriptPubKey: "a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba87",
+				Amount: 0.00001, Satoshis: 1000, Ts: 2000}},
+	}
+	for _, test := range tests {
+		if utxo := NewInsightUtxo(test.unspent, 12); !reflect.DeepEqual(utxo, test.utxo) {
+			t.Errorf("NewInsightUtxo(%+v) = %+v, want %+v", test.unspent, utxo, test.utxo)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Sequence numbers below this value signal opt-in replace-by-fee (BIP 125)
+const MaxRBFSequence uint32 = 0xfffffffe
+
+// Transaction spending the same outpoint as another one
+type TxConflict struct {
+	Hash        string   `json:"hash"`
+	PrevOut     *PrevOut `json:"prev_out"`
+	Confirmed   bool     `json:"confirmed"`
+	Replacement bool     `json:"replacement"`
+	RBF         bool     `json:"rbf"`
+}
+
+// Payload published over the btcplex:doublespends channel
+type DoubleSpend struct {
+	Tx        *Tx           `json:"tx"`
+	Conflicts []*TxConflict `json:"conflicts"`
+	Time      int64         `json:"time"`
+}
+
+// Return true if at least one input signals replaceability
+func (tx *Tx) SignalsRBF() bool {
+	for _, txi := range tx.TxIns {
+		if txi.Sequence < MaxRBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
+func outpointKey(prevout *PrevOut) string {
+	return fmt.Sprintf("%v:%v", prevout.Hash, prevout.Vout)
+}
+
+func saveConflict(c redis.Conn, hash string, conflict *TxConflict) {
+	conflictjson, _ := json.Marshal(conflict)
+	c.Do("HSET", fmt.Sprintf("btcplex:conflicts:%v", hash), conflict.Hash, string(conflictjson))
+}
+
+// Record every outpoint spent by the unconfirmed transaction in btcplex:mempool:outpoints,
+// and return the conflicts found against other unconfirmed transactions (mempool contains
+// the txids currently known by bitcoind) and against the SSDB index.
+func IndexUnconfirmedSpends(pool, spool *redis.Pool, tx *Tx, mempool map[string]struct{}) (conflicts []*TxConflict, err error) {
+	c := pool.Get()
+	defer c.Close()
+	sc := spool.Get()
+	defer sc.Close()
+	conflicts = []*TxConflict{}
+	others := map[string]*Tx{}
+	for _, txi := range tx.TxIns {
+		opkey := outpointKey(txi.PrevOut)
+		spentjson, _ := redis.String(sc.Do("GET", fmt.Sprintf("txo:%v:%v:spent", txi.PrevOut.Hash, txi.PrevOut.Vout)))
+		if spentjson != "" {
+			spent := new(TxoSpent)
+			json.Unmarshal([]byte(spentjson), spent)
+			if spent.Spent && spent.InputHash != tx.Hash {
+				conflict := &TxConflict{Hash: spent.InputHash, PrevOut: txi.PrevOut, Confirmed: true}
+				saveConflict(c, tx.Hash, conflict)
+				saveConflict(c, spent.InputHash, &TxConflict{Hash: tx.Hash, PrevOut: txi.PrevOut})
+				conflicts = append(conflicts, conflict)
+			}
+		}
+		spender, _ := redis.String(c.Do("HGET", "btcplex:mempool:outpoints", opkey))
+		if spender != "" && spender != tx.Hash {
+			otherrbf := false
+			if other := cachedUnconfirmedTx(pool, others, spender); other != nil {
+				otherrbf = other.RBF
+			}
+			_, stillinpool := mempool[spender]
+			conflict := &TxConflict{Hash: spender, PrevOut: txi.PrevOut, Replacement: !stillinpool, RBF: otherrbf}
+			saveConflict(c, tx.Hash, conflict)
+			saveConflict(c, spender, &TxConflict{Hash: tx.Hash, PrevOut: txi.PrevOut, Replacement: !stillinpool, RBF: otherrbf})
+			conflicts = append(conflicts, conflict)
+		}
+		if _, err = c.Do("HSET", "btcplex:mempool:outpoints", opkey, tx.Hash); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Remove the outpoints spent by an unconfirmed transaction that left the memory pool,
+// conflicts are kept for a while so the winning transaction still shows them.
+func UnindexUnconfirmedSpends(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	for _, txi := range tx.TxIns {
+		opkey := outpointKey(txi.PrevOut)
+		spender, _ := redis.String(c.Do("HGET", "btcplex:mempool:outpoints", opkey))
+		if spender == tx.Hash {
+			c.Do("HDEL", "btcplex:mempool:outpoints", opkey)
+		}
+	}
+	_, err = c.Do("EXPIRE", fmt.Sprintf("btcplex:conflicts:%v", tx.Hash), 3600*20)
+	return
+}
+
+// Keep a sorted set of unconfirmed transactions for every address involved
+// (btcplex:addr:%v:utxs, scored by first seen time)
+func IndexUnconfirmedAddresses(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	for _, addr := range tx.Addresses() {
+		if addr == "" {
+			continue
+		}
+		if _, err = c.Do("ZADD", fmt.Sprintf("btcplex:addr:%v:utxs", addr), tx.FirstSeenTime, tx.Hash); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func UnindexUnconfirmedAddresses(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	for _, addr := range tx.Addresses() {
+		if addr == "" {
+			continue
+		}
+		if _, err = c.Do("ZREM", fmt.Sprintf("btcplex:addr:%v:utxs", addr), tx.Hash); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Mark outputs spent by an unconfirmed transaction, outputs already spent in a block are left untouched
+func (tx *Tx) FetchUnconfirmedSpent(pool *redis.Pool) (err error) {
+	return FetchUnconfirmedSpentTxs(pool, []*Tx{tx})
+}
+
+// Mark the outputs of the transactions spent by an unconfirmed transaction, with a single lookup
+// of the memory pool outpoints
+func FetchUnconfirmedSpentTxs(pool *redis.Pool, txs []*Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	opkeys := redis.Args{}.Add("btcplex:mempool:outpoints")
+	txos := []*TxOut{}
+	prevouts := []*PrevOut{}
+	for _, tx := range txs {
+		// TxOuts are ordered by vout
+		for txoindex, txo := range tx.TxOuts {
+			prevout := &PrevOut{Hash: tx.Hash, Vout: uint32(txoindex)}
+			opkeys = opkeys.Add(outpointKey(prevout))
+			txos = append(txos, txo)
+			prevouts = append(prevouts, prevout)
+		}
+	}
+	if len(txos) == 0 {
+		return
+	}
+	spenders, err := redis.Strings(c.Do("HMGET", opkeys...))
+	if err != nil {
+		return
+	}
+	utxs := map[string]*Tx{}
+	for i, spender := range spenders {
+		txo := txos[i]
+		if spender == "" || (txo.Spent != nil && txo.Spent.Spent) {
+			continue
+		}
+		utx, cached := utxs[spender]
+		if !cached {
+			utx, err = GetUnconfirmedTx(pool, spender)
+			if err != nil {
+				err = nil
+				continue
+			}
+			utxs[spender] = utx
+		}
+		txospent := &TxoSpent{Spent: true, Unconfirmed: true, InputHash: spender}
+		for txiindex, txi := range utx.TxIns {
+			if txi.PrevOut.Hash == prevouts[i].Hash && txi.PrevOut.Vout == prevouts[i].Vout {
+				txospent.InputIndex = uint32(txiindex)
+			}
+		}
+		txo.Spent = txospent
+	}
+	return
+}
+
+// Mark the block outputs spent by an unconfirmed transaction
+func (block *Block) FetchUnconfirmedSpent(pool *redis.Pool) (err error) {
+	return FetchUnconfirmedSpentTxs(pool, block.Txs)
+}
+
+// Unconfirmed transaction looked up once per call, nil if it already left the memory pool
+func cachedUnconfirmedTx(pool *redis.Pool, cache map[string]*Tx, hash string) *Tx {
+	utx, cached := cache[hash]
+	if !cached {
+		utx, _ = GetUnconfirmedTx(pool, hash)
+		cache[hash] = utx
+	}
+	return utx
+}
+
+// Check the transactions of a newly processed block against the unconfirmed spends,
+// flagging unconfirmed transactions that lost the race (one double spend event each,
+// with all their conflicting inputs).
+func DetectBlockConflicts(pool *redis.Pool, block *Block) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	utxs := map[string]*Tx{}
+	losers := []string{}
+	conflicts := map[string][]*TxConflict{}
+	for _, tx := range block.Txs {
+		for _, txi := range tx.TxIns {
+			spender, _ := redis.String(c.Do("HGET", "btcplex:mempool:outpoints", outpointKey(txi.PrevOut)))
+			if spender == "" || spender == tx.Hash {
+				continue
+			}
+			utx := cachedUnconfirmedTx(pool, utxs, spender)
+			if utx == nil {
+				continue
+			}
+			conflict := &TxConflict{Hash: tx.Hash, PrevOut: txi.PrevOut, Confirmed: true, RBF: utx.RBF}
+			saveConflict(c, spender, conflict)
+			saveConflict(c, tx.Hash, &TxConflict{Hash: spender, PrevOut: txi.PrevOut, RBF: utx.RBF})
+			if len(conflicts[spender]) == 0 {
+				losers = append(losers, spender)
+			}
+			conflicts[spender] = append(conflicts[spender], conflict)
+		}
+	}
+	for _, spender := range losers {
+		PublishDoubleSpend(pool, utxs[spender], conflicts[spender])
+	}
+	return
+}
+
+// Publish a double spend over btcplex:doublespends and the addr:%v:doublespends
+// channel of every address involved
+func PublishDoubleSpend(pool *redis.Pool, tx *Tx, conflicts []*TxConflict) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	dsjson, err := json.Marshal(&DoubleSpend{Tx: tx, Conflicts: conflicts, Time: time.Now().UTC().Unix()})
+	if err != nil {
+		return
+	}
+	addrset := make(map[string]struct{})
+	for _, addr := range tx.Addresses() {
+		addrset[addr] = struct{}{}
+	}
+	for _, conflict := range conflicts {
+		addrset[conflict.PrevOut.Address] = struct{}{}
+	}
+	channels := []string{}
+	for addr, _ := range addrset {
+		channels = append(channels, fmt.Sprintf("addr:%v:doublespends", addr))
+	}
+	log.Printf("Double spend detected: %v (%v conflicts)\n", tx.Hash, len(conflicts))
+	PublishEvent(c, "btcplex:doublespends", string(dsjson))
+	_, err = multiPublishScript.Do(c, redis.Args{}.Add(string(dsjson)).AddFlat(channels)...)
+	return
+}
+
+// Load conflicts recorded for the transaction (confirmed or not)
+func (tx *Tx) FetchConflicts(pool *redis.Pool) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	conflictsjson, err := redis.Strings(c.Do("HVALS", fmt.Sprintf("btcplex:conflicts:%v", tx.Hash)))
+	if err != nil {
+		return
+	}
+	tx.Conflicts = []*TxConflict{}
+	for _, conflictjson := range conflictsjson {
+		conflict := new(TxConflict)
+		if err = json.Unmarshal([]byte(conflictjson), conflict); err != nil {
+			return
+		}
+		tx.Conflicts = append(tx.Conflicts, conflict)
+	}
+	tx.DoubleSpent = len(tx.Conflicts) > 0
+	return
+}
+
+// Lower bounds (satoshis per byte) of the fee rate histogram buckets
+var FeeRateBuckets = []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 12, 15, 20, 30, 40, 50, 60, 70, 80, 100, 120, 150, 200, 300, 500, 1000}
+
+type FeeRateBucket struct {
+	MinFeeRate float64 `json:"min_fee_rate"`
+	MaxFeeRate float64 `json:"max_fee_rate,omitempty"`
+	TxCnt      uint64  `json:"n_tx"`
+	Size       uint64  `json:"size"`
+	TotalFees  uint64  `json:"total_fees"`
+}
+
+type MempoolInfo struct {
+	TxCnt     uint64                       `json:"n_tx"`
+	Size      uint64                       `json:"size"`
+	TotalFees uint64                       `json:"total_fees"`
+	Links     map[string]map[string]string `json:"_links,omitempty"`
+}
+
+// Keep the fee rate index (btcplex:mempool:feerate) and the memory pool totals
+// (btcplex:mempool:stats) up to date
+func IndexUnconfirmedFeeRate(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	txkey := fmt.Sprintf("btcplex:utx:%v", tx.Hash)
+	added, err := redis.Int(c.Do("ZADD", "btcplex:mempool:feerate", tx.FeeRate(), txkey))
+	if err != nil || added == 0 {
+		return
+	}
+	c.Do("HSET", "btcplex:mempool:sizes", tx.Hash, tx.Size)
+	c.Do("HINCRBY", "btcplex:mempool:stats", "size", tx.Size)
+	_, err = c.Do("HINCRBY", "btcplex:mempool:stats", "fees", tx.Fee())
+	return
+}
+
+func UnindexUnconfirmedFeeRate(pool *redis.Pool, tx *Tx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	txkey := fmt.Sprintf("btcplex:utx:%v", tx.Hash)
+	removed, err := redis.Int(c.Do("ZREM", "btcplex:mempool:feerate", txkey))
+	if err != nil || removed == 0 {
+		return
+	}
+	c.Do("HDEL", "btcplex:mempool:sizes", tx.Hash)
+	c.Do("HINCRBY", "btcplex:mempool:stats", "size", -int64(tx.Size))
+	_, err = c.Do("HINCRBY", "btcplex:mempool:stats", "fees", -int64(tx.Fee()))
+	return
+}
+
+// Return the number of unconfirmed transactions, their total size and fees
+func GetMempoolInfo(pool *redis.Pool) (info *MempoolInfo, err error) {
+	c := pool.Get()
+	defer c.Close()
+	info = new(MempoolInfo)
+	txcnt, err := redis.Int(c.Do("ZCARD", "btcplex:rawmempool"))
+	if err != nil {
+		return
+	}
+	info.TxCnt = uint64(txcnt)
+	stats, err := redis.StringMap(c.Do("HGETALL", "btcplex:mempool:stats"))
+	if err != nil {
+		return
+	}
+	info.Size, _ = strconv.ParseUint(stats["size"], 10, 64)
+	info.TotalFees, _ = strconv.ParseUint(stats["fees"], 10, 64)
+	return
+}
+
+// Return the index of the FeeRateBuckets bucket containing the fee rate
+func feeRateBucket(feerate float64) int {
+	bi := sort.SearchFloat64s(FeeRateBuckets, feerate)
+	if bi == len(FeeRateBuckets) || FeeRateBuckets[bi] != feerate {
+		bi--
+	}
+	if bi < 0 {
+		bi = 0
+	}
+	return bi
+}
+
+// Group fee rates (along with the matching sizes/fees) into FeeRateBuckets
+func FeeRateHistogram(feerates []float64, sizes []uint64) (buckets []*FeeRateBucket) {
+	buckets = []*FeeRateBucket{}
+	for i, min := range FeeRateBuckets {
+		bucket := &FeeRateBucket{MinFeeRate: min}
+		if i+1 < len(FeeRateBuckets) {
+			bucket.MaxFeeRate = FeeRateBuckets[i+1]
+		}
+		buckets = append(buckets, bucket)
+	}
+	for i, feerate := range feerates {
+		bi := feeRateBucket(feerate)
+		buckets[bi].TxCnt++
+		buckets[bi].Size += sizes[i]
+		buckets[bi].TotalFees += uint64(feerate*float64(sizes[i]) + 0.5)
+	}
+	return
+}
+
+// Return the fee rate histogram of the current memory pool
+func GetMempoolHistogram(pool *redis.Pool) (buckets []*FeeRateBucket, err error) {
+	feerates, sizes, err := GetMempoolFeeRates(pool)
+	if err != nil {
+		return
+	}
+	buckets = FeeRateHistogram(feerates, sizes)
+	return
+}
+
+// Return the fee rates/sizes of the current memory pool
+func GetMempoolFeeRates(pool *redis.Pool) (feerates []float64, sizes []uint64, err error) {
+	c := pool.Get()
+	defer c.Close()
+	feerates = []float64{}
+	sizes = []uint64{}
+	data, err := redis.Strings(c.Do("ZRANGE", "btcplex:mempool:feerate", 0, -1, "WITHSCORES"))
+	if err != nil {
+		return
+	}
+	txsizes, err := redis.StringMap(c.Do("HGETALL", "btcplex:mempool:sizes"))
+	if err != nil {
+		return
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		feerate, _ := strconv.ParseFloat(data[i+1], 64)
+		size, _ := strconv.ParseUint(txsizes[strings.TrimPrefix(data[i], "btcplex:utx:")], 10, 64)
+		feerates = append(feerates, feerate)
+		sizes = append(sizes, size)
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package btcplex
+
+import (
+	"testing"
+)
+
+func TestFeeRate(t *testing.T) {
+	type feeRateTest struct {
+		Tx      *Tx
+		Fee     uint64
+		FeeRate float64
+	}
+
+	feeRateTests := []feeRateTest{
+		{&Tx{TotalIn: 150000, TotalOut: 100000, Size: 250}, 50000, 200},
+		// Generation transaction
+		{&Tx{TotalIn: 0, TotalOut: 1000 * COIN, Size: 120}, 0, 0},
+		{&Tx{TotalIn: 1000, TotalOut: 1000, Size: 0}, 0, 0},
+	}
+
+	for _, item := range feeRateTests {
+		if item.Tx.Fee() != item.Fee {
+			t.Error("for fee of", item.Tx, "expected", item.Fee, "got", item.Tx.Fee())
+		}
+		if item.Tx.FeeRate() != item.FeeRate {
+			t.Error("for fee rate of", item.Tx, "expected", item.FeeRate, "got", item.Tx.FeeRate())
+		}
+	}
+}
+
+func TestFeeRateHistogram(t *testing.T) {
+	buckets := FeeRateHistogram([]float64{0.5, 1, 1.5, 7.9, 10, 2500}, []uint64{200, 250, 300, 400, 226, 1000})
+	if len(buckets) != len(FeeRateBuckets) {
+		t.Fatal("expected", len(FeeRateBuckets), "buckets, got", len(buckets))
+	}
+	type bucketTest struct {
+		MinFeeRate float64
+		TxCnt      uint64
+		Size       uint64
+	}
+	bucketTests := []bucketTest{
+		{0, 1, 200},
+		{1, 2, 550},
+		{6, 1, 400},
+		{10, 1, 226},
+		{1000, 1, 1000},
+		{2, 0, 0},
+	}
+	for _, item := range bucketTests {
+		for _, bucket := range buckets {
+			if bucket.MinFeeRate != item.MinFeeRate {
+				continue
+			}
+			if bucket.TxCnt != item.TxCnt || bucket.Size != item.Size {
+				t.Error("for bucket", item.MinFeeRate, "expected", item.TxCnt, item.Size,
+					"got", bucket.TxCnt, bucket.Size)
+			}
+		}
+	}
+	if buckets[len(buckets)-1].MaxFeeRate != 0 {
+		t.Error("last bucket should be unbounded")
+	}
+}
+
+func TestSignalsRBF(t *testing.T) {
+	final := &Tx{TxIns: []*TxIn{{Sequence: 0xffffffff}, {Sequence: 0xfffffffe}}}
+	if final.SignalsRBF() {
+		t.Error("final sequences should not signal RBF")
+	}
+	rbf := &Tx{TxIns: []*TxIn{{Sequence: 0xffffffff}, {Sequence: 0xfffffffd}}}
+	if !rbf.SignalsRBF() {
+		t.Error("sequence 0xfffffffd should signal RBF")
+	}
+}
@@ -2,6 +2,7 @@ package btcplex
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bradfitz/iter"
 	"github.com/garyburd/redigo/redis"
@@ -9,6 +10,13 @@ import (
 
 const COIN uint64 = 100000000
 
+// Returned when the requested object is not in the index
+var ErrNotFound = errors.New("Not found")
+
+// Memory pool store (Redis) used by Tx.Build to mark the outputs spent by unconfirmed
+// transactions, set at startup by the commands serving transactions (nil disables it)
+var UnconfirmedSpentPool *redis.Pool
+
 type Block struct {
 	Hash       string `json:"hash"`
 	Height     uint   `json:"height"`
@@ -22,11 +30,13 @@ type Block struct {
 	TxCnt      uint32 `json:"n_tx"`
 	TotalBTC   uint64 `json:"total_out"`
 	//    BlockReward float64 `json:"-"`
-	Parent string                       `json:"prev_block"`
-	Next   string                       `json:"next_block"`
-	Links  map[string]map[string]string `json:"_links,omitempty"`
-	Meta   *BlockMeta                   `json:"-"`
-	Main   bool                         `json:"-"`
+	Parent     string                       `json:"prev_block"`
+	Next       string                       `json:"next_block"`
+	Difficulty float64                      `json:"difficulty,omitempty"`
+	Chainwork  string                       `json:"chainwork,omitempty"`
+	Links      map[string]map[string]string `json:"_links,omitempty"`
+	Meta       *BlockMeta                   `json:"-"`
+	Main       bool                         `json:"-"`
 }
 
 type Tx struct {
@@ -46,6 +56,9 @@ type Tx struct {
 	BlockTime       uint32                       `json:"block_time"`
 	FirstSeenTime   uint32                       `json:"first_seen_time"`
 	FirstSeenHeight uint                         `json:"first_seen_height"`
+	RBF             bool                         `json:"rbf,omitempty"`
+	DoubleSpent     bool                         `json:"double_spent,omitempty"`
+	Conflicts       []*TxConflict                `json:"conflicts,omitempty"`
 	TxAddressInfo   *TxAddressInfo               `json:"-"`
 	Links           map[string]map[string]string `json:"_links,omitempty"`
 }
@@ -79,29 +92,38 @@ type TxIn struct {
 	BlockTime uint32   `json:"-"`
 	PrevOut   *PrevOut `json:"prev_out"`
 	Index     uint32   `json:"n"`
+	Sequence  uint32   `json:"sequence"`
 }
 
 type TxoSpent struct {
 	Spent       bool   `json:"spent"`
+	Unconfirmed bool   `json:"unconfirmed,omitempty"`
 	BlockHeight uint32 `json:"block_height,omitempty"`
 	InputHash   string `json:"tx_hash,omitempty"`
 	InputIndex  uint32 `json:"in_index,omitempty"`
 }
 
 type BlockMeta struct {
-	Main   bool   `redis:"main"`
-	Next   string `redis:"next"`
-	Parent string `redis:"parent"`
-	Height int    `redis:"height"`
+	Main      bool   `redis:"main"`
+	Next      string `redis:"next"`
+	Parent    string `redis:"parent"`
+	Height    int    `redis:"height"`
+	Chainwork string `redis:"chainwork"`
 }
 
+// The block reward is divided by 5 at subsidyReductionHeight, then halved
+// every subsidyHalvingInterval blocks, down to 1 coin
+const (
+	subsidyReductionHeight = 100000
+	subsidyHalvingInterval = 950000
+)
+
 // Return block reward at the given height
 func GetBlockReward(height uint) (subsidy uint64) {
 	subsidy = 5000 * COIN
-	halvingInterval := uint64(950000)
 	halvings := uint64(0)
-	if height >= 100000 {
-		halvings = (uint64(height) - 100000) / halvingInterval
+	if height >= subsidyReductionHeight {
+		halvings = (uint64(height) - subsidyReductionHeight) / subsidyHalvingInterval
 		subsidy /= 5
 	}
 
@@ -112,11 +134,30 @@ func GetBlockReward(height uint) (subsidy uint64) {
 	return
 }
 
+// Return the fee paid by the transaction (0 for generation transactions)
+func (tx *Tx) Fee() uint64 {
+	if tx.TotalIn < tx.TotalOut {
+		return 0
+	}
+	return tx.TotalIn - tx.TotalOut
+}
+
+// Return the fee rate in satoshis per byte
+func (tx *Tx) FeeRate() float64 {
+	if tx.Size == 0 {
+		return 0
+	}
+	return float64(tx.Fee()) / float64(tx.Size)
+}
+
 // Return block hash for the given height
 func GetBlockHash(rpool *redis.Pool, height uint) (hash string, err error) {
 	c := rpool.Get()
 	defer c.Close()
 	hash, err = redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
 	return
 }
 
@@ -125,6 +166,9 @@ func GetBlockByHash(rpool *redis.Pool, hash string) (block *Block, err error) {
 	c := rpool.Get()
 	defer c.Close()
 	blockjson, err := redis.String(c.Do("GET", fmt.Sprintf("block:%v", hash)))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
 	if err != nil {
 		return
 	}
@@ -138,6 +182,9 @@ func GetBlockCachedByHash(rpool *redis.Pool, hash string) (block *Block, err err
 	c := rpool.Get()
 	defer c.Close()
 	blockjson, err := redis.String(c.Do("GET", fmt.Sprintf("block:%v:cached", hash)))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
 	if err != nil {
 		return
 	}
@@ -179,6 +226,8 @@ func (block *Block) FetchMeta(rpool *redis.Pool) (err error) {
 	}
 	block.Next = meta.Next
 	block.Main = meta.Main
+	block.Chainwork = meta.Chainwork
+	block.Difficulty = BitsToDifficulty(block.Bits)
 	return
 }
 
@@ -200,10 +249,18 @@ func NewBlockMeta(rpool *redis.Pool, block_hash string) (blockmeta *BlockMeta, e
 func GetTx(rpool *redis.Pool, hash string) (tx *Tx, err error) {
 	c := rpool.Get()
 	defer c.Close()
+	txjson, err := redis.String(c.Do("GET", fmt.Sprintf("tx:%v", hash)))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
 	tx = new(Tx)
-	txjson, _ := redis.String(c.Do("GET", fmt.Sprintf("tx:%v", hash)))
-	err = json.Unmarshal([]byte(txjson), tx)
-	tx.Build(rpool)
+	if err = json.Unmarshal([]byte(txjson), tx); err != nil {
+		return
+	}
+	err = tx.Build(rpool)
 	return
 }
 
@@ -241,6 +298,53 @@ func (tx *Tx) Build(rpool *redis.Pool) (err error) {
 		}
 		tx.TxOuts = append(tx.TxOuts, ctxo)
 	}
+	if UnconfirmedSpentPool != nil {
+		FetchUnconfirmedSpentTxs(UnconfirmedSpentPool, []*Tx{tx})
+	}
+	return
+}
+
+// Return up to limit main chain blocks, from the best block (nil cursor) or below the cursor
+// height, down to the genesis block, the next cursor score is the height of the last returned block
+func GetBlocksFromCursor(rpool *redis.Pool, cursor *Cursor, limit int) (blocks []*Block, next *Cursor, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	blocks = []*Block{}
+	var height int
+	if cursor == nil {
+		height, err = redis.Int(c.Do("GET", "height:latest"))
+		if err != nil {
+			return
+		}
+	} else {
+		height = int(cursor.Score) - 1
+	}
+	blockskeys := []interface{}{}
+	for ; height >= 0 && len(blockskeys) < limit; height-- {
+		chash, cerr := GetBlockHash(rpool, uint(height))
+		if cerr != nil {
+			err = cerr
+			return
+		}
+		blockskeys = append(blockskeys, fmt.Sprintf("block:%v", chash))
+	}
+	if len(blockskeys) == 0 {
+		return
+	}
+	blocksjson, err := redis.Strings(c.Do("MGET", blockskeys...))
+	if err != nil {
+		return
+	}
+	for _, blockjson := range blocksjson {
+		cblock := new(Block)
+		if err = json.Unmarshal([]byte(blockjson), cblock); err != nil {
+			return
+		}
+		blocks = append(blocks, cblock)
+	}
+	if height >= 0 {
+		next = &Cursor{Score: float64(height + 1)}
+	}
 	return
 }
 
@@ -0,0 +1,149 @@
+package btcplex
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Max number of addresses per multi-address query
+const MaxMultiAddresses = 100
+
+// Transaction along with its net value for a set of addresses
+type MultiAddressTx struct {
+	*Tx
+	NetValue int64 `json:"net_value"`
+}
+
+type MultiAddressData struct {
+	Addresses     []*AddressData    `json:"addresses"`
+	TotalReceived uint64            `json:"total_received"`
+	TotalSent     uint64            `json:"total_sent"`
+	FinalBalance  uint64            `json:"final_balance"`
+	Txs           []*MultiAddressTx `json:"txs"`
+}
+
+// Fetch the summary of every address and their merged transactions history
+func GetMultiAddress(rpool *redis.Pool, addresses []string, cursor *Cursor, limit int) (multi *MultiAddressData, next *Cursor, err error) {
+	multi = &MultiAddressData{Addresses: []*AddressData{}}
+	set := map[string]bool{}
+	for _, address := range addresses {
+		if set[address] {
+			continue
+		}
+		set[address] = true
+		addressdata, aerr := GetAddress(rpool, address)
+		if aerr != nil {
+			err = aerr
+			return
+		}
+		multi.Addresses = append(multi.Addresses, addressdata)
+		multi.TotalReceived += addressdata.TotalReceived
+		multi.TotalSent += addressdata.TotalSent
+		multi.FinalBalance += addressdata.FinalBalance
+	}
+	multi.Txs, next, err = GetMultiAddressTxs(rpool, addresses, cursor, limit)
+	return
+}
+
+// Return up to limit txs involving any of the addresses following the cursor,
+// a tx involving several addresses is only returned once
+func GetMultiAddressTxs(rpool *redis.Pool, addresses []string, cursor *Cursor, limit int) (txs []*MultiAddressTx, next *Cursor, err error) {
+	c := rpool.Get()
+	defer c.Close()
+
+	txs = []*MultiAddressTx{}
+	set := map[string]bool{}
+	lists := [][]string{}
+	listsscores := [][]float64{}
+	more := false
+	// Every history restarts at the cursor score, the merged txs sharing it are skipped below
+	var tiecursor *Cursor
+	skip := 0
+	if cursor != nil {
+		tiecursor = &Cursor{Score: cursor.Score}
+		skip = cursor.Index
+	}
+	for _, address := range addresses {
+		if set[address] {
+			continue
+		}
+		set[address] = true
+		members, scores, znext, zerr := ZRangeFromCursor(c, fmt.Sprintf("addr:%v", address), "-inf", "+inf", false, tiecursor, skip+limit)
+		if zerr != nil {
+			err = zerr
+			return
+		}
+		lists = append(lists, members)
+		listsscores = append(listsscores, scores)
+		more = more || znext != nil
+	}
+	hashes, scores, truncated := mergeTxHashes(lists, listsscores, skip+limit)
+	skipped := 0
+	for skipped < skip && skipped < len(hashes) && scores[skipped] == cursor.Score {
+		skipped++
+	}
+	hashes, scores = hashes[skipped:], scores[skipped:]
+	if len(hashes) > limit {
+		hashes, scores = hashes[:limit], scores[:limit]
+		truncated = true
+	}
+	for _, hash := range hashes {
+		tx, txerr := GetTx(rpool, hash)
+		if txerr != nil {
+			err = txerr
+			return
+		}
+		txs = append(txs, &MultiAddressTx{Tx: tx, NetValue: multiAddressValue(tx, set)})
+	}
+	if (more || truncated) && len(hashes) > 0 {
+		next = CursorAfter(cursor, scores)
+	}
+	return
+}
+
+// Merge the txs histories (each sorted like ZREVRANGEBYSCORE), removing duplicates,
+// truncated is true if there was more than limit txs
+func mergeTxHashes(lists [][]string, listsscores [][]float64, limit int) (hashes []string, scores []float64, truncated bool) {
+	merged := map[string]float64{}
+	for i, list := range lists {
+		for j, hash := range list {
+			merged[hash] = listsscores[i][j]
+		}
+	}
+	hashes = make([]string, 0, len(merged))
+	for hash := range merged {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if merged[hashes[i]] != merged[hashes[j]] {
+			return merged[hashes[i]] > merged[hashes[j]]
+		}
+		return hashes[i] > hashes[j]
+	})
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+		truncated = true
+	}
+	scores = make([]float64, len(hashes))
+	for i, hash := range hashes {
+		scores[i] = merged[hash]
+	}
+	return
+}
+
+// Value received minus value sent by the set of addresses in the tx
+func multiAddressValue(tx *Tx, set map[string]bool) (value int64) {
+	for _, txi := range tx.TxIns {
+		if txi.PrevOut != nil && set[txi.PrevOut.Address] {
+			value -= int64(txi.PrevOut.Value)
+		}
+	}
+	for _, txo := range tx.TxOuts {
+		if set[txo.Addr] {
+			value += int64(txo.Value)
+		}
+	}
+	return
+}
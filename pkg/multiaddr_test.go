@@ -0,0 +1,56 @@
+package btcplex
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeTxHashes(t *testing.T) {
+	lists := [][]string{{"c", "b", "a"}, {"d", "c", "e"}}
+	scores := [][]float64{{20, 10, 10}, {30, 20, 5}}
+	tests := []struct {
+		limit     int
+		want      string
+		truncated bool
+	}{
+		{10, "dcbae", false},
+		{5, "dcbae", false},
+		{3, "dcb", true},
+	}
+	for _, test := range tests {
+		hashes, hscores, truncated := mergeTxHashes(lists, scores, test.limit)
+		if strings.Join(hashes, "") != test.want || truncated != test.truncated {
+			t.Errorf("limit %v: got %v (truncated=%v), want %v (truncated=%v)", test.limit, hashes, truncated, test.want, test.truncated)
+		}
+		if len(hscores) != len(hashes) || hscores[0] != 30 {
+			t.Errorf("limit %v: unexpected scores %v", test.limit, hscores)
+		}
+	}
+}
+
+func TestMultiAddressValue(t *testing.T) {
+	tx := &Tx{
+		TxIns: []*TxIn{
+			{PrevOut: &PrevOut{Address: "A", Value: 1000}},
+			{PrevOut: &PrevOut{Address: "B", Value: 500}},
+		},
+		TxOuts: []*TxOut{
+			{Addr: "C", Value: 1200},
+			{Addr: "A", Value: 250},
+		},
+	}
+	tests := []struct {
+		set   map[string]bool
+		value int64
+	}{
+		{map[string]bool{"A": true}, -750},
+		{map[string]bool{"A": true, "B": true}, -1250},
+		{map[string]bool{"C": true}, 1200},
+		{map[string]bool{"D": true}, 0},
+	}
+	for _, test := range tests {
+		if value := multiAddressValue(tx, test.set); value != test.value {
+			t.Errorf("multiAddressValue(%v) = %v, want %v", test.set, value, test.value)
+		}
+	}
+}
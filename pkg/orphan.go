@@ -1,6 +1,7 @@
 package btcplex
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/garyburd/redigo/redis"
@@ -11,15 +12,50 @@ func (tx *Tx) Revert(spool *redis.Pool) (err error) {
 	defer ssdb.Close()
 
 	for _, txi := range tx.TxIns {
-		ssdb.Do("HINCRBY", fmt.Sprintf("addr:%v:h", txi.PrevOut.Address), "ts", -int(txi.PrevOut.Value))
+		// The output may have been spent by a conflicting transaction since
+		spentkey := fmt.Sprintf("txo:%v:%v:spent", txi.PrevOut.Hash, txi.PrevOut.Vout)
+		spentjson, _ := redis.String(ssdb.Do("GET", spentkey))
+		spent := new(TxoSpent)
+		if json.Unmarshal([]byte(spentjson), spent) == nil && spent.InputHash == tx.Hash {
+			ssdb.Do("DEL", spentkey)
+		}
+		IncrAddressTotal(ssdb, txi.PrevOut.Address, "ts", -int64(txi.PrevOut.Value))
 		ssdb.Do("ZREM", fmt.Sprintf("addr:%v", txi.PrevOut.Address), tx.Hash)
 		ssdb.Do("ZREM", fmt.Sprintf("addr:%v:sent", txi.PrevOut.Address), tx.Hash)
+		// Unspent again, scored by the block time of the tx that created it
+		if prevtime, perr := redis.Int64(ssdb.Do("ZSCORE", fmt.Sprintf("addr:%v:received", txi.PrevOut.Address), txi.PrevOut.Hash)); perr == nil {
+			IndexUnspent(ssdb, txi.PrevOut.Address, txi.PrevOut.Hash, txi.PrevOut.Vout, uint32(prevtime))
+		}
 
 	}
-	for _, txo := range tx.TxOuts {
-		ssdb.Do("HINCRBY", fmt.Sprintf("addr:%v:h", txo.Addr), "tr", -int(txo.Value))
+	for txoindex, txo := range tx.TxOuts {
+		IncrAddressTotal(ssdb, txo.Addr, "tr", -int64(txo.Value))
 		ssdb.Do("ZREM", fmt.Sprintf("addr:%v", txo.Addr), tx.Hash)
 		ssdb.Do("ZREM", fmt.Sprintf("addr:%v:received", txo.Addr), tx.Hash)
+		UnindexUnspent(ssdb, txo.Addr, tx.Hash, uint32(txoindex))
+	}
+
+	return
+}
+
+// Apply again a reverted transaction, when its block is back in the main chain
+func (tx *Tx) Apply(spool *redis.Pool) (err error) {
+	ssdb := spool.Get()
+	defer ssdb.Close()
+
+	for _, txi := range tx.TxIns {
+		spentjson, _ := json.Marshal(&TxoSpent{Spent: true, BlockHeight: uint32(tx.BlockHeight), InputHash: tx.Hash, InputIndex: txi.Index})
+		ssdb.Do("SET", fmt.Sprintf("txo:%v:%v:spent", txi.PrevOut.Hash, txi.PrevOut.Vout), spentjson)
+		IncrAddressTotal(ssdb, txi.PrevOut.Address, "ts", int64(txi.PrevOut.Value))
+		ssdb.Do("ZADD", fmt.Sprintf("addr:%v", txi.PrevOut.Address), tx.BlockTime, tx.Hash)
+		ssdb.Do("ZADD", fmt.Sprintf("addr:%v:sent", txi.PrevOut.Address), tx.BlockTime, tx.Hash)
+		UnindexUnspent(ssdb, txi.PrevOut.Address, txi.PrevOut.Hash, txi.PrevOut.Vout)
+	}
+	for txoindex, txo := range tx.TxOuts {
+		IncrAddressTotal(ssdb, txo.Addr, "tr", int64(txo.Value))
+		ssdb.Do("ZADD", fmt.Sprintf("addr:%v", txo.Addr), tx.BlockTime, tx.Hash)
+		ssdb.Do("ZADD", fmt.Sprintf("addr:%v:received", txo.Addr), tx.BlockTime, tx.Hash)
+		IndexUnspent(ssdb, txo.Addr, tx.Hash, uint32(txoindex), tx.BlockTime)
 	}
 
 	return
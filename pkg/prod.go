@@ -7,18 +7,21 @@ import (
 	"github.com/garyburd/redigo/redis"
 )
 
-func CatchUpLatestBlock(conf *Config, rpool *redis.Pool, spool *redis.Pool) (done bool) {
-	blockcount := GetBlockCountRPC(conf)
+func CatchUpLatestBlock(conf *Config, rpool *redis.Pool, spool *redis.Pool) (done bool, err error) {
+	blockcount, err := GetBlockCountRPC(conf)
+	if err != nil {
+		return
+	}
 	sc := spool.Get()
 	defer sc.Close()
 	latestheight, _ := redis.Int(sc.Do("GET", "height:latest"))
 	if uint(latestheight) == blockcount {
-		return true
+		return true, nil
 	}
 	hash := GetBlockHashRPC(conf, uint(latestheight)+1)
 	log.Printf("Catch up block: %v\n", hash)
-	SaveBlockFromRPC(conf, spool, hash)
-	return false
+	_, err = SaveBlockFromRPC(conf, spool, hash)
+	return
 }
 
 func ProcessNewBlock(conf *Config, rpool *redis.Pool, spool *redis.Pool) {
@@ -36,11 +39,15 @@ func ProcessNewBlock(conf *Config, rpool *redis.Pool, spool *redis.Pool) {
 			newblock, err := SaveBlockFromRPC(conf, spool, hash)
 			if err != nil {
 				log.Printf("Error processing new block: %v\n", err)
+			} else if !newblock.Main {
+				log.Printf("Block %v has less work than the main chain, kept as a side branch\n", hash)
 			} else {
 				// Once the block is processed, we can publish it as btcplex own blocknotify
-				c.Do("PUBLISH", "btcplex:blocknotify2", hash)
+				PublishEvent(c, "btcplex:blocknotify2", hash)
 				newblockjson, _ := json.Marshal(newblock)
-				c.Do("PUBLISH", "btcplex:newblock", string(newblockjson))
+				PublishEvent(c, "btcplex:newblock", string(newblockjson))
+				DetectBlockConflicts(rpool, newblock)
+				RecordBlockFeeStats(rpool, spool, newblock)
 			}
 			c.Close()
 		}
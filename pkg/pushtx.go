@@ -0,0 +1,115 @@
+package btcplex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Reasons a transaction is rejected by PushTx
+const (
+	RejectDecode          = "decode-failed"
+	RejectAlreadyKnown    = "already-known"
+	RejectMissingInputs   = "missing-inputs"
+	RejectSpentInputs     = "inputs-spent"
+	RejectMempoolConflict = "mempool-conflict"
+	RejectRPC             = "rpc-rejected"
+)
+
+type PushTxError struct {
+	Reason  string
+	Message string
+}
+
+func (pusherr *PushTxError) Error() string {
+	return fmt.Sprintf("%v: %v", pusherr.Reason, pusherr.Message)
+}
+
+func rejectTx(reason string, format string, args ...interface{}) *PushTxError {
+	return &PushTxError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// Check that every input of the transaction exists in the index (confirmed or
+// in the memory pool) and is unspent, spending an output already spent by an
+// unconfirmed transaction is only allowed if it signals replaceability
+func CheckRawTx(pool *redis.Pool, spool *redis.Pool, tx *RawTx) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	sc := spool.Get()
+	defer sc.Close()
+
+	if tx.IsCoinbase() {
+		return rejectTx(RejectDecode, "Generation transactions can't be relayed")
+	}
+	confirmed, _ := redis.Bool(sc.Do("EXISTS", fmt.Sprintf("tx:%v", tx.Hash)))
+	unconfirmed, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("btcplex:utx:%v", tx.Hash)))
+	if confirmed || unconfirmed {
+		return rejectTx(RejectAlreadyKnown, "Transaction %v already known", tx.Hash)
+	}
+	for vin, txi := range tx.TxIns {
+		exists, err := redis.Bool(sc.Do("EXISTS", fmt.Sprintf("txo:%v:%v", txi.PrevHash, txi.PrevIndex)))
+		if err != nil {
+			return err
+		}
+		if exists {
+			spent, _ := redis.Bool(sc.Do("EXISTS", fmt.Sprintf("txo:%v:%v:spent", txi.PrevHash, txi.PrevIndex)))
+			if spent {
+				return rejectTx(RejectSpentInputs, "Input %v spends %v:%v, already spent in the block chain", vin, txi.PrevHash, txi.PrevIndex)
+			}
+		} else {
+			prevtx, perr := GetUnconfirmedTx(pool, txi.PrevHash)
+			if perr != nil || int(txi.PrevIndex) >= len(prevtx.TxOuts) {
+				return rejectTx(RejectMissingInputs, "Input %v spends %v:%v, unknown output", vin, txi.PrevHash, txi.PrevIndex)
+			}
+		}
+		prevout := &PrevOut{Hash: txi.PrevHash, Vout: txi.PrevIndex}
+		spender, _ := redis.String(c.Do("HGET", "btcplex:mempool:outpoints", outpointKey(prevout)))
+		if spender != "" {
+			other, oerr := GetUnconfirmedTx(pool, spender)
+			if oerr != nil || !other.RBF {
+				return rejectTx(RejectMempoolConflict, "Input %v spends %v:%v, already spent by unconfirmed transaction %v", vin, txi.PrevHash, txi.PrevIndex, spender)
+			}
+		}
+	}
+	return
+}
+
+// Validate and relay a signed transaction via bitcoind, then store it in the memory pool
+// right away, rejections are returned as *PushTxError
+func PushTx(conf *Config, pool *redis.Pool, spool *redis.Pool, rawtx string) (tx *Tx, err error) {
+	decoded, err := DecodeRawTxHex(rawtx)
+	if err != nil {
+		return nil, rejectTx(RejectDecode, "Invalid raw transaction")
+	}
+	if err = CheckRawTx(pool, spool, decoded); err != nil {
+		return
+	}
+	_, err = SendRawTransactionRPC(conf, rawtx)
+	if rpcerr, isrpcerr := err.(*RPCError); isrpcerr {
+		return nil, rejectTx(RejectRPC, rpcerr.Message)
+	}
+	if err != nil {
+		return
+	}
+	// The inputs were all found by CheckRawTx, no need to fetch the transaction back from bitcoind
+	sc := spool.Get()
+	defer sc.Close()
+	dtx := NewDecodedTx(decoded)
+	if err = dtx.resolveInputs(pool, sc); err != nil {
+		return
+	}
+	tx = dtx.Tx()
+	height, _ := redis.Int(sc.Do("GET", "height:latest"))
+	tx.FirstSeenTime = uint32(time.Now().UTC().Unix())
+	tx.FirstSeenHeight = uint(height)
+	// Transactions conflicting with an accepted transaction have been replaced,
+	// so none of them is flagged as still in the memory pool
+	if err = SaveUnconfirmedTx(pool, spool, tx, nil); err != nil {
+		return
+	}
+	c := pool.Get()
+	defer c.Close()
+	_, err = c.Do("ZADD", "btcplex:rawmempool", tx.FirstSeenTime, fmt.Sprintf("btcplex:utx:%v", tx.Hash))
+	return
+}
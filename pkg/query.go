@@ -0,0 +1,139 @@
+package btcplex
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Helpers for the Blockchain.info/BlockExplorer query API endpoints
+
+var ErrInvalidAddress = errors.New("Invalid address")
+var ErrInvalidHash160 = errors.New("Invalid hash160")
+
+// Number of blocks used for the averages when not given, and the max
+const (
+	DefaultRecentBlocks = 100
+	MaxRecentBlocks     = 1000
+)
+
+// Totals over the latest blocks
+type RecentStats struct {
+	Blocks   int
+	TxCnt    uint64
+	Size     uint64
+	Volume   uint64
+	Interval int64
+	LastTime uint32
+}
+
+// Hex encoded hash160 of the address
+func AddressToHash(address string) (hash160 string, err error) {
+	data, err := Base58CheckDecode(address)
+	if err != nil {
+		return
+	}
+	if len(data) != 21 {
+		return "", ErrInvalidAddress
+	}
+	return hex.EncodeToString(data[1:]), nil
+}
+
+// Pubkey hash address of the hex encoded hash160
+func HashToAddress(hash160 string) (address string, err error) {
+	data, err := hex.DecodeString(hash160)
+	if err != nil || len(data) != 20 {
+		return "", ErrInvalidHash160
+	}
+	return PubKeyHashAddress(data), nil
+}
+
+// Expected number of hashes needed to find a block at the given difficulty
+func HashesToWin(difficulty float64) float64 {
+	return difficulty * 4294967296
+}
+
+// Sum the stats of the latest main chain blocks, blocks without stats (not backfilled) are skipped
+func GetRecentStats(rpool *redis.Pool, blocks int) (stats *RecentStats, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	stats = new(RecentStats)
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	for height := latest; height >= 0 && height > latest-blocks; height-- {
+		hash, herr := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+		if herr != nil {
+			return nil, herr
+		}
+		statsjson, serr := redis.String(c.Do("GET", fmt.Sprintf("block:%v:stats", hash)))
+		if serr == redis.ErrNil {
+			break
+		}
+		if serr != nil {
+			return nil, serr
+		}
+		bstats := new(BlockStats)
+		if err = json.Unmarshal([]byte(statsjson), bstats); err != nil {
+			return
+		}
+		if stats.Blocks == 0 {
+			stats.LastTime = bstats.Time
+		}
+		stats.add(bstats)
+	}
+	return
+}
+
+func (stats *RecentStats) add(bstats *BlockStats) {
+	stats.Blocks++
+	stats.TxCnt += uint64(bstats.TxCnt)
+	stats.Size += uint64(bstats.Size)
+	stats.Volume += bstats.Volume
+	stats.Interval += bstats.Interval
+}
+
+// Average transaction size in bytes
+func (stats *RecentStats) AvgTxSize() float64 {
+	if stats.TxCnt == 0 {
+		return 0
+	}
+	return float64(stats.Size) / float64(stats.TxCnt)
+}
+
+// Average transaction output volume in satoshis
+func (stats *RecentStats) AvgTxValue() float64 {
+	if stats.TxCnt == 0 {
+		return 0
+	}
+	return float64(stats.Volume) / float64(stats.TxCnt)
+}
+
+// Average number of transactions per block
+func (stats *RecentStats) AvgTxNumber() float64 {
+	if stats.Blocks == 0 {
+		return 0
+	}
+	return float64(stats.TxCnt) / float64(stats.Blocks)
+}
+
+// Average time between blocks in seconds
+func (stats *RecentStats) AvgInterval() float64 {
+	if stats.Blocks == 0 {
+		return 0
+	}
+	return float64(stats.Interval) / float64(stats.Blocks)
+}
+
+// Estimated seconds until the next block at the given unix time
+func (stats *RecentStats) ETA(now int64) float64 {
+	eta := float64(stats.LastTime) + stats.AvgInterval() - float64(now)
+	if eta < 0 {
+		return 0
+	}
+	return eta
+}
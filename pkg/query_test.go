@@ -0,0 +1,93 @@
+package btcplex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddressToHash(t *testing.T) {
+	tests := []struct {
+		address, hash160 string
+		err              error
+	}{
+		{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", "62e907b15cbf27d5425399ebf6f0fb50ebb88f18", nil},
+		{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCm", "", ErrInvalidChecksum},
+	}
+	for _, test := range tests {
+		hash160, err := AddressToHash(test.address)
+		if hash160 != test.hash160 || err != test.err {
+			t.Errorf("AddressToHash(%v) = %v, %v, want %v, %v", test.address, hash160, err, test.hash160, test.err)
+		}
+	}
+}
+
+func TestHashToAddress(t *testing.T) {
+	tests := []struct {
+		hash160, address string
+		err              error
+	}{
+		{"62e907b15cbf27d5425399ebf6f0fb50ebb88f18", "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", nil},
+		{"62E907B15CBF27D5425399EBF6F0FB50EBB88F18", "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", nil},
+		{"62e907b15cbf27d5425399ebf6f0fb50ebb88f", "", ErrInvalidHash160},
+		{"not hex", "", ErrInvalidHash160},
+	}
+	for _, test := range tests {
+		address, err := HashToAddress(test.hash160)
+		if address != test.address || err != test.err {
+			t.Errorf("HashToAddress(%v) = %v, %v, want %v, %v", test.hash160, address, err, test.address, test.err)
+		}
+	}
+}
+
+func TestRecentStats(t *testing.T) {
+	stats := new(RecentStats)
+	stats.LastTime = 10000
+	stats.add(&BlockStats{TxCnt: 1, Size: 200, Volume: 5000 * COIN, Interval: 100})
+	stats.add(&BlockStats{TxCnt: 3, Size: 1000, Volume: 1000 * COIN, Interval: 140})
+	tests := []struct {
+		name        string
+		value, want float64
+	}{
+		{"AvgTxSize", stats.AvgTxSize(), 300},
+		{"AvgTxValue", stats.AvgTxValue(), 1500 * float64(COIN)},
+		{"AvgTxNumber", stats.AvgTxNumber(), 2},
+		{"AvgInterval", stats.AvgInterval(), 120},
+		{"ETA", stats.ETA(10020), 100},
+		// Overdue
+		{"ETA", stats.ETA(10200), 0},
+		{"AvgTxSize", new(RecentStats).AvgTxSize(), 0},
+		{"AvgInterval", new(RecentStats).AvgInterval(), 0},
+	}
+	for _, test := range tests {
+		if test.value != test.want {
+			t.Errorf("%v = %v, want %v", test.name, test.value, test.want)
+		}
+	}
+}
+
+func TestNewBlockchainTx(t *testing.T) {
+	tx := &Tx{Hash: "tx", Version: 1, TxInCnt: 1, TxOutCnt: 2, Size: 226, BlockHash: "block", BlockHeight: 10, BlockTime: 1000,
+		TotalIn: 5000, TotalOut: 4900,
+		TxIns: []*TxIn{{Sequence: 0xffffffff, PrevOut: &PrevOut{Hash: "prev", Vout: 1, Address: "A", Value: 5000}}},
+		TxOuts: []*TxOut{
+			{Addr: "B", Value: 3000, Index: 0, Spent: &TxoSpent{Spent: true}},
+			{Addr: "A", Value: 1900, Index: 1, Spent: &TxoSpent{}},
+		}}
+	tx.TxAddressInfo = tx.AddressInfo("A")
+	height, result := uint(10), int64(-3100)
+	want := &BlockchainTx{Hash: "tx", Ver: 1, VinSz: 1, VoutSz: 2, Size: 226, RelayedBy: "0.0.0.0", BlockHeight: &height,
+		Time: 1000, Fee: 100, Result: &result,
+		Inputs: []*BlockchainInput{{Sequence: 0xffffffff, PrevOut: &BlockchainOutput{Spent: true, Value: 5000, N: 1, Addr: "A"}}},
+		Out: []*BlockchainOutput{
+			{Spent: true, Value: 3000, N: 0, Addr: "B"},
+			{Value: 1900, N: 1, Addr: "A"},
+		}}
+	if btx := NewBlockchainTx(tx); !reflect.DeepEqual(btx, want) {
+		t.Errorf("NewBlockchainTx = %+v, want %+v", btx, want)
+	}
+	// Unconfirmed
+	utx := &Tx{Hash: "utx", FirstSeenTime: 2000}
+	if btx := NewBlockchainTx(utx); btx.BlockHeight != nil || btx.Time != 2000 {
+		t.Errorf("NewBlockchainTx(unconfirmed) block_height = %v, time = %v, want nil, 2000", btx.BlockHeight, btx.Time)
+	}
+}
@@ -0,0 +1,211 @@
+package btcplex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// Raw transaction deserializer, so transactions can be inspected without bitcoind
+
+var ErrInvalidRawTx = errors.New("Invalid raw transaction")
+
+// Outputs (and their total) can't exceed the coins supply
+const MaxMoney = 21000000 * COIN
+
+type RawTxIn struct {
+	PrevHash  string
+	PrevIndex uint32
+	Script    []byte
+	Sequence  uint32
+	Witness   [][]byte
+}
+
+type RawTxOut struct {
+	Value  uint64
+	Script []byte
+}
+
+type RawTx struct {
+	Hash     string
+	Version  uint32
+	TxIns    []*RawTxIn
+	TxOuts   []*RawTxOut
+	LockTime uint32
+	Size     int
+}
+
+type rawTxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *rawTxReader) read(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.err = ErrInvalidRawTx
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *rawTxReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *rawTxReader) varInt() uint64 {
+	prefix := r.read(1)[0]
+	switch prefix {
+	case 0xfd:
+		return uint64(binary.LittleEndian.Uint16(r.read(2)))
+	case 0xfe:
+		return uint64(binary.LittleEndian.Uint32(r.read(4)))
+	case 0xff:
+		return binary.LittleEndian.Uint64(r.read(8))
+	}
+	return uint64(prefix)
+}
+
+// Read a count of items of at least minsize bytes each, a count that can't fit
+// in the remaining data is rejected before allocating anything
+func (r *rawTxReader) count(minsize int) int {
+	n := r.varInt()
+	if r.err == nil && n > uint64(len(r.data)-r.pos)/uint64(minsize) {
+		r.err = ErrInvalidRawTx
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (r *rawTxReader) varBytes() []byte {
+	return append([]byte{}, r.read(r.count(1))...)
+}
+
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[i] = b[len(b)-1-i]
+	}
+	return r
+}
+
+// Reverse a hash (hashes are displayed in reverse byte order)
+func reverseHash(b []byte) string {
+	return hex.EncodeToString(reverseBytes(b))
+}
+
+func DecodeRawTxHex(s string) (tx *RawTx, err error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidRawTx
+	}
+	return DecodeRawTx(raw)
+}
+
+// Deserialize a transaction, with or without witness data (BIP144)
+func DecodeRawTx(raw []byte) (tx *RawTx, err error) {
+	r := &rawTxReader{data: raw}
+	tx = &RawTx{Size: len(raw), Version: r.uint32()}
+	// Marker and flag of the witness serialization
+	witness := false
+	if len(raw) > r.pos+1 && raw[r.pos] == 0 && raw[r.pos+1] == 1 {
+		witness = true
+		r.pos += 2
+	}
+	// Smallest input: outpoint (36) + script length (1) + sequence (4)
+	txincnt := r.count(41)
+	for i := 0; i < txincnt; i++ {
+		txi := &RawTxIn{PrevHash: reverseHash(r.read(32)), PrevIndex: r.uint32()}
+		txi.Script = r.varBytes()
+		txi.Sequence = r.uint32()
+		tx.TxIns = append(tx.TxIns, txi)
+	}
+	// Smallest output: value (8) + script length (1)
+	txoutcnt := r.count(9)
+	totalout := uint64(0)
+	for i := 0; i < txoutcnt; i++ {
+		txo := &RawTxOut{Value: binary.LittleEndian.Uint64(r.read(8))}
+		txo.Script = r.varBytes()
+		if totalout, err = addValue(totalout, txo.Value); err != nil {
+			return nil, err
+		}
+		tx.TxOuts = append(tx.TxOuts, txo)
+	}
+	if witness {
+		for _, txi := range tx.TxIns {
+			itemcnt := r.count(1)
+			for i := 0; i < itemcnt; i++ {
+				txi.Witness = append(txi.Witness, r.varBytes())
+			}
+		}
+	}
+	tx.LockTime = r.uint32()
+	if r.err != nil || r.pos != len(raw) || len(tx.TxIns) == 0 || len(tx.TxOuts) == 0 {
+		return nil, ErrInvalidRawTx
+	}
+	hash := sha256.Sum256(tx.serialize())
+	hash = sha256.Sum256(hash[:])
+	tx.Hash = reverseHash(hash[:])
+	return
+}
+
+// Checked sum of two amounts, ErrInvalidRawTx past MaxMoney
+func addValue(total, value uint64) (uint64, error) {
+	if value > MaxMoney || total > MaxMoney-value {
+		return 0, ErrInvalidRawTx
+	}
+	return total + value, nil
+}
+
+func writeVarInt(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xfd)
+		binary.Write(buf, binary.LittleEndian, uint16(n))
+	case n <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		binary.Write(buf, binary.LittleEndian, uint32(n))
+	default:
+		buf.WriteByte(0xff)
+		binary.Write(buf, binary.LittleEndian, n)
+	}
+}
+
+// Serialization without witness data, used to compute the hash
+func (tx *RawTx) serialize() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, tx.Version)
+	writeVarInt(&buf, uint64(len(tx.TxIns)))
+	for _, txi := range tx.TxIns {
+		prevhash, _ := hex.DecodeString(txi.PrevHash)
+		for i := len(prevhash) - 1; i >= 0; i-- {
+			buf.WriteByte(prevhash[i])
+		}
+		binary.Write(&buf, binary.LittleEndian, txi.PrevIndex)
+		writeVarInt(&buf, uint64(len(txi.Script)))
+		buf.Write(txi.Script)
+		binary.Write(&buf, binary.LittleEndian, txi.Sequence)
+	}
+	writeVarInt(&buf, uint64(len(tx.TxOuts)))
+	for _, txo := range tx.TxOuts {
+		binary.Write(&buf, binary.LittleEndian, txo.Value)
+		writeVarInt(&buf, uint64(len(txo.Script)))
+		buf.Write(txo.Script)
+	}
+	binary.Write(&buf, binary.LittleEndian, tx.LockTime)
+	return buf.Bytes()
+}
+
+// Check if the transaction is a generation transaction (single input with a null outpoint)
+func (tx *RawTx) IsCoinbase() bool {
+	return len(tx.TxIns) == 1 && tx.TxIns[0].PrevIndex == 0xffffffff &&
+		tx.TxIns[0].PrevHash == "0000000000000000000000000000000000000000000000000000000000000000"
+}
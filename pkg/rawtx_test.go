@@ -0,0 +1,91 @@
+package btcplex
+
+import (
+	"strings"
+	"testing"
+)
+
+// Genesis block generation transaction
+const genesisRawTx = "01000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000"
+
+func TestDecodeRawTx(t *testing.T) {
+	tx, err := DecodeRawTxHex(genesisRawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Hash != GenesisTx {
+		t.Errorf("Hash = %v, want %v", tx.Hash, GenesisTx)
+	}
+	if !tx.IsCoinbase() || len(tx.TxOuts) != 1 || tx.TxOuts[0].Value != 50*COIN || len(tx.TxOuts[0].Script) != 67 {
+		t.Errorf("unexpected decoded tx %+v", tx)
+	}
+	if tx.TxIns[0].Sequence != 0xffffffff || tx.Size != len(genesisRawTx)/2 {
+		t.Errorf("unexpected decoded txin %+v", tx.TxIns[0])
+	}
+
+	// Same transaction with a witness (BIP144), the hash doesn't change
+	witnesstx := genesisRawTx[:8] + "0001" + genesisRawTx[8:len(genesisRawTx)-8] + "0102abcd" + genesisRawTx[len(genesisRawTx)-8:]
+	tx, err = DecodeRawTxHex(witnesstx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Hash != GenesisTx || len(tx.TxIns[0].Witness) != 1 || len(tx.TxIns[0].Witness[0]) != 2 {
+		t.Errorf("unexpected decoded witness tx %+v", tx)
+	}
+}
+
+func TestDecodeRawTxInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"zz",
+		genesisRawTx[:len(genesisRawTx)-2],
+		genesisRawTx + "00",
+		// Huge input count
+		"01000000ffffffffff" + strings.Repeat("00", 50),
+		// Output above the coins supply
+		strings.Replace(genesisRawTx, "0100f2052a01000000", "01ffffffffffffffff", 1),
+	}
+	for _, test := range tests {
+		if _, err := DecodeRawTxHex(test); err != ErrInvalidRawTx {
+			t.Errorf("DecodeRawTxHex(%.20v...) error = %v, want ErrInvalidRawTx", test, err)
+		}
+	}
+}
+
+func TestAddValue(t *testing.T) {
+	if total, err := addValue(MaxMoney-1, 1); total != MaxMoney || err != nil {
+		t.Errorf("addValue(MaxMoney-1, 1) = %v, %v", total, err)
+	}
+	for _, values := range [][2]uint64{{MaxMoney, 1}, {1, 1<<64 - 1}, {1<<64 - 1, 1<<64 - 1}} {
+		if _, err := addValue(values[0], values[1]); err != ErrInvalidRawTx {
+			t.Errorf("addValue(%v, %v) error = %v, want ErrInvalidRawTx", values[0], values[1], err)
+		}
+	}
+}
+
+func TestNewDecodedTx(t *testing.T) {
+	tx, _ := DecodeRawTxHex(genesisRawTx)
+	dtx := NewDecodedTx(tx)
+	if !dtx.Coinbase || dtx.RBF || dtx.TxIns[0].PrevOut != nil || dtx.TotalOut != 50*COIN {
+		t.Errorf("unexpected decoded tx %+v", dtx)
+	}
+	txo := dtx.TxOuts[0]
+	if txo.Type != ScriptPubKey || len(txo.Addresses) != 1 || txo.Addresses[0] != "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn" {
+		t.Errorf("unexpected decoded txout %+v", txo)
+	}
+	if txo.ScriptAsm != genesisPubKey+" OP_CHECKSIG" {
+		t.Errorf("ScriptAsm = %v", txo.ScriptAsm)
+	}
+}
+
+func TestDecodedTxTx(t *testing.T) {
+	raw, _ := DecodeRawTxHex(genesisRawTx)
+	tx := NewDecodedTx(raw).Tx()
+	if tx.Hash != GenesisTx || tx.TxInCnt != 1 || tx.TxOutCnt != 1 || tx.TotalOut != 50*COIN || int(tx.Size) != len(genesisRawTx)/2 {
+		t.Errorf("unexpected tx %+v", tx)
+	}
+	txo := tx.TxOuts[0]
+	if txo.Addr != "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn" || txo.Value != 50*COIN || txo.Spent == nil || txo.Spent.Spent {
+		t.Errorf("unexpected txout %+v", txo)
+	}
+}
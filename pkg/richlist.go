@@ -0,0 +1,165 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Sorted set of address balances (in satoshis), ranking addresses for the rich list,
+// not a valid address since "l" isn't part of the base58 alphabet
+const BalancesKey = "addr:balances"
+
+// Only the top addresses can be browsed
+const MaxRichListSize = 10000
+
+// Upper bounds (in satoshis) of the distribution buckets, from 0.001 to 10M coins
+var DistributionBounds = []uint64{1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15}
+
+type RichListEntry struct {
+	Rank    int    `json:"rank"`
+	Address string `json:"address"`
+	Balance uint64 `json:"balance"`
+}
+
+// Addresses holding a balance in [From, To), To is 0 for the last bucket
+type DistributionBucket struct {
+	From      uint64 `json:"from"`
+	To        uint64 `json:"to"`
+	Addresses int    `json:"addresses"`
+}
+
+// Update the totals (field "tr" or "ts") of an address along with its balance in the rich list,
+// outputs without address (nonstandard scripts) aren't tracked
+func IncrAddressTotal(c redis.Conn, address string, field string, value int64) (err error) {
+	if address == "" {
+		return
+	}
+	if _, err = c.Do("HINCRBY", fmt.Sprintf("addr:%v:h", address), field, value); err != nil {
+		return
+	}
+	if field == "ts" {
+		value = -value
+	}
+	_, err = c.Do("ZINCRBY", BalancesKey, value, address)
+	return
+}
+
+// Number of addresses with a positive balance
+func GetFundedAddressesCount(rpool *redis.Pool) (count int, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	return redis.Int(c.Do("ZCOUNT", BalancesKey, 1, "+inf"))
+}
+
+// Return the richest addresses, from start to stop (both included, 0 being the richest)
+func GetRichList(rpool *redis.Pool, start, stop int) (entries []*RichListEntry, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	entries = []*RichListEntry{}
+	values, err := redis.Values(c.Do("ZREVRANGE", BalancesKey, start, stop, "WITHSCORES"))
+	if err != nil {
+		return
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		address, _ := redis.String(values[i], nil)
+		balance, _ := redis.Int64(values[i+1], nil)
+		if balance <= 0 {
+			break
+		}
+		entries = append(entries, &RichListEntry{Rank: start + i/2 + 1, Address: address, Balance: uint64(balance)})
+	}
+	return
+}
+
+// Count funded addresses by balance range
+func GetDistribution(rpool *redis.Pool) (buckets []*DistributionBucket, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	buckets = []*DistributionBucket{}
+	from := uint64(1)
+	for i := 0; i <= len(DistributionBounds); i++ {
+		bucket := &DistributionBucket{From: from}
+		max := "+inf"
+		if i < len(DistributionBounds) {
+			bucket.To = DistributionBounds[i]
+			max = fmt.Sprintf("%v", bucket.To-1)
+		}
+		if bucket.Addresses, err = redis.Int(c.Do("ZCOUNT", BalancesKey, from, max)); err != nil {
+			return
+		}
+		buckets = append(buckets, bucket)
+		from = bucket.To
+	}
+	buckets[0].From = 0
+	return
+}
+
+// Rebuild the balances sorted set from the addresses totals, addresses are collected
+// from the outputs of every main chain block up to the latest height, progress is
+// reported every 1000 blocks
+func RebuildRichList(rpool *redis.Pool, progress func(height int, addresses int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	addresses := map[string]bool{}
+	for height := 0; height <= latest; height++ {
+		hash, herr := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+		if herr != nil {
+			return herr
+		}
+		txskeys, terr := redis.Strings(c.Do("ZRANGE", fmt.Sprintf("block:%v:txs", hash), 0, -1))
+		if terr != nil {
+			return terr
+		}
+		txskeysi := []interface{}{}
+		for _, txkey := range txskeys {
+			txskeysi = append(txskeysi, txkey)
+		}
+		if len(txskeysi) == 0 {
+			continue
+		}
+		txsjson, _ := redis.Strings(c.Do("MGET", txskeysi...))
+		for _, txjson := range txsjson {
+			tx := new(Tx)
+			if err = json.Unmarshal([]byte(txjson), tx); err != nil {
+				return
+			}
+			if tx.TxOutCnt == 0 {
+				continue
+			}
+			txokeys := []interface{}{}
+			for i := uint32(0); i < tx.TxOutCnt; i++ {
+				txokeys = append(txokeys, fmt.Sprintf("txo:%v:%v", tx.Hash, i))
+			}
+			txosjson, _ := redis.Strings(c.Do("MGET", txokeys...))
+			for _, txojson := range txosjson {
+				txo := new(TxOut)
+				if json.Unmarshal([]byte(txojson), txo) == nil && txo.Addr != "" {
+					addresses[txo.Addr] = true
+				}
+			}
+		}
+		if progress != nil && height%1000 == 0 {
+			progress(height, len(addresses))
+		}
+	}
+	for address := range addresses {
+		addressh := new(AddressHash)
+		v, verr := redis.Values(c.Do("HGETALL", fmt.Sprintf("addr:%v:h", address)))
+		if verr != nil {
+			return verr
+		}
+		if err = redis.ScanStruct(v, addressh); err != nil {
+			return
+		}
+		if _, err = c.Do("ZADD", BalancesKey, int64(addressh.TotalReceived)-int64(addressh.TotalSent), address); err != nil {
+			return
+		}
+	}
+	return
+}
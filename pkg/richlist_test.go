@@ -0,0 +1,44 @@
+package btcplex
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Record the commands sent, replies are always nil
+type recordConn struct {
+	cmds []string
+}
+
+func (c *recordConn) Close() error                      { return nil }
+func (c *recordConn) Err() error                        { return nil }
+func (c *recordConn) Send(string, ...interface{}) error { return nil }
+func (c *recordConn) Flush() error                      { return nil }
+func (c *recordConn) Receive() (interface{}, error)     { return nil, nil }
+
+func (c *recordConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.cmds = append(c.cmds, fmt.Sprint(append([]interface{}{cmd}, args...)...))
+	return nil, nil
+}
+
+func TestIncrAddressTotal(t *testing.T) {
+	c := new(recordConn)
+	IncrAddressTotal(c, "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", "tr", 5000)
+	IncrAddressTotal(c, "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", "ts", 3000)
+	// Reverted spend
+	IncrAddressTotal(c, "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", "ts", -3000)
+	// Nonstandard output
+	IncrAddressTotal(c, "", "tr", 1000)
+	want := []string{
+		fmt.Sprint("HINCRBY", "addr:MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn:h", "tr", int64(5000)),
+		fmt.Sprint("ZINCRBY", BalancesKey, int64(5000), "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"),
+		fmt.Sprint("HINCRBY", "addr:MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn:h", "ts", int64(3000)),
+		fmt.Sprint("ZINCRBY", BalancesKey, int64(-3000), "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"),
+		fmt.Sprint("HINCRBY", "addr:MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn:h", "ts", int64(-3000)),
+		fmt.Sprint("ZINCRBY", BalancesKey, int64(3000), "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"),
+	}
+	if !reflect.DeepEqual(c.cmds, want) {
+		t.Errorf("commands = %q, want %q", c.cmds, want)
+	}
+}
@@ -24,13 +24,11 @@ func CallBitcoinRPC(address string, method string, id interface{}, params []inte
 		"params": params,
 	})
 	if err != nil {
-		log.Fatalf("Marshal: %v", err)
 		return nil, err
 	}
 	resp, err := http.Post(address,
 		"application/json", strings.NewReader(string(data)))
 	if err != nil {
-		log.Fatalf("Post: %v", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -45,7 +43,6 @@ func CallBitcoinRPC(address string, method string, id interface{}, params []inte
 	err = decoder.Decode(&result)
 	//err = json.Unmarshal(body, &result)
 	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
 		return nil, err
 	}
 	return result, nil
@@ -57,17 +54,24 @@ func GetBlockHashRPC(conf *Config, height uint) string {
 	if err != nil {
 		return ""
 	}
-	return res["result"].(string)
+	hash, _ := res["result"].(string)
+	return hash
 }
 
-func GetBlockCountRPC(conf *Config) uint {
-	// Get the block hash
+func GetBlockCountRPC(conf *Config) (count uint, err error) {
 	res, err := CallBitcoinRPC(conf.BitcoindRpcUrl, "getblockcount", 1, []interface{}{})
 	if err != nil {
-		return uint(0)
+		return
+	}
+	if err = rpcResponseError(res); err != nil {
+		return
 	}
-	count, _ := res["result"].(json.Number).Int64()
-	return uint(count)
+	countjson, isnumber := res["result"].(json.Number)
+	if !isnumber {
+		return 0, errors.New("Unexpected RPC result")
+	}
+	count64, err := countjson.Int64()
+	return uint(count64), err
 }
 
 type BitcoindInfo struct {
@@ -88,29 +92,24 @@ func GetInfoRPC(conf *Config) (bitcoindinfo *BitcoindInfo, err error) {
 	if err != nil {
 		return
 	}
-	jsoninfo := res["result"].(map[string]interface{})
+	jsoninfo, err := rpcResultObject(res)
+	if err != nil {
+		return
+	}
 	bitcoindinfo.ProtocolVersion, _ = jsoninfo["protocolversion"].(json.Number).Int64()
 	bitcoindinfo.Version, _ = jsoninfo["version"].(json.Number).Int64()
 	bitcoindinfo.Blocks, _ = jsoninfo["blocks"].(json.Number).Int64()
 	bitcoindinfo.TimeOffset, _ = jsoninfo["timeoffset"].(json.Number).Int64()
 	bitcoindinfo.Connections, _ = jsoninfo["connections"].(json.Number).Int64()
 	bitcoindinfo.Difficulty, _ = jsoninfo["difficulty"].(json.Number).Float64()
-	bitcoindinfo.Proxy = jsoninfo["proxy"].(string)
-	bitcoindinfo.Testnet = jsoninfo["testnet"].(bool)
-	bitcoindinfo.Errors = jsoninfo["errors"].(string)
+	bitcoindinfo.Proxy, _ = jsoninfo["proxy"].(string)
+	bitcoindinfo.Testnet, _ = jsoninfo["testnet"].(bool)
+	bitcoindinfo.Errors, _ = jsoninfo["errors"].(string)
 	return
 }
 
-func SaveBlockFromRPC(conf *Config, pool *redis.Pool, hash string) (block *Block, err error) {
-	c := pool.Get()
-	defer c.Close()
-	var wg sync.WaitGroup
-	sem := make(chan bool, 5)
-	// Get the block hash
-	//res, err := CallBitcoinRPC(conf.BitcoindRpcUrl, "getblockhash", 1, []interface{}{block_height})
-	//if err != nil {
-	//	log.Fatalf("Err: %v", err)
-	//}
+// Fetch a block along with its transaction ids
+func getBlockRPC(conf *Config, hash string) (block *Block, txids []string, err error) {
 	res, err := CallBitcoinRPC(conf.BitcoindRpcUrl, "getblock", 1, []interface{}{hash})
 	if err != nil {
 		return
@@ -125,42 +124,7 @@ func SaveBlockFromRPC(conf *Config, pool *redis.Pool, hash string) (block *Block
 	block.Hash = blockjson["hash"].(string)
 	bheight, _ := blockjson["height"].(json.Number).Int64()
 	block.Height = uint(bheight)
-	block.Parent = blockjson["previousblockhash"].(string)
-
-	prevheight := block.Height - 1
-	prevhashtest := block.Parent
-	prevnext := block.Hash
-	for {
-		prevkey := fmt.Sprintf("height:%v", prevheight)
-		prevcnt, _ := redis.Int(c.Do("ZCARD", prevkey))
-		// SSDB doesn't support negative slice yet
-		prevs, _ := redis.Strings(c.Do("ZRANGE", prevkey, 0, prevcnt-1))
-		if len(prevs) == 0 {
-			break
-		}
-		for _, cprevhash := range prevs {
-			if cprevhash == prevhashtest {
-				// current block parent
-				prevhashtest, _ = redis.String(c.Do("HGET", fmt.Sprintf("block:%v:h", cprevhash), "parent"))
-				// Set main to 1 and the next => prevnext
-				c.Do("HMSET", fmt.Sprintf("block:%v:h", cprevhash), "main", true, "next", prevnext)
-				c.Do("SET", fmt.Sprintf("block:height:%v", prevheight), cprevhash)
-				prevnext = cprevhash
-			} else {
-				// Set main to 0
-				c.Do("HSET", fmt.Sprintf("block:%v:h", cprevhash), "main", false)
-				oblock, _ := GetBlockCachedByHash(pool, cprevhash)
-				for _, otx := range oblock.Txs {
-					otx.Revert(pool)
-				}
-			}
-		}
-		if len(prevs) == 1 {
-			break
-		}
-		prevheight--
-	}
-
+	block.Parent, _ = blockjson["previousblockhash"].(string)
 	vertmp, _ := blockjson["version"].(json.Number).Int64()
 	block.Version = uint32(vertmp)
 	block.MerkleRoot = blockjson["merkleroot"].(string)
@@ -172,29 +136,114 @@ func SaveBlockFromRPC(conf *Config, pool *redis.Pool, hash string) (block *Block
 	block.BlockTime = uint32(btimetmp)
 	blockbits, _ := strconv.ParseInt(blockjson["bits"].(string), 16, 0)
 	block.Bits = uint32(blockbits)
-	block.TxCnt = uint32(len(blockjson["tx"].([]interface{})))
+	for _, txid := range blockjson["tx"].([]interface{}) {
+		txids = append(txids, txid.(string))
+	}
+	block.TxCnt = uint32(len(txids))
+	return
+}
+
+// Save a block, the main chain only switches to its branch if it has more work,
+// otherwise the block is kept as a side branch tip and its transactions aren't indexed
+func SaveBlockFromRPC(conf *Config, pool *redis.Pool, hash string) (block *Block, err error) {
+	c := pool.Get()
+	defer c.Close()
+	if known, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("block:%v", hash))); known {
+		if block, err = GetBlockCachedByHash(pool, hash); err == nil {
+			block.FetchMeta(pool)
+		}
+		return
+	}
+	block, txids, err := getBlockRPC(conf, hash)
+	if err != nil {
+		return
+	}
+	// Only the new tip is notified after a reorg, save the missing ancestors first
+	if block.Height > 0 {
+		if known, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("block:%v", block.Parent))); !known {
+			if _, err = SaveBlockFromRPC(conf, pool, block.Parent); err != nil {
+				return
+			}
+		}
+	}
+	besthash := GetBestBlockHash(c)
+	SaveBlockHeader(c, block)
+	if !IsBestChain(c, block, besthash) {
+		c.Do("HSET", fmt.Sprintf("block:%v:h", block.Hash), "main", false)
+		blockjson, _ := json.Marshal(block)
+		c.Do("MSET", fmt.Sprintf("block:%v", block.Hash), blockjson, fmt.Sprintf("block:%v:cached", block.Hash), blockjson)
+		return
+	}
+	if block.Parent != besthash {
+		if err = reorganizeRPC(conf, pool, c, block); err != nil {
+			return
+		}
+	}
+	err = connectBlockRPC(conf, pool, c, block, txids)
+	return
+}
+
+// Disconnect the main chain blocks above the fork point and connect the new branch, up to the block parent
+func reorganizeRPC(conf *Config, pool *redis.Pool, c redis.Conn, block *Block) (err error) {
+	branch, forkheight, err := FindFork(pool, block.Parent)
+	if err != nil {
+		return
+	}
+	log.Printf("Reorganize: fork at height %v, connecting %v blocks\n", forkheight, len(branch)+1)
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	for height := uint(latest); height > forkheight; height-- {
+		hash, herr := redis.String(c.Do("GET", fmt.Sprintf("block:height:%v", height)))
+		if herr != nil {
+			return herr
+		}
+		if err = DisconnectBlock(pool, c, hash); err != nil {
+			return
+		}
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		bblock, txids, berr := getBlockRPC(conf, branch[i])
+		if berr != nil {
+			return berr
+		}
+		if err = connectBlockRPC(conf, pool, c, bblock, txids); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Index the block transactions and make it the tip of the main chain
+func connectBlockRPC(conf *Config, pool *redis.Pool, c redis.Conn, block *Block, txids []string) (err error) {
+	var wg sync.WaitGroup
+	sem := make(chan bool, 5)
 	tout := uint64(0)
 	txs := []*Tx{}
 	var txmut sync.Mutex
-	for txindex, txjson := range blockjson["tx"].([]interface{}) {
+	for txindex, txid := range txids {
 		sem <- true
 		wg.Add(1)
-		go func(txjson interface{}, tout *uint64, block *Block, txs *[]*Tx) {
+		go func(txid string, txindex int, tout *uint64, block *Block, txs *[]*Tx) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			tx, _ := SaveTxFromRPC(conf, pool, txjson.(string), block, txindex)
+			tx, _ := SaveTxFromRPC(conf, pool, txid, block, txindex)
 			//(conf *Config, pool *redis.Pool, tx_id string, block *Block, tx_index int)
 			atomic.AddUint64(tout, tx.TotalOut)
 			txmut.Lock()
 			*txs = append(*txs, tx)
 			txmut.Unlock()
-		}(txjson, &tout, block, &txs)
+		}(txid, txindex, &tout, block, &txs)
 	}
 	wg.Wait()
 	block.TotalBTC = uint64(tout)
+	block.Main = true
 
-	c.Do("ZADD", fmt.Sprintf("height:%v", block.Height), block.BlockTime, block.Hash)
-	c.Do("HMSET", fmt.Sprintf("block:%v:h", block.Hash), "parent", block.Parent, "height", block.Height, "main", true)
+	c.Do("HSET", fmt.Sprintf("block:%v:h", block.Hash), "main", true)
+	if block.Parent != "" {
+		c.Do("HSET", fmt.Sprintf("block:%v:h", block.Parent), "next", block.Hash)
+	}
 	blockjson2, _ := json.Marshal(block)
 	c.Do("ZADD", "blocks", block.BlockTime, block.Hash)
 	c.Do("MSET", fmt.Sprintf("block:%v", block.Hash), blockjson2, "height:latest", int(block.Height), fmt.Sprintf("block:height:%v", block.Height), block.Hash)
@@ -202,6 +251,11 @@ func SaveBlockFromRPC(conf *Config, pool *redis.Pool, hash string) (block *Block
 	block.Txs = txs
 	fullblockjson, _ := json.Marshal(block)
 	c.Do("SET", fmt.Sprintf("block:%v:cached", block.Hash), fullblockjson)
+	parenttime := uint32(0)
+	if parent, perr := GetBlockByHash(pool, block.Parent); perr == nil {
+		parenttime = parent.BlockTime
+	}
+	SaveBlockStats(c, block.Hash, NewBlockStats(block, parenttime))
 	return
 }
 
@@ -275,6 +329,8 @@ func GetTxRPC(conf *Config, tx_id string, block *Block) (tx *Tx, err error) {
 			txinjsonprevout.Hash = txijson.(map[string]interface{})["txid"].(string)
 			tmpvout, _ := txijson.(map[string]interface{})["vout"].(json.Number).Int64()
 			txinjsonprevout.Vout = uint32(tmpvout)
+			tmpseq, _ := txijson.(map[string]interface{})["sequence"].(json.Number).Int64()
+			txi.Sequence = uint32(tmpseq)
 
 			// Check if bitcoind is patched to fetch value/address without additional RPC call
 			// cf. README
@@ -324,6 +380,7 @@ func GetTxRPC(conf *Config, tx_id string, block *Block) (tx *Tx, err error) {
 	tx.TxInCnt = uint32(len(tx.TxIns))
 	tx.TotalOut = uint64(total_tx_out)
 	tx.TotalIn = uint64(total_tx_in)
+	tx.RBF = tx.SignalsRBF()
 	return
 }
 
@@ -376,6 +433,8 @@ func SaveTxFromRPC(conf *Config, pool *redis.Pool, tx_id string, block *Block, t
 				txinjsonprevout.Hash = txijson.(map[string]interface{})["txid"].(string)
 				tmpvout, _ := txijson.(map[string]interface{})["vout"].(json.Number).Int64()
 				txinjsonprevout.Vout = uint32(tmpvout)
+				tmpseq, _ := txijson.(map[string]interface{})["sequence"].(json.Number).Int64()
+				txi.Sequence = uint32(tmpseq)
 
 				// Check if bitcoind is patched to fetch value/address without additional RPC call
 				// cf. README
@@ -416,7 +475,8 @@ func SaveTxFromRPC(conf *Config, pool *redis.Pool, tx_id string, block *Block, t
 
 				c.Do("ZADD", fmt.Sprintf("addr:%v", txinjsonprevout.Address), block.BlockTime, tx.Hash)
 				c.Do("ZADD", fmt.Sprintf("addr:%v:sent", txinjsonprevout.Address), block.BlockTime, tx.Hash)
-				c.Do("HINCRBY", fmt.Sprintf("addr:%v:h", txinjsonprevout.Address), "ts", txinjsonprevout.Value)
+				UnindexUnspent(c, txinjsonprevout.Address, txinjsonprevout.Hash, txinjsonprevout.Vout)
+				IncrAddressTotal(c, txinjsonprevout.Address, "ts", int64(txinjsonprevout.Value))
 
 			}(pool, txijson, txiindex, &total_tx_in, tx, block)
 		}
@@ -460,7 +520,9 @@ func SaveTxFromRPC(conf *Config, pool *redis.Pool, tx_id string, block *Block, t
 			//conn.Send("ZADD", fmt.Sprintf("txo:%v", tx.Hash), txo_index, ntxokey)
 			c.Do("ZADD", fmt.Sprintf("addr:%v", txo.Addr), block.BlockTime, tx.Hash)
 			c.Do("ZADD", fmt.Sprintf("addr:%v:received", txo.Addr), block.BlockTime, tx.Hash)
-			c.Do("HINCRBY", fmt.Sprintf("addr:%v:h", txo.Addr), "tr", txo.Value)
+			IndexUnspent(c, txo.Addr, tx.Hash, uint32(txo_index), block.BlockTime)
+			IncrAddressTotal(c, txo.Addr, "tr", int64(txo.Value))
+			IndexScriptHash(c, txo.Addr)
 		}(pool, txojson, txo_index, &total_tx_out, tx, block)
 
 	}
@@ -485,8 +547,15 @@ func GetRawMemPoolRPC(conf *Config) (unconfirmedtxs []string, err error) {
 	if err != nil {
 		return
 	}
+	if err = rpcResponseError(res); err != nil {
+		return
+	}
+	txids, islist := res["result"].([]interface{})
+	if !islist {
+		return nil, errors.New("Unexpected RPC result")
+	}
 	unconfirmedtxs = []string{}
-	for _, txid := range res["result"].([]interface{}) {
+	for _, txid := range txids {
 		unconfirmedtxs = append(unconfirmedtxs, txid.(string))
 	}
 	return
@@ -497,6 +566,67 @@ func GetRawMemPoolVerboseRPC(conf *Config) (unconfirmedtxs map[string]interface{
 	if err != nil {
 		return
 	}
-	unconfirmedtxs = res["result"].(map[string]interface{})
+	return rpcResultObject(res)
+}
+
+// Error returned by bitcoind (e.g. a rejected transaction)
+type RPCError struct {
+	Code    int64
+	Message string
+}
+
+func (rpcerr *RPCError) Error() string {
+	return fmt.Sprintf("RPC error %v: %v", rpcerr.Code, rpcerr.Message)
+}
+
+// RPC error of a bitcoind response, nil if the call succeeded
+func rpcResponseError(res map[string]interface{}) error {
+	if rpcerr, iserr := res["error"].(map[string]interface{}); iserr {
+		code, _ := rpcerr["code"].(json.Number).Int64()
+		message, _ := rpcerr["message"].(string)
+		return &RPCError{Code: code, Message: message}
+	}
+	return nil
+}
+
+// Result object of a bitcoind response, or the RPC error
+func rpcResultObject(res map[string]interface{}) (result map[string]interface{}, err error) {
+	if err = rpcResponseError(res); err != nil {
+		return
+	}
+	result, isobject := res["result"].(map[string]interface{})
+	if !isobject {
+		err = errors.New("Unexpected RPC result")
+	}
+	return
+}
+
+// Relay a signed transaction, return its hash
+func SendRawTransactionRPC(conf *Config, rawtx string) (txid string, err error) {
+	res, err := CallBitcoinRPC(conf.BitcoindRpcUrl, "sendrawtransaction", 1, []interface{}{rawtx})
+	if err != nil {
+		return
+	}
+	if err = rpcResponseError(res); err != nil {
+		return
+	}
+	txid, _ = res["result"].(string)
 	return
 }
+
+// Fetch a transaction (confirmed or in the memory pool) from bitcoind, as hex
+// or decoded if verbose
+func GetRawTxRPC(conf *Config, txid string, verbose bool) (rawtx interface{}, err error) {
+	verbosity := 0
+	if verbose {
+		verbosity = 1
+	}
+	res, err := CallBitcoinRPC(conf.BitcoindRpcUrl, "getrawtransaction", 1, []interface{}{txid, verbosity})
+	if err != nil {
+		return
+	}
+	if err = rpcResponseError(res); err != nil {
+		return
+	}
+	return res["result"], nil
+}
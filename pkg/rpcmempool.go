@@ -6,13 +6,14 @@ import (
 	"github.com/garyburd/redigo/redis"
 	_ "io/ioutil"
 	"log"
+	"strconv"
 	"sync"
 	"time"
 )
 
 // Get unconfirmed transactions from memory pool, along with
 // first seem time/block height, requires a recent bitcoind version
-func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
+func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, spool *redis.Pool, running *bool) {
 	var wg sync.WaitGroup
 	var lastts, cts int64
 	var lastkey, ckey string
@@ -22,28 +23,44 @@ func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
 	c := pool.Get()
 	defer c.Close()
 
-	// Cleanup old keys since it has stopped
-	oldkeys, _ := redis.Strings(c.Do("ZRANGE", "btcplex:rawmempool", 0, -1))
-	c.Do("DEL", redis.Args{}.AddFlat(oldkeys)...)
-	c.Do("DEL", "btcplex:rawmempool")
-
 	// We fetch 25 tx max in the pool
 	sem := make(chan bool, 25)
 
+	reconciled := false
 	for {
 		if !*running {
 			log.Println("Stopping ProcessUnconfirmedTxs")
 			break
 		}
 
+		// Reuse the memory pool stored before the last shutdown, once bitcoind answers
+		if !reconciled {
+			var err error
+			if lastkey, lastts, err = ReconcileUnconfirmedTxs(conf, pool); err != nil {
+				log.Printf("Can't reconcile the memory pool: %v\n", err)
+				time.Sleep(1 * time.Second)
+				continue
+			}
+			reconciled = true
+		}
+
 		cts = time.Now().UTC().Unix()
 		ckey = fmt.Sprintf("btcplex:rawmempool:%v", cts)
 
 		//log.Printf("lastkey:%+v, ckey:%+v\n", lastkey, ckey)
 
-		// Call bitcoind RPC
-		unconfirmedtxsverbose, _ := GetRawMemPoolVerboseRPC(conf)
-		unconfirmedtxs, _ := GetRawMemPoolRPC(conf)
+		// Call bitcoind RPC, a failed call would look like an empty memory pool
+		unconfirmedtxsverbose, verr := GetRawMemPoolVerboseRPC(conf)
+		unconfirmedtxs, err := GetRawMemPoolRPC(conf)
+		if verr != nil || err != nil {
+			log.Printf("Can't fetch the memory pool: %v %v\n", verr, err)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		mempool := make(map[string]struct{}, len(unconfirmedtxs))
+		for _, txid := range unconfirmedtxs {
+			mempool[txid] = struct{}{}
+		}
 
 		for _, txid := range unconfirmedtxs {
 			wg.Add(1)
@@ -64,8 +81,7 @@ func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
 						tx.FirstSeenTime = uint32(fseentime)
 						fseenheight, _ := txmeta["height"].(json.Number).Int64()
 						tx.FirstSeenHeight = uint(fseenheight)
-						txjson, _ := json.Marshal(tx)
-						c.Do("SET", txkey, string(txjson))
+						SaveUnconfirmedTx(pool, spool, tx, mempool)
 					}
 					c.Do("ZADD", "btcplex:rawmempool", fseentime, txkey)
 					// Put the TX in a snapshot do detect deleted tx
@@ -78,8 +94,7 @@ func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
 			// We remove tx that are no longer in the pool using the last snapshot
 			dkeys, _ := redis.Strings(c.Do("SDIFF", lastkey, ckey))
 			//log.Printf("Deleting %v utxs\n", len(dkeys))
-			c.Do("DEL", redis.Args{}.AddFlat(dkeys)...)
-			c.Do("ZREM", redis.Args{}.Add("btcplex:rawmempool").AddFlat(dkeys)...)
+			removeUnconfirmedTxs(pool, dkeys)
 			c.Do("DEL", lastkey)
 			// Since getrawmempool return transaction sorted by name, we replay them sorted by time asc
 			newkeys, _ := redis.Strings(c.Do("ZRANGEBYSCORE", "btcplex:rawmempool", fmt.Sprintf("(%v", lastts), cts))
@@ -87,13 +102,13 @@ func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
 				txjson, _ := redis.String(c.Do("GET", newkey))
 				ctx := new(Tx)
 				json.Unmarshal([]byte(txjson), ctx)
-				// Notify SSE unconfirmed transactions
-				alreadypublished, _ := redis.Bool(c.Do("EXISTS", fmt.Sprintf("btcplex:utx:%v:published", ctx.Hash)))
-				if !alreadypublished {
-					c.Do("PUBLISH", "btcplex:utxs", txjson)
+				// Notify SSE unconfirmed transactions, the published flag is set atomically
+				// so a transaction is never published twice (even across restarts)
+				notpublished, _ := redis.String(c.Do("SET", fmt.Sprintf("btcplex:utx:%v:published", ctx.Hash), cts, "EX", 3600*20, "NX"))
+				if notpublished == "OK" {
+					PublishEvent(c, "btcplex:utxs", txjson)
 					// Notify transaction to every channel address
 					multiPublishScript.Do(c, redis.Args{}.Add(txjson).AddFlat(ctx.AddressesChannels())...)
-					c.Do("SETEX", fmt.Sprintf("btcplex:utx:%v:published", ctx.Hash), 3600*20, cts)
 					//c.Do("SADD", "btcplex:utxs:published", ctx.Hash)
 				}
 
@@ -103,17 +118,134 @@ func ProcessUnconfirmedTxs(conf *Config, pool *redis.Pool, running *bool) {
 		}
 		lastkey = ckey
 		lastts = cts
+		// Keep track of the last snapshot, needed by the reconciliation at startup
+		c.Do("HMSET", "btcplex:rawmempool:state", "snapshot", lastkey, "ts", lastts)
 		time.Sleep(1 * time.Second)
 	}
 }
 
+// Store a new unconfirmed transaction along with its indexes (the btcplex:rawmempool
+// entry is left to the caller), mempool holds the hashes of bitcoind memory pool
+func SaveUnconfirmedTx(pool *redis.Pool, spool *redis.Pool, tx *Tx, mempool map[string]struct{}) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	txjson, err := json.Marshal(tx)
+	if err != nil {
+		return
+	}
+	if _, err = c.Do("SET", fmt.Sprintf("btcplex:utx:%v", tx.Hash), string(txjson)); err != nil {
+		return
+	}
+	IndexUnconfirmedAddresses(pool, tx)
+	IndexUnconfirmedFeeRate(pool, tx)
+	SaveFirstSeenHeight(pool, tx)
+	// So Electrum clients can subscribe to addresses before their first confirmed tx
+	sc := spool.Get()
+	for _, txo := range tx.TxOuts {
+		IndexScriptHash(sc, txo.Addr)
+	}
+	sc.Close()
+	conflicts, err := IndexUnconfirmedSpends(pool, spool, tx, mempool)
+	if len(conflicts) > 0 {
+		PublishDoubleSpend(pool, tx, conflicts)
+	}
+	return
+}
+
+// Remove unconfirmed transactions (btcplex:utx:%v keys) that left the memory pool
+func removeUnconfirmedTxs(pool *redis.Pool, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	c := pool.Get()
+	defer c.Close()
+	for _, key := range keys {
+		txjson, _ := redis.String(c.Do("GET", key))
+		tx := new(Tx)
+		if json.Unmarshal([]byte(txjson), tx) == nil {
+			UnindexUnconfirmedSpends(pool, tx)
+			UnindexUnconfirmedAddresses(pool, tx)
+			UnindexUnconfirmedFeeRate(pool, tx)
+		}
+	}
+	c.Do("DEL", redis.Args{}.AddFlat(keys)...)
+	c.Do("ZREM", redis.Args{}.Add("btcplex:rawmempool").AddFlat(keys)...)
+}
+
+// Compare the memory pool stored in Redis with bitcoind one, transactions still
+// in the memory pool are kept (they won't be fetched/published again), confirmed or
+// evicted ones are removed. Return a snapshot of the kept transactions along with
+// the time of the last snapshot taken before the shutdown.
+func ReconcileUnconfirmedTxs(conf *Config, pool *redis.Pool) (snapshotkey string, snapshotts int64, err error) {
+	c := pool.Get()
+	defer c.Close()
+
+	unconfirmedtxs, err := GetRawMemPoolRPC(conf)
+	if err != nil {
+		return
+	}
+	mempool := make(map[string]struct{}, len(unconfirmedtxs))
+	for _, txid := range unconfirmedtxs {
+		mempool[fmt.Sprintf("btcplex:utx:%v", txid)] = struct{}{}
+	}
+
+	state, _ := redis.StringMap(c.Do("HGETALL", "btcplex:rawmempool:state"))
+	if state["snapshot"] != "" {
+		c.Do("DEL", state["snapshot"])
+	}
+
+	oldkeys, _ := redis.Strings(c.Do("ZRANGE", "btcplex:rawmempool", 0, -1))
+	keptkeys := []string{}
+	droppedkeys := []string{}
+	for _, oldkey := range oldkeys {
+		if _, stillinpool := mempool[oldkey]; stillinpool {
+			keptkeys = append(keptkeys, oldkey)
+		} else {
+			droppedkeys = append(droppedkeys, oldkey)
+		}
+	}
+	removeUnconfirmedTxs(pool, droppedkeys)
+	// Indexes may be missing if the memory pool was stored by an older version,
+	// memory pool totals are rebuilt from scratch
+	c.Do("DEL", "btcplex:mempool:feerate", "btcplex:mempool:sizes", "btcplex:mempool:stats")
+	for _, keptkey := range keptkeys {
+		txjson, _ := redis.String(c.Do("GET", keptkey))
+		tx := new(Tx)
+		if json.Unmarshal([]byte(txjson), tx) == nil {
+			IndexUnconfirmedAddresses(pool, tx)
+			IndexUnconfirmedFeeRate(pool, tx)
+		}
+	}
+	log.Printf("Reconciled memory pool: %v kept, %v dropped\n", len(keptkeys), len(droppedkeys))
+
+	if len(keptkeys) == 0 {
+		c.Do("DEL", "btcplex:mempool:outpoints")
+	}
+	// First run, nothing to replay
+	if state["ts"] == "" {
+		return
+	}
+	snapshotts, _ = strconv.ParseInt(state["ts"], 10, 64)
+	snapshotkey = fmt.Sprintf("btcplex:rawmempool:%v:reconciled", snapshotts)
+	if len(keptkeys) > 0 {
+		_, err = c.Do("SADD", redis.Args{}.Add(snapshotkey).AddFlat(keptkeys)...)
+	}
+	return
+}
+
 // Fetch unconfirmed tx from Redis
 func GetUnconfirmedTx(pool *redis.Pool, hash string) (tx *Tx, err error) {
 	c := pool.Get()
 	defer c.Close()
 	txkey := fmt.Sprintf("btcplex:utx:%v", hash)
+	txjson, err := redis.String(c.Do("GET", txkey))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
 	tx = new(Tx)
-	txjson, _ := redis.String(c.Do("GET", txkey))
 	err = json.Unmarshal([]byte(txjson), tx)
 	return
 }
@@ -0,0 +1,191 @@
+package btcplex
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Output script classification, matching bitcoind scriptPubKey types
+
+const (
+	ScriptPubKey            = "pubkey"
+	ScriptPubKeyHash        = "pubkeyhash"
+	ScriptScriptHash        = "scripthash"
+	ScriptMultiSig          = "multisig"
+	ScriptNullData          = "nulldata"
+	ScriptWitnessKeyHash    = "witness_v0_keyhash"
+	ScriptWitnessScriptHash = "witness_v0_scripthash"
+	ScriptNonStandard       = "nonstandard"
+)
+
+const (
+	opPushData1     = 0x4c
+	opPushData2     = 0x4d
+	opPushData4     = 0x4e
+	op1             = 0x51
+	op16            = 0x60
+	opReturn        = 0x6a
+	opDup           = 0x76
+	opEqual         = 0x87
+	opEqualVerify   = 0x88
+	opHash160       = 0xa9
+	opCheckSig      = 0xac
+	opCheckMultiSig = 0xae
+)
+
+var opcodeNames = map[byte]string{
+	0x00: "0", 0x4f: "OP_1NEGATE", 0x50: "OP_RESERVED",
+	0x61: "OP_NOP", 0x62: "OP_VER", 0x63: "OP_IF", 0x64: "OP_NOTIF", 0x65: "OP_VERIF", 0x66: "OP_VERNOTIF",
+	0x67: "OP_ELSE", 0x68: "OP_ENDIF", 0x69: "OP_VERIFY", 0x6a: "OP_RETURN",
+	0x6b: "OP_TOALTSTACK", 0x6c: "OP_FROMALTSTACK", 0x6d: "OP_2DROP", 0x6e: "OP_2DUP", 0x6f: "OP_3DUP",
+	0x70: "OP_2OVER", 0x71: "OP_2ROT", 0x72: "OP_2SWAP", 0x73: "OP_IFDUP", 0x74: "OP_DEPTH", 0x75: "OP_DROP",
+	0x76: "OP_DUP", 0x77: "OP_NIP", 0x78: "OP_OVER", 0x79: "OP_PICK", 0x7a: "OP_ROLL", 0x7b: "OP_ROT",
+	0x7c: "OP_SWAP", 0x7d: "OP_TUCK", 0x7e: "OP_CAT", 0x7f: "OP_SUBSTR", 0x80: "OP_LEFT", 0x81: "OP_RIGHT",
+	0x82: "OP_SIZE", 0x83: "OP_INVERT", 0x84: "OP_AND", 0x85: "OP_OR", 0x86: "OP_XOR", 0x87: "OP_EQUAL",
+	0x88: "OP_EQUALVERIFY", 0x89: "OP_RESERVED1", 0x8a: "OP_RESERVED2", 0x8b: "OP_1ADD", 0x8c: "OP_1SUB",
+	0x8d: "OP_2MUL", 0x8e: "OP_2DIV", 0x8f: "OP_NEGATE", 0x90: "OP_ABS", 0x91: "OP_NOT", 0x92: "OP_0NOTEQUAL",
+	0x93: "OP_ADD", 0x94: "OP_SUB", 0x95: "OP_MUL", 0x96: "OP_DIV", 0x97: "OP_MOD", 0x98: "OP_LSHIFT",
+	0x99: "OP_RSHIFT", 0x9a: "OP_BOOLAND", 0x9b: "OP_BOOLOR", 0x9c: "OP_NUMEQUAL", 0x9d: "OP_NUMEQUALVERIFY",
+	0x9e: "OP_NUMNOTEQUAL", 0x9f: "OP_LESSTHAN", 0xa0: "OP_GREATERTHAN", 0xa1: "OP_LESSTHANOREQUAL",
+	0xa2: "OP_GREATERTHANOREQUAL", 0xa3: "OP_MIN", 0xa4: "OP_MAX", 0xa5: "OP_WITHIN", 0xa6: "OP_RIPEMD160",
+	0xa7: "OP_SHA1", 0xa8: "OP_SHA256", 0xa9: "OP_HASH160", 0xaa: "OP_HASH256", 0xab: "OP_CODESEPARATOR",
+	0xac: "OP_CHECKSIG", 0xad: "OP_CHECKSIGVERIFY", 0xae: "OP_CHECKMULTISIG", 0xaf: "OP_CHECKMULTISIGVERIFY",
+	0xb0: "OP_NOP1", 0xb1: "OP_CHECKLOCKTIMEVERIFY", 0xb2: "OP_CHECKSEQUENCEVERIFY", 0xb3: "OP_NOP4",
+	0xb4: "OP_NOP5", 0xb5: "OP_NOP6", 0xb6: "OP_NOP7", 0xb7: "OP_NOP8", 0xb8: "OP_NOP9", 0xb9: "OP_NOP10",
+}
+
+// Script operation, Data is set for push operations
+type scriptOp struct {
+	Opcode byte
+	Data   []byte
+}
+
+// Split a script into operations, ok is false if a push overflows the script
+func parseScript(script []byte) (ops []scriptOp, ok bool) {
+	for i := 0; i < len(script); {
+		op := scriptOp{Opcode: script[i]}
+		i++
+		size := -1
+		switch {
+		case op.Opcode < opPushData1:
+			size = int(op.Opcode)
+		case op.Opcode == opPushData1 && i+1 <= len(script):
+			size = int(script[i])
+			i++
+		case op.Opcode == opPushData2 && i+2 <= len(script):
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op.Opcode == opPushData4 && i+4 <= len(script):
+			size = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		case op.Opcode >= opPushData1 && op.Opcode <= opPushData4:
+			return ops, false
+		}
+		if size >= 0 {
+			if size > len(script)-i {
+				return ops, false
+			}
+			op.Data = script[i : i+size]
+			i += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, true
+}
+
+// Human readable script (same format as bitcoind "asm")
+func DisassembleScript(script []byte) string {
+	ops, ok := parseScript(script)
+	parts := []string{}
+	for _, op := range ops {
+		switch {
+		case op.Data != nil && len(op.Data) > 0:
+			parts = append(parts, hex.EncodeToString(op.Data))
+		case op.Opcode >= op1 && op.Opcode <= op16:
+			parts = append(parts, fmt.Sprintf("%v", op.Opcode-op1+1))
+		case opcodeNames[op.Opcode] != "":
+			parts = append(parts, opcodeNames[op.Opcode])
+		default:
+			parts = append(parts, "OP_UNKNOWN")
+		}
+	}
+	if !ok {
+		parts = append(parts, "[error]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Encode a script hash address for the active chain
+func ScriptHashAddress(hash160 []byte) string {
+	return Base58CheckEncode(append([]byte{ActiveChainParams.ScriptHashAddrID}, hash160...))
+}
+
+// Output script paying to the address, pay-to-pubkey outputs are indexed under
+// their pubkey hash address so they get the pubkeyhash script
+func AddressScript(address string) (class string, script []byte, err error) {
+	data, err := Base58CheckDecode(address)
+	if err != nil {
+		return
+	}
+	if len(data) != 21 {
+		return "", nil, ErrInvalidAddress
+	}
+	switch data[0] {
+	case ActiveChainParams.PubKeyHashAddrID:
+		script = append([]byte{opDup, opHash160, 20}, data[1:]...)
+		return ScriptPubKeyHash, append(script, opEqualVerify, opCheckSig), nil
+	case ActiveChainParams.ScriptHashAddrID:
+		script = append([]byte{opHash160, 20}, data[1:]...)
+		return ScriptScriptHash, append(script, opEqual), nil
+	}
+	return "", nil, ErrInvalidAddress
+}
+
+func isPubKey(data []byte) bool {
+	return (len(data) == 33 && (data[0] == 2 || data[0] == 3)) || (len(data) == 65 && data[0] == 4)
+}
+
+// Classify an output script and extract its addresses (pay-to-pubkey outputs
+// are shown as the pubkey hash address, like the rest of the index)
+func ScriptAddresses(script []byte) (class string, addresses []string) {
+	addresses = []string{}
+	n := len(script)
+	switch {
+	case n == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 && script[23] == opEqualVerify && script[24] == opCheckSig:
+		return ScriptPubKeyHash, append(addresses, PubKeyHashAddress(script[3:23]))
+	case n == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual:
+		return ScriptScriptHash, append(addresses, ScriptHashAddress(script[2:22]))
+	case n == 22 && script[0] == 0 && script[1] == 20:
+		return ScriptWitnessKeyHash, addresses
+	case n == 34 && script[0] == 0 && script[1] == 32:
+		return ScriptWitnessScriptHash, addresses
+	case n > 0 && script[0] == opReturn:
+		return ScriptNullData, addresses
+	}
+	ops, ok := parseScript(script)
+	if !ok || len(ops) < 2 {
+		return ScriptNonStandard, addresses
+	}
+	last := ops[len(ops)-1].Opcode
+	if len(ops) == 2 && last == opCheckSig && isPubKey(ops[0].Data) {
+		return ScriptPubKey, append(addresses, PubKeyHashAddress(Hash160(ops[0].Data)))
+	}
+	// m <pubkey>... n OP_CHECKMULTISIG
+	if last == opCheckMultiSig && len(ops) >= 4 {
+		m, keys := ops[0].Opcode, ops[1:len(ops)-2]
+		nkeys := ops[len(ops)-2].Opcode
+		if m < op1 || m > op16 || nkeys < m || nkeys > op16 || int(nkeys-op1+1) != len(keys) {
+			return ScriptNonStandard, addresses
+		}
+		for _, key := range keys {
+			if !isPubKey(key.Data) {
+				return ScriptNonStandard, []string{}
+			}
+			addresses = append(addresses, PubKeyHashAddress(Hash160(key.Data)))
+		}
+		return ScriptMultiSig, addresses
+	}
+	return ScriptNonStandard, addresses
+}
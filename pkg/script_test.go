@@ -0,0 +1,69 @@
+package btcplex
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+const genesisPubKey = "04678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5f"
+
+func TestScriptAddresses(t *testing.T) {
+	tests := []struct {
+		script    string
+		class     string
+		addresses []string
+	}{
+		{"41" + genesisPubKey + "ac", ScriptPubKey, []string{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"}},
+		{"76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", ScriptPubKeyHash, []string{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"}},
+		{"a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba87", ScriptScriptHash, []string{"4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9"}},
+		{"512102" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798" + "41" + genesisPubKey + "52ae", ScriptMultiSig,
+			[]string{"MJaRnao1s62a2zAKSkmG582KbLKianqb7v", "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"}},
+		{"6a0568656c6c6f", ScriptNullData, []string{}},
+		{"001462e907b15cbf27d5425399ebf6f0fb50ebb88f18", ScriptWitnessKeyHash, []string{}},
+		{"51", ScriptNonStandard, []string{}},
+		// Truncated push
+		{"4c05abcd", ScriptNonStandard, []string{}},
+	}
+	for _, test := range tests {
+		script, _ := hex.DecodeString(test.script)
+		class, addresses := ScriptAddresses(script)
+		if class != test.class || !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("ScriptAddresses(%v) = %v %v, want %v %v", test.script, class, addresses, test.class, test.addresses)
+		}
+	}
+}
+
+func TestDisassembleScript(t *testing.T) {
+	tests := []struct {
+		script, asm string
+	}{
+		{"76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", "OP_DUP OP_HASH160 62e907b15cbf27d5425399ebf6f0fb50ebb88f18 OP_EQUALVERIFY OP_CHECKSIG"},
+		{"6a4c0568656c6c6f", "OP_RETURN 68656c6c6f"},
+		{"0052ae", "0 2 OP_CHECKMULTISIG"},
+		{"4c05abcd", "[error]"},
+	}
+	for _, test := range tests {
+		script, _ := hex.DecodeString(test.script)
+		if asm := DisassembleScript(script); asm != test.asm {
+			t.Errorf("DisassembleScript(%v) = %q, want %q", test.script, asm, test.asm)
+		}
+	}
+}
+
+func TestAddressScript(t *testing.T) {
+	tests := []struct {
+		address, class, script string
+		err                    error
+	}{
+		{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", ScriptPubKeyHash, "76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac", nil},
+		{"4qbNFSWAHxuRPjRbz5d9wZczAyEie92KX9", ScriptScriptHash, "a91489abcdefabbaabbaabbaabbaabbaabbaabbaabba87", nil},
+		{"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCm", "", "", ErrInvalidChecksum},
+	}
+	for _, test := range tests {
+		class, script, err := AddressScript(test.address)
+		if class != test.class || hex.EncodeToString(script) != test.script || err != test.err {
+			t.Errorf("AddressScript(%v) = %v %x %v, want %v %v %v", test.address, class, script, err, test.class, test.script, test.err)
+		}
+	}
+}
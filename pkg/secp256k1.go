@@ -0,0 +1,104 @@
+package btcplex
+
+import (
+	"errors"
+	"math/big"
+)
+
+// Minimal secp256k1 arithmetic (affine coordinates), enough for public key derivation,
+// performance isn't a concern as only a few hundred points are computed per request
+
+var ErrInvalidPubKey = errors.New("Invalid public key")
+
+var (
+	secpP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secpN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+)
+
+// Point on the curve, nil is the point at infinity
+type ecPoint struct {
+	X, Y *big.Int
+}
+
+func ecAdd(a, b *ecPoint) *ecPoint {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	lambda := new(big.Int)
+	if a.X.Cmp(b.X) == 0 {
+		sum := new(big.Int).Add(a.Y, b.Y)
+		if sum.Mod(sum, secpP).Sign() == 0 {
+			return nil
+		}
+		// Doubling: lambda = 3x^2 / 2y
+		dy := new(big.Int).Lsh(a.Y, 1)
+		lambda.Mul(a.X, a.X)
+		lambda.Mul(lambda, big.NewInt(3))
+		lambda.Mul(lambda, dy.ModInverse(dy, secpP))
+	} else {
+		// lambda = (y2 - y1) / (x2 - x1)
+		dx := new(big.Int).Sub(b.X, a.X)
+		dx.Mod(dx, secpP)
+		lambda.Sub(b.Y, a.Y)
+		lambda.Mul(lambda, dx.ModInverse(dx, secpP))
+	}
+	lambda.Mod(lambda, secpP)
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, a.X)
+	x.Sub(x, b.X)
+	x.Mod(x, secpP)
+	y := new(big.Int).Sub(a.X, x)
+	y.Mul(y, lambda)
+	y.Sub(y, a.Y)
+	y.Mod(y, secpP)
+	return &ecPoint{x, y}
+}
+
+func ecScalarBaseMult(k *big.Int) (r *ecPoint) {
+	p := &ecPoint{secpGx, secpGy}
+	for i := 0; i < k.BitLen(); i++ {
+		if k.Bit(i) == 1 {
+			r = ecAdd(r, p)
+		}
+		p = ecAdd(p, p)
+	}
+	return
+}
+
+// Parse a compressed (33 bytes) public key
+func parseCompressedPubKey(b []byte) (*ecPoint, error) {
+	if len(b) != 33 || (b[0] != 2 && b[0] != 3) {
+		return nil, ErrInvalidPubKey
+	}
+	x := new(big.Int).SetBytes(b[1:])
+	if x.Cmp(secpP) >= 0 {
+		return nil, ErrInvalidPubKey
+	}
+	// y^2 = x^3 + 7, p = 3 mod 4 so y = (x^3 + 7)^((p+1)/4)
+	y2 := new(big.Int).Exp(x, big.NewInt(3), secpP)
+	y2.Add(y2, big.NewInt(7))
+	y2.Mod(y2, secpP)
+	exp := new(big.Int).Add(secpP, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, secpP)
+	if new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(y2) != 0 {
+		return nil, ErrInvalidPubKey
+	}
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(secpP, y)
+	}
+	return &ecPoint{x, y}, nil
+}
+
+func (p *ecPoint) compressed() []byte {
+	out := make([]byte, 33)
+	out[0] = 2 + byte(p.Y.Bit(0))
+	xb := p.X.Bytes()
+	copy(out[33-len(xb):], xb)
+	return out
+}
@@ -0,0 +1,126 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Socket.io 2 text packets over the Engine.io 3 websocket and polling transports, as spoken by the
+// Insight clients: a single (default) namespace, events and their acks, no binary attachments.
+// Engine.io packets start with their type ("2" ping, "3" pong, "4" message...), socket.io
+// packets are carried by message packets ("40" connect, "41" disconnect, "42" event, "43" ack).
+// Polling requests carry several packets, each prefixed by its length ("2:40").
+
+const (
+	EngineIOOpen    = "0"
+	EngineIOClose   = "1"
+	EngineIOPing    = "2"
+	EngineIOPong    = "3"
+	EngineIONoop    = "6"
+	SocketIOConnect = "40"
+	SocketIOClose   = "41"
+	SocketIOEvent   = "42"
+	SocketIOAck     = "43"
+)
+
+// Handshake sent when the transport opens, clients ping every pingInterval and expect a pong within pingTimeout
+func EngineIOOpenPacket(sid string, pingInterval, pingTimeout time.Duration) string {
+	handshake, _ := json.Marshal(map[string]interface{}{"sid": sid, "upgrades": []string{},
+		"pingInterval": int64(pingInterval / time.Millisecond), "pingTimeout": int64(pingTimeout / time.Millisecond)})
+	return EngineIOOpen + string(handshake)
+}
+
+// Polling payload of the packets, lengths are counted in UTF-16 code units like the JavaScript clients do
+func EngineIOPayload(packets []string) string {
+	payload := ""
+	for _, packet := range packets {
+		payload += strconv.Itoa(utf16Len(packet)) + ":" + packet
+	}
+	return payload
+}
+
+// Packets of a polling payload
+func ParseEngineIOPayload(payload string) (packets []string, err error) {
+	for payload != "" {
+		i := strings.IndexByte(payload, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("Missing packet length")
+		}
+		length, perr := strconv.Atoi(payload[:i])
+		if perr != nil || length < 1 {
+			return nil, fmt.Errorf("Invalid packet length")
+		}
+		payload = payload[i+1:]
+		end, units := 0, 0
+		for _, r := range payload {
+			if units == length {
+				break
+			}
+			units += utf16Len(string(r))
+			end += len(string(r))
+		}
+		if units != length {
+			return nil, fmt.Errorf("Truncated packet")
+		}
+		packets = append(packets, payload[:end])
+		payload = payload[end:]
+	}
+	return
+}
+
+func utf16Len(s string) (n int) {
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return
+}
+
+func SocketIOEventPacket(event string, args ...interface{}) (packet string, err error) {
+	data, err := json.Marshal(append([]interface{}{event}, args...))
+	if err != nil {
+		return
+	}
+	return SocketIOEvent + string(data), nil
+}
+
+// Parse an event packet (starting with "42"), ackid is empty if the client doesn't expect an ack
+func ParseSocketIOEvent(packet string) (event string, args []json.RawMessage, ackid string, err error) {
+	if !strings.HasPrefix(packet, SocketIOEvent) {
+		return "", nil, "", fmt.Errorf("Not an event packet")
+	}
+	payload := packet[len(SocketIOEvent):]
+	if strings.HasPrefix(payload, "/") {
+		i := strings.IndexByte(payload, ',')
+		if i < 0 || payload[:i] != "/" {
+			return "", nil, "", fmt.Errorf("Unknown namespace")
+		}
+		payload = payload[i+1:]
+	}
+	i := 0
+	for i < len(payload) && payload[i] >= '0' && payload[i] <= '9' {
+		i++
+	}
+	ackid, payload = payload[:i], payload[i:]
+	if err = json.Unmarshal([]byte(payload), &args); err != nil {
+		return "", nil, "", fmt.Errorf("Malformed event")
+	}
+	if len(args) == 0 || json.Unmarshal(args[0], &event) != nil {
+		return "", nil, "", fmt.Errorf("Missing event name")
+	}
+	return event, args[1:], ackid, nil
+}
+
+// Acknowledge an event with the given results
+func SocketIOAckPacket(ackid string, args ...interface{}) (packet string, err error) {
+	data, err := json.Marshal(append([]interface{}{}, args...))
+	if err != nil {
+		return
+	}
+	return SocketIOAck + ackid + string(data), nil
+}
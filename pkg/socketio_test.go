@@ -0,0 +1,85 @@
+package btcplex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEngineIOOpenPacket(t *testing.T) {
+	want := `0{"pingInterval":25000,"pingTimeout":60000,"sid":"abc","upgrades":[]}`
+	if packet := EngineIOOpenPacket("abc", 25*time.Second, time.Minute); packet != want {
+		t.Errorf("EngineIOOpenPacket = %v, want %v", packet, want)
+	}
+}
+
+func TestSocketIOEventPacket(t *testing.T) {
+	packet, err := SocketIOEventPacket("tx", map[string]interface{}{"txid": "a"})
+	if want := `42["tx",{"txid":"a"}]`; packet != want || err != nil {
+		t.Errorf("SocketIOEventPacket = %v, %v, want %v", packet, err, want)
+	}
+	packet, err = SocketIOAckPacket("12")
+	if want := `4312[]`; packet != want || err != nil {
+		t.Errorf("SocketIOAckPacket = %v, %v, want %v", packet, err, want)
+	}
+}
+
+func TestParseSocketIOEvent(t *testing.T) {
+	tests := []struct {
+		packet string
+		event  string
+		args   []string
+		ackid  string
+		valid  bool
+	}{
+		{`42["subscribe","inv"]`, "subscribe", []string{`"inv"`}, "", true},
+		{`427["subscribe","inv"]`, "subscribe", []string{`"inv"`}, "7", true},
+		{`42/,["unsubscribe","MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"]`, "unsubscribe", []string{`"MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn"`}, "", true},
+		{`42["ping"]`, "ping", []string{}, "", true},
+		{`42/admin,["subscribe","inv"]`, "", nil, "", false},
+		{`42[]`, "", nil, "", false},
+		{`42[1,"inv"]`, "", nil, "", false},
+		{`42{"subscribe":"inv"}`, "", nil, "", false},
+		{`2`, "", nil, "", false},
+	}
+	for _, test := range tests {
+		event, args, ackid, err := ParseSocketIOEvent(test.packet)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseSocketIOEvent(%v) error = %v, want valid %v", test.packet, err, test.valid)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if event != test.event || ackid != test.ackid || len(args) != len(test.args) {
+			t.Errorf("ParseSocketIOEvent(%v) = %v, %s, %v, want %v, %v, %v", test.packet, event, args, ackid, test.event, test.args, test.ackid)
+			continue
+		}
+		for i, arg := range args {
+			if string(arg) != test.args[i] {
+				t.Errorf("ParseSocketIOEvent(%v) arg %v = %s, want %v", test.packet, i, arg, test.args[i])
+			}
+		}
+	}
+}
+
+func TestEngineIOPayload(t *testing.T) {
+	packets := []string{"40", `42["tx",{"txid":"é"}]`, "2", `42["😀"]`}
+	payload := EngineIOPayload(packets)
+	if want := `2:4021:42["tx",{"txid":"é"}]1:28:42["😀"]`; payload != want {
+		t.Errorf("EngineIOPayload = %v, want %v", payload, want)
+	}
+	parsed, err := ParseEngineIOPayload(payload)
+	if err != nil || len(parsed) != len(packets) {
+		t.Fatalf("ParseEngineIOPayload(%v) = %v, %v", payload, parsed, err)
+	}
+	for i, packet := range parsed {
+		if packet != packets[i] {
+			t.Errorf("packet %v = %v, want %v", i, packet, packets[i])
+		}
+	}
+	for _, payload := range []string{"2:4", "40", "a:40", "0:", "2:40x"} {
+		if packets, err := ParseEngineIOPayload(payload); err == nil {
+			t.Errorf("ParseEngineIOPayload(%v) = %v, should fail", payload, packets)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Coin supply computed from the subsidy schedule, blocks whose generation transaction
+// claimed less than the reward plus fees are indexed in ``supply:underclaimed``
+// (hash sorted by height) and the coins never created are totaled in ``supply:unclaimed``
+
+// Range of heights sharing the same block reward, EndHeight is 0 for the tail emission
+type EmissionEra struct {
+	StartHeight uint   `json:"start_height"`
+	EndHeight   uint   `json:"end_height"`
+	Reward      uint64 `json:"reward"`
+	SupplyAtEnd uint64 `json:"supply_at_end,omitempty"`
+}
+
+type RewardChange struct {
+	Height        uint   `json:"height"`
+	Reward        uint64 `json:"reward"`
+	BlocksLeft    uint   `json:"blocks_left"`
+	EstimatedTime uint32 `json:"estimated_time"`
+}
+
+type SupplyInfo struct {
+	Height             uint                         `json:"height"`
+	Reward             uint64                       `json:"reward"`
+	TotalCoins         uint64                       `json:"total_coins"`
+	Unclaimed          uint64                       `json:"unclaimed"`
+	Circulating        uint64                       `json:"circulating"`
+	UnderclaimedBlocks int                          `json:"underclaimed_blocks"`
+	NextRewardChange   *RewardChange                `json:"next_reward_change"`
+	Schedule           []*EmissionEra               `json:"schedule"`
+	Links              map[string]map[string]string `json:"_links,omitempty"`
+}
+
+type UnderclaimedBlock struct {
+	Hash      string `json:"hash"`
+	Height    uint   `json:"height"`
+	Allowed   uint64 `json:"allowed"`
+	Claimed   uint64 `json:"claimed"`
+	Unclaimed uint64 `json:"unclaimed"`
+}
+
+// Height of the next block reward change, ok is false once the tail emission is reached
+func NextRewardChange(height uint) (next uint, ok bool) {
+	if height < subsidyReductionHeight {
+		return subsidyReductionHeight, true
+	}
+	next = subsidyReductionHeight + ((height-subsidyReductionHeight)/subsidyHalvingInterval+1)*subsidyHalvingInterval
+	if GetBlockReward(next) == GetBlockReward(height) {
+		return 0, false
+	}
+	return next, true
+}
+
+// Coins issued by the subsidy schedule for blocks 0 to height (both included)
+func SubsidySupply(height uint) (supply uint64) {
+	start := uint(0)
+	for {
+		reward := GetBlockReward(start)
+		next, ok := NextRewardChange(start)
+		if !ok || next > height {
+			return supply + uint64(height-start+1)*reward
+		}
+		supply += uint64(next-start) * reward
+		start = next
+	}
+}
+
+// Block reward eras, up to the tail emission
+func EmissionSchedule() (eras []*EmissionEra) {
+	eras = []*EmissionEra{}
+	start := uint(0)
+	for {
+		era := &EmissionEra{StartHeight: start, Reward: GetBlockReward(start)}
+		eras = append(eras, era)
+		next, ok := NextRewardChange(start)
+		if !ok {
+			return
+		}
+		era.EndHeight = next - 1
+		era.SupplyAtEnd = SubsidySupply(era.EndHeight)
+		start = next
+	}
+}
+
+// Reward not claimed by the generation transaction (nil if missing)
+func unclaimedReward(height uint, fees uint64, coinbase *Tx) uint64 {
+	if coinbase == nil {
+		return 0
+	}
+	if allowed := GetBlockReward(height) + fees; coinbase.TotalOut < allowed {
+		return allowed - coinbase.TotalOut
+	}
+	return 0
+}
+
+func incrUnclaimed(c redis.Conn, hash string, stats *BlockStats, sign int64) {
+	if stats.Unclaimed == 0 {
+		return
+	}
+	if sign > 0 {
+		c.Do("ZADD", "supply:underclaimed", stats.Height, hash)
+	} else {
+		c.Do("ZREM", "supply:underclaimed", hash)
+	}
+	c.Do("INCRBY", "supply:unclaimed", sign*int64(stats.Unclaimed))
+}
+
+// Supply at the latest height, the genesis block reward can't be spent so it's not circulating
+func GetSupplyInfo(rpool *redis.Pool) (info *SupplyInfo, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	latest, err := redis.Int(c.Do("GET", "height:latest"))
+	if err != nil {
+		return
+	}
+	info = &SupplyInfo{Height: uint(latest), Reward: GetBlockReward(uint(latest)), Schedule: EmissionSchedule()}
+	info.TotalCoins = SubsidySupply(info.Height)
+	unclaimed, _ := redis.Int64(c.Do("GET", "supply:unclaimed"))
+	info.Unclaimed = uint64(unclaimed)
+	info.Circulating = info.TotalCoins - info.Unclaimed - GetBlockReward(0)
+	info.UnderclaimedBlocks, _ = redis.Int(c.Do("ZCARD", "supply:underclaimed"))
+	if next, ok := NextRewardChange(info.Height); ok {
+		info.NextRewardChange = &RewardChange{Height: next, Reward: GetBlockReward(next), BlocksLeft: next - info.Height}
+		if block, berr := getBlockByHeight(rpool, info.Height); berr == nil {
+			info.NextRewardChange.EstimatedTime = block.BlockTime + uint32(info.NextRewardChange.BlocksLeft)*ActiveChainParams.TargetSpacing
+		}
+	}
+	return
+}
+
+// Return blocks that claimed less than allowed, highest first
+func GetUnderclaimedBlocks(rpool *redis.Pool, cursor *Cursor, limit int) (blocks []*UnderclaimedBlock, next *Cursor, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	blocks = []*UnderclaimedBlock{}
+	hashes, next, err := ZRevRangeFromCursor(c, "supply:underclaimed", cursor, limit)
+	if err != nil {
+		return
+	}
+	for _, hash := range hashes {
+		statsjson, serr := redis.String(c.Do("GET", fmt.Sprintf("block:%v:stats", hash)))
+		if serr != nil {
+			return nil, nil, serr
+		}
+		stats := new(BlockStats)
+		if err = json.Unmarshal([]byte(statsjson), stats); err != nil {
+			return
+		}
+		allowed := GetBlockReward(stats.Height) + stats.Fees
+		blocks = append(blocks, &UnderclaimedBlock{Hash: hash, Height: stats.Height, Allowed: allowed,
+			Claimed: allowed - stats.Unclaimed, Unclaimed: stats.Unclaimed})
+	}
+	return
+}
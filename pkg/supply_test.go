@@ -0,0 +1,68 @@
+package btcplex
+
+import (
+	"testing"
+)
+
+func TestNextRewardChange(t *testing.T) {
+	tests := []struct {
+		height, next uint
+		ok           bool
+	}{
+		{0, 100000, true},
+		{99999, 100000, true},
+		{100000, 1050000, true},
+		{1049999, 1050000, true},
+		{1050000, 2000000, true},
+		// 1000 >> 9 = 1.95 coins, then 1 coin forever
+		{8650000, 9600000, true},
+		{9600000, 0, false},
+		{20000000, 0, false},
+	}
+	for _, test := range tests {
+		if next, ok := NextRewardChange(test.height); next != test.next || ok != test.ok {
+			t.Errorf("NextRewardChange(%v) = %v %v, want %v %v", test.height, next, ok, test.next, test.ok)
+		}
+	}
+}
+
+func TestSubsidySupply(t *testing.T) {
+	tests := []struct {
+		height uint
+		supply uint64
+	}{
+		{0, 5000 * COIN},
+		{99999, 100000 * 5000 * COIN},
+		{100000, 100000*5000*COIN + 1000*COIN},
+		{1050000, 100000*5000*COIN + 950000*1000*COIN + 500*COIN},
+	}
+	for _, test := range tests {
+		if supply := SubsidySupply(test.height); supply != test.supply {
+			t.Errorf("SubsidySupply(%v) = %v, want %v", test.height, supply, test.supply)
+		}
+	}
+	// Same as summing every block reward
+	total := uint64(0)
+	for height := uint(0); height <= 2100000; height++ {
+		total += GetBlockReward(height)
+	}
+	if supply := SubsidySupply(2100000); supply != total {
+		t.Errorf("SubsidySupply(2100000) = %v, want %v", supply, total)
+	}
+}
+
+func TestEmissionSchedule(t *testing.T) {
+	eras := EmissionSchedule()
+	if len(eras) != 12 {
+		t.Fatalf("got %v eras, want 12", len(eras))
+	}
+	tail := eras[len(eras)-1]
+	if tail.StartHeight != 9600000 || tail.EndHeight != 0 || tail.Reward != COIN {
+		t.Errorf("unexpected tail emission %+v", tail)
+	}
+	for i, era := range eras[:len(eras)-1] {
+		if era.SupplyAtEnd != SubsidySupply(era.EndHeight) || eras[i+1].StartHeight != era.EndHeight+1 {
+			t.Errorf("unexpected era %+v", era)
+		}
+	}
+}
@@ -50,27 +50,79 @@ func TxIndex(tx1, tx2 *Tx) bool {
 	return tx1.Index < tx2.Index
 }
 
-// Return all unconfirmed transactions from Redis
-func GetUnconfirmedTxs(pool *redis.Pool) (utxs []*Tx, err error) {
+// Return unconfirmed transactions from Redis (from start to stop, both included),
+// sorted by first seen time or fee rate (desc)
+func GetUnconfirmedTxs(pool *redis.Pool, sortby string, start, stop int) (utxs []*Tx, err error) {
 	c := pool.Get()
 	defer c.Close()
+	zkey := "btcplex:rawmempool"
+	if sortby == "feerate" {
+		zkey = "btcplex:mempool:feerate"
+	}
+	// Members are btcplex:utx:%v keys
+	utxskeys, err := redis.Strings(c.Do("ZREVRANGE", zkey, start, stop))
+	if err != nil {
+		return
+	}
+	return getUnconfirmedTxsByKeys(c, utxskeys)
+}
+
+// Return up to limit unconfirmed transactions following the cursor (nil to start
+// from the most recent/highest fee rate)
+func GetUnconfirmedTxsFromCursor(pool *redis.Pool, sortby string, cursor *Cursor, limit int) (utxs []*Tx, next *Cursor, err error) {
+	c := pool.Get()
+	defer c.Close()
+	zkey := "btcplex:rawmempool"
+	if sortby == "feerate" {
+		zkey = "btcplex:mempool:feerate"
+	}
+	utxskeys, next, err := ZRevRangeFromCursor(c, zkey, cursor, limit)
+	if err != nil {
+		return
+	}
+	utxs, err = getUnconfirmedTxsByKeys(c, utxskeys)
+	return
+}
+
+// MGET the btcplex:utx:%v keys, skipping transactions removed in the meantime
+func getUnconfirmedTxsByKeys(c redis.Conn, utxskeys []string) (utxs []*Tx, err error) {
 	utxs = []*Tx{}
-	utxsid, _ := redis.Strings(c.Do("SMEMBERS", "btcplex:rawmempool"))
-	for _, utxid := range utxsid {
-		txraw, _ := redis.String(c.Do("GET", fmt.Sprintf("btcplex:utx:%v", utxid)))
+	if len(utxskeys) == 0 {
+		return
+	}
+	txsraw, err := redis.Strings(c.Do("MGET", redis.Args{}.AddFlat(utxskeys)...))
+	if err != nil {
+		return
+	}
+	for _, txraw := range txsraw {
+		if txraw == "" {
+			continue
+		}
 		utx := new(Tx)
-		if txraw != "" {
-			err = json.Unmarshal([]byte(txraw), utx)
-			if err != nil {
-				return
-			}
-			utxs = append(utxs, utx)
+		if err = json.Unmarshal([]byte(txraw), utx); err != nil {
+			return
 		}
+		utxs = append(utxs, utx)
 	}
-	By(TxFirstSeenDesc).Sort(utxs)
 	return
 }
 
+// Return unconfirmed transactions involving the given address, sorted by first seen time desc
+func GetUnconfirmedTxsByAddress(pool *redis.Pool, address string) (utxs []*Tx, err error) {
+	c := pool.Get()
+	defer c.Close()
+	utxs = []*Tx{}
+	utxsid, err := redis.Strings(c.Do("ZREVRANGE", fmt.Sprintf("btcplex:addr:%v:utxs", address), 0, -1))
+	if err != nil || len(utxsid) == 0 {
+		return
+	}
+	utxskeys := []string{}
+	for _, utxid := range utxsid {
+		utxskeys = append(utxskeys, fmt.Sprintf("btcplex:utx:%v", utxid))
+	}
+	return getUnconfirmedTxsByKeys(c, utxskeys)
+}
+
 // Return a set containing every addresses listed in txis/txos
 func (tx *Tx) Addresses() (addresses []string) {
 	addrset := make(map[string]struct{})
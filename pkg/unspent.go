@@ -0,0 +1,231 @@
+package btcplex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Unspent outputs index, addr:%v:unspent holds the "hash:vout" outputs paying to the address scored
+// by block time, they're added when indexed and removed once spent. The transactions of a block are
+// indexed concurrently so an output spent in its own block can be left behind, spent outputs are
+// dropped from the index when listed.
+
+// Outputs fetched per round trip when listing the index
+const unspentpage = 1000
+
+// Output not spent yet, BlockHeight and BlockTime are zero for memory pool outputs
+type UnspentOutput struct {
+	TxHash        string `json:"tx_hash"`
+	Index         uint32 `json:"n"`
+	Address       string `json:"address"`
+	Value         uint64 `json:"value"`
+	BlockHeight   uint   `json:"block_height"`
+	BlockTime     uint32 `json:"block_time"`
+	FirstSeenTime uint32 `json:"first_seen_time,omitempty"`
+}
+
+func IndexUnspent(c redis.Conn, address, hash string, vout uint32, blocktime uint32) (err error) {
+	if address == "" {
+		return
+	}
+	_, err = c.Do("ZADD", fmt.Sprintf("addr:%v:unspent", address), blocktime, fmt.Sprintf("%v:%v", hash, vout))
+	return
+}
+
+func UnindexUnspent(c redis.Conn, address, hash string, vout uint32) (err error) {
+	if address == "" {
+		return
+	}
+	_, err = c.Do("ZREM", fmt.Sprintf("addr:%v:unspent", address), fmt.Sprintf("%v:%v", hash, vout))
+	return
+}
+
+// Index the unspent outputs of every funded address of the rich list, progress is reported every 10000 addresses
+func RebuildUnspent(rpool *redis.Pool, progress func(addresses int)) (err error) {
+	c := rpool.Get()
+	defer c.Close()
+	cnt, err := redis.Int(c.Do("ZCARD", BalancesKey))
+	if err != nil {
+		return
+	}
+	for start := 0; start < cnt; start += 10000 {
+		if progress != nil {
+			progress(start)
+		}
+		addresses, aerr := redis.Strings(c.Do("ZRANGE", BalancesKey, start, start+9999))
+		if aerr != nil {
+			return aerr
+		}
+		for _, address := range addresses {
+			if err = rebuildAddressUnspent(c, rpool, address); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func rebuildAddressUnspent(c redis.Conn, rpool *redis.Pool, address string) (err error) {
+	if _, err = c.Do("DEL", fmt.Sprintf("addr:%v:unspent", address)); err != nil {
+		return
+	}
+	balance, err := redis.Int64(c.Do("ZSCORE", BalancesKey, address))
+	if err != nil || balance <= 0 {
+		return
+	}
+	zkey := fmt.Sprintf("addr:%v:received", address)
+	cnt, err := redis.Int(c.Do("ZCARD", zkey))
+	if err != nil {
+		return
+	}
+	for start := 0; start < cnt; start += 500 {
+		hashes, herr := redis.Strings(c.Do("ZRANGE", zkey, start, start+499))
+		if herr != nil {
+			return herr
+		}
+		for _, hash := range hashes {
+			tx, txerr := GetTx(rpool, hash)
+			if txerr != nil {
+				return txerr
+			}
+			for _, output := range tx.unspentOutputs(address) {
+				if err = IndexUnspent(c, address, output.TxHash, output.Index, output.BlockTime); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// Return the outputs paying to the address not spent yet, the confirmed ones (newest first)
+// followed by the memory pool ones, outputs spent by unconfirmed transactions are left out
+func GetAddressUnspent(rpool *redis.Pool, pool *redis.Pool, address string) (unspent []*UnspentOutput, err error) {
+	return GetAddressesUnspent(rpool, pool, []string{address})
+}
+
+// Unspent outputs of each address in turn
+func GetAddressesUnspent(rpool *redis.Pool, pool *redis.Pool, addresses []string) (unspent []*UnspentOutput, err error) {
+	c := rpool.Get()
+	defer c.Close()
+	unspent = []*UnspentOutput{}
+	for _, address := range addresses {
+		outputs, aerr := addressUnspent(c, pool, address)
+		if aerr != nil {
+			return nil, aerr
+		}
+		unspent = append(unspent, outputs...)
+	}
+	return
+}
+
+func addressUnspent(c redis.Conn, pool *redis.Pool, address string) (unspent []*UnspentOutput, err error) {
+	zkey := fmt.Sprintf("addr:%v:unspent", address)
+	cnt, err := redis.Int(c.Do("ZCARD", zkey))
+	if err != nil {
+		return
+	}
+	spent := []*PrevOut{}
+	// SSDB doesn't support negative slice yet
+	for start := 0; start < cnt; start += unspentpage {
+		members, zerr := redis.Strings(c.Do("ZREVRANGE", zkey, start, start+unspentpage-1))
+		if zerr != nil {
+			return nil, zerr
+		}
+		outputs, stale, uerr := indexedUnspent(c, pool, address, members)
+		if uerr != nil {
+			return nil, uerr
+		}
+		unspent = append(unspent, outputs...)
+		spent = append(spent, stale...)
+	}
+	// Once listed so the pages don't shift
+	for _, prevout := range spent {
+		UnindexUnspent(c, address, prevout.Hash, prevout.Vout)
+	}
+	utxs, err := GetUnconfirmedTxsByAddress(pool, address)
+	if err != nil {
+		return
+	}
+	for _, utx := range utxs {
+		utx.FetchUnconfirmedSpent(pool)
+		unspent = append(unspent, utx.unspentOutputs(address)...)
+	}
+	return
+}
+
+// Outputs of the index members still unspent, along with the confirmed spends left in the index
+func indexedUnspent(c redis.Conn, pool *redis.Pool, address string, members []string) (unspent []*UnspentOutput, stale []*PrevOut, err error) {
+	if len(members) == 0 {
+		return
+	}
+	prevouts := []*PrevOut{}
+	txskeys, txokeys, spentkeys := []interface{}{}, []interface{}{}, []interface{}{}
+	opkeys := redis.Args{}.Add("btcplex:mempool:outpoints")
+	for _, member := range members {
+		i := strings.LastIndexByte(member, ':')
+		vout, perr := strconv.ParseUint(member[i+1:], 10, 32)
+		if i < 0 || perr != nil {
+			return nil, nil, fmt.Errorf("Invalid unspent output %v", member)
+		}
+		prevout := &PrevOut{Hash: member[:i], Vout: uint32(vout)}
+		prevouts = append(prevouts, prevout)
+		txskeys = append(txskeys, fmt.Sprintf("tx:%v", prevout.Hash))
+		txokeys = append(txokeys, fmt.Sprintf("txo:%v:%v", prevout.Hash, prevout.Vout))
+		spentkeys = append(spentkeys, fmt.Sprintf("txo:%v:%v:spent", prevout.Hash, prevout.Vout))
+		opkeys = opkeys.Add(outpointKey(prevout))
+	}
+	txsjson, err := redis.Strings(c.Do("MGET", txskeys...))
+	if err != nil {
+		return
+	}
+	txosjson, err := redis.Strings(c.Do("MGET", txokeys...))
+	if err != nil {
+		return
+	}
+	spentsjson, err := redis.Strings(c.Do("MGET", spentkeys...))
+	if err != nil {
+		return
+	}
+	mc := pool.Get()
+	spenders, err := redis.Strings(mc.Do("HMGET", opkeys...))
+	mc.Close()
+	if err != nil {
+		return
+	}
+	for i, prevout := range prevouts {
+		if spentsjson[i] != "" || txsjson[i] == "" || txosjson[i] == "" {
+			stale = append(stale, prevout)
+			continue
+		}
+		if spenders[i] != "" {
+			continue
+		}
+		tx, txo := new(Tx), new(TxOut)
+		if err = json.Unmarshal([]byte(txsjson[i]), tx); err != nil {
+			return
+		}
+		if err = json.Unmarshal([]byte(txosjson[i]), txo); err != nil {
+			return
+		}
+		unspent = append(unspent, &UnspentOutput{TxHash: prevout.Hash, Index: prevout.Vout, Address: address, Value: txo.Value,
+			BlockHeight: tx.BlockHeight, BlockTime: tx.BlockTime})
+	}
+	return
+}
+
+// TxOuts are ordered by vout
+func (tx *Tx) unspentOutputs(address string) (unspent []*UnspentOutput) {
+	for txoindex, txo := range tx.TxOuts {
+		if txo.Addr != address || (txo.Spent != nil && txo.Spent.Spent) {
+			continue
+		}
+		unspent = append(unspent, &UnspentOutput{TxHash: tx.Hash, Index: uint32(txoindex), Address: address, Value: txo.Value,
+			BlockHeight: tx.BlockHeight, BlockTime: tx.BlockTime, FirstSeenTime: tx.FirstSeenTime})
+	}
+	return
+}
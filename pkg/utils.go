@@ -4,3 +4,8 @@ package btcplex
 func FloatToUint(x float64) uint64 {
 	return uint64(int64((x * float64(100000000.0)) + float64(0.5)))
 }
+
+// Satoshis to coins
+func UintToFloat(x uint64) float64 {
+	return float64(x) / float64(COIN)
+}
@@ -0,0 +1,581 @@
+package btcplex
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Webhooks are stored in Redis (btcplex:webhooks hash), indexed by owner and by event target,
+// deliveries are queued in the btcplex:webhooks:queue zset (scored by due time), see docs/api_webhooks.md
+
+const (
+	WebhookAddress = "address"
+	WebhookTx      = "tx"
+	WebhookBlock   = "block"
+)
+
+const (
+	MaxWebhooksPerOwner     = 1000
+	MaxWebhookConfirmations = 100
+	MaxWebhookAttempts      = 10
+	// Entries kept in the per webhook delivery log and dead letter list
+	WebhookLogSize = 100
+	// Delay before the first retry, doubled after each failed attempt
+	WebhookRetryDelay    = 10 * time.Second
+	MaxWebhookRetryDelay = time.Hour
+)
+
+var ErrTooManyWebhooks = errors.New("Too many webhooks")
+
+var ErrPrivateWebhookHost = errors.New("Webhook host must be a public address")
+
+// Loopback, private, link-local (cloud metadata services), CGNAT and unspecified networks,
+// webhooks can't reach them
+var privateNetworks = parseCIDRs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "::/128", "::1/128", "fc00::/7", "fe80::/10")
+
+func parseCIDRs(cidrs ...string) (networks []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+// Check that the IP is a public unicast address
+func IsPublicIP(ip net.IP) bool {
+	if ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+const webhookQueueKey = "btcplex:webhooks:queue"
+
+type Webhook struct {
+	Id            string `json:"id"`
+	Owner         string `json:"-"`
+	Event         string `json:"event"`
+	Url           string `json:"url"`
+	Address       string `json:"address,omitempty"`
+	Txid          string `json:"txid,omitempty"`
+	Confirmations uint   `json:"confirmations,omitempty"`
+	// HMAC-SHA256 key of the X-Btcplex-Signature header
+	Secret  string `json:"secret"`
+	Created int64  `json:"created"`
+	// Tx webhooks fire once
+	Triggered int64 `json:"triggered,omitempty"`
+}
+
+// Stored with the owner, hidden from the API
+type storedWebhook struct {
+	*Webhook
+	Owner string `json:"owner"`
+}
+
+type WebhookDelivery struct {
+	Id        string          `json:"id"`
+	WebhookId string          `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempt   int             `json:"attempt"`
+	Created   int64           `json:"created"`
+	// Outcome of the last attempt
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type WebhookLogEntry struct {
+	DeliveryId string `json:"delivery_id"`
+	Event      string `json:"event"`
+	Attempt    int    `json:"attempt"`
+	Time       int64  `json:"time"`
+	Status     int    `json:"status,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Duration   int64  `json:"duration_ms"`
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Webhooks are owned by the API key that created them, the key itself isn't stored
+func WebhookOwner(apikey string) string {
+	sum := sha256.Sum256([]byte(apikey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Check the webhook fields, and clear the ones unused by its event
+func ValidateWebhook(hook *Webhook) error {
+	u, err := url.Parse(hook.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid url %v", hook.Url)
+	}
+	// Host names are checked once resolved, when delivering
+	host := u.Hostname()
+	if ip := net.ParseIP(host); (ip != nil && !IsPublicIP(ip)) || host == "localhost" {
+		return ErrPrivateWebhookHost
+	}
+	switch hook.Event {
+	case WebhookAddress:
+		if valid, _ := IsAddress(hook.Address); !valid {
+			return fmt.Errorf("Invalid address %v", hook.Address)
+		}
+		hook.Txid, hook.Confirmations = "", 0
+	case WebhookTx:
+		if decoded, err := hex.DecodeString(hook.Txid); err != nil || len(decoded) != 32 {
+			return fmt.Errorf("Invalid txid %v", hook.Txid)
+		}
+		if hook.Confirmations > MaxWebhookConfirmations {
+			return fmt.Errorf("Confirmations must be between 0 and %v", MaxWebhookConfirmations)
+		}
+		hook.Address = ""
+	case WebhookBlock:
+		hook.Address, hook.Txid, hook.Confirmations = "", "", 0
+	default:
+		return fmt.Errorf("Unknown event %v", hook.Event)
+	}
+	return nil
+}
+
+// Set of the webhook ids to notify for the event target
+func webhookIndexKey(hook *Webhook) string {
+	switch hook.Event {
+	case WebhookAddress:
+		return fmt.Sprintf("btcplex:webhooks:addr:%v", hook.Address)
+	case WebhookTx:
+		return fmt.Sprintf("btcplex:webhooks:tx:%v", hook.Txid)
+	}
+	return "btcplex:webhooks:block"
+}
+
+// Store a validated webhook, generating its id and secret
+func CreateWebhook(pool *redis.Pool, owner string, hook *Webhook) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	cnt, err := redis.Int(c.Do("SCARD", fmt.Sprintf("btcplex:webhooks:owner:%v", owner)))
+	if err != nil {
+		return
+	}
+	if cnt >= MaxWebhooksPerOwner {
+		return ErrTooManyWebhooks
+	}
+	hook.Id = randomHex(16)
+	hook.Owner = owner
+	if hook.Secret == "" {
+		hook.Secret = randomHex(32)
+	}
+	hook.Created = time.Now().Unix()
+	hookjson, _ := json.Marshal(&storedWebhook{hook, owner})
+	c.Send("MULTI")
+	c.Send("HSET", "btcplex:webhooks", hook.Id, string(hookjson))
+	c.Send("SADD", fmt.Sprintf("btcplex:webhooks:owner:%v", owner), hook.Id)
+	c.Send("SADD", webhookIndexKey(hook), hook.Id)
+	if hook.Event == WebhookTx {
+		// Txs waiting for confirmations, checked on each new block
+		c.Send("SADD", "btcplex:webhooks:txs", hook.Txid)
+	}
+	_, err = c.Do("EXEC")
+	return
+}
+
+func GetWebhook(pool *redis.Pool, id string) (hook *Webhook, err error) {
+	c := pool.Get()
+	defer c.Close()
+	hookjson, err := redis.String(c.Do("HGET", "btcplex:webhooks", id))
+	if err == redis.ErrNil {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return
+	}
+	stored := &storedWebhook{Webhook: new(Webhook)}
+	if err = json.Unmarshal([]byte(hookjson), stored); err != nil {
+		return
+	}
+	hook = stored.Webhook
+	hook.Owner = stored.Owner
+	return
+}
+
+// Fetch webhooks by id, missing ones are skipped
+func GetWebhooks(pool *redis.Pool, ids []string) (hooks []*Webhook, err error) {
+	hooks = []*Webhook{}
+	for _, id := range ids {
+		hook, herr := GetWebhook(pool, id)
+		if herr == ErrNotFound {
+			continue
+		}
+		if herr != nil {
+			return nil, herr
+		}
+		hooks = append(hooks, hook)
+	}
+	return
+}
+
+func GetOwnerWebhooks(pool *redis.Pool, owner string) (hooks []*Webhook, err error) {
+	c := pool.Get()
+	ids, err := redis.Strings(c.Do("SMEMBERS", fmt.Sprintf("btcplex:webhooks:owner:%v", owner)))
+	c.Close()
+	if err != nil {
+		return
+	}
+	return GetWebhooks(pool, ids)
+}
+
+// Webhooks registered for the address payments, the tx confirmations or the new blocks
+func GetTargetWebhooks(pool *redis.Pool, event, target string) (hooks []*Webhook, err error) {
+	c := pool.Get()
+	ids, err := redis.Strings(c.Do("SMEMBERS", webhookIndexKey(&Webhook{Event: event, Address: target, Txid: target})))
+	c.Close()
+	if err != nil {
+		return
+	}
+	return GetWebhooks(pool, ids)
+}
+
+// Txids with tx webhooks waiting for confirmations
+func GetPendingWebhookTxs(pool *redis.Pool) (txids []string, err error) {
+	c := pool.Get()
+	defer c.Close()
+	return redis.Strings(c.Do("SMEMBERS", "btcplex:webhooks:txs"))
+}
+
+// Remove the webhook and its logs, pending deliveries are dropped when they're due
+func DeleteWebhook(pool *redis.Pool, hook *Webhook) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("HDEL", "btcplex:webhooks", hook.Id)
+	c.Send("SREM", fmt.Sprintf("btcplex:webhooks:owner:%v", hook.Owner), hook.Id)
+	c.Send("SREM", webhookIndexKey(hook), hook.Id)
+	c.Send("DEL", fmt.Sprintf("btcplex:webhook:%v:log", hook.Id), fmt.Sprintf("btcplex:webhook:%v:deadletter", hook.Id))
+	if _, err = c.Do("EXEC"); err != nil {
+		return
+	}
+	return pruneWebhookTx(c, hook)
+}
+
+// Stop checking the tx confirmations once it has no webhook left
+func pruneWebhookTx(c redis.Conn, hook *Webhook) (err error) {
+	if hook.Event != WebhookTx {
+		return
+	}
+	if cnt, _ := redis.Int(c.Do("SCARD", webhookIndexKey(hook))); cnt == 0 {
+		_, err = c.Do("SREM", "btcplex:webhooks:txs", hook.Txid)
+	}
+	return
+}
+
+// Mark the tx webhook as fired, it stays listed (with its log) until deleted
+func TriggerWebhook(pool *redis.Pool, hook *Webhook) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	hook.Triggered = time.Now().Unix()
+	hookjson, _ := json.Marshal(&storedWebhook{hook, hook.Owner})
+	c.Send("MULTI")
+	c.Send("HSET", "btcplex:webhooks", hook.Id, string(hookjson))
+	c.Send("SREM", webhookIndexKey(hook), hook.Id)
+	if _, err = c.Do("EXEC"); err != nil {
+		return
+	}
+	return pruneWebhookTx(c, hook)
+}
+
+// Queue a delivery of the payload (marshalled with the delivery id) to the webhook
+func QueueWebhookDelivery(pool *redis.Pool, hook *Webhook, payload map[string]interface{}) (delivery *WebhookDelivery, err error) {
+	delivery = &WebhookDelivery{Id: randomHex(16), WebhookId: hook.Id, Event: hook.Event, Created: time.Now().Unix()}
+	payload["id"] = delivery.Id
+	payload["event"] = hook.Event
+	payload["webhook_id"] = hook.Id
+	if delivery.Payload, err = json.Marshal(payload); err != nil {
+		return
+	}
+	err = scheduleWebhookDelivery(pool, delivery, time.Now())
+	return
+}
+
+func scheduleWebhookDelivery(pool *redis.Pool, delivery *WebhookDelivery, due time.Time) (err error) {
+	c := pool.Get()
+	defer c.Close()
+	deliveryjson, _ := json.Marshal(delivery)
+	_, err = c.Do("ZADD", webhookQueueKey, due.Unix(), string(deliveryjson))
+	return
+}
+
+// Claim up to limit due deliveries, a delivery is only claimed by one worker
+func ClaimWebhookDeliveries(pool *redis.Pool, limit int) (deliveries []*WebhookDelivery, err error) {
+	c := pool.Get()
+	defer c.Close()
+	members, err := redis.Strings(c.Do("ZRANGEBYSCORE", webhookQueueKey, "-inf", time.Now().Unix(), "LIMIT", 0, limit))
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		if claimed, _ := redis.Int(c.Do("ZREM", webhookQueueKey, member)); claimed == 0 {
+			continue
+		}
+		delivery := new(WebhookDelivery)
+		if err := json.Unmarshal([]byte(member), delivery); err == nil {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return
+}
+
+// Delay before retrying a delivery that failed attempt times
+func WebhookBackoff(attempt int) time.Duration {
+	delay := WebhookRetryDelay
+	for i := 1; i < attempt && delay < MaxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxWebhookRetryDelay {
+		delay = MaxWebhookRetryDelay
+	}
+	return delay
+}
+
+// Hex encoded HMAC-SHA256 of "<timestamp>.<body>"
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HTTP client delivering webhooks, it only connects to public addresses (checked after the
+// host is resolved) and doesn't follow redirects
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return ErrPrivateWebhookHost
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// POST the signed payload, anything but a 2xx response (redirects included) is a failure
+func SendWebhook(client *http.Client, hook *Webhook, delivery *WebhookDelivery) (status int, err error) {
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "BTCplex-Webhooks")
+	req.Header.Set("X-Btcplex-Event", delivery.Event)
+	req.Header.Set("X-Btcplex-Delivery", delivery.Id)
+	req.Header.Set("X-Btcplex-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Btcplex-Signature", "sha256="+SignWebhookPayload(hook.Secret, timestamp, delivery.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	status = resp.StatusCode
+	if status < 200 || status > 299 {
+		err = fmt.Errorf("Unexpected status %v", status)
+	}
+	return
+}
+
+// Deliver the claimed delivery, log the attempt and either retry it later
+// or move it to the dead letter list once it failed MaxWebhookAttempts times
+func ProcessWebhookDelivery(pool *redis.Pool, client *http.Client, delivery *WebhookDelivery) (err error) {
+	hook, err := GetWebhook(pool, delivery.WebhookId)
+	if err == ErrNotFound {
+		// Deleted since the delivery was queued
+		return nil
+	}
+	if err != nil {
+		// Redis error, try again later without counting an attempt
+		scheduleWebhookDelivery(pool, delivery, time.Now().Add(WebhookRetryDelay))
+		return
+	}
+	delivery.Attempt++
+	start := time.Now()
+	delivery.Status, err = SendWebhook(client, hook, delivery)
+	entry := &WebhookLogEntry{DeliveryId: delivery.Id, Event: delivery.Event, Attempt: delivery.Attempt, Time: start.Unix(),
+		Status: delivery.Status, Duration: int64(time.Since(start) / time.Millisecond)}
+	delivery.Error = ""
+	if err != nil {
+		entry.Error = err.Error()
+		delivery.Error = err.Error()
+	}
+
+	c := pool.Get()
+	defer c.Close()
+	logkey := fmt.Sprintf("btcplex:webhook:%v:log", hook.Id)
+	entryjson, _ := json.Marshal(entry)
+	c.Send("LPUSH", logkey, string(entryjson))
+	c.Send("LTRIM", logkey, 0, WebhookLogSize-1)
+	c.Flush()
+	c.Receive()
+	c.Receive()
+	if err == nil {
+		return
+	}
+	if delivery.Attempt < MaxWebhookAttempts {
+		return scheduleWebhookDelivery(pool, delivery, time.Now().Add(WebhookBackoff(delivery.Attempt)))
+	}
+	deadkey := fmt.Sprintf("btcplex:webhook:%v:deadletter", hook.Id)
+	deliveryjson, _ := json.Marshal(delivery)
+	c.Send("LPUSH", deadkey, string(deliveryjson))
+	c.Send("LTRIM", deadkey, 0, WebhookLogSize-1)
+	c.Flush()
+	c.Receive()
+	_, err = c.Receive()
+	return
+}
+
+func getWebhookList(pool *redis.Pool, key string, item func() interface{}) (items []interface{}, err error) {
+	c := pool.Get()
+	defer c.Close()
+	entries, err := redis.Strings(c.Do("LRANGE", key, 0, WebhookLogSize-1))
+	if err != nil {
+		return
+	}
+	items = []interface{}{}
+	for _, entry := range entries {
+		v := item()
+		if json.Unmarshal([]byte(entry), v) == nil {
+			items = append(items, v)
+		}
+	}
+	return
+}
+
+// Latest delivery attempts, most recent first
+func GetWebhookLog(pool *redis.Pool, id string) (entries []interface{}, err error) {
+	return getWebhookList(pool, fmt.Sprintf("btcplex:webhook:%v:log", id), func() interface{} { return new(WebhookLogEntry) })
+}
+
+// Deliveries that failed MaxWebhookAttempts times, most recent first
+func GetWebhookDeadLetters(pool *redis.Pool, id string) (deliveries []interface{}, err error) {
+	return getWebhookList(pool, fmt.Sprintf("btcplex:webhook:%v:deadletter", id), func() interface{} { return new(WebhookDelivery) })
+}
+
+// Queue the dead letters again, with their attempts reset, and return how many were queued
+func RedeliverWebhookDeadLetters(pool *redis.Pool, id string) (cnt int, err error) {
+	c := pool.Get()
+	defer c.Close()
+	deadkey := fmt.Sprintf("btcplex:webhook:%v:deadletter", id)
+	for {
+		deliveryjson, rerr := redis.String(c.Do("RPOP", deadkey))
+		if rerr == redis.ErrNil {
+			return
+		}
+		if rerr != nil {
+			return cnt, rerr
+		}
+		delivery := new(WebhookDelivery)
+		if json.Unmarshal([]byte(deliveryjson), delivery) != nil {
+			continue
+		}
+		delivery.Attempt, delivery.Status, delivery.Error = 0, 0, ""
+		if err = scheduleWebhookDelivery(pool, delivery, time.Now()); err != nil {
+			return
+		}
+		cnt++
+	}
+}
+
+// Amount received by the address in the tx, 0 if it's only spending from it
+func AddressReceived(tx *Tx, address string) (received uint64) {
+	for _, txo := range tx.TxOuts {
+		if txo.Addr == address {
+			received += txo.Value
+		}
+	}
+	return
+}
+
+// Claim the block txs never published as unconfirmed (mined without a memory pool sighting),
+// the btcplex:utx:%v:published flag is set like the memory pool sync does so each tx is published once
+func ClaimUnpublishedBlockTxs(pool *redis.Pool, block *Block) (txs []*Tx, err error) {
+	c := pool.Get()
+	defer c.Close()
+	txs = []*Tx{}
+	now := time.Now().UTC().Unix()
+	for _, tx := range block.Txs {
+		claimed, serr := redis.String(c.Do("SET", fmt.Sprintf("btcplex:utx:%v:published", tx.Hash), now, "EX", 3600*20, "NX"))
+		if serr == redis.ErrNil {
+			continue
+		}
+		if serr != nil {
+			return nil, serr
+		}
+		if claimed == "OK" {
+			txs = append(txs, tx)
+		}
+	}
+	return
+}
+
+// Queue the tx webhook delivery if the tx has enough confirmations (0 for the
+// memory pool), latest being the best block height, and mark it as fired
+func CheckTxWebhook(rpool, pool *redis.Pool, hook *Webhook, latest uint) (fired bool, err error) {
+	payload := map[string]interface{}{"txid": hook.Txid, "confirmations": uint(0)}
+	tx, err := GetTx(rpool, hook.Txid)
+	switch {
+	case err == nil:
+		payload["confirmations"] = Confirmations(tx.BlockHeight, latest)
+		payload["block_hash"] = tx.BlockHash
+		payload["block_height"] = tx.BlockHeight
+	case err == ErrNotFound:
+		c := pool.Get()
+		unconfirmed, uerr := redis.Bool(c.Do("EXISTS", fmt.Sprintf("btcplex:utx:%v", hook.Txid)))
+		c.Close()
+		if uerr != nil || !unconfirmed {
+			return false, uerr
+		}
+	default:
+		return
+	}
+	if payload["confirmations"].(uint) < hook.Confirmations {
+		return false, nil
+	}
+	if _, err = QueueWebhookDelivery(pool, hook, payload); err != nil {
+		return
+	}
+	return true, TriggerWebhook(pool, hook)
+}
@@ -0,0 +1,154 @@
+package btcplex
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidateWebhook(t *testing.T) {
+	txid := "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+	tests := []struct {
+		hook  Webhook
+		valid bool
+	}{
+		{Webhook{Event: WebhookAddress, Url: "https://example.com/hook", Address: "M7uAERuQW2AotfyLDyewFGcLUDtAYu9v5V"}, true},
+		{Webhook{Event: WebhookAddress, Url: "https://example.com/hook", Address: "MXEmDYChDCdgi77RFPzFjPt86j97MwEZsu"}, false},
+		{Webhook{Event: WebhookTx, Url: "http://example.com:8080/", Txid: txid, Confirmations: 6}, true},
+		{Webhook{Event: WebhookTx, Url: "http://example.com/", Txid: txid}, true},
+		{Webhook{Event: WebhookTx, Url: "http://example.com/", Txid: txid, Confirmations: MaxWebhookConfirmations + 1}, false},
+		{Webhook{Event: WebhookTx, Url: "http://example.com/", Txid: "0e3e"}, false},
+		{Webhook{Event: WebhookBlock, Url: "https://example.com/"}, true},
+		{Webhook{Event: WebhookBlock, Url: "ftp://example.com/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "/hook"}, false},
+		{Webhook{Event: "balance", Url: "https://example.com/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://127.0.0.1:8080/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://169.254.169.254/latest/meta-data/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://10.1.2.3/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://[::1]/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://localhost/"}, false},
+		{Webhook{Event: WebhookBlock, Url: "http://93.184.216.34/"}, true},
+	}
+	for i, test := range tests {
+		if err := ValidateWebhook(&test.hook); (err == nil) != test.valid {
+			t.Errorf("test %v: ValidateWebhook = %v, want valid %v", i, err, test.valid)
+		}
+	}
+
+	hook := &Webhook{Event: WebhookBlock, Url: "https://example.com/", Txid: txid, Confirmations: 3}
+	ValidateWebhook(hook)
+	if hook.Txid != "" || hook.Confirmations != 0 {
+		t.Errorf("fields unused by the event should be cleared")
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{9, 2560 * time.Second},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+	for _, test := range tests {
+		if delay := WebhookBackoff(test.attempt); delay != test.delay {
+			t.Errorf("WebhookBackoff(%v) = %v, want %v", test.attempt, delay, test.delay)
+		}
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	sig := SignWebhookPayload("secret", 1400000000, []byte(`{"id":"1"}`))
+	if want := "e22f6be870d9e038e3d222b7674924b0705decd501feebf44fd4c92304ab89b0"; sig != want {
+		t.Errorf("SignWebhookPayload = %v, want %v", sig, want)
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	status := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		timestamp, _ := strconv.ParseInt(req.Header.Get("X-Btcplex-Timestamp"), 10, 64)
+		if req.Header.Get("X-Btcplex-Signature") != "sha256="+SignWebhookPayload("secret", timestamp, body) {
+			t.Errorf("invalid signature %v", req.Header.Get("X-Btcplex-Signature"))
+		}
+		if req.Header.Get("X-Btcplex-Event") != WebhookBlock || req.Header.Get("X-Btcplex-Delivery") != "d1" {
+			t.Errorf("unexpected headers %v", req.Header)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := &Webhook{Id: "w1", Event: WebhookBlock, Url: server.URL, Secret: "secret"}
+	delivery := &WebhookDelivery{Id: "d1", WebhookId: "w1", Event: WebhookBlock, Payload: []byte(`{"id":"d1"}`)}
+	client := &http.Client{Timeout: time.Second}
+	if code, err := SendWebhook(client, hook, delivery); code != 200 || err != nil {
+		t.Errorf("SendWebhook = %v, %v, want 200", code, err)
+	}
+	status = 500
+	if code, err := SendWebhook(client, hook, delivery); code != 500 || err == nil {
+		t.Errorf("SendWebhook = %v, %v, want 500 and an error", code, err)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.20.1.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+	}
+	for _, test := range tests {
+		if public := IsPublicIP(net.ParseIP(test.ip)); public != test.public {
+			t.Errorf("IsPublicIP(%v) = %v, want %v", test.ip, public, test.public)
+		}
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("the webhook client reached a loopback address")
+	}))
+	defer server.Close()
+	hook := &Webhook{Id: "w1", Event: WebhookBlock, Url: server.URL, Secret: "secret"}
+	delivery := &WebhookDelivery{Id: "d1", WebhookId: "w1", Event: WebhookBlock, Payload: []byte(`{"id":"d1"}`)}
+	if _, err := SendWebhook(NewWebhookClient(time.Second), hook, delivery); err == nil {
+		t.Errorf("SendWebhook to %v should fail", server.URL)
+	}
+
+	redirect := NewWebhookClient(time.Second).CheckRedirect(nil, nil)
+	if redirect != http.ErrUseLastResponse {
+		t.Errorf("CheckRedirect = %v, want http.ErrUseLastResponse", redirect)
+	}
+}
+
+func TestAddressReceived(t *testing.T) {
+	tx := &Tx{TxOuts: []*TxOut{{Addr: "a", Value: 5}, {Addr: "b", Value: 7}, {Addr: "a", Value: 3}}}
+	for address, want := range map[string]uint64{"a": 8, "b": 7, "c": 0} {
+		if received := AddressReceived(tx, address); received != want {
+			t.Errorf("AddressReceived(%v) = %v, want %v", address, received, want)
+		}
+	}
+}
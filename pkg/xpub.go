@@ -0,0 +1,191 @@
+package btcplex
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// Number of consecutive unused addresses ending the scan of a chain (BIP44)
+	DefaultGapLimit = 20
+	MaxGapLimit     = MaxXpubAddresses / 2
+	// Max number of addresses derived per scan (both chains), capped like /multiaddr
+	MaxXpubAddresses = MaxMultiAddresses
+	// Derived addresses are cached in Redis for a day
+	xpubcachettl = 3600 * 24
+)
+
+// Used address of an extended public key
+type XpubAddress struct {
+	Path          string `json:"path"`
+	Address       string `json:"address"`
+	TxCnt         uint64 `json:"n_tx"`
+	TotalReceived uint64 `json:"total_received"`
+	TotalSent     uint64 `json:"total_sent"`
+	FinalBalance  uint64 `json:"final_balance"`
+}
+
+type XpubData struct {
+	Xpub               string                       `json:"xpub"`
+	Addresses          []*XpubAddress               `json:"addresses"`
+	TotalReceived      uint64                       `json:"total_received"`
+	TotalSent          uint64                       `json:"total_sent"`
+	FinalBalance       uint64                       `json:"final_balance"`
+	NextReceiveAddress string                       `json:"next_receive_address"`
+	NextReceivePath    string                       `json:"next_receive_path"`
+	NextChangeAddress  string                       `json:"next_change_address"`
+	NextChangePath     string                       `json:"next_change_path"`
+	Txs                []*MultiAddressTx            `json:"txs"`
+	NextCursor         *Cursor                      `json:"next_cursor"`
+	Truncated          bool                         `json:"truncated"`
+	Links              map[string]map[string]string `json:"_links,omitempty"`
+}
+
+// Derive the receive (0) and change (1) addresses of the extended public key until
+// gap consecutive addresses are unused, return the used addresses along with
+// their merged transactions history (up to limit txs following the cursor).
+// At most MaxXpubAddresses are derived, Truncated is set if a chain was cut short.
+// pool is the Redis pool caching the derivations, rpool the index.
+func ScanXpub(pool, rpool *redis.Pool, xpub string, gap int, cursor *Cursor, limit int) (xpubdata *XpubData, err error) {
+	c := rpool.Get()
+	defer c.Close()
+
+	key, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return
+	}
+	derivations, err := newXpubDerivations(pool, xpub, key)
+	if err != nil {
+		return
+	}
+	defer derivations.save()
+	xpubdata = &XpubData{Xpub: xpub, Addresses: []*XpubAddress{}}
+	used := []string{}
+	budget := MaxXpubAddresses
+	for _, chain := range []uint32{0, 1} {
+		// Leave room for gap change addresses
+		maxindex := budget
+		if chain == 0 {
+			maxindex -= gap
+		}
+		unused := 0
+		nextaddress, nextpath := "", ""
+		index := uint32(0)
+		for ; unused < gap && int(index) < maxindex; index++ {
+			address, derr := derivations.address(chain, index)
+			if derr != nil {
+				err = derr
+				return
+			}
+			if address == "" {
+				continue
+			}
+			path := fmt.Sprintf("M/%v/%v", chain, index)
+			txcnt, zerr := redis.Int(c.Do("ZCARD", fmt.Sprintf("addr:%v", address)))
+			if zerr != nil {
+				err = zerr
+				return
+			}
+			if txcnt == 0 {
+				// First unused address after the last used one
+				if unused == 0 {
+					nextaddress, nextpath = address, path
+				}
+				unused++
+				continue
+			}
+			unused = 0
+			nextaddress, nextpath = "", ""
+			addressdata, aerr := GetAddress(rpool, address)
+			if aerr != nil {
+				err = aerr
+				return
+			}
+			xpubdata.Addresses = append(xpubdata.Addresses, &XpubAddress{
+				Path:          path,
+				Address:       address,
+				TxCnt:         addressdata.TxCnt,
+				TotalReceived: addressdata.TotalReceived,
+				TotalSent:     addressdata.TotalSent,
+				FinalBalance:  addressdata.FinalBalance,
+			})
+			xpubdata.TotalReceived += addressdata.TotalReceived
+			xpubdata.TotalSent += addressdata.TotalSent
+			xpubdata.FinalBalance += addressdata.FinalBalance
+			used = append(used, address)
+		}
+		budget -= int(index)
+		if unused < gap {
+			xpubdata.Truncated = true
+		}
+		if chain == 0 {
+			xpubdata.NextReceiveAddress, xpubdata.NextReceivePath = nextaddress, nextpath
+		} else {
+			xpubdata.NextChangeAddress, xpubdata.NextChangePath = nextaddress, nextpath
+		}
+	}
+	xpubdata.Txs, xpubdata.NextCursor, err = GetMultiAddressTxs(rpool, used, cursor, limit)
+	return
+}
+
+// Addresses derived from an extended public key, cached in the btcplex:xpub:<xpub> Redis hash
+// ("chain/index" fields, empty for the invalid children skipped by BIP32)
+type xpubDerivations struct {
+	pool      *redis.Pool
+	xpub      string
+	key       *ExtendedKey
+	chainkeys map[uint32]*ExtendedKey
+	cached    map[string]string
+	derived   []interface{}
+}
+
+func newXpubDerivations(pool *redis.Pool, xpub string, key *ExtendedKey) (d *xpubDerivations, err error) {
+	c := pool.Get()
+	defer c.Close()
+	cached, err := redis.StringMap(c.Do("HGETALL", fmt.Sprintf("btcplex:xpub:%v", xpub)))
+	if err != nil {
+		return
+	}
+	return &xpubDerivations{pool: pool, xpub: xpub, key: key, chainkeys: map[uint32]*ExtendedKey{}, cached: cached}, nil
+}
+
+// Address at M/chain/index, only derived on a cache miss
+func (d *xpubDerivations) address(chain, index uint32) (address string, err error) {
+	field := fmt.Sprintf("%v/%v", chain, index)
+	if address, cached := d.cached[field]; cached {
+		return address, nil
+	}
+	chainkey, derived := d.chainkeys[chain]
+	if !derived {
+		if chainkey, err = d.key.Child(chain); err != nil {
+			return
+		}
+		d.chainkeys[chain] = chainkey
+	}
+	child, err := chainkey.Child(index)
+	if err == ErrInvalidChild {
+		err = nil
+	} else if err != nil {
+		return
+	} else {
+		address = child.Address()
+	}
+	d.cached[field] = address
+	d.derived = append(d.derived, field, address)
+	return
+}
+
+// Cache the new derivations, errors are ignored since they only cost a later derivation
+func (d *xpubDerivations) save() {
+	if len(d.derived) == 0 {
+		return
+	}
+	c := d.pool.Get()
+	defer c.Close()
+	key := fmt.Sprintf("btcplex:xpub:%v", d.xpub)
+	c.Send("MULTI")
+	c.Send("HMSET", append([]interface{}{key}, d.derived...)...)
+	c.Send("EXPIRE", key, xpubcachettl)
+	c.Do("EXEC")
+}